@@ -0,0 +1,38 @@
+package lymbo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// ArchiveStore keeps the tickets removed by expiration, e.g. for compliance
+// or analytics, see Settings.WithArchive.
+type ArchiveStore interface {
+	// Archive stores tickets about to be removed by Store.ExpireTickets.
+	// They are kept in the store if it fails.
+	Archive(ctx context.Context, tickets []Ticket) error
+}
+
+type jsonlArchive struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// JSONLArchive returns an ArchiveStore writing every archived ticket to w
+// as a line of JSON. Writes are serialized; w is neither synced nor closed.
+func JSONLArchive(w io.Writer) ArchiveStore {
+	return &jsonlArchive{enc: json.NewEncoder(w)}
+}
+
+func (a *jsonlArchive) Archive(_ context.Context, tickets []Ticket) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range tickets {
+		if err := a.enc.Encode(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
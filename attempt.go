@@ -0,0 +1,64 @@
+package lymbo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Attempt is a run of the handler of a ticket, logged in Ticket.AttemptLog
+// with the outcome the handler reported, so that the retry story of a flaky
+// ticket can be told. Outcomes reported outside of a handler processing the
+// ticket, and deleted tickets, are not logged.
+type Attempt struct {
+	// Number is the attempt the run was, see Ticket.Attempts.
+	Number int `json:"number"`
+	// Start is when the handler started.
+	Start time.Time `json:"start"`
+	// Worker identifies the worker that ran the handler, see Settings.WithWorkerID.
+	Worker string `json:"worker,omitempty"`
+	// Duration is how long the handler ran until its outcome was reported.
+	Duration time.Duration `json:"duration"`
+	// Outcome is the operation that settled the run: OpDone, OpFail, OpRetry,
+	// or OpAck and OpCancel for tickets kept with WithKeep.
+	Outcome Op `json:"outcome"`
+	// Error is the ErrorReason the outcome was reported with, without its History.
+	Error *ErrorInfo `json:"error,omitempty"`
+}
+
+// defaultWorkerID identifies the process: its hostname and pid.
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// LogAttempt appends a to the AttemptLog of t. A nil a is ignored.
+func (t *Ticket) LogAttempt(a *Attempt) {
+	if a != nil {
+		// clipped, so that copies of t sharing the log are never written to
+		t.AttemptLog = append(slices.Clip(t.AttemptLog), *a)
+	}
+}
+
+// attemptOf returns the run of the handler processing tid in ctx settled by
+// the outcome of o at now, nil outside of it.
+func attemptOf(ctx context.Context, tid TicketId, o *Opts, now time.Time) *Attempt {
+	s, ok := ctx.Value(settledKey{}).(settled)
+	if !ok || s.ticket.ID != tid || s.run == nil || o.outcome == "" {
+		return nil
+	}
+	a := *s.run
+	a.Duration = now.Sub(a.Start)
+	a.Outcome = o.outcome
+	if o.errorReason != nil {
+		e := *o.errorReason
+		e.History = nil
+		a.Error = &e
+	}
+	return &a
+}
@@ -0,0 +1,119 @@
+package lymbo
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ochaton/lymbo/status"
+)
+
+// AuditEntry records a status change of a ticket, see Settings.WithAuditLog.
+type AuditEntry struct {
+	TicketID TicketId      `json:"ticket_id"`
+	Status   status.Status `json:"status"`
+
+	// Actor made the change: the caller set by WithActor, or else the worker
+	// ID of the Kharon, see Settings.WithWorkerID.
+	Actor string `json:"actor"`
+
+	// Reason is the error reason of failed and cancelled tickets, or why the
+	// change was made, e.g. an exhausted ticket.
+	Reason string `json:"reason,omitempty"`
+
+	At time.Time `json:"at"`
+}
+
+// AuditLog records the status changes of tickets, e.g. for compliance, see
+// Settings.WithAuditLog.
+type AuditLog interface {
+	// Record appends entries to the log.
+	Record(ctx context.Context, entries []AuditEntry) error
+
+	// History returns the entries of the ticket id, oldest first, none if
+	// the log has no entry of it.
+	History(ctx context.Context, id TicketId) ([]AuditEntry, error)
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx making actor the Actor of the status
+// changes made with it, e.g. the operator calling an admin API.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set on ctx by WithActor, "" if none.
+func ActorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// audit records the change of the tickets ids to status st, if an audit log
+// is set. The operation succeeded already, so failures are only logged.
+func (k *Kharon) audit(ctx context.Context, st status.Status, reason string, ids ...TicketId) {
+	if k.settings.auditLog == nil || len(ids) == 0 {
+		return
+	}
+	actor := ActorFrom(ctx)
+	if actor == "" {
+		actor = k.settings.workerID
+	}
+	now := k.now()
+	entries := make([]AuditEntry, len(ids))
+	for i, id := range ids {
+		entries[i] = AuditEntry{TicketID: id, Status: st, Actor: actor, Reason: reason, At: now}
+	}
+	if err := k.settings.auditLog.Record(ctx, entries); err != nil {
+		k.logger.ErrorContext(ctx, "error recording status change in audit log",
+			"ticket_ids", ids,
+			"status", st,
+			"error", err,
+		)
+	}
+}
+
+// reasonOf returns the message of the error reason e, "" if nil.
+func reasonOf(e *ErrorInfo) string {
+	if e == nil {
+		return ""
+	}
+	return e.Message
+}
+
+// History returns the status changes of the ticket tid recorded by the audit
+// log, oldest first.
+// Returns ErrAuditDisabled if no audit log is set, see Settings.WithAuditLog.
+func (k *Kharon) History(ctx context.Context, tid TicketId) ([]AuditEntry, error) {
+	if k.settings.auditLog == nil {
+		return nil, ErrAuditDisabled
+	}
+	return k.settings.auditLog.History(ctx, tid)
+}
+
+type memoryAuditLog struct {
+	mu      sync.RWMutex
+	entries map[TicketId][]AuditEntry
+}
+
+// MemoryAuditLog returns an AuditLog keeping the entries in memory, e.g. for
+// tests. Entries are never removed, even once their tickets expire.
+func MemoryAuditLog() AuditLog {
+	return &memoryAuditLog{entries: make(map[TicketId][]AuditEntry)}
+}
+
+func (l *memoryAuditLog) Record(_ context.Context, entries []AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range entries {
+		l.entries[e.TicketID] = append(l.entries[e.TicketID], e)
+	}
+	return nil
+}
+
+func (l *memoryAuditLog) History(_ context.Context, id TicketId) ([]AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return slices.Clone(l.entries[id]), nil
+}
@@ -0,0 +1,156 @@
+package lymbo
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// BackoffConfig configures the exponential backoff computed by NextRetry.
+type BackoffConfig struct {
+	// Base of the exponential backoff: the delay is Base^attempts seconds.
+	Base float64
+
+	// MaxDelay caps the delay.
+	MaxDelay time.Duration
+
+	// MaxAttempts is the number of deliveries a ticket gets. 0 means unlimited.
+	MaxAttempts int
+}
+
+// NextRetry returns the delay the stores apply when retrying a ticket with
+// BackoffDelay after the given number of attempts: min(Base^attempts seconds, MaxDelay),
+// not counting jitter. Pass Ticket.Attempts from within a handler.
+//
+// exhausted reports that the next delivery would exceed MaxAttempts,
+// so a ticket retried now would be failed instead of processed.
+func NextRetry(attempts int, cfg BackoffConfig) (delay time.Duration, exhausted bool) {
+	exhausted = cfg.MaxAttempts > 0 && attempts >= cfg.MaxAttempts
+
+	d := math.Pow(cfg.Base, float64(attempts)) * float64(time.Second)
+	if d >= float64(cfg.MaxDelay) {
+		// also guards the conversion against overflow
+		return cfg.MaxDelay, exhausted
+	}
+	return time.Duration(d), exhausted
+}
+
+// Backoff is a strategy computing how long to wait before the next delivery
+// of a ticket that has been attempted the given number of times.
+// Implementations must be safe for concurrent use.
+type Backoff interface {
+	Delay(attempts int) time.Duration
+}
+
+// ExponentialBackoff waits min(Base^attempts seconds, MaxDelay),
+// the default strategy with DefaultBackoffBase and MaxBackoffDelay.
+type ExponentialBackoff struct {
+	Base     float64
+	MaxDelay time.Duration
+}
+
+func (b ExponentialBackoff) Delay(attempts int) time.Duration {
+	delay, _ := NextRetry(attempts, BackoffConfig{Base: b.Base, MaxDelay: b.MaxDelay})
+	return delay
+}
+
+// LinearBackoff waits Initial + Step*attempts, capped at MaxDelay if set.
+type LinearBackoff struct {
+	Initial  time.Duration
+	Step     time.Duration
+	MaxDelay time.Duration
+}
+
+func (b LinearBackoff) Delay(attempts int) time.Duration {
+	d := b.Initial + b.Step*time.Duration(attempts)
+	if b.MaxDelay > 0 && (d > b.MaxDelay || d < b.Initial) {
+		// d < Initial guards against overflow
+		return b.MaxDelay
+	}
+	return max(d, 0)
+}
+
+// ConstantBackoff waits the same duration whatever the attempts.
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) Delay(int) time.Duration {
+	return max(time.Duration(b), 0)
+}
+
+// BackoffFunc adapts a function to the Backoff interface.
+type BackoffFunc func(attempts int) time.Duration
+
+func (f BackoffFunc) Delay(attempts int) time.Duration {
+	return f(attempts)
+}
+
+// Jitter randomizes the backoff delays of the tickets claimed by a poll, so
+// that tickets failing together aren't redelivered in lockstep, see
+// Settings.WithBackoffJitter. Unlike WithJitter and WithFullJitter, stores
+// draw it per ticket, PostgreSQL included.
+type Jitter int
+
+const (
+	// JitterNone keeps the delays of the backoff.
+	JitterNone Jitter = iota
+
+	// JitterEqual waits half the delay, plus a random duration up to the other half.
+	JitterEqual
+
+	// JitterFull waits a random duration up to the delay.
+	JitterFull
+
+	// JitterDecorrelated waits a random duration from the delay of the first
+	// attempt up to three times the delay of the previous one, capped at
+	// PollRequest.MaxBackoffDelay.
+	JitterDecorrelated
+)
+
+// Delay returns the delay of b after the given number of attempts,
+// randomized by j. maxDelay caps the delays of JitterDecorrelated.
+func (j Jitter) Delay(b Backoff, attempts int, maxDelay time.Duration) time.Duration {
+	d := b.Delay(attempts)
+	switch j {
+	case JitterEqual:
+		if d > 1 {
+			return d/2 + rand.N(d-d/2)
+		}
+	case JitterFull:
+		if d > 0 {
+			return rand.N(d)
+		}
+	case JitterDecorrelated:
+		first := b.Delay(0)
+		d = first
+		if spread := 3*b.Delay(max(attempts-1, 0)) - first; spread > 0 {
+			d += rand.N(spread)
+		}
+		if maxDelay > 0 {
+			d = min(d, maxDelay)
+		}
+	}
+	return d
+}
+
+// WithJitter adds a random duration in [0, jitter) to the delays of b,
+// so that tickets failing together are not retried in lockstep.
+func WithJitter(b Backoff, jitter time.Duration) Backoff {
+	return BackoffFunc(func(attempts int) time.Duration {
+		d := b.Delay(attempts)
+		if jitter > 0 {
+			d += rand.N(jitter)
+		}
+		return d
+	})
+}
+
+// WithFullJitter replaces the delays of b by a random duration in [0, delay),
+// spreading retries the most at the cost of some immediate ones.
+func WithFullJitter(b Backoff) Backoff {
+	return BackoffFunc(func(attempts int) time.Duration {
+		if d := b.Delay(attempts); d > 0 {
+			return rand.N(d)
+		}
+		return 0
+	})
+}
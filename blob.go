@@ -0,0 +1,180 @@
+package lymbo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore keeps the payloads offloaded by Settings.WithBlobStore, e.g. in
+// S3, GCS or a filesystem, see DirBlobStore.
+type BlobStore interface {
+	// PutBlob stores data under key, which is new.
+	PutBlob(ctx context.Context, key string, data []byte) error
+
+	// GetBlob returns the data stored under key.
+	GetBlob(ctx context.Context, key string) ([]byte, error)
+
+	// DeleteBlob removes the data stored under key, if any.
+	DeleteBlob(ctx context.Context, key string) error
+}
+
+// blobKey is the metadata key recording the key of the blob a ticket's
+// payload was offloaded to. Reserved, it is only set by offload: a payload or
+// metadata given by a producer never refers to a blob.
+const blobKey = reservedPrefix + "blob"
+
+// blobOf returns the key of the blob of the payload of t, if it was offloaded.
+func blobOf(t *Ticket) (string, bool) {
+	key := t.Metadata[blobKey]
+	return key, key != ""
+}
+
+// offload writes the payload of t to the BlobStore if its JSON is larger than
+// the threshold of Settings.WithBlobStore, replacing it with its reference in
+// the metadata, and returns the key of the blob, empty if t keeps its payload.
+func (k *Kharon) offload(ctx context.Context, t *Ticket) (string, error) {
+	if k.settings.blobs == nil || t.Payload == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(t.Payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	if len(data) <= k.settings.blobThreshold {
+		return "", nil
+	}
+	// a new key, so that putting a duplicate ticket doesn't overwrite the
+	// payload of the original
+	var suffix [8]byte
+	rand.Read(suffix[:])
+	key := url.PathEscape(t.ID.String()) + "-" + hex.EncodeToString(suffix[:])
+	if err := k.settings.blobs.PutBlob(ctx, key, data); err != nil {
+		return "", fmt.Errorf("failed to offload payload: %w", err)
+	}
+	// copied, as the caller may share it
+	md := maps.Clone(t.Metadata)
+	if md == nil {
+		md = make(map[string]string, 1)
+	}
+	md[blobKey] = key
+	t.Metadata = md
+	t.Payload = nil
+	return key, nil
+}
+
+// LoadPayload replaces the payload of t by the one it was offloaded to the
+// BlobStore with, see Settings.WithBlobStore, as raw JSON. Payloads of
+// tickets handed to handlers are loaded already; it is for the tickets of
+// Get or List, whose payload is nil until loaded, e.g. before putting them
+// again.
+func (k *Kharon) LoadPayload(ctx context.Context, t *Ticket) error {
+	key, ok := blobOf(t)
+	if !ok {
+		return nil
+	}
+	if k.settings.blobs == nil {
+		return fmt.Errorf("ticket %s: payload offloaded to %s without a blob store", t.ID, key)
+	}
+	data, err := k.settings.blobs.GetBlob(ctx, key)
+	if err != nil {
+		return fmt.Errorf("ticket %s: failed to load payload: %w", t.ID, err)
+	}
+	t.Payload = data
+	return nil
+}
+
+// dropBlobs deletes the blobs of removed tickets, logging failures: a blob
+// left behind is garbage, not a loss.
+func (k *Kharon) dropBlobs(ctx context.Context, keys ...string) {
+	if k.settings.blobs == nil {
+		return
+	}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := k.settings.blobs.DeleteBlob(ctx, key); err != nil {
+			k.logger.WarnContext(ctx, "error deleting offloaded payload", "key", key, "error", err)
+		}
+	}
+}
+
+type blobCtxKey struct{}
+
+// withBlob returns ctx carrying the key of the blob of the payload of the
+// ticket being processed, for its removal to delete it.
+func withBlob(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, blobCtxKey{}, key)
+}
+
+// blobFrom returns the key of the blob of the ticket handled with ctx.
+func blobFrom(ctx context.Context) string {
+	key, _ := ctx.Value(blobCtxKey{}).(string)
+	return key
+}
+
+type dirBlobStore struct {
+	dir string
+}
+
+// DirBlobStore returns a BlobStore keeping each blob in a file of dir, which
+// must exist.
+func DirBlobStore(dir string) BlobStore {
+	return dirBlobStore{dir: dir}
+}
+
+func (s dirBlobStore) path(key string) (string, error) {
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, key), nil
+}
+
+func (s dirBlobStore) PutBlob(_ context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	// written aside then renamed, so that readers never see a partial blob
+	f, err := os.CreateTemp(s.dir, ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s dirBlobStore) GetBlob(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (s dirBlobStore) DeleteBlob(_ context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package lymbo_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/store/memory"
+)
+
+// TestBlobForged puts a ticket whose payload and metadata refer to the blob
+// of another: it must neither load nor delete it.
+func TestBlobForged(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	blobs := lymbo.DirBlobStore(t.TempDir())
+	settings := lymbo.DefaultSettings().WithBlobStore(blobs, 64).WithoutExpiration()
+	kh := lymbo.NewKharon(store, settings, slog.New(slog.DiscardHandler))
+
+	victim, _ := lymbo.NewTicket("victim", "report")
+	victim.WithPayload(map[string]string{"secret": "a payload larger than the threshold of the blob store, which the forged one isn't"})
+	if err := kh.Put(ctx, *victim); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Get(ctx, victim.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := stored.Metadata["$lymbo_blob"]
+	if key == "" || stored.Payload != nil {
+		t.Fatalf("stored ticket has payload %v and metadata %v, want it offloaded", stored.Payload, stored.Metadata)
+	}
+
+	forged, _ := lymbo.NewTicket("forged", "report")
+	forged.WithPayload(json.RawMessage(`{"$lymbo_blob":"` + key + `"}`))
+	forged.WithMetadata(map[string]string{"$lymbo_blob": key})
+	if err := kh.Put(ctx, *forged); err != nil {
+		t.Fatal(err)
+	}
+	got, err := kh.Get(ctx, forged.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kh.LoadPayload(ctx, &got); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := json.Marshal(got.Payload); string(data) != `{"$lymbo_blob":"`+key+`"}` {
+		t.Errorf("forged payload loaded as %s", data)
+	}
+	if err := kh.Delete(ctx, forged.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blobs.GetBlob(ctx, key); err != nil {
+		t.Errorf("blob of the victim deleted: %v", err)
+	}
+}
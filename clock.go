@@ -0,0 +1,65 @@
+package lymbo
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time of tickets: when they are due, created, modified,
+// polled or expired. Tests set a FakeClock to advance it without sleeping,
+// see Settings.WithClock. Waits, e.g. between polls, use the wall clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock, the default Clock.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// FakeClock is a Clock standing still until advanced, for tests.
+// Tickets made by NewTicket are due at the wall clock time they were made:
+// set their Runat, or put them WithRunAfter, to be due at its time.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time the clock was set to.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set sets the clock to now.
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// now returns the time of the clock of k, see Settings.WithClock.
+func (k *Kharon) now() time.Time {
+	return k.settings.clock.Now()
+}
+
+// Now returns the time of the clock of k, see Settings.WithClock: the one to
+// stamp the tickets put or updated on behalf of k, e.g. by an API wrapping it.
+func (k *Kharon) Now() time.Time {
+	return k.now()
+}
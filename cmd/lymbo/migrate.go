@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/migrate"
+	"github.com/ochaton/lymbo/status"
+	"github.com/spf13/cobra"
+)
+
+func newMigrateCmd(g *globals) *cobra.Command {
+	var (
+		to        string
+		toTable   string
+		types     []string
+		tenants   []string
+		state     string
+		batchSize int
+		rate      float64
+		verify    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "migrate --to <dsn>",
+		Short: "Copy the tickets of the store to another one",
+		Long: `Copy the tickets of the store to the store --to, as they are, their status,
+attempts and times included, e.g. from PostgreSQL to Redis, then check
+that they were with --verify, printing the tickets missing or differing.
+Stop the workers of the store first: tickets changed meanwhile may be copied
+as of before their change. To migrate a live queue, run the workers on a
+migrate.DualStore from Go instead.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := migrate.Config{
+				Select:    lymbo.ListRequest{Types: types, Tenants: tenants},
+				BatchSize: batchSize,
+				Rate:      rate,
+			}
+			if state != "" {
+				st, err := status.FromString(state)
+				if err != nil {
+					return err
+				}
+				cfg.Select.Status = &st
+			}
+
+			ctx := cmd.Context()
+			src, closeSrc, err := g.open(ctx)
+			if err != nil {
+				return err
+			}
+			defer closeSrc()
+			dst, closeDst, err := openStore(ctx, to, toTable)
+			if err != nil {
+				return err
+			}
+			defer closeDst()
+
+			n, err := migrate.Copy(ctx, src, dst, cfg)
+			fmt.Fprintf(cmd.OutOrStdout(), "%d tickets copied\n", n)
+			if err != nil || !verify {
+				return err
+			}
+
+			report, err := migrate.Verify(ctx, src, dst, cfg)
+			if err != nil {
+				return err
+			}
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+			if !report.OK() {
+				return errors.New("tickets missing or differing in the destination store")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&to, "to", "", "DSN of the destination store, postgres://... or redis://...")
+	cmd.Flags().StringVar(&toTable, "to-table", "", "postgres table name or redis key prefix of the destination store")
+	cmd.Flags().StringSliceVar(&types, "type", nil, "copy only the tickets of these types")
+	cmd.Flags().StringSliceVar(&tenants, "tenant", nil, "copy only the tickets of these tenants")
+	cmd.Flags().StringVar(&state, "status", "", "copy only the tickets in this status, e.g. pending")
+	cmd.Flags().IntVar(&batchSize, "batch", lymbo.SnapshotBatchSize, "number of tickets listed and put at once")
+	cmd.Flags().Float64Var(&rate, "rate", 0, "maximum number of tickets copied per second, 0 for no limit")
+	cmd.Flags().BoolVar(&verify, "verify", false, "check the copied tickets once copied")
+	cmd.MarkFlagRequired("to")
+	return cmd
+}
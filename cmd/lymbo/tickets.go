@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/httpadmin"
+	"github.com/ochaton/lymbo/status"
+	"github.com/spf13/cobra"
+)
+
+// input is the JSON form of a ticket to put. ID defaults to a new UUIDv7.
+type input struct {
+	ID        lymbo.TicketId    `json:"id"`
+	Type      string            `json:"type"`
+	Queue     string            `json:"queue"`
+	TenantID  string            `json:"tenant_id"`
+	Runat     time.Time         `json:"runat"`
+	Deadline  *time.Time        `json:"deadline"`
+	Nice      *int              `json:"nice"`
+	UniqueKey string            `json:"unique_key"`
+	Labels    map[string]string `json:"labels"`
+	Metadata  map[string]string `json:"metadata"`
+	DependsOn []lymbo.TicketId  `json:"depends_on"`
+	Payload   json.RawMessage   `json:"payload"`
+}
+
+func (in input) ticket() (lymbo.Ticket, error) {
+	if in.ID == "" {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return lymbo.Ticket{}, err
+		}
+		in.ID = lymbo.TicketId(id.String())
+	}
+	t, err := lymbo.NewTicket(in.ID, in.Type)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	t.Queue = in.Queue
+	t.TenantID = in.TenantID
+	t.UniqueKey = in.UniqueKey
+	t.Labels = in.Labels
+	t.Metadata = in.Metadata
+	t.DependsOn = in.DependsOn
+	if !in.Runat.IsZero() {
+		t.Runat = in.Runat
+	}
+	if in.Nice != nil {
+		t.Nice = *in.Nice
+	}
+	t.Deadline = in.Deadline
+	if len(in.Payload) > 0 {
+		t.Payload = in.Payload
+	}
+	return *t, nil
+}
+
+func newPutCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "put [file]",
+		Short: "Enqueue tickets read as JSON objects from file or stdin",
+		Long: `Enqueue tickets read as a stream of JSON objects from file, or stdin if
+none or "-" is given, and print their IDs. The fields are id (a new UUIDv7
+if empty), type, queue, runat, deadline, nice, unique_key, labels, metadata,
+depends_on and payload.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			in := cmd.InOrStdin()
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				in = f
+			}
+
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			dec := json.NewDecoder(in)
+			for {
+				var v input
+				if err := dec.Decode(&v); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return fmt.Errorf("failed to decode ticket: %w", err)
+				}
+				t, err := v.ticket()
+				if err != nil {
+					return err
+				}
+				if err := kh.Put(ctx, t); err != nil {
+					return fmt.Errorf("failed to put ticket %s: %w", t.ID, err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), t.ID)
+			}
+		},
+	}
+}
+
+func newGetCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <id>",
+		Short: "Print a ticket as JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			return printTicket(ctx, cmd.OutOrStdout(), kh, lymbo.TicketId(args[0]))
+		},
+	}
+}
+
+func newSearchCmd(g *globals) *cobra.Command {
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Print the tickets whose payload matches a JSON path predicate",
+		Long: `Print the tickets whose payload matches the SQL/JSON path predicate query
+as JSON, oldest first, e.g. lymbo search '$.order_id == "A-42"'. Only
+PostgreSQL stores support it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			tickets, err := kh.Search(ctx, args[0], limit)
+			if err != nil {
+				return err
+			}
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			for _, t := range tickets {
+				if err := enc.Encode(httpadmin.TicketOf(t)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 100, "maximum number of tickets printed")
+	return cmd
+}
+
+func newCancelCmd(g *globals) *cobra.Command {
+	var reason string
+	cmd := &cobra.Command{
+		Use:   "cancel <id>",
+		Short: "Cancel a pending ticket, keeping it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			tid := lymbo.TicketId(args[0])
+			t, err := kh.Get(lymbo.PrimaryContext(ctx), tid)
+			if err != nil {
+				return err
+			}
+			if t.Status != status.Pending {
+				return fmt.Errorf("%w: ticket is %s", lymbo.ErrInvalidStatusTransition, t.Status)
+			}
+			// WithUpdate writes synchronously, the Kharon isn't running
+			err = kh.Cancel(ctx, tid,
+				lymbo.WithKeep(),
+				lymbo.WithErrorReason(reason),
+				lymbo.WithUpdate(func(_ context.Context, t *lymbo.Ticket) error {
+					now := time.Now()
+					t.Mtime = &now
+					return nil
+				}),
+			)
+			if err != nil {
+				return err
+			}
+			return printTicket(ctx, cmd.OutOrStdout(), kh, tid)
+		},
+	}
+	cmd.Flags().StringVar(&reason, "reason", httpadmin.CancelReason, "error reason of the cancelled ticket")
+	return cmd
+}
+
+func newRetryCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "retry <id>",
+		Short: "Make a ticket pending and due now",
+		Long: `Make a ticket pending and due now. Dead tickets are requeued with their
+attempts reset, the others keep them.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			tid := lymbo.TicketId(args[0])
+			t, err := kh.Get(lymbo.PrimaryContext(ctx), tid)
+			if err != nil {
+				return err
+			}
+			if t.Status == status.Dead {
+				err = kh.RequeueDead(ctx, tid)
+			} else {
+				err = kh.Retry(ctx, tid, lymbo.WithUpdate(func(_ context.Context, t *lymbo.Ticket) error {
+					now := time.Now()
+					t.Status = status.Pending
+					t.Runat = now
+					t.Mtime = &now
+					return nil
+				}))
+			}
+			if err != nil {
+				return err
+			}
+			return printTicket(ctx, cmd.OutOrStdout(), kh, tid)
+		},
+	}
+}
+
+func newRetryFailedCmd(g *globals) *cobra.Command {
+	var (
+		types        []string
+		tenants      []string
+		since        time.Duration
+		keepAttempts bool
+	)
+	cmd := &cobra.Command{
+		Use:   "retry-failed",
+		Short: "Make the failed tickets pending and due now",
+		Long: `Make the failed tickets pending and due now, at once, e.g. after an incident.
+Their attempts are reset unless --keep-attempts is given. Failed tickets whose
+unique key is held by a pending ticket stay failed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			req := lymbo.RetryFailedRequest{Types: types, Tenants: tenants, KeepAttempts: keepAttempts}
+			if since > 0 {
+				req.Failed.From = time.Now().Add(-since)
+			}
+			n, err := kh.RetryFailed(ctx, req)
+			fmt.Fprintf(cmd.OutOrStdout(), "%d tickets retried\n", n)
+			return err
+		},
+	}
+	cmd.Flags().StringSliceVar(&types, "type", nil, "retry only the tickets of these types")
+	cmd.Flags().StringSliceVar(&tenants, "tenant", nil, "retry only the tickets of these tenants")
+	cmd.Flags().DurationVar(&since, "since", 0, "retry only the tickets failed within this duration, e.g. 1h")
+	cmd.Flags().BoolVar(&keepAttempts, "keep-attempts", false, "keep the attempts of the tickets instead of resetting them")
+	return cmd
+}
+
+func newStatsCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats",
+		Short: "Print the number of tickets per type and status",
+		Long: `Print the number of tickets per type and status. Every ticket of the store
+is listed, which may take a while for large stores.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			tickets, err := kh.List(ctx, lymbo.ListRequest{})
+			if err != nil {
+				return err
+			}
+			statuses := []status.Status{status.Pending, status.Done, status.Failed, status.Dead, status.Cancelled}
+			counts := make(map[string]map[status.Status]int)
+			for _, t := range tickets {
+				if counts[t.Type] == nil {
+					counts[t.Type] = make(map[status.Status]int)
+				}
+				counts[t.Type][t.Status]++
+			}
+			types := make([]string, 0, len(counts))
+			for typ := range counts {
+				types = append(types, typ)
+			}
+			sort.Strings(types)
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', tabwriter.AlignRight)
+			fmt.Fprint(w, "TYPE\t")
+			for _, st := range statuses {
+				fmt.Fprintf(w, "%s\t", st)
+			}
+			fmt.Fprintln(w)
+			for _, typ := range types {
+				fmt.Fprintf(w, "%s\t", typ)
+				for _, st := range statuses {
+					fmt.Fprintf(w, "%d\t", counts[typ][st])
+				}
+				fmt.Fprintln(w)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newPauseCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "pause <type>",
+		Short: "Stop polling the tickets of a type, keeping them pending",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			return kh.Pause(ctx, args[0])
+		},
+	}
+}
+
+func newResumeCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume <type>",
+		Short: "Poll the tickets of a paused type again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			return kh.Resume(ctx, args[0])
+		},
+	}
+}
+
+func newPausedCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "paused",
+		Short: "Print the paused types, one per line",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, nil)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			types, err := kh.Paused(ctx)
+			if err != nil {
+				return err
+			}
+			for _, typ := range types {
+				fmt.Fprintln(cmd.OutOrStdout(), typ)
+			}
+			return nil
+		},
+	}
+}
+
+func newExpireCmd(g *globals) *cobra.Command {
+	var (
+		batchSize int
+		retention map[string]string
+	)
+	cmd := &cobra.Command{
+		Use:   "expire",
+		Short: "Remove the expired terminal tickets",
+		Long: `Remove the expired terminal tickets, as the expiration worker of a Kharon
+does, in batches until none is left. Tickets expire once their runat has
+passed, or after the --retention of their status since their last change.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			settings := lymbo.DefaultSettings()
+			for s, d := range retention {
+				st, err := status.FromString(s)
+				if err != nil {
+					return err
+				}
+				dur, err := time.ParseDuration(d)
+				if err != nil {
+					return fmt.Errorf("invalid retention of %s: %w", s, err)
+				}
+				settings.WithRetention(st, dur)
+			}
+
+			ctx := cmd.Context()
+			kh, closeStore, err := g.kharon(ctx, settings)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+
+			n, err := kh.ExpireAll(ctx, batchSize, time.Now())
+			fmt.Fprintf(cmd.OutOrStdout(), "%d tickets expired\n", n)
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&batchSize, "batch", 1000, "number of tickets removed at once")
+	cmd.Flags().StringToStringVar(&retention, "retention", nil, "retention per status, e.g. done=24h,failed=168h")
+	return cmd
+}
+
+// printTicket prints the ticket tid as indented JSON.
+func printTicket(ctx context.Context, w io.Writer, kh *lymbo.Kharon, tid lymbo.TicketId) error {
+	t, err := kh.Get(ctx, tid)
+	if errors.Is(err, lymbo.ErrTicketNotFound) {
+		return fmt.Errorf("ticket %s not found", tid)
+	} else if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(httpadmin.TicketOf(t))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"plugin"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/httpadmin"
+	"github.com/spf13/cobra"
+)
+
+// RegisterSymbol is the function a handler plugin exports, called with the
+// Kharon running the handlers and the router to register them with.
+const RegisterSymbol = "Register"
+
+// maxReason caps the stderr output kept as the ErrorReason of a failed command.
+const maxReason = 4096
+
+type workFlags struct {
+	execs           map[string]string
+	plugins         []string
+	keep            bool
+	workers         int
+	queue           string
+	processTime     time.Duration
+	reactionDelay   time.Duration
+	maxAttempts     int
+	shutdownTimeout time.Duration
+}
+
+func newWorkCmd(g *globals) *cobra.Command {
+	f := &workFlags{}
+	cmd := &cobra.Command{
+		Use:   "work",
+		Short: "Process tickets with commands or plugins until interrupted",
+		Long: `Process tickets until interrupted, then drain the running handlers for up
+to --shutdown-timeout.
+
+--exec type=command runs command with sh -c for each ticket of type, with
+the ticket as JSON on stdin and LYMBO_TICKET_ID, LYMBO_TICKET_TYPE and
+LYMBO_TICKET_ATTEMPTS in its environment. A zero exit status acks the ticket,
+or marks it done with the JSON output of stdout, if any, as result with --keep.
+Any other status leaves the ticket to be retried once its process time and
+backoff have passed, with stderr as error reason, until --max-attempts.
+
+--plugin path loads a Go plugin built with -buildmode=plugin that exports
+	func Register(kh *lymbo.Kharon, r *lymbo.Router) error
+to register its handlers, which settle their tickets themselves.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return work(cmd.Context(), g, f)
+		},
+	}
+	cmd.Flags().StringToStringVar(&f.execs, "exec", nil, "command handling each ticket type, e.g. email=./send.sh")
+	cmd.Flags().StringArrayVar(&f.plugins, "plugin", nil, "Go plugin registering handlers (repeatable)")
+	cmd.Flags().BoolVar(&f.keep, "keep", false, "mark tickets of commands done with their output instead of acking them")
+	cmd.Flags().IntVar(&f.workers, "workers", 0, "number of concurrent handlers, defaults to lymbo's")
+	cmd.Flags().StringVar(&f.queue, "queue", "", "queue to poll, defaults to the default queue")
+	cmd.Flags().DurationVar(&f.processTime, "process-time", 0, "time a handler has before its ticket is redelivered, defaults to lymbo's")
+	cmd.Flags().DurationVar(&f.reactionDelay, "max-reaction-delay", 0, "longest time between polls of an idle queue, defaults to lymbo's")
+	cmd.Flags().IntVar(&f.maxAttempts, "max-attempts", 0, "attempts before a ticket is dead-lettered, 0 for unlimited")
+	cmd.Flags().DurationVar(&f.shutdownTimeout, "shutdown-timeout", 30*time.Second, "time running handlers are waited for on interrupt")
+	return cmd
+}
+
+func work(ctx context.Context, g *globals, f *workFlags) error {
+	if len(f.execs) == 0 && len(f.plugins) == 0 {
+		return errors.New("no handlers given, set --exec or --plugin")
+	}
+
+	settings := lymbo.DefaultSettings().WithQueue(f.queue)
+	if f.workers > 0 {
+		settings.WithWorkers(f.workers)
+	}
+	if f.processTime > 0 {
+		settings.WithProcessTime(f.processTime)
+	}
+	if f.reactionDelay > 0 {
+		settings.WithMaxReactionDelay(f.reactionDelay)
+	}
+	if f.maxAttempts > 0 {
+		settings.WithMaxAttempts(f.maxAttempts)
+	}
+	kh, closeStore, err := g.kharon(ctx, settings)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	r := lymbo.NewRouter()
+	for typ, command := range f.execs {
+		if err := r.Handle(typ, execHandler(kh, command, f.keep)); err != nil {
+			return fmt.Errorf("failed to register command of %s: %w", typ, err)
+		}
+	}
+	for _, path := range f.plugins {
+		if err := loadPlugin(kh, r, path); err != nil {
+			return err
+		}
+	}
+
+	// the context is cancelled on interrupt, Shutdown drains Run instead
+	done := make(chan error, 1)
+	go func() { done <- kh.Run(context.WithoutCancel(ctx), r) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), f.shutdownTimeout)
+	defer cancel()
+	if err := kh.Shutdown(sctx); err != nil {
+		return fmt.Errorf("failed to drain handlers: %w", err)
+	}
+	return <-done
+}
+
+// loadPlugin registers the handlers of the plugin at path with r.
+func loadPlugin(kh *lymbo.Kharon, r *lymbo.Router, path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open plugin: %w", err)
+	}
+	sym, err := p.Lookup(RegisterSymbol)
+	if err != nil {
+		return fmt.Errorf("failed to load plugin %s: %w", path, err)
+	}
+	register, ok := sym.(func(*lymbo.Kharon, *lymbo.Router) error)
+	if !ok {
+		return fmt.Errorf("plugin %s: %s is %T, not func(*lymbo.Kharon, *lymbo.Router) error", path, RegisterSymbol, sym)
+	}
+	if err := register(kh, r); err != nil {
+		return fmt.Errorf("plugin %s: %w", path, err)
+	}
+	return nil
+}
+
+// execHandler runs command for each ticket, see the work command.
+func execHandler(kh *lymbo.Kharon, command string, keep bool) lymbo.HandlerFunc {
+	return func(ctx context.Context, t *lymbo.Ticket) error {
+		in, err := json.Marshal(httpadmin.TicketOf(*t))
+		if err != nil {
+			return kh.Fail(ctx, t.ID, lymbo.WithErrorReason(err.Error()))
+		}
+
+		var stdout, stderr bytes.Buffer
+		c := exec.CommandContext(ctx, "sh", "-c", command)
+		c.Stdin = bytes.NewReader(in)
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+		c.Env = append(os.Environ(),
+			"LYMBO_TICKET_ID="+t.ID.String(),
+			"LYMBO_TICKET_TYPE="+t.Type,
+			"LYMBO_TICKET_ATTEMPTS="+strconv.Itoa(t.Attempts),
+		)
+		if err := c.Run(); err != nil {
+			reason := strings.TrimSpace(stderr.String())
+			if len(reason) > maxReason {
+				reason = reason[len(reason)-maxReason:]
+			}
+			if reason == "" {
+				reason = err.Error()
+			}
+			// the Runat set by the poll redelivers the ticket after its backoff
+			return kh.Retry(ctx, t.ID, lymbo.WithErrorReason(reason))
+		}
+
+		if !keep {
+			return kh.Ack(ctx, t.ID)
+		}
+		var opts []lymbo.Option
+		if out := bytes.TrimSpace(stdout.Bytes()); json.Valid(out) {
+			opts = append(opts, lymbo.WithResult(json.RawMessage(out)))
+		}
+		return kh.Done(ctx, t.ID, opts...)
+	}
+}
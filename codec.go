@@ -0,0 +1,189 @@
+package lymbo
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec serializes ticket payloads.
+type Codec interface {
+	Encode(v any) ([]byte, error)
+	Decode(data []byte, v any) error
+}
+
+var (
+	// JSONCodec encodes payloads as JSON. It is the default codec.
+	// JSON payloads are stored as-is (JSONB in PostgreSQL) and stay queryable.
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec encodes payloads with encoding/gob.
+	GobCodec Codec = gobCodec{}
+)
+
+// Codec returns the payload codec of tickets of type typ, set by
+// Settings.WithCodec, JSONCodec by default.
+func (k *Kharon) Codec(typ string) Codec {
+	if c := k.settings.codecs[typ]; c != nil {
+		return c
+	}
+	return JSONCodec
+}
+
+type codecKey struct{}
+
+// CodecFrom returns the codec of the ticket being processed by the handler
+// of ctx, set by Settings.WithCodec, JSONCodec by default.
+func CodecFrom(ctx context.Context) Codec {
+	if c, ok := ctx.Value(codecKey{}).(Codec); ok {
+		return c
+	}
+	return JSONCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v any) ([]byte, error)    { return json.Marshal(v) }
+func (jsonCodec) Decode(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// encodedPayload holds the output of a non-JSON codec.
+// Stores marshaling payloads to JSON keep it as a base64 string.
+type encodedPayload []byte
+
+// SetPayload encodes v with codec c and sets it as the ticket payload.
+// A nil codec means JSONCodec.
+func SetPayload(t *Ticket, c Codec, v any) error {
+	p, err := encode(c, v, "payload")
+	if err != nil {
+		return err
+	}
+	t.Payload = p
+	return nil
+}
+
+// DecodePayload decodes a payload set with SetPayload and the same codec.
+// It accepts both the in-memory form and the raw JSON returned by JSON-backed
+// stores such as PostgreSQL. A nil codec means JSONCodec.
+func DecodePayload[T any](t *Ticket, c Codec) (T, error) {
+	if t.Payload == nil {
+		var v T
+		return v, ErrPayloadEmpty
+	}
+	return decode[T](t.Payload, c, "payload")
+}
+
+// EncodeResult encodes v with codec c for WithResult, as SetPayload does for
+// payloads. Only needed for codecs other than JSONCodec. A nil codec means JSONCodec.
+func EncodeResult(c Codec, v any) (any, error) {
+	return encode(c, v, "result")
+}
+
+// encode encodes v, the payload or result of a ticket, with codec c.
+func encode(c Codec, v any, what string) (any, error) {
+	if c == nil {
+		c = JSONCodec
+	}
+	data, err := c.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", what, err)
+	}
+	if z, ok := c.(compressingCodec); ok && len(data) > z.threshold {
+		if data, err = compress(z.compression, data); err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", what, err)
+		}
+		return compressedPayload{Compression: z.compression, Data: data}, nil
+	}
+	if isJSON(c) {
+		return json.RawMessage(data), nil
+	}
+	return encodedPayload(data), nil
+}
+
+// isJSON reports whether c encodes as JSON, compressing or not.
+func isJSON(c Codec) bool {
+	_, ok := uncompressed(c).(jsonCodec)
+	return ok
+}
+
+// DecodeResult decodes a result returned by Kharon.GetResult with codec c.
+// With JSONCodec, results set by WithResult as is are accepted, the memory
+// store keeping them unencoded; other codecs need the result encoded by
+// EncodeResult. A nil codec means JSONCodec.
+func DecodeResult[T any](result any, c Codec) (T, error) {
+	var v T
+	if result == nil {
+		return v, ErrResultEmpty
+	}
+	if c == nil {
+		c = JSONCodec
+	}
+	switch result.(type) {
+	case json.RawMessage, encodedPayload, compressedPayload, []byte:
+	default:
+		if isJSON(c) {
+			data, err := json.Marshal(result)
+			if err != nil {
+				return v, fmt.Errorf("failed to encode result: %w", err)
+			}
+			result = json.RawMessage(data)
+		}
+	}
+	return decode[T](result, c, "result")
+}
+
+// decode decodes p, the payload or result of a ticket, with codec c.
+func decode[T any](p any, c Codec, what string) (T, error) {
+	var v T
+	if c == nil {
+		c = JSONCodec
+	}
+
+	var data []byte
+	switch p := p.(type) {
+	case json.RawMessage:
+		data = p
+	case encodedPayload:
+		data = p
+	case compressedPayload:
+		var err error
+		if data, err = p.decompress(); err != nil {
+			return v, fmt.Errorf("failed to decompress %s: %w", what, err)
+		}
+	case []byte:
+		// raw JSON read back from the store
+		data = p
+		if z, ok := compressedFrom(p); ok {
+			var err error
+			if data, err = z.decompress(); err != nil {
+				return v, fmt.Errorf("failed to decompress %s: %w", what, err)
+			}
+		} else if !isJSON(c) {
+			if err := json.Unmarshal(p, &data); err != nil {
+				return v, fmt.Errorf("failed to unwrap encoded %s: %w", what, err)
+			}
+		}
+	default:
+		return v, fmt.Errorf("unsupported %s type %T", what, p)
+	}
+
+	if err := c.Decode(data, &v); err != nil {
+		return v, fmt.Errorf("failed to decode %s: %w", what, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,20 @@
+// Package msgpack provides a MessagePack lymbo.Codec.
+//
+// Usage:
+//
+//	err := lymbo.SetPayload(ticket, msgpack.Codec, payload)
+//	payload, err := lymbo.DecodePayload[MyPayload](ticket, msgpack.Codec)
+package msgpack
+
+import (
+	"github.com/ochaton/lymbo"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes payloads as MessagePack.
+var Codec lymbo.Codec = codec{}
+
+type codec struct{}
+
+func (codec) Encode(v any) ([]byte, error)    { return msgpack.Marshal(v) }
+func (codec) Decode(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
@@ -0,0 +1,49 @@
+// Package protobuf provides a Protocol Buffers lymbo.Codec, for payloads of
+// generated message types.
+//
+// Usage:
+//
+//	err := lymbo.SetPayload(ticket, protobuf.Codec, &pb.Task{UserId: "123"})
+//	task, err := lymbo.DecodePayload[*pb.Task](ticket, protobuf.Codec)
+package protobuf
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ochaton/lymbo"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes payloads, which must be proto.Message, in the binary wire
+// format. It decodes into a message or a pointer to one, allocating it.
+var Codec lymbo.Codec = codec{}
+
+type codec struct{}
+
+func (codec) Encode(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (codec) Decode(data []byte, v any) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	// DecodePayload[*pb.Task] decodes into a **pb.Task
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() && rv.Elem().Kind() == reflect.Pointer {
+		mv := reflect.New(rv.Elem().Type().Elem())
+		if m, ok := mv.Interface().(proto.Message); ok {
+			if err := proto.Unmarshal(data, m); err != nil {
+				return err
+			}
+			rv.Elem().Set(mv)
+			return nil
+		}
+	}
+	return fmt.Errorf("protobuf: can't decode into %T", v)
+}
@@ -0,0 +1,126 @@
+package lymbo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm compressing the payloads of a codec of Compress.
+type Compression string
+
+const (
+	Gzip Compression = "gzip"
+	Zstd Compression = "zstd"
+)
+
+// compressedKey flags the JSON form of a compressedPayload, with the
+// algorithm as value.
+const compressedKey = "$lymbo_compressed"
+
+// compressedPayload is a payload, or result, compressed by a codec of Compress.
+// Stores marshaling payloads to JSON keep it as
+// {"$lymbo_compressed": "gzip", "data": "<base64>"}, so that compressed and
+// uncompressed tickets coexist in a store.
+type compressedPayload struct {
+	Compression Compression `json:"$lymbo_compressed"`
+	Data        []byte      `json:"data"`
+}
+
+type compressingCodec struct {
+	Codec
+	compression Compression
+	threshold   int
+}
+
+// Compress returns codec c compressing with algorithm z the payloads it
+// encodes to more than threshold bytes, e.g. Compress(JSONCodec, Zstd, 4<<10).
+// Compressed payloads are flagged as such in the store: they are decoded by
+// any codec, c or Compress(c, ...), while smaller ones are stored as c alone
+// stores them, JSON payloads staying queryable.
+// Panics if z is neither Gzip nor Zstd.
+func Compress(c Codec, z Compression, threshold int) Codec {
+	if z != Gzip && z != Zstd {
+		panic("kharon: unknown compression " + string(z))
+	}
+	if c == nil {
+		c = JSONCodec
+	}
+	return compressingCodec{Codec: c, compression: z, threshold: threshold}
+}
+
+// uncompressed returns the codec compressed by c, c itself if it doesn't compress.
+func uncompressed(c Codec) Codec {
+	if z, ok := c.(compressingCodec); ok {
+		return z.Codec
+	}
+	return c
+}
+
+// compress returns data compressed with z.
+func compress(z Compression, data []byte) ([]byte, error) {
+	switch z {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", z)
+}
+
+// decompress returns the data of p decompressed.
+func (p compressedPayload) decompress() ([]byte, error) {
+	switch p.Compression {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(p.Data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case Zstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(p.Data, nil)
+	}
+	return nil, fmt.Errorf("unknown compression %q", p.Compression)
+}
+
+// zstd encoders and decoders are safe for concurrent EncodeAll and
+// DecodeAll, and costly to create.
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+// compressedFrom returns the compressed payload of data, raw JSON read back
+// from a store, if it is one.
+func compressedFrom(data []byte) (compressedPayload, bool) {
+	var p compressedPayload
+	if len(data) == 0 || data[0] != '{' || !bytes.Contains(data, []byte(`"`+compressedKey+`"`)) {
+		return p, false
+	}
+	if err := json.Unmarshal(data, &p); err != nil || p.Compression == "" {
+		return p, false
+	}
+	return p, true
+}
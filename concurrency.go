@@ -0,0 +1,63 @@
+package lymbo
+
+import (
+	"context"
+)
+
+// newSlots returns the semaphores of the concurrency limits by ticket type.
+func newSlots(limits map[string]int) map[string]chan struct{} {
+	if len(limits) == 0 {
+		return nil
+	}
+	slots := make(map[string]chan struct{}, len(limits))
+	for typ, n := range limits {
+		slots[typ] = make(chan struct{}, n)
+	}
+	return slots
+}
+
+// freeSlots returns how many more tickets of each concurrency limited type
+// may be dispatched, for PollRequest.LimitPerType.
+func (k *Kharon) freeSlots() map[string]int {
+	if len(k.slots) == 0 {
+		return nil
+	}
+	free := make(map[string]int, len(k.slots))
+	for typ, slots := range k.slots {
+		free[typ] = cap(slots) - len(slots)
+	}
+	return free
+}
+
+// acquire takes a slot for dispatching t if its type is concurrency limited,
+// waiting for one to be released. It reports false if ctx is done first.
+func (k *Kharon) acquire(ctx context.Context, t *Ticket) bool {
+	slots, ok := k.slots[t.Type]
+	if !ok {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release gives back the slot of t taken by acquire, waking the poller if
+// it was the last one, so that the tickets of the type left out of the
+// previous polls are polled without waiting for the next one.
+func (k *Kharon) release(t *Ticket) {
+	slots, ok := k.slots[t.Type]
+	if !ok {
+		return
+	}
+	full := len(slots) == cap(slots)
+	<-slots
+	if full {
+		select {
+		case k.wake <- k.now():
+		default:
+		}
+	}
+}
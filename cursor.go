@@ -0,0 +1,50 @@
+package lymbo
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+)
+
+// Cursor is the position of a ticket in the order of Store.List,
+// by Ctime, then ID.
+type Cursor struct {
+	Ctime time.Time
+	ID    TicketId
+}
+
+// CursorOf returns the position of t.
+func CursorOf(t Ticket) Cursor {
+	return Cursor{Ctime: t.Ctime, ID: t.ID}
+}
+
+// Precedes reports whether the ticket at c comes before t in the list order.
+func (c Cursor) Precedes(t Ticket) bool {
+	if cmp := c.Ctime.Compare(t.Ctime); cmp != 0 {
+		return cmp < 0
+	}
+	return c.ID < t.ID
+}
+
+// String returns c as an opaque, URL-safe token, see ParseCursor.
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Ctime.UTC().Format(time.RFC3339Nano) + " " + string(c.ID)))
+}
+
+// ParseCursor parses a cursor formatted by Cursor.String.
+// Returns ErrCursorInvalid if s isn't one.
+func ParseCursor(s string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrCursorInvalid
+	}
+	ctime, id, ok := strings.Cut(string(data), " ")
+	if !ok {
+		return Cursor{}, ErrCursorInvalid
+	}
+	t, err := time.Parse(time.RFC3339Nano, ctime)
+	if err != nil {
+		return Cursor{}, ErrCursorInvalid
+	}
+	return Cursor{Ctime: t, ID: TicketId(id)}, nil
+}
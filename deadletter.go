@@ -0,0 +1,78 @@
+package lymbo
+
+import (
+	"context"
+
+	"github.com/ochaton/lymbo/status"
+)
+
+// purgeBatch is the number of dead tickets PurgeDead deletes at once.
+const purgeBatch = 1000
+
+// ListDead returns up to limit dead-lettered tickets, i.e. tickets that ran out
+// of attempts (see Settings.WithMaxAttempts), oldest first. 0 means all of them.
+func (k *Kharon) ListDead(ctx context.Context, limit int) ([]Ticket, error) {
+	return k.store.List(ctx, ListRequest{Status: &status.Dead, Limit: limit})
+}
+
+// RequeueDead moves a dead-lettered ticket back to pending, due now with its
+// attempts reset, e.g. once the failure was fixed. opts apply as for Retry.
+// Returns ErrInvalidStatusTransition if the ticket isn't dead.
+func (k *Kharon) RequeueDead(ctx context.Context, tid TicketId, opts ...Option) error {
+	o := toOpts(&Opts{keep: true, status: &status.Pending}, opts...)
+	err := k.store.Update(ctx, tid, func(ctx context.Context, t *Ticket) error {
+		if t.Status != status.Dead {
+			return ErrInvalidStatusTransition
+		}
+		now := k.now()
+		t.Attempts = 0
+		t.Runat = now
+		t.Mtime = &now
+		return k.beforeUpdate(ctx, t, o)
+	})
+	if err != nil {
+		return err
+	}
+	k.audit(ctx, status.Pending, "requeued from the dead letters", tid)
+	k.stats.retried.value.Add(1)
+	return nil
+}
+
+// RetryFailed moves the failed tickets selected by req back to pending, due
+// now unless req.Now is set, with their attempts reset unless
+// req.KeepAttempts, e.g. to recover the tickets that failed during an
+// incident, and returns how many it retried. See Store.RetryFailed for the
+// tickets holding a UniqueKey.
+func (k *Kharon) RetryFailed(ctx context.Context, req RetryFailedRequest) (int, error) {
+	if req.Now.IsZero() {
+		req.Now = k.now()
+	}
+	n, err := k.store.RetryFailed(ctx, req)
+	k.stats.retried.value.Add(int64(n))
+	return n, err
+}
+
+// PurgeDead deletes every dead-lettered ticket and returns how many were deleted.
+func (k *Kharon) PurgeDead(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		dead, err := k.ListDead(ctx, purgeBatch)
+		if err != nil || len(dead) == 0 {
+			return total, err
+		}
+
+		ids := make([]TicketId, len(dead))
+		for i, t := range dead {
+			ids[i] = t.ID
+		}
+		if err := k.store.DeleteBatch(ctx, ids); err != nil {
+			return total, err
+		}
+		total += len(ids)
+		k.stats.deleted.value.Add(int64(len(ids)))
+
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package lymbo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ochaton/lymbo/status"
+)
+
+// overdue reports whether t is past its deadline at now.
+func overdue(t Ticket, now time.Time) bool {
+	return t.Deadline != nil && !t.Deadline.After(now)
+}
+
+// expireOverdue settles up to limit pending tickets past their deadline.
+// Returns the number of tickets settled, also on error. Errors are logged.
+func (k *Kharon) expireOverdue(ctx context.Context, now time.Time, limit int) (int, error) {
+	tickets, err := k.store.ListOverdue(ctx, now, limit)
+	if err != nil {
+		k.logger.ErrorContext(ctx, "error listing overdue tickets", "error", err)
+		return 0, err
+	}
+
+	n := 0
+	var errs []error
+	for _, t := range tickets {
+		settled, err := k.settleOverdue(ctx, t.ID, now)
+		if err != nil {
+			errs = append(errs, err)
+		} else if settled {
+			n++
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// settleOverdue fails, or cancels per WithDeadlineStatus, the ticket tid if
+// it is still pending past its deadline, reporting whether it did. The
+// transition is written synchronously, so that a handler settling the ticket
+// meanwhile wins. Errors are logged.
+func (k *Kharon) settleOverdue(ctx context.Context, tid TicketId, now time.Time) (bool, error) {
+	st := k.settings.deadlineStatus
+	var settled bool
+	var ticket Ticket
+	err := k.store.Update(ctx, tid, func(_ context.Context, t *Ticket) error {
+		if t.Status != status.Pending || !overdue(*t, now) {
+			return nil
+		}
+		runat := now.Add(InfinityDelay.fixed.duration)
+		t.Status = st
+		t.SetErrorReason(&ErrorInfo{Message: ErrDeadlineExceeded.Error(), OccurredAt: now})
+		t.Runat = runat
+		t.Mtime = &now
+		settled = true
+		ticket = *t
+		return nil
+	})
+	if errors.Is(err, ErrTicketNotFound) {
+		// removed meanwhile
+		return false, nil
+	}
+	if err != nil {
+		k.logger.ErrorContext(ctx, "error settling overdue ticket",
+			"ticket_id", tid,
+			"error", err,
+		)
+		return false, err
+	}
+	if !settled {
+		return false, nil
+	}
+
+	k.audit(ctx, st, ErrDeadlineExceeded.Error(), tid)
+	if st == status.Cancelled {
+		k.stats.canceled.add(1, &ticket)
+	} else {
+		k.stats.failed.add(1, &ticket)
+		emit(ctx, k.events, TicketFailed{ID: tid, Ticket: &ticket, ErrorReason: ticket.ErrorReason})
+	}
+	k.logger.WarnContext(ctx, "ticket exceeded its deadline",
+		"ticket_id", tid,
+		"status", st,
+	)
+	return true, nil
+}
@@ -0,0 +1,103 @@
+package lymbo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrorInfo describes why a ticket failed, was retried or cancelled. It is
+// the ErrorReason of tickets, serialized as JSON by the stores.
+type ErrorInfo struct {
+	// Message is the error message.
+	Message string `json:"message"`
+	// Code is an optional machine-readable error code, e.g. "upstream_timeout".
+	Code string `json:"code,omitempty"`
+	// Stack is the stack trace of the failure, set for handler panics.
+	Stack string `json:"stack,omitempty"`
+	// OccurredAt is when the failure was reported.
+	OccurredAt time.Time `json:"occurred_at,omitzero"`
+	// Attempt is the attempt of the ticket that failed, see Ticket.Attempts.
+	Attempt int `json:"attempt,omitempty"`
+	// History are the previous failures of the ticket, oldest first, kept
+	// across retries. Their own History is always empty.
+	History []ErrorInfo `json:"history,omitempty"`
+}
+
+// Error returns the message, so that handlers can return an *ErrorInfo
+// to set the Code of the ErrorReason their error is recorded with.
+func (e *ErrorInfo) Error() string {
+	return e.Message
+}
+
+// UnmarshalJSON decodes an ErrorInfo, or the message of a plain JSON string as
+// stored by earlier versions. Other JSON values are kept verbatim as the message.
+func (e *ErrorInfo) UnmarshalJSON(data []byte) error {
+	var msg string
+	if err := json.Unmarshal(data, &msg); err == nil {
+		*e = ErrorInfo{Message: msg}
+		return nil
+	}
+	type plain ErrorInfo
+	if err := json.Unmarshal(data, (*plain)(e)); err == nil {
+		return nil
+	}
+	*e = ErrorInfo{Message: string(data)}
+	return nil
+}
+
+// errorInfo converts the reason given to WithErrorReason to an ErrorInfo:
+// an ErrorInfo is taken as is, an error wrapping an *ErrorInfo as that one,
+// and anything else is formatted as the message. OccurredAt defaults to now.
+func errorInfo(reason any) *ErrorInfo {
+	var info ErrorInfo
+	switch r := reason.(type) {
+	case nil:
+		return nil
+	case ErrorInfo:
+		info = r
+	case *ErrorInfo:
+		if r == nil {
+			return nil
+		}
+		info = *r
+	case error:
+		var target *ErrorInfo
+		if errors.As(r, &target) {
+			info = *target
+			info.Message = r.Error()
+		} else {
+			info = ErrorInfo{Message: r.Error()}
+		}
+	case string:
+		info = ErrorInfo{Message: r}
+	default:
+		info = ErrorInfo{Message: fmt.Sprint(r)}
+	}
+	if info.OccurredAt.IsZero() {
+		info.OccurredAt = time.Now()
+	}
+	return &info
+}
+
+// SetErrorReason records e as the ErrorReason of t, moving the previous one
+// to its History. The Attempt of e defaults to t.Attempts. A nil e is ignored.
+func (t *Ticket) SetErrorReason(e *ErrorInfo) {
+	if e == nil {
+		return
+	}
+	next := *e
+	if next.Attempt == 0 {
+		next.Attempt = t.Attempts
+	}
+	next.History = nil
+	if prev := t.ErrorReason; prev != nil {
+		next.History = make([]ErrorInfo, 0, len(prev.History)+1)
+		next.History = append(next.History, prev.History...)
+		last := *prev
+		last.History = nil
+		next.History = append(next.History, last)
+	}
+	t.ErrorReason = &next
+}
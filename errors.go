@@ -10,4 +10,14 @@ var (
 	ErrTicketIDInvalid         = errors.New("ticket ID is invalid")
 	ErrTicketNotFound          = errors.New("ticket not found")
 	ErrInvalidStatusTransition = errors.New("invalid status transition")
+	ErrPayloadEmpty            = errors.New("ticket payload is empty")
+	ErrLeaseLost               = errors.New("ticket lease lost")
+	ErrNotifyDisabled          = errors.New("store notifications are disabled")
+	ErrSearchUnsupported       = errors.New("store doesn't support payload search")
+	ErrAuditDisabled           = errors.New("audit log is disabled")
+	ErrDuplicateTicket         = errors.New("duplicate ticket")
+	ErrResultNotReady          = errors.New("ticket result is not ready")
+	ErrResultEmpty             = errors.New("ticket result is empty")
+	ErrCursorInvalid           = errors.New("list cursor is invalid")
+	ErrDeadlineExceeded        = errors.New("deadline exceeded")
 )
@@ -0,0 +1,110 @@
+package lymbo
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// TicketAdded is emitted for every ticket put by the Kharon, follow-up tickets
+// of AckAndAdd and FailAndAdd included.
+type TicketAdded struct {
+	Ticket Ticket
+}
+
+// TicketPolled is emitted for every ticket claimed by the poller, before it
+// is sent to a worker.
+type TicketPolled struct {
+	Ticket Ticket
+}
+
+// TicketAcked is emitted for every ticket acknowledged with Ack or AckAndAdd.
+type TicketAcked struct {
+	ID TicketId
+	// Ticket is the ticket as delivered to its handler, nil if it was acked
+	// outside of a handler processing it.
+	Ticket *Ticket
+}
+
+// TicketFailed is emitted for every ticket marked as failed with Fail or
+// FailAndAdd, or failed for exceeding its deadline.
+type TicketFailed struct {
+	ID TicketId
+	// Ticket is the ticket as delivered to its handler, or as failed for its
+	// deadline, nil if it was failed outside of a handler processing it.
+	Ticket      *Ticket
+	ErrorReason *ErrorInfo
+}
+
+// TicketsExpired is emitted for every batch of tickets removed by expiration.
+// Stores only report how many tickets they removed.
+type TicketsExpired struct {
+	Count int64
+}
+
+// Events dispatches the lifecycle events of a Kharon to their subscribers,
+// see Subscribe.
+type Events struct {
+	mu   sync.RWMutex
+	next int
+	subs map[reflect.Type][]subscriber
+}
+
+type subscriber struct {
+	id int
+	fn any // func(context.Context, E)
+}
+
+// Events returns the lifecycle events of k, to Subscribe to.
+func (k *Kharon) Events() *Events {
+	return k.events
+}
+
+// Subscribe registers fn to be called with every event of type E emitted by
+// ev, one of TicketAdded, TicketPolled, TicketAcked, TicketFailed or
+// TicketsExpired, e.g. for audit logging. fn runs synchronously on the
+// goroutine of the operation emitting the event, so it should not block.
+// Events follow the operation; outcomes written in batches may still be
+// queued when theirs are emitted. The returned function unsubscribes fn.
+func Subscribe[E any](ev *Events, fn func(context.Context, E)) (unsubscribe func()) {
+	typ := reflect.TypeFor[E]()
+
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	if ev.subs == nil {
+		ev.subs = make(map[reflect.Type][]subscriber)
+	}
+	id := ev.next
+	ev.next++
+	ev.subs[typ] = append(ev.subs[typ], subscriber{id: id, fn: fn})
+
+	return func() {
+		ev.mu.Lock()
+		defer ev.mu.Unlock()
+		ev.subs[typ] = slices.DeleteFunc(slices.Clone(ev.subs[typ]), func(s subscriber) bool {
+			return s.id == id
+		})
+	}
+}
+
+// emit calls the subscribers of events of type E with e, in the order they subscribed.
+func emit[E any](ctx context.Context, ev *Events, e E) {
+	ev.mu.RLock()
+	// unsubscribing replaces the slice, so it can be iterated unlocked
+	subs := ev.subs[reflect.TypeFor[E]()]
+	ev.mu.RUnlock()
+
+	for _, s := range subs {
+		s.fn.(func(context.Context, E))(ctx, e)
+	}
+}
+
+// copyOf returns a copy of t, nil if t is nil.
+func copyOf(t *Ticket) *Ticket {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -0,0 +1,334 @@
+// Package grpc exposes the enqueue and management of the tickets of a Kharon
+// over gRPC, so that services written in any language can push work into its
+// store through a lymbo server process. The service is defined by
+// lymbopb/lymbo.proto, for clients to generate their stubs from.
+//
+// Usage:
+//
+//	kh := lymbo.NewKharon(store, settings, logger)
+//	s := grpc.NewServer() // google.golang.org/grpc
+//	lymbopb.RegisterLymboServer(s, lymbogrpc.NewServer(kh, lymbogrpc.Config{}))
+//	s.Serve(lis)
+//
+// Errors of the store map to gRPC codes: NOT_FOUND for a missing ticket,
+// INVALID_ARGUMENT for an invalid ID, type, payload or filter,
+// FAILED_PRECONDITION for an invalid status transition and ALREADY_EXISTS
+// for a duplicate unique key. The server doesn't authenticate requests:
+// install the interceptors of the application.
+package grpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative lymbopb/lymbo.proto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	rpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/grpc/lymbopb"
+	"github.com/ochaton/lymbo/status"
+)
+
+// DefaultListLimit caps the tickets of a page of a list when Config.ListLimit is 0.
+const DefaultListLimit = 1000
+
+// CancelReason is the ErrorReason of tickets cancelled without a reason.
+const CancelReason = "cancelled by operator"
+
+type Config struct {
+	// ListLimit caps the number of tickets a page of a list returns, and is
+	// the limit of lists without one. Defaults to DefaultListLimit.
+	ListLimit int
+}
+
+// Server serves the Lymbo service of a Kharon.
+type Server struct {
+	lymbopb.UnimplementedLymboServer
+
+	kh        *lymbo.Kharon
+	listLimit int
+}
+
+var _ lymbopb.LymboServer = (*Server)(nil)
+
+func NewServer(kh *lymbo.Kharon, cfg Config) *Server {
+	if cfg.ListLimit <= 0 {
+		cfg.ListLimit = DefaultListLimit
+	}
+	return &Server{kh: kh, listLimit: cfg.ListLimit}
+}
+
+// Enqueue puts the ticket of the request, its ID defaulting to a new UUIDv7.
+func (s *Server) Enqueue(ctx context.Context, req *lymbopb.EnqueueRequest) (*lymbopb.Ticket, error) {
+	id := lymbo.TicketId(req.GetId())
+	if id == "" {
+		u, err := uuid.NewV7()
+		if err != nil {
+			return nil, storeError(err)
+		}
+		id = lymbo.TicketId(u.String())
+	}
+	t, err := lymbo.NewTicket(id, req.GetType())
+	if err != nil {
+		return nil, storeError(err)
+	}
+	t.Queue = req.GetQueue()
+	t.UniqueKey = req.GetUniqueKey()
+	t.Labels = req.GetLabels()
+	t.Metadata = req.GetMetadata()
+	for _, dep := range req.GetDependsOn() {
+		t.DependsOn = append(t.DependsOn, lymbo.TicketId(dep))
+	}
+	if req.Runat != nil {
+		t.Runat = req.GetRunat().AsTime()
+	}
+	if req.Deadline != nil {
+		d := req.GetDeadline().AsTime()
+		t.Deadline = &d
+	}
+	if req.Nice != nil {
+		t.Nice = int(req.GetNice())
+	}
+	if p := req.GetPayload(); len(p) > 0 {
+		if !json.Valid(p) {
+			return nil, rpcstatus.Error(codes.InvalidArgument, "payload is not JSON")
+		}
+		t.Payload = json.RawMessage(p)
+	}
+
+	// Put sets the creation time, which is returned
+	now := s.kh.Now()
+	if err := s.kh.Put(ctx, *t, lymbo.WithCtime(now)); err != nil {
+		return nil, storeError(err)
+	}
+	t.Ctime = now
+	return ticketOf(*t), nil
+}
+
+func (s *Server) Get(ctx context.Context, req *lymbopb.GetRequest) (*lymbopb.Ticket, error) {
+	t, err := s.kh.Get(ctx, lymbo.TicketId(req.GetId()))
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return ticketOf(t), nil
+}
+
+// Cancel marks a pending ticket as cancelled, with the reason of the request
+// as ErrorReason, and keeps it for inspection.
+func (s *Server) Cancel(ctx context.Context, req *lymbopb.CancelRequest) (*lymbopb.Ticket, error) {
+	tid := lymbo.TicketId(req.GetId())
+	reason := req.GetReason()
+	if reason == "" {
+		reason = CancelReason
+	}
+	// Cancel sets the status before calling the update, so it's checked first
+	t, err := s.kh.Get(ctx, tid)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	if t.Status != status.Pending {
+		return nil, storeError(fmt.Errorf("%w: ticket is %s", lymbo.ErrInvalidStatusTransition, t.Status))
+	}
+	err = s.kh.Cancel(ctx, tid,
+		lymbo.WithKeep(),
+		lymbo.WithErrorReason(reason),
+		lymbo.WithUpdate(func(_ context.Context, t *lymbo.Ticket) error {
+			now := s.kh.Now()
+			t.Mtime = &now
+			return nil
+		}),
+	)
+	return s.respond(ctx, tid, err)
+}
+
+// Retry makes a ticket pending and due now. Dead tickets are requeued with
+// their attempts reset, as Kharon.RequeueDead does, the others keep them.
+func (s *Server) Retry(ctx context.Context, req *lymbopb.RetryRequest) (*lymbopb.Ticket, error) {
+	tid := lymbo.TicketId(req.GetId())
+	t, err := s.kh.Get(ctx, tid)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	if t.Status == status.Dead {
+		err = s.kh.RequeueDead(ctx, tid)
+	} else {
+		// the update is written synchronously, even if kh isn't running
+		err = s.kh.Retry(ctx, tid, lymbo.WithUpdate(func(_ context.Context, t *lymbo.Ticket) error {
+			now := s.kh.Now()
+			t.Status = status.Pending
+			t.Runat = now
+			t.Mtime = &now
+			return nil
+		}))
+	}
+	return s.respond(ctx, tid, err)
+}
+
+// List returns a page of the tickets selected by the request, following
+// the one of its cursor.
+func (s *Server) List(ctx context.Context, req *lymbopb.ListRequest) (*lymbopb.ListResponse, error) {
+	lr := lymbo.ListRequest{Types: req.GetTypes(), Limit: s.listLimit}
+	if st := req.GetStatus(); st != "" {
+		v, err := status.FromString(st)
+		if err != nil {
+			return nil, rpcstatus.Error(codes.InvalidArgument, err.Error())
+		}
+		lr.Status = &v
+	}
+	if n := req.GetLimit(); n < 0 {
+		return nil, rpcstatus.Error(codes.InvalidArgument, "limit must not be negative")
+	} else if n > 0 {
+		lr.Limit = min(int(n), s.listLimit)
+	}
+	if c := req.GetCursor(); c != "" {
+		cursor, err := lymbo.ParseCursor(c)
+		if err != nil {
+			return nil, rpcstatus.Error(codes.InvalidArgument, err.Error())
+		}
+		lr.After = &cursor
+	}
+	for _, p := range []struct {
+		ts  *timestamppb.Timestamp
+		dst *time.Time
+	}{
+		{req.GetCreatedFrom(), &lr.Created.From},
+		{req.GetCreatedTo(), &lr.Created.To},
+		{req.GetRunatFrom(), &lr.Runat.From},
+		{req.GetRunatTo(), &lr.Runat.To},
+	} {
+		if p.ts != nil {
+			*p.dst = p.ts.AsTime()
+		}
+	}
+
+	tickets, err := s.kh.List(ctx, lr)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	out := &lymbopb.ListResponse{Tickets: make([]*lymbopb.Ticket, 0, len(tickets))}
+	for _, t := range tickets {
+		out.Tickets = append(out.Tickets, ticketOf(t))
+	}
+	if next := lr.Next(tickets); next != nil {
+		out.NextCursor = next.String()
+	}
+	return out, nil
+}
+
+// Stats returns lymbo.Stats in its JSON form.
+func (s *Server) Stats(context.Context, *lymbopb.StatsRequest) (*lymbopb.StatsResponse, error) {
+	data, err := json.Marshal(s.kh.Stats())
+	if err != nil {
+		return nil, storeError(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, storeError(err)
+	}
+	stats, err := structpb.NewStruct(m)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return &lymbopb.StatsResponse{Stats: stats}, nil
+}
+
+// respond returns err, or the ticket tid once changed.
+func (s *Server) respond(ctx context.Context, tid lymbo.TicketId, err error) (*lymbopb.Ticket, error) {
+	if err != nil {
+		return nil, storeError(err)
+	}
+	return s.Get(ctx, &lymbopb.GetRequest{Id: tid.String()})
+}
+
+// ticketOf returns the protobuf form of t. Payload and Result are JSON,
+// inlined as is if they are JSON already, as read back from most stores.
+func ticketOf(t lymbo.Ticket) *lymbopb.Ticket {
+	pt := &lymbopb.Ticket{
+		Id:        t.ID.String(),
+		Status:    t.Status.String(),
+		Type:      t.Type,
+		Queue:     t.Queue,
+		Runat:     timestamppb.New(t.Runat),
+		Nice:      int32(t.Nice),
+		Ctime:     timestamppb.New(t.Ctime),
+		Attempts:  int32(t.Attempts),
+		Owner:     t.Owner,
+		UniqueKey: t.UniqueKey,
+		Labels:    t.Labels,
+		Metadata:  t.Metadata,
+		Payload:   rawJSON(t.Payload),
+		Result:    rawJSON(t.Result),
+	}
+	if t.Deadline != nil {
+		pt.Deadline = timestamppb.New(*t.Deadline)
+	}
+	if t.Mtime != nil {
+		pt.Mtime = timestamppb.New(*t.Mtime)
+	}
+	if e := t.ErrorReason; e != nil {
+		pt.ErrorReason = &lymbopb.ErrorInfo{
+			Message: e.Message,
+			Code:    e.Code,
+			Stack:   e.Stack,
+			Attempt: int32(e.Attempt),
+		}
+		if !e.OccurredAt.IsZero() {
+			pt.ErrorReason.OccurredAt = timestamppb.New(e.OccurredAt)
+		}
+	}
+	for _, dep := range t.DependsOn {
+		pt.DependsOn = append(pt.DependsOn, dep.String())
+	}
+	return pt
+}
+
+// rawJSON returns v as JSON: raw JSON as is, and other values marshaled,
+// e.g. bytes that aren't JSON as a base64 string.
+func rawJSON(v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case json.RawMessage:
+		if json.Valid(v) {
+			return v
+		}
+	case []byte:
+		if json.Valid(v) {
+			return v
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(err.Error())
+	}
+	return data
+}
+
+// storeError maps the errors of the store to gRPC statuses.
+func storeError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, lymbo.ErrTicketNotFound):
+		code = codes.NotFound
+	case errors.Is(err, lymbo.ErrTicketIDInvalid), errors.Is(err, lymbo.ErrTicketIDEmpty),
+		errors.Is(err, lymbo.ErrTidEmpty), errors.Is(err, lymbo.ErrTypeEmpty):
+		code = codes.InvalidArgument
+	case errors.Is(err, lymbo.ErrInvalidStatusTransition):
+		code = codes.FailedPrecondition
+	case errors.Is(err, lymbo.ErrDuplicateTicket):
+		code = codes.AlreadyExists
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return rpcstatus.Error(code, err.Error())
+}
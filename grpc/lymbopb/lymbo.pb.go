@@ -0,0 +1,982 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: lymbo.proto
+
+// Remote enqueue and management of the tickets of a lymbo Kharon,
+// served by the github.com/ochaton/lymbo/grpc package.
+
+package lymbopb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Ticket struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// pending, done, failed, cancelled or dead.
+	Status    string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Type      string                 `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	Queue     string                 `protobuf:"bytes,4,opt,name=queue,proto3" json:"queue,omitempty"`
+	Runat     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=runat,proto3" json:"runat,omitempty"`
+	Deadline  *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	Nice      int32                  `protobuf:"varint,7,opt,name=nice,proto3" json:"nice,omitempty"`
+	Ctime     *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=ctime,proto3" json:"ctime,omitempty"`
+	Mtime     *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=mtime,proto3" json:"mtime,omitempty"`
+	Attempts  int32                  `protobuf:"varint,10,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	Owner     string                 `protobuf:"bytes,11,opt,name=owner,proto3" json:"owner,omitempty"`
+	UniqueKey string                 `protobuf:"bytes,12,opt,name=unique_key,json=uniqueKey,proto3" json:"unique_key,omitempty"`
+	Labels    map[string]string      `protobuf:"bytes,13,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Metadata  map[string]string      `protobuf:"bytes,14,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// JSON, as stored.
+	Payload     []byte     `protobuf:"bytes,15,opt,name=payload,proto3" json:"payload,omitempty"`
+	ErrorReason *ErrorInfo `protobuf:"bytes,16,opt,name=error_reason,json=errorReason,proto3" json:"error_reason,omitempty"`
+	// JSON, as stored.
+	Result        []byte   `protobuf:"bytes,17,opt,name=result,proto3" json:"result,omitempty"`
+	DependsOn     []string `protobuf:"bytes,18,rep,name=depends_on,json=dependsOn,proto3" json:"depends_on,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ticket) Reset() {
+	*x = Ticket{}
+	mi := &file_lymbo_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ticket) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ticket) ProtoMessage() {}
+
+func (x *Ticket) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ticket.ProtoReflect.Descriptor instead.
+func (*Ticket) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Ticket) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Ticket) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Ticket) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Ticket) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *Ticket) GetRunat() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Runat
+	}
+	return nil
+}
+
+func (x *Ticket) GetDeadline() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Deadline
+	}
+	return nil
+}
+
+func (x *Ticket) GetNice() int32 {
+	if x != nil {
+		return x.Nice
+	}
+	return 0
+}
+
+func (x *Ticket) GetCtime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Ctime
+	}
+	return nil
+}
+
+func (x *Ticket) GetMtime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Mtime
+	}
+	return nil
+}
+
+func (x *Ticket) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *Ticket) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+func (x *Ticket) GetUniqueKey() string {
+	if x != nil {
+		return x.UniqueKey
+	}
+	return ""
+}
+
+func (x *Ticket) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Ticket) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *Ticket) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *Ticket) GetErrorReason() *ErrorInfo {
+	if x != nil {
+		return x.ErrorReason
+	}
+	return nil
+}
+
+func (x *Ticket) GetResult() []byte {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *Ticket) GetDependsOn() []string {
+	if x != nil {
+		return x.DependsOn
+	}
+	return nil
+}
+
+type ErrorInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Code          string                 `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	Stack         string                 `protobuf:"bytes,3,opt,name=stack,proto3" json:"stack,omitempty"`
+	OccurredAt    *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=occurred_at,json=occurredAt,proto3" json:"occurred_at,omitempty"`
+	Attempt       int32                  `protobuf:"varint,5,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ErrorInfo) Reset() {
+	*x = ErrorInfo{}
+	mi := &file_lymbo_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ErrorInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ErrorInfo) ProtoMessage() {}
+
+func (x *ErrorInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ErrorInfo.ProtoReflect.Descriptor instead.
+func (*ErrorInfo) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ErrorInfo) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ErrorInfo) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *ErrorInfo) GetStack() string {
+	if x != nil {
+		return x.Stack
+	}
+	return ""
+}
+
+func (x *ErrorInfo) GetOccurredAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.OccurredAt
+	}
+	return nil
+}
+
+func (x *ErrorInfo) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+type EnqueueRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Defaults to a new UUIDv7.
+	Id    string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Type  string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Queue string `protobuf:"bytes,3,opt,name=queue,proto3" json:"queue,omitempty"`
+	// Defaults to now.
+	Runat    *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=runat,proto3" json:"runat,omitempty"`
+	Deadline *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	// Defaults to the nice of new tickets.
+	Nice      *int32            `protobuf:"varint,6,opt,name=nice,proto3,oneof" json:"nice,omitempty"`
+	UniqueKey string            `protobuf:"bytes,7,opt,name=unique_key,json=uniqueKey,proto3" json:"unique_key,omitempty"`
+	Labels    map[string]string `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Metadata  map[string]string `protobuf:"bytes,9,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	DependsOn []string          `protobuf:"bytes,10,rep,name=depends_on,json=dependsOn,proto3" json:"depends_on,omitempty"`
+	// JSON.
+	Payload       []byte `protobuf:"bytes,11,opt,name=payload,proto3" json:"payload,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnqueueRequest) Reset() {
+	*x = EnqueueRequest{}
+	mi := &file_lymbo_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnqueueRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnqueueRequest) ProtoMessage() {}
+
+func (x *EnqueueRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnqueueRequest.ProtoReflect.Descriptor instead.
+func (*EnqueueRequest) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *EnqueueRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *EnqueueRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *EnqueueRequest) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *EnqueueRequest) GetRunat() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Runat
+	}
+	return nil
+}
+
+func (x *EnqueueRequest) GetDeadline() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Deadline
+	}
+	return nil
+}
+
+func (x *EnqueueRequest) GetNice() int32 {
+	if x != nil && x.Nice != nil {
+		return *x.Nice
+	}
+	return 0
+}
+
+func (x *EnqueueRequest) GetUniqueKey() string {
+	if x != nil {
+		return x.UniqueKey
+	}
+	return ""
+}
+
+func (x *EnqueueRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *EnqueueRequest) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *EnqueueRequest) GetDependsOn() []string {
+	if x != nil {
+		return x.DependsOn
+	}
+	return nil
+}
+
+func (x *EnqueueRequest) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+type GetRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRequest) Reset() {
+	*x = GetRequest{}
+	mi := &file_lymbo_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRequest) ProtoMessage() {}
+
+func (x *GetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRequest.ProtoReflect.Descriptor instead.
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type CancelRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Defaults to "cancelled by operator".
+	Reason        string `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelRequest) Reset() {
+	*x = CancelRequest{}
+	mi := &file_lymbo_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelRequest) ProtoMessage() {}
+
+func (x *CancelRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelRequest.ProtoReflect.Descriptor instead.
+func (*CancelRequest) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CancelRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CancelRequest) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type RetryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RetryRequest) Reset() {
+	*x = RetryRequest{}
+	mi := &file_lymbo_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RetryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RetryRequest) ProtoMessage() {}
+
+func (x *RetryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RetryRequest.ProtoReflect.Descriptor instead.
+func (*RetryRequest) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RetryRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ListRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Empty for every status.
+	Status      string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Types       []string               `protobuf:"bytes,2,rep,name=types,proto3" json:"types,omitempty"`
+	CreatedFrom *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=created_from,json=createdFrom,proto3" json:"created_from,omitempty"`
+	CreatedTo   *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_to,json=createdTo,proto3" json:"created_to,omitempty"`
+	RunatFrom   *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=runat_from,json=runatFrom,proto3" json:"runat_from,omitempty"`
+	RunatTo     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=runat_to,json=runatTo,proto3" json:"runat_to,omitempty"`
+	// Capped by the server, which defaults to its cap.
+	Limit int32 `protobuf:"varint,7,opt,name=limit,proto3" json:"limit,omitempty"`
+	// The next_cursor of the previous page.
+	Cursor        string `protobuf:"bytes,8,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRequest) Reset() {
+	*x = ListRequest{}
+	mi := &file_lymbo_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRequest) ProtoMessage() {}
+
+func (x *ListRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRequest.ProtoReflect.Descriptor instead.
+func (*ListRequest) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ListRequest) GetTypes() []string {
+	if x != nil {
+		return x.Types
+	}
+	return nil
+}
+
+func (x *ListRequest) GetCreatedFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedFrom
+	}
+	return nil
+}
+
+func (x *ListRequest) GetCreatedTo() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedTo
+	}
+	return nil
+}
+
+func (x *ListRequest) GetRunatFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RunatFrom
+	}
+	return nil
+}
+
+func (x *ListRequest) GetRunatTo() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RunatTo
+	}
+	return nil
+}
+
+func (x *ListRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+type ListResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Tickets []*Ticket              `protobuf:"bytes,1,rep,name=tickets,proto3" json:"tickets,omitempty"`
+	// Empty on the last page.
+	NextCursor    string `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListResponse) Reset() {
+	*x = ListResponse{}
+	mi := &file_lymbo_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListResponse) ProtoMessage() {}
+
+func (x *ListResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListResponse.ProtoReflect.Descriptor instead.
+func (*ListResponse) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListResponse) GetTickets() []*Ticket {
+	if x != nil {
+		return x.Tickets
+	}
+	return nil
+}
+
+func (x *ListResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+type StatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	mi := &file_lymbo_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{8}
+}
+
+type StatsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The JSON form of lymbo.Stats.
+	Stats         *structpb.Struct `protobuf:"bytes,1,opt,name=stats,proto3" json:"stats,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsResponse) Reset() {
+	*x = StatsResponse{}
+	mi := &file_lymbo_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsResponse) ProtoMessage() {}
+
+func (x *StatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_lymbo_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsResponse.ProtoReflect.Descriptor instead.
+func (*StatsResponse) Descriptor() ([]byte, []int) {
+	return file_lymbo_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *StatsResponse) GetStats() *structpb.Struct {
+	if x != nil {
+		return x.Stats
+	}
+	return nil
+}
+
+var File_lymbo_proto protoreflect.FileDescriptor
+
+const file_lymbo_proto_rawDesc = "" +
+	"\n" +
+	"\vlymbo.proto\x12\blymbo.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x80\x06\n" +
+	"\x06Ticket\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x14\n" +
+	"\x05queue\x18\x04 \x01(\tR\x05queue\x120\n" +
+	"\x05runat\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x05runat\x126\n" +
+	"\bdeadline\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\bdeadline\x12\x12\n" +
+	"\x04nice\x18\a \x01(\x05R\x04nice\x120\n" +
+	"\x05ctime\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\x05ctime\x120\n" +
+	"\x05mtime\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\x05mtime\x12\x1a\n" +
+	"\battempts\x18\n" +
+	" \x01(\x05R\battempts\x12\x14\n" +
+	"\x05owner\x18\v \x01(\tR\x05owner\x12\x1d\n" +
+	"\n" +
+	"unique_key\x18\f \x01(\tR\tuniqueKey\x124\n" +
+	"\x06labels\x18\r \x03(\v2\x1c.lymbo.v1.Ticket.LabelsEntryR\x06labels\x12:\n" +
+	"\bmetadata\x18\x0e \x03(\v2\x1e.lymbo.v1.Ticket.MetadataEntryR\bmetadata\x12\x18\n" +
+	"\apayload\x18\x0f \x01(\fR\apayload\x126\n" +
+	"\ferror_reason\x18\x10 \x01(\v2\x13.lymbo.v1.ErrorInfoR\verrorReason\x12\x16\n" +
+	"\x06result\x18\x11 \x01(\fR\x06result\x12\x1d\n" +
+	"\n" +
+	"depends_on\x18\x12 \x03(\tR\tdependsOn\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xa6\x01\n" +
+	"\tErrorInfo\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12\x14\n" +
+	"\x05stack\x18\x03 \x01(\tR\x05stack\x12;\n" +
+	"\voccurred_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"occurredAt\x12\x18\n" +
+	"\aattempt\x18\x05 \x01(\x05R\aattempt\"\xa8\x04\n" +
+	"\x0eEnqueueRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x14\n" +
+	"\x05queue\x18\x03 \x01(\tR\x05queue\x120\n" +
+	"\x05runat\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x05runat\x126\n" +
+	"\bdeadline\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\bdeadline\x12\x17\n" +
+	"\x04nice\x18\x06 \x01(\x05H\x00R\x04nice\x88\x01\x01\x12\x1d\n" +
+	"\n" +
+	"unique_key\x18\a \x01(\tR\tuniqueKey\x12<\n" +
+	"\x06labels\x18\b \x03(\v2$.lymbo.v1.EnqueueRequest.LabelsEntryR\x06labels\x12B\n" +
+	"\bmetadata\x18\t \x03(\v2&.lymbo.v1.EnqueueRequest.MetadataEntryR\bmetadata\x12\x1d\n" +
+	"\n" +
+	"depends_on\x18\n" +
+	" \x03(\tR\tdependsOn\x12\x18\n" +
+	"\apayload\x18\v \x01(\fR\apayload\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\a\n" +
+	"\x05_nice\"\x1c\n" +
+	"\n" +
+	"GetRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"7\n" +
+	"\rCancelRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"\x1e\n" +
+	"\fRetryRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xd5\x02\n" +
+	"\vListRequest\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x14\n" +
+	"\x05types\x18\x02 \x03(\tR\x05types\x12=\n" +
+	"\fcreated_from\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\vcreatedFrom\x129\n" +
+	"\n" +
+	"created_to\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedTo\x129\n" +
+	"\n" +
+	"runat_from\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\trunatFrom\x125\n" +
+	"\brunat_to\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\arunatTo\x12\x14\n" +
+	"\x05limit\x18\a \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06cursor\x18\b \x01(\tR\x06cursor\"[\n" +
+	"\fListResponse\x12*\n" +
+	"\atickets\x18\x01 \x03(\v2\x10.lymbo.v1.TicketR\atickets\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\"\x0e\n" +
+	"\fStatsRequest\">\n" +
+	"\rStatsResponse\x12-\n" +
+	"\x05stats\x18\x01 \x01(\v2\x17.google.protobuf.StructR\x05stats2\xc6\x02\n" +
+	"\x05Lymbo\x125\n" +
+	"\aEnqueue\x12\x18.lymbo.v1.EnqueueRequest\x1a\x10.lymbo.v1.Ticket\x12-\n" +
+	"\x03Get\x12\x14.lymbo.v1.GetRequest\x1a\x10.lymbo.v1.Ticket\x123\n" +
+	"\x06Cancel\x12\x17.lymbo.v1.CancelRequest\x1a\x10.lymbo.v1.Ticket\x121\n" +
+	"\x05Retry\x12\x16.lymbo.v1.RetryRequest\x1a\x10.lymbo.v1.Ticket\x125\n" +
+	"\x04List\x12\x15.lymbo.v1.ListRequest\x1a\x16.lymbo.v1.ListResponse\x128\n" +
+	"\x05Stats\x12\x16.lymbo.v1.StatsRequest\x1a\x17.lymbo.v1.StatsResponseB/Z-github.com/ochaton/lymbo/grpc/lymbopb;lymbopbb\x06proto3"
+
+var (
+	file_lymbo_proto_rawDescOnce sync.Once
+	file_lymbo_proto_rawDescData []byte
+)
+
+func file_lymbo_proto_rawDescGZIP() []byte {
+	file_lymbo_proto_rawDescOnce.Do(func() {
+		file_lymbo_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_lymbo_proto_rawDesc), len(file_lymbo_proto_rawDesc)))
+	})
+	return file_lymbo_proto_rawDescData
+}
+
+var file_lymbo_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_lymbo_proto_goTypes = []any{
+	(*Ticket)(nil),                // 0: lymbo.v1.Ticket
+	(*ErrorInfo)(nil),             // 1: lymbo.v1.ErrorInfo
+	(*EnqueueRequest)(nil),        // 2: lymbo.v1.EnqueueRequest
+	(*GetRequest)(nil),            // 3: lymbo.v1.GetRequest
+	(*CancelRequest)(nil),         // 4: lymbo.v1.CancelRequest
+	(*RetryRequest)(nil),          // 5: lymbo.v1.RetryRequest
+	(*ListRequest)(nil),           // 6: lymbo.v1.ListRequest
+	(*ListResponse)(nil),          // 7: lymbo.v1.ListResponse
+	(*StatsRequest)(nil),          // 8: lymbo.v1.StatsRequest
+	(*StatsResponse)(nil),         // 9: lymbo.v1.StatsResponse
+	nil,                           // 10: lymbo.v1.Ticket.LabelsEntry
+	nil,                           // 11: lymbo.v1.Ticket.MetadataEntry
+	nil,                           // 12: lymbo.v1.EnqueueRequest.LabelsEntry
+	nil,                           // 13: lymbo.v1.EnqueueRequest.MetadataEntry
+	(*timestamppb.Timestamp)(nil), // 14: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),       // 15: google.protobuf.Struct
+}
+var file_lymbo_proto_depIdxs = []int32{
+	14, // 0: lymbo.v1.Ticket.runat:type_name -> google.protobuf.Timestamp
+	14, // 1: lymbo.v1.Ticket.deadline:type_name -> google.protobuf.Timestamp
+	14, // 2: lymbo.v1.Ticket.ctime:type_name -> google.protobuf.Timestamp
+	14, // 3: lymbo.v1.Ticket.mtime:type_name -> google.protobuf.Timestamp
+	10, // 4: lymbo.v1.Ticket.labels:type_name -> lymbo.v1.Ticket.LabelsEntry
+	11, // 5: lymbo.v1.Ticket.metadata:type_name -> lymbo.v1.Ticket.MetadataEntry
+	1,  // 6: lymbo.v1.Ticket.error_reason:type_name -> lymbo.v1.ErrorInfo
+	14, // 7: lymbo.v1.ErrorInfo.occurred_at:type_name -> google.protobuf.Timestamp
+	14, // 8: lymbo.v1.EnqueueRequest.runat:type_name -> google.protobuf.Timestamp
+	14, // 9: lymbo.v1.EnqueueRequest.deadline:type_name -> google.protobuf.Timestamp
+	12, // 10: lymbo.v1.EnqueueRequest.labels:type_name -> lymbo.v1.EnqueueRequest.LabelsEntry
+	13, // 11: lymbo.v1.EnqueueRequest.metadata:type_name -> lymbo.v1.EnqueueRequest.MetadataEntry
+	14, // 12: lymbo.v1.ListRequest.created_from:type_name -> google.protobuf.Timestamp
+	14, // 13: lymbo.v1.ListRequest.created_to:type_name -> google.protobuf.Timestamp
+	14, // 14: lymbo.v1.ListRequest.runat_from:type_name -> google.protobuf.Timestamp
+	14, // 15: lymbo.v1.ListRequest.runat_to:type_name -> google.protobuf.Timestamp
+	0,  // 16: lymbo.v1.ListResponse.tickets:type_name -> lymbo.v1.Ticket
+	15, // 17: lymbo.v1.StatsResponse.stats:type_name -> google.protobuf.Struct
+	2,  // 18: lymbo.v1.Lymbo.Enqueue:input_type -> lymbo.v1.EnqueueRequest
+	3,  // 19: lymbo.v1.Lymbo.Get:input_type -> lymbo.v1.GetRequest
+	4,  // 20: lymbo.v1.Lymbo.Cancel:input_type -> lymbo.v1.CancelRequest
+	5,  // 21: lymbo.v1.Lymbo.Retry:input_type -> lymbo.v1.RetryRequest
+	6,  // 22: lymbo.v1.Lymbo.List:input_type -> lymbo.v1.ListRequest
+	8,  // 23: lymbo.v1.Lymbo.Stats:input_type -> lymbo.v1.StatsRequest
+	0,  // 24: lymbo.v1.Lymbo.Enqueue:output_type -> lymbo.v1.Ticket
+	0,  // 25: lymbo.v1.Lymbo.Get:output_type -> lymbo.v1.Ticket
+	0,  // 26: lymbo.v1.Lymbo.Cancel:output_type -> lymbo.v1.Ticket
+	0,  // 27: lymbo.v1.Lymbo.Retry:output_type -> lymbo.v1.Ticket
+	7,  // 28: lymbo.v1.Lymbo.List:output_type -> lymbo.v1.ListResponse
+	9,  // 29: lymbo.v1.Lymbo.Stats:output_type -> lymbo.v1.StatsResponse
+	24, // [24:30] is the sub-list for method output_type
+	18, // [18:24] is the sub-list for method input_type
+	18, // [18:18] is the sub-list for extension type_name
+	18, // [18:18] is the sub-list for extension extendee
+	0,  // [0:18] is the sub-list for field type_name
+}
+
+func init() { file_lymbo_proto_init() }
+func file_lymbo_proto_init() {
+	if File_lymbo_proto != nil {
+		return
+	}
+	file_lymbo_proto_msgTypes[2].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_lymbo_proto_rawDesc), len(file_lymbo_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_lymbo_proto_goTypes,
+		DependencyIndexes: file_lymbo_proto_depIdxs,
+		MessageInfos:      file_lymbo_proto_msgTypes,
+	}.Build()
+	File_lymbo_proto = out.File
+	file_lymbo_proto_goTypes = nil
+	file_lymbo_proto_depIdxs = nil
+}
@@ -0,0 +1,332 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: lymbo.proto
+
+// Remote enqueue and management of the tickets of a lymbo Kharon,
+// served by the github.com/ochaton/lymbo/grpc package.
+
+package lymbopb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Lymbo_Enqueue_FullMethodName = "/lymbo.v1.Lymbo/Enqueue"
+	Lymbo_Get_FullMethodName     = "/lymbo.v1.Lymbo/Get"
+	Lymbo_Cancel_FullMethodName  = "/lymbo.v1.Lymbo/Cancel"
+	Lymbo_Retry_FullMethodName   = "/lymbo.v1.Lymbo/Retry"
+	Lymbo_List_FullMethodName    = "/lymbo.v1.Lymbo/List"
+	Lymbo_Stats_FullMethodName   = "/lymbo.v1.Lymbo/Stats"
+)
+
+// LymboClient is the client API for Lymbo service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LymboClient interface {
+	// Enqueue puts a new pending ticket. Fails with ALREADY_EXISTS if its
+	// unique_key is held by another pending ticket of the same type.
+	Enqueue(ctx context.Context, in *EnqueueRequest, opts ...grpc.CallOption) (*Ticket, error)
+	// Get returns a ticket, NOT_FOUND if it doesn't exist.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Ticket, error)
+	// Cancel cancels a pending ticket, keeping it for inspection.
+	// Fails with FAILED_PRECONDITION if it isn't pending.
+	Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Ticket, error)
+	// Retry makes a ticket pending and due now. Dead tickets are requeued
+	// with their attempts reset.
+	Retry(ctx context.Context, in *RetryRequest, opts ...grpc.CallOption) (*Ticket, error)
+	// List returns a page of tickets, oldest first.
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	// Stats returns the counters of the Kharon serving the request.
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+}
+
+type lymboClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLymboClient(cc grpc.ClientConnInterface) LymboClient {
+	return &lymboClient{cc}
+}
+
+func (c *lymboClient) Enqueue(ctx context.Context, in *EnqueueRequest, opts ...grpc.CallOption) (*Ticket, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ticket)
+	err := c.cc.Invoke(ctx, Lymbo_Enqueue_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lymboClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Ticket, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ticket)
+	err := c.cc.Invoke(ctx, Lymbo_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lymboClient) Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Ticket, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ticket)
+	err := c.cc.Invoke(ctx, Lymbo_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lymboClient) Retry(ctx context.Context, in *RetryRequest, opts ...grpc.CallOption) (*Ticket, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ticket)
+	err := c.cc.Invoke(ctx, Lymbo_Retry_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lymboClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, Lymbo_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lymboClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, Lymbo_Stats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LymboServer is the server API for Lymbo service.
+// All implementations must embed UnimplementedLymboServer
+// for forward compatibility.
+type LymboServer interface {
+	// Enqueue puts a new pending ticket. Fails with ALREADY_EXISTS if its
+	// unique_key is held by another pending ticket of the same type.
+	Enqueue(context.Context, *EnqueueRequest) (*Ticket, error)
+	// Get returns a ticket, NOT_FOUND if it doesn't exist.
+	Get(context.Context, *GetRequest) (*Ticket, error)
+	// Cancel cancels a pending ticket, keeping it for inspection.
+	// Fails with FAILED_PRECONDITION if it isn't pending.
+	Cancel(context.Context, *CancelRequest) (*Ticket, error)
+	// Retry makes a ticket pending and due now. Dead tickets are requeued
+	// with their attempts reset.
+	Retry(context.Context, *RetryRequest) (*Ticket, error)
+	// List returns a page of tickets, oldest first.
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	// Stats returns the counters of the Kharon serving the request.
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	mustEmbedUnimplementedLymboServer()
+}
+
+// UnimplementedLymboServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLymboServer struct{}
+
+func (UnimplementedLymboServer) Enqueue(context.Context, *EnqueueRequest) (*Ticket, error) {
+	return nil, status.Error(codes.Unimplemented, "method Enqueue not implemented")
+}
+func (UnimplementedLymboServer) Get(context.Context, *GetRequest) (*Ticket, error) {
+	return nil, status.Error(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedLymboServer) Cancel(context.Context, *CancelRequest) (*Ticket, error) {
+	return nil, status.Error(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedLymboServer) Retry(context.Context, *RetryRequest) (*Ticket, error) {
+	return nil, status.Error(codes.Unimplemented, "method Retry not implemented")
+}
+func (UnimplementedLymboServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedLymboServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedLymboServer) mustEmbedUnimplementedLymboServer() {}
+func (UnimplementedLymboServer) testEmbeddedByValue()               {}
+
+// UnsafeLymboServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LymboServer will
+// result in compilation errors.
+type UnsafeLymboServer interface {
+	mustEmbedUnimplementedLymboServer()
+}
+
+func RegisterLymboServer(s grpc.ServiceRegistrar, srv LymboServer) {
+	// If the following call panics, it indicates UnimplementedLymboServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Lymbo_ServiceDesc, srv)
+}
+
+func _Lymbo_Enqueue_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnqueueRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LymboServer).Enqueue(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Lymbo_Enqueue_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LymboServer).Enqueue(ctx, req.(*EnqueueRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lymbo_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LymboServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Lymbo_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LymboServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lymbo_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LymboServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Lymbo_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LymboServer).Cancel(ctx, req.(*CancelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lymbo_Retry_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LymboServer).Retry(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Lymbo_Retry_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LymboServer).Retry(ctx, req.(*RetryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lymbo_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LymboServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Lymbo_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LymboServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lymbo_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LymboServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Lymbo_Stats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LymboServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Lymbo_ServiceDesc is the grpc.ServiceDesc for Lymbo service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Lymbo_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "lymbo.v1.Lymbo",
+	HandlerType: (*LymboServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Enqueue",
+			Handler:    _Lymbo_Enqueue_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _Lymbo_Get_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _Lymbo_Cancel_Handler,
+		},
+		{
+			MethodName: "Retry",
+			Handler:    _Lymbo_Retry_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Lymbo_List_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _Lymbo_Stats_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "lymbo.proto",
+}
@@ -0,0 +1,77 @@
+package lymbo
+
+import (
+	"math"
+	"slices"
+	"sync/atomic"
+)
+
+var (
+	// DurationBuckets are the upper bounds, in seconds, of the buckets of the
+	// StartLatency and HandlerDuration histograms of Stats.
+	DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+	// AttemptsBuckets are the upper bounds of the buckets of the Attempts
+	// histogram of Stats.
+	AttemptsBuckets = []float64{1, 2, 3, 5, 10, 20, 50}
+)
+
+// Histogram is a snapshot of observations sorted into buckets, as Prometheus
+// histograms are.
+type Histogram struct {
+	// Bounds are the upper bounds of the buckets, in increasing order.
+	Bounds []float64 `json:"bounds"`
+	// Counts are the cumulative counts of the buckets: Counts[i] is the number
+	// of observations less than or equal to Bounds[i].
+	Counts []int64 `json:"counts"`
+	// Count is the number of observations, those above the last bound included.
+	Count int64 `json:"count"`
+	// Sum is the sum of the observations.
+	Sum float64 `json:"sum"`
+}
+
+type histogram struct {
+	bounds []float64
+	counts []atomic.Int64 // per bucket, the last one above the bounds
+	sum    atomic.Uint64  // float64 bits
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: slices.Clone(bounds),
+		counts: make([]atomic.Int64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i].Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: slices.Clone(h.bounds),
+		Counts: make([]int64, len(h.bounds)),
+		Sum:    math.Float64frombits(h.sum.Load()),
+	}
+	for i := range h.counts {
+		s.Count += h.counts[i].Load()
+		if i < len(s.Counts) {
+			s.Counts[i] = s.Count
+		}
+	}
+	return s
+}
+
+func (h *histogram) reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.sum.Store(0)
+}
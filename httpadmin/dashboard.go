@@ -0,0 +1,17 @@
+package httpadmin
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboardHTML is a single page polling /summary and /tickets, with buttons
+// to retry failed tickets and cancel pending ones.
+//
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+func (h *Handler) dashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
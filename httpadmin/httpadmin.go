@@ -0,0 +1,526 @@
+// Package httpadmin exposes a REST API to inspect and manage the tickets of a
+// Kharon, so that operators can handle a queue without a database shell.
+//
+// Usage:
+//
+//	kh := lymbo.NewKharon(store, settings, logger)
+//	http.Handle("/admin/", http.StripPrefix("/admin", httpadmin.NewHandler(kh, httpadmin.Config{})))
+//
+// Endpoints:
+//
+//	GET    /tickets?status=failed&type=email&limit=100  list tickets, oldest first, by pages
+//	GET    /tickets/{id}                                 get a ticket
+//	GET    /tickets/{id}/history                         the status changes of a ticket, see lymbo.Kharon.History
+//	GET    /search?q=$.order_id=="A-42"&limit=100        search tickets by payload, oldest first
+//	POST   /tickets/{id}/retry                           make a ticket pending and due now
+//	POST   /tickets/{id}/cancel?reason=...               cancel a pending ticket, keeping it
+//	POST   /tickets/{id}/move?queue=slow&type=email      move a ticket to another queue or type
+//	POST   /tickets/retry-failed?type=email              make the failed tickets pending and due now
+//	DELETE /tickets/{id}                                 delete a ticket
+//	GET    /paused                                       the paused types
+//	POST   /types/{type}/pause                           stop polling the tickets of a type
+//	POST   /types/{type}/resume                          poll the tickets of a paused type again
+//	GET    /stats                                        lymbo.Stats of the Kharon
+//	GET    /summary                                      Summary of the store
+//	GET    /                                             the dashboard, a web UI of the above
+//
+// Responses are JSON, errors being {"error": "..."}. The handler doesn't
+// authenticate requests: wrap it with the middleware of the application.
+package httpadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+)
+
+// DefaultListLimit caps the tickets of a page of a list when Config.ListLimit is 0.
+const DefaultListLimit = 1000
+
+// CancelReason is the ErrorReason of tickets cancelled without a reason.
+const CancelReason = "cancelled by operator"
+
+type Config struct {
+	// ListLimit caps the number of tickets a page of a list returns, and is
+	// the limit of lists without one. Defaults to DefaultListLimit.
+	ListLimit int
+
+	// ThroughputWindow is the period the throughput of Summary.Types is
+	// measured over. Defaults to DefaultThroughputWindow.
+	ThroughputWindow time.Duration
+
+	// RecentFailures caps Summary.RecentFailures. Defaults to DefaultRecentFailures.
+	RecentFailures int
+
+	// Actor, if set, names the caller of a request, e.g. the user it was
+	// authenticated as, recorded as the actor of the status changes it
+	// makes, see lymbo.WithActor. Defaults to the worker ID of the Kharon.
+	Actor func(r *http.Request) string
+}
+
+// Handler serves the admin API of a Kharon.
+type Handler struct {
+	kh             *lymbo.Kharon
+	listLimit      int
+	window         time.Duration
+	recentFailures int
+	actor          func(r *http.Request) string
+	mux            *http.ServeMux
+}
+
+// Ensure Handler implements http.Handler interface.
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler returns the admin API of kh. kh doesn't need to be running:
+// every change is written to the store before the response is sent.
+func NewHandler(kh *lymbo.Kharon, cfg Config) *Handler {
+	if cfg.ListLimit <= 0 {
+		cfg.ListLimit = DefaultListLimit
+	}
+	if cfg.ThroughputWindow <= 0 {
+		cfg.ThroughputWindow = DefaultThroughputWindow
+	}
+	if cfg.RecentFailures <= 0 {
+		cfg.RecentFailures = DefaultRecentFailures
+	}
+	h := &Handler{
+		kh:             kh,
+		listLimit:      cfg.ListLimit,
+		window:         cfg.ThroughputWindow,
+		recentFailures: cfg.RecentFailures,
+		actor:          cfg.Actor,
+		mux:            http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /tickets", h.list)
+	h.mux.HandleFunc("GET /tickets/{id}", h.get)
+	h.mux.HandleFunc("GET /tickets/{id}/history", h.history)
+	h.mux.HandleFunc("GET /search", h.search)
+	h.mux.HandleFunc("POST /tickets/{id}/retry", h.retry)
+	h.mux.HandleFunc("POST /tickets/{id}/cancel", h.cancel)
+	h.mux.HandleFunc("POST /tickets/{id}/move", h.move)
+	h.mux.HandleFunc("POST /tickets/retry-failed", h.retryFailed)
+	h.mux.HandleFunc("DELETE /tickets/{id}", h.delete)
+	h.mux.HandleFunc("GET /paused", h.paused)
+	h.mux.HandleFunc("POST /types/{type}/pause", h.pause)
+	h.mux.HandleFunc("POST /types/{type}/resume", h.resume)
+	h.mux.HandleFunc("GET /stats", h.stats)
+	h.mux.HandleFunc("GET /summary", h.summary)
+	h.mux.HandleFunc("GET /{$}", h.dashboard)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.actor != nil {
+		if actor := h.actor(r); actor != "" {
+			r = r.WithContext(lymbo.WithActor(r.Context(), actor))
+		}
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// Ticket is the JSON form of a lymbo.Ticket. Payload and Result are inlined
+// if they are JSON, as read back from most stores.
+type Ticket struct {
+	ID          lymbo.TicketId    `json:"id"`
+	Status      status.Status     `json:"status"`
+	Type        string            `json:"type"`
+	Queue       string            `json:"queue,omitempty"`
+	TenantID    string            `json:"tenant_id,omitempty"`
+	Runat       time.Time         `json:"runat"`
+	Deadline    *time.Time        `json:"deadline,omitempty"`
+	Nice        int               `json:"nice"`
+	Ctime       time.Time         `json:"ctime"`
+	Mtime       *time.Time        `json:"mtime,omitempty"`
+	Attempts    int               `json:"attempts"`
+	Owner       string            `json:"owner,omitempty"`
+	UniqueKey   string            `json:"unique_key,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Payload     json.RawMessage   `json:"payload,omitempty"`
+	ErrorReason *lymbo.ErrorInfo  `json:"error_reason,omitempty"`
+	Result      json.RawMessage   `json:"result,omitempty"`
+	AttemptLog  []lymbo.Attempt   `json:"attempt_log,omitempty"`
+	DependsOn   []lymbo.TicketId  `json:"depends_on,omitempty"`
+}
+
+// TicketOf returns the JSON form of t.
+func TicketOf(t lymbo.Ticket) Ticket {
+	return Ticket{
+		ID:          t.ID,
+		Status:      t.Status,
+		Type:        t.Type,
+		Queue:       t.Queue,
+		TenantID:    t.TenantID,
+		Runat:       t.Runat,
+		Deadline:    t.Deadline,
+		Nice:        t.Nice,
+		Ctime:       t.Ctime,
+		Mtime:       t.Mtime,
+		Attempts:    t.Attempts,
+		Owner:       t.Owner,
+		UniqueKey:   t.UniqueKey,
+		Labels:      t.Labels,
+		Metadata:    t.Metadata,
+		Payload:     rawJSON(t.Payload),
+		ErrorReason: t.ErrorReason,
+		Result:      rawJSON(t.Result),
+		AttemptLog:  t.AttemptLog,
+		DependsOn:   t.DependsOn,
+	}
+}
+
+// rawJSON returns v as JSON: raw JSON as is, and other values marshaled,
+// e.g. bytes that aren't JSON as a base64 string.
+func rawJSON(v any) json.RawMessage {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case json.RawMessage:
+		if json.Valid(v) {
+			return v
+		}
+	case []byte:
+		if json.Valid(v) {
+			return v
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(err.Error())
+	}
+	return data
+}
+
+// List is a page of tickets.
+type List struct {
+	Tickets []Ticket `json:"tickets"`
+
+	// Next is the cursor parameter of the next page, empty on the last one.
+	Next string `json:"next,omitempty"`
+}
+
+// list serves a page of the tickets selected by the status, type and tenant
+// (repeated for several ones), created_from, created_to, runat_from and runat_to
+// (RFC 3339) parameters, following the one of the cursor parameter.
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := lymbo.ListRequest{Types: q["type"], Tenants: q["tenant"], Limit: h.listLimit}
+	if s := q.Get("status"); s != "" {
+		st, err := status.FromString(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		req.Status = &st
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("limit must be a positive integer"))
+			return
+		}
+		req.Limit = min(n, h.listLimit)
+	}
+	if s := q.Get("cursor"); s != "" {
+		c, err := lymbo.ParseCursor(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		req.After = &c
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"created_from", &req.Created.From},
+		{"created_to", &req.Created.To},
+		{"runat_from", &req.Runat.From},
+		{"runat_to", &req.Runat.To},
+	} {
+		if s := q.Get(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", p.name, err))
+				return
+			}
+			*p.dst = t
+		}
+	}
+
+	tickets, err := h.kh.List(r.Context(), req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	out := List{Tickets: make([]Ticket, 0, len(tickets))}
+	for _, t := range tickets {
+		out.Tickets = append(out.Tickets, TicketOf(t))
+	}
+	if next := req.Next(tickets); next != nil {
+		out.Next = next.String()
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// search serves the tickets whose payload matches the SQL/JSON path
+// predicate of the q parameter, see lymbo.Kharon.Search, up to the limit
+// parameter.
+func (h *Handler) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, errors.New("q is required"))
+		return
+	}
+	limit := h.listLimit
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("limit must be a positive integer"))
+			return
+		}
+		limit = min(n, h.listLimit)
+	}
+
+	tickets, err := h.kh.Search(r.Context(), query, limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	out := List{Tickets: make([]Ticket, 0, len(tickets))}
+	for _, t := range tickets {
+		out.Tickets = append(out.Tickets, TicketOf(t))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	t, err := h.kh.Get(r.Context(), lymbo.TicketId(r.PathValue("id")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, TicketOf(t))
+}
+
+// History is the audit trail of a ticket.
+type History struct {
+	Entries []lymbo.AuditEntry `json:"entries"`
+}
+
+// history serves the status changes of a ticket recorded by the audit log
+// of the Kharon, oldest first.
+func (h *Handler) history(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.kh.History(r.Context(), lymbo.TicketId(r.PathValue("id")))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if entries == nil {
+		entries = []lymbo.AuditEntry{}
+	}
+	writeJSON(w, http.StatusOK, History{Entries: entries})
+}
+
+// retry makes a ticket pending and due now. Dead tickets are requeued with
+// their attempts reset, as Kharon.RequeueDead does, the others keep them.
+func (h *Handler) retry(w http.ResponseWriter, r *http.Request) {
+	ctx, tid := r.Context(), lymbo.TicketId(r.PathValue("id"))
+	t, err := h.kh.Get(lymbo.PrimaryContext(ctx), tid)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if t.Status == status.Dead {
+		err = h.kh.RequeueDead(ctx, tid)
+	} else {
+		// the update is written synchronously, even if kh isn't running
+		err = h.kh.Retry(ctx, tid, lymbo.WithUpdate(func(_ context.Context, t *lymbo.Ticket) error {
+			now := h.kh.Now()
+			t.Status = status.Pending
+			t.Runat = now
+			t.Mtime = &now
+			return nil
+		}))
+	}
+	h.respond(w, r, err)
+}
+
+// cancel marks a pending ticket as cancelled, with the reason query parameter
+// as ErrorReason, and keeps it for inspection.
+func (h *Handler) cancel(w http.ResponseWriter, r *http.Request) {
+	ctx, tid := r.Context(), lymbo.TicketId(r.PathValue("id"))
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = CancelReason
+	}
+	// Cancel sets the status before calling the update, so it's checked first
+	t, err := h.kh.Get(lymbo.PrimaryContext(ctx), tid)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if t.Status != status.Pending {
+		writeStoreError(w, fmt.Errorf("%w: ticket is %s", lymbo.ErrInvalidStatusTransition, t.Status))
+		return
+	}
+	err = h.kh.Cancel(ctx, tid,
+		lymbo.WithKeep(),
+		lymbo.WithErrorReason(reason),
+		lymbo.WithUpdate(func(_ context.Context, t *lymbo.Ticket) error {
+			now := h.kh.Now()
+			t.Mtime = &now
+			return nil
+		}),
+	)
+	h.respond(w, r, err)
+}
+
+// move moves a ticket to the queue query parameter, the default queue if
+// none, and to the type parameter unless empty, see Kharon.Move.
+func (h *Handler) move(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	err := h.kh.Move(r.Context(), lymbo.TicketId(r.PathValue("id")), q.Get("queue"), q.Get("type"))
+	h.respond(w, r, err)
+}
+
+// Retried is the number of tickets retried by a bulk retry.
+type Retried struct {
+	Retried int `json:"retried"`
+}
+
+// retryFailed makes the failed tickets selected by the type and tenant
+// (repeated for several ones), created_from, created_to, failed_from and
+// failed_to (RFC 3339) parameters pending and due now, with their attempts
+// reset unless keep_attempts is true.
+func (h *Handler) retryFailed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := lymbo.RetryFailedRequest{Types: q["type"], Tenants: q["tenant"]}
+	if s := q.Get("keep_attempts"); s != "" {
+		keep, err := strconv.ParseBool(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("keep_attempts must be a boolean"))
+			return
+		}
+		req.KeepAttempts = keep
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"created_from", &req.Created.From},
+		{"created_to", &req.Created.To},
+		{"failed_from", &req.Failed.From},
+		{"failed_to", &req.Failed.To},
+	} {
+		if s := q.Get(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", p.name, err))
+				return
+			}
+			*p.dst = t
+		}
+	}
+
+	n, err := h.kh.RetryFailed(r.Context(), req)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Retried{Retried: n})
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	ctx, tid := r.Context(), lymbo.TicketId(r.PathValue("id"))
+	if _, err := h.kh.Get(lymbo.PrimaryContext(ctx), tid); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := h.kh.Delete(ctx, tid); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Paused lists the paused types, see lymbo.Kharon.Pause.
+type Paused struct {
+	Types []string `json:"types"`
+}
+
+func (h *Handler) paused(w http.ResponseWriter, r *http.Request) {
+	types, err := h.kh.Paused(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Paused{Types: append([]string{}, types...)})
+}
+
+// pause stops the polls of the tickets of a type, and responds with the
+// paused types.
+func (h *Handler) pause(w http.ResponseWriter, r *http.Request) {
+	if err := h.kh.Pause(r.Context(), r.PathValue("type")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.paused(w, r)
+}
+
+// resume polls the tickets of a paused type again, and responds with the
+// paused types left.
+func (h *Handler) resume(w http.ResponseWriter, r *http.Request) {
+	if err := h.kh.Resume(r.Context(), r.PathValue("type")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.paused(w, r)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.kh.Stats())
+}
+
+// respond writes err, or the ticket of the request once changed.
+func (h *Handler) respond(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.get(w, r)
+}
+
+// writeStoreError maps the errors of the store to HTTP statuses.
+func writeStoreError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, lymbo.ErrTicketNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, lymbo.ErrTicketIDInvalid), errors.Is(err, lymbo.ErrTicketIDEmpty):
+		code = http.StatusBadRequest
+	case errors.Is(err, lymbo.ErrInvalidStatusTransition), errors.Is(err, lymbo.ErrDuplicateTicket):
+		code = http.StatusConflict
+	case errors.Is(err, lymbo.ErrSearchUnsupported), errors.Is(err, lymbo.ErrAuditDisabled):
+		code = http.StatusNotImplemented
+	}
+	writeError(w, code, err)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,111 @@
+package httpadmin
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+)
+
+// DefaultThroughputWindow is the window of Summary.Types when Config.ThroughputWindow is 0.
+const DefaultThroughputWindow = time.Hour
+
+// DefaultRecentFailures is the length of Summary.RecentFailures when Config.RecentFailures is 0.
+const DefaultRecentFailures = 20
+
+// Summary is an overview of the store, as shown by the dashboard.
+type Summary struct {
+	// Counts is the number of tickets per status.
+	Counts map[string]int `json:"counts"`
+
+	// Types break the tickets down per Type, ordered by Type.
+	Types []TypeSummary `json:"types"`
+
+	// Window is the period in seconds the throughput of Types is measured over.
+	Window float64 `json:"window_seconds"`
+
+	// RecentFailures are the failed and dead tickets, most recently modified first.
+	RecentFailures []Ticket `json:"recent_failures"`
+
+	// Stats are the counters of the Kharon serving the API.
+	Stats lymbo.Stats `json:"stats"`
+}
+
+// TypeSummary describes the tickets of a Type. Done and Failed count the
+// tickets settled within the window and kept in the store: acked tickets,
+// which are deleted, are not counted.
+type TypeSummary struct {
+	Type    string `json:"type"`
+	Pending int    `json:"pending"`
+	Done    int    `json:"done"`
+	Failed  int    `json:"failed"`
+
+	// Throughput is the number of tickets settled per minute within the window.
+	Throughput float64 `json:"throughput"`
+}
+
+// summary lists every ticket of the store, so each request is as costly as
+// the gauges of the metrics package are on a scrape.
+func (h *Handler) summary(w http.ResponseWriter, r *http.Request) {
+	tickets, err := h.kh.List(r.Context(), lymbo.ListRequest{})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	since := h.kh.Now().Add(-h.window)
+	s := Summary{
+		Counts: make(map[string]int),
+		Window: h.window.Seconds(),
+		Stats:  h.kh.Stats(),
+	}
+	types := make(map[string]*TypeSummary)
+	var failures []lymbo.Ticket
+	for _, t := range tickets {
+		s.Counts[t.Status.String()]++
+		ts, ok := types[t.Type]
+		if !ok {
+			ts = &TypeSummary{Type: t.Type}
+			types[t.Type] = ts
+		}
+		failed := t.Status == status.Failed || t.Status == status.Dead
+		if failed {
+			failures = append(failures, t)
+		}
+		switch {
+		case t.Status == status.Pending:
+			ts.Pending++
+		case modified(t).Before(since):
+			// settled before the window
+		case t.Status == status.Done:
+			ts.Done++
+		case failed:
+			ts.Failed++
+		}
+	}
+
+	s.Types = make([]TypeSummary, 0, len(types))
+	for _, ts := range types {
+		ts.Throughput = float64(ts.Done+ts.Failed) / h.window.Minutes()
+		s.Types = append(s.Types, *ts)
+	}
+	slices.SortFunc(s.Types, func(a, b TypeSummary) int { return cmp.Compare(a.Type, b.Type) })
+
+	slices.SortFunc(failures, func(a, b lymbo.Ticket) int { return modified(b).Compare(modified(a)) })
+	s.RecentFailures = make([]Ticket, 0, min(len(failures), h.recentFailures))
+	for _, t := range failures[:min(len(failures), h.recentFailures)] {
+		s.RecentFailures = append(s.RecentFailures, TicketOf(t))
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// modified returns when t was last modified, falling back to its Ctime.
+func modified(t lymbo.Ticket) time.Time {
+	if t.Mtime != nil {
+		return *t.Mtime
+	}
+	return t.Ctime
+}
@@ -0,0 +1,239 @@
+// Package httpenqueue serves an HTTP endpoint putting tickets into the store
+// of a Kharon, for producers such as webhooks that can't talk to the store
+// directly. Requests may be required to be signed with a shared secret.
+//
+// Usage:
+//
+//	kh := lymbo.NewKharon(store, settings, logger)
+//	h := httpenqueue.NewHandler(kh, httpenqueue.Config{Secret: []byte(os.Getenv("LYMBO_SECRET"))})
+//	http.Handle("/ingest/", http.StripPrefix("/ingest", h))
+//
+// Endpoint:
+//
+//	POST /tickets  put the ticket of the JSON body, responding 201 with it
+//
+// The body is the JSON of Input. With Config.Secret set, the request must
+// carry the hex HMAC-SHA256 of its body in the signature header, prefixed by
+// "sha256=", as GitHub signs its webhooks:
+//
+//	X-Lymbo-Signature: sha256=6f1ed002ab5595859014ebf0951522d9...
+//
+// Responses are JSON, errors being {"error": "..."}: 400 for an invalid
+// ticket, 401 for a missing or wrong signature, 409 for a duplicate unique
+// key and 413 for a body over Config.MaxBodySize.
+package httpenqueue
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/httpadmin"
+)
+
+// DefaultSignatureHeader is the header of the signature when
+// Config.SignatureHeader is empty.
+const DefaultSignatureHeader = "X-Lymbo-Signature"
+
+// DefaultMaxBodySize caps the body of a request when Config.MaxBodySize is 0.
+const DefaultMaxBodySize = 1 << 20
+
+// signaturePrefix prefixes the hex HMAC of the signature header.
+const signaturePrefix = "sha256="
+
+var (
+	ErrSignatureMissing = errors.New("signature missing")
+	ErrSignatureInvalid = errors.New("signature invalid")
+)
+
+type Config struct {
+	// Secret is the key of the HMAC-SHA256 signing the body of the requests.
+	// Requests aren't verified when it is empty.
+	Secret []byte
+
+	// SignatureHeader is the header of the signature. Defaults to
+	// DefaultSignatureHeader.
+	SignatureHeader string
+
+	// MaxBodySize caps the size of the body of a request, in bytes. Defaults
+	// to DefaultMaxBodySize.
+	MaxBodySize int64
+}
+
+// Handler serves the enqueue endpoint of a Kharon.
+type Handler struct {
+	kh          *lymbo.Kharon
+	secret      []byte
+	header      string
+	maxBodySize int64
+	mux         *http.ServeMux
+}
+
+// Ensure Handler implements http.Handler interface.
+var _ http.Handler = (*Handler)(nil)
+
+// NewHandler returns the enqueue endpoint of kh. kh doesn't need to be
+// running: tickets are put before the response is sent.
+func NewHandler(kh *lymbo.Kharon, cfg Config) *Handler {
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = DefaultSignatureHeader
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = DefaultMaxBodySize
+	}
+	h := &Handler{
+		kh:          kh,
+		secret:      cfg.Secret,
+		header:      cfg.SignatureHeader,
+		maxBodySize: cfg.MaxBodySize,
+		mux:         http.NewServeMux(),
+	}
+	h.mux.HandleFunc("POST /tickets", h.enqueue)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Input is the JSON body of an enqueue request. ID defaults to a new
+// UUIDv7, Runat to now.
+type Input struct {
+	ID        lymbo.TicketId    `json:"id"`
+	Type      string            `json:"type"`
+	Queue     string            `json:"queue"`
+	TenantID  string            `json:"tenant_id"`
+	Runat     time.Time         `json:"runat"`
+	Deadline  *time.Time        `json:"deadline"`
+	Nice      *int              `json:"nice"`
+	UniqueKey string            `json:"unique_key"`
+	Labels    map[string]string `json:"labels"`
+	Metadata  map[string]string `json:"metadata"`
+	DependsOn []lymbo.TicketId  `json:"depends_on"`
+	Payload   json.RawMessage   `json:"payload"`
+}
+
+// Ticket returns the ticket of in.
+func (in Input) Ticket() (lymbo.Ticket, error) {
+	if in.ID == "" {
+		id, err := uuid.NewV7()
+		if err != nil {
+			return lymbo.Ticket{}, err
+		}
+		in.ID = lymbo.TicketId(id.String())
+	}
+	t, err := lymbo.NewTicket(in.ID, in.Type)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	t.Queue = in.Queue
+	t.TenantID = in.TenantID
+	t.UniqueKey = in.UniqueKey
+	t.Labels = in.Labels
+	t.Metadata = in.Metadata
+	t.DependsOn = in.DependsOn
+	if !in.Runat.IsZero() {
+		t.Runat = in.Runat
+	}
+	if in.Nice != nil {
+		t.Nice = *in.Nice
+	}
+	t.Deadline = in.Deadline
+	if len(in.Payload) > 0 {
+		t.Payload = in.Payload
+	}
+	return *t, nil
+}
+
+func (h *Handler) enqueue(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	if err != nil {
+		if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.verify(r.Header.Get(h.header), body); err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	var in Input
+	if err := json.Unmarshal(body, &in); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ticket: %w", err))
+		return
+	}
+	t, err := in.Ticket()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Put sets the creation time, which is responded
+	now := h.kh.Now()
+	if err := h.kh.Put(r.Context(), t, lymbo.WithCtime(now)); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	t.Ctime = now
+	writeJSON(w, http.StatusCreated, httpadmin.TicketOf(t))
+}
+
+// verify checks signature is the HMAC of body, if the handler has a secret.
+func (h *Handler) verify(signature string, body []byte) error {
+	if len(h.secret) == 0 {
+		return nil
+	}
+	if signature == "" {
+		return ErrSignatureMissing
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrSignatureInvalid
+	}
+	if !hmac.Equal(got, Sign(h.secret, body)) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign returns the HMAC-SHA256 of body with secret. Producers send it hex
+// encoded and prefixed by "sha256=" in the signature header.
+func Sign(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// writeStoreError maps the errors of the store to HTTP statuses.
+func writeStoreError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, lymbo.ErrTicketIDInvalid), errors.Is(err, lymbo.ErrTicketIDEmpty):
+		code = http.StatusBadRequest
+	case errors.Is(err, lymbo.ErrDuplicateTicket):
+		code = http.StatusConflict
+	}
+	writeError(w, code, err)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
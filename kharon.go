@@ -2,9 +2,10 @@ package lymbo
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
-	"math"
-	"runtime/debug"
+	"math/rand/v2"
 	"sync"
 	"time"
 
@@ -40,6 +41,9 @@ var InfinityDelay = FixedDelay(100 * 365 * 24 * time.Hour)
 type msg struct {
 	tid TicketId
 	upd *UpdateSet
+
+	// blob is the key of the offloaded payload of a deleted ticket.
+	blob string
 }
 
 // Kharon is the main orchestrator for the job processing system.
@@ -51,11 +55,32 @@ type Kharon struct {
 	income   chan *Ticket
 	outcome  chan msg
 
-	stats *stats
+	// wake receives the Runat of tickets notified by a Notifier store.
+	wake chan time.Time
+
+	stats  *stats
+	rates  *ratesRing
+	events *Events
+
+	// buckets are the token buckets of the rate limited ticket types.
+	buckets map[string]*bucket
+
+	// slots are the semaphores of the concurrency limited ticket types,
+	// taken by the tickets dispatched to the workers.
+	slots map[string]chan struct{}
+
+	// run is the state of the current Run, nil when not running.
+	mu  sync.Mutex
+	run *running
+
+	// inflight holds the handling of every ticket being processed, by *Ticket.
+	inflight sync.Map
 }
 
+// ResetStats zeroes the cumulative counters of Stats and the rates of Rates.
 func (kh *Kharon) ResetStats() {
 	kh.stats.reset()
+	kh.rates.reset()
 }
 
 // NewKharon creates a new Kharon instance with the provided store, settings, and logger.
@@ -82,34 +107,49 @@ func NewKharon(store Store, s *Settings, logger *slog.Logger) *Kharon {
 		logger:   logger,
 		income:   make(chan *Ticket, s.workers),
 		outcome:  make(chan msg, 10*s.workers),
+		wake:     make(chan time.Time, wakeBuffer),
 		stats:    newStats(),
+		rates:    &ratesRing{},
+		events:   &Events{},
+		buckets:  newBuckets(s.rateLimits),
+		slots:    newSlots(s.concurrency),
 	}
 }
 
-func beforeUpdate(ctx context.Context, t *Ticket, o *Opts) error {
+func (k *Kharon) beforeUpdate(ctx context.Context, t *Ticket, o *Opts) error {
 	switch o.delay.how {
 	case delayFixed:
-		t.Runat = time.Now().Add(o.delay.fixed.duration)
+		t.Runat = k.now().Add(o.delay.fixed.duration)
 	case delayExponential:
 		// exponential backoff support
-		delay := time.Duration(math.Pow(o.delay.exponential.base, float64(t.Attempts)) * float64(time.Second))
-		delay = min(delay, o.delay.exponential.maxDelay)
-		t.Runat = time.Now().Add(delay)
+		delay, _ := NextRetry(t.Attempts, BackoffConfig{
+			Base:     o.delay.exponential.base,
+			MaxDelay: o.delay.exponential.maxDelay,
+		})
+		t.Runat = k.now().Add(delay + max(o.delay.exponential.jitter, 0))
+	case delayStrategy:
+		t.Runat = k.now().Add(o.delay.strategy.Delay(t.Attempts))
 	default:
 		// no delay
 	}
+	if o.status != nil || o.delay.how != delayUnset {
+		// settled, no longer held by the poll that claimed it
+		t.Owner = ""
+	}
 	if o.status != nil {
 		t.Status = *o.status
 	}
-	if o.errorReason != nil {
-		t.ErrorReason = o.errorReason
-	}
+	t.SetErrorReason(o.errorReason)
+	t.LogAttempt(o.attempt)
 	if o.nice != nil {
 		t.Nice = *o.nice
 	}
 	if o.payload != nil {
 		t.Payload = o.payload
 	}
+	if o.result != nil {
+		t.Result = o.result
+	}
 	if o.update != nil {
 		if err := o.update(ctx, t); err != nil {
 			return err
@@ -119,25 +159,67 @@ func beforeUpdate(ctx context.Context, t *Ticket, o *Opts) error {
 }
 
 func (k *Kharon) save(ctx context.Context, tid TicketId, o *Opts) error {
-	if o.update != nil {
-		return k.store.Update(ctx, tid, func(ctx context.Context, t *Ticket) error {
-			return beforeUpdate(ctx, t, o)
+	markSettled(ctx, tid)
+	o.attempt = attemptOf(ctx, tid, o, k.now())
+	token, checked := leaseFrom(ctx, tid)
+	if o.update != nil || o.delay.how == delayStrategy {
+		var before, after Ticket
+		err := k.store.Update(ctx, tid, func(ctx context.Context, t *Ticket) error {
+			if checked && t.Lease != token {
+				return ErrLeaseLost
+			}
+			before = *t
+			if err := k.beforeUpdate(ctx, t, o); err != nil {
+				return err
+			}
+			after = *t
+			return nil
 		})
+		if err != nil {
+			return k.leaseErr(ctx, tid, err)
+		}
+		if after.Status != before.Status {
+			k.audit(ctx, after.Status, reasonOf(after.ErrorReason), tid)
+		}
+		return nil
+	}
+
+	us := k.toUpdateSet(tid, o)
+	if checked {
+		// written synchronously to report ErrLeaseLost to the caller
+		us.Lease = token
+		if err := k.store.UpdateSet(ctx, *us); err != nil {
+			return k.leaseErr(ctx, tid, err)
+		}
+	} else {
+		k.outcome <- msg{
+			tid: tid,
+			upd: us,
+		}
+	}
+	if o.status != nil {
+		k.audit(ctx, *o.status, reasonOf(o.errorReason), tid)
 	}
+	return nil
+}
 
+// toUpdateSet converts the options other than WithUpdate and StrategyDelay to an UpdateSet.
+func (k *Kharon) toUpdateSet(tid TicketId, o *Opts) *UpdateSet {
 	us := &UpdateSet{
 		Id:          tid,
 		Status:      o.status,
 		Nice:        o.nice,
 		Payload:     o.payload,
 		ErrorReason: o.errorReason,
+		Result:      o.result,
+		Attempt:     o.attempt,
 	}
 
 	switch o.delay.how {
 	case delayFixed:
 		// no-op
 		us.Runat = new(time.Time)
-		*us.Runat = time.Now().Add(o.delay.fixed.duration)
+		*us.Runat = k.now().Add(o.delay.fixed.duration)
 	case delayExponential:
 		// exponential backoff support
 		us.Backoff = &DelayBackoff{
@@ -148,22 +230,86 @@ func (k *Kharon) save(ctx context.Context, tid TicketId, o *Opts) error {
 	default:
 		// no delay
 	}
+	return us
+}
 
-	k.outcome <- msg{
-		tid: tid,
-		upd: us,
+// settle writes the outcome of a ticket together with the follow-up ticket
+// next, put as a pending ticket created now, bypassing the pusher.
+func (k *Kharon) settle(ctx context.Context, tid TicketId, o *Opts, next Ticket) (err error) {
+	markSettled(ctx, tid)
+	o.attempt = attemptOf(ctx, tid, o, k.now())
+	next.Status = status.Pending
+	next.Ctime = k.now()
+	dropReserved(&next)
+	ctx, end := k.trace(ctx, OpAdd, &next)
+	defer func() { end(err) }()
+	k.inject(ctx, &next)
+	blob, err := k.offload(ctx, &next)
+	if err != nil {
+		return err
+	}
+
+	s := Settlement{Update: UpdateSet{Id: tid}, Next: []Ticket{next}}
+	switch {
+	case !o.keep:
+		s.Delete = true
+	case o.update != nil || o.delay.how == delayStrategy:
+		s.Func = func(ctx context.Context, t *Ticket) error {
+			return k.beforeUpdate(ctx, t, o)
+		}
+	default:
+		s.Update = *k.toUpdateSet(tid, o)
+	}
+	if token, ok := leaseFrom(ctx, tid); ok {
+		s.Update.Lease = token
+	}
+
+	if err := k.store.Settle(ctx, s); err != nil {
+		k.dropBlobs(ctx, blob)
+		return k.leaseErr(ctx, tid, err)
 	}
+	if s.Delete {
+		k.dropBlobs(ctx, blobFrom(ctx))
+	}
+	if o.status != nil {
+		k.audit(ctx, *o.status, reasonOf(o.errorReason), tid)
+	}
+	k.audit(ctx, next.Status, "", next.ID)
+	k.stats.added.add(1, &next)
+	emit(ctx, k.events, TicketAdded{Ticket: next})
 	return nil
 }
 
-func (k *Kharon) delete(_ context.Context, tid TicketId) error {
+func (k *Kharon) delete(ctx context.Context, tid TicketId, o *Opts) error {
+	markSettled(ctx, tid)
+	if token, ok := leaseFrom(ctx, tid); ok {
+		// an empty conditional update only verifies the lease
+		if err := k.store.UpdateSet(ctx, UpdateSet{Id: tid, Lease: token}); err != nil {
+			return k.leaseErr(ctx, tid, err)
+		}
+	}
 	k.outcome <- msg{
-		tid: tid,
-		upd: nil,
+		tid:  tid,
+		upd:  nil,
+		blob: blobFrom(ctx),
+	}
+	if o.status != nil {
+		k.audit(ctx, *o.status, reasonOf(o.errorReason), tid)
 	}
 	return nil
 }
 
+// leaseErr counts and logs lease conflicts, returning err unchanged.
+func (k *Kharon) leaseErr(ctx context.Context, tid TicketId, err error) error {
+	if errors.Is(err, ErrLeaseLost) {
+		k.stats.leaseConflicts.add(1, handled(ctx, tid))
+		k.logger.WarnContext(ctx, "ticket lease lost, it was claimed again by another poll",
+			"ticket_id", tid,
+		)
+	}
+	return err
+}
+
 func toOpts(o *Opts, opts ...Option) *Opts {
 	for _, opt := range opts {
 		opt(o)
@@ -171,121 +317,574 @@ func toOpts(o *Opts, opts ...Option) *Opts {
 	return o
 }
 
-func (k *Kharon) Ack(ctx context.Context, tid TicketId, opts ...Option) error {
-	o := toOpts(&Opts{keep: false, status: &status.Done, delay: InfinityDelay}, opts...)
-	var err error
+func (k *Kharon) Ack(ctx context.Context, tid TicketId, opts ...Option) (err error) {
+	ctx, end := k.traceOutcome(ctx, OpAck, tid)
+	defer func() { end(err) }()
+
+	o := toOpts(&Opts{keep: false, status: &status.Done, delay: InfinityDelay, outcome: OpAck}, opts...)
 	if o.keep {
 		err = k.save(ctx, tid, o)
 	} else {
-		err = k.delete(ctx, tid)
+		err = k.delete(ctx, tid, o)
 	}
 	if err != nil {
 		return err
 	}
-	k.stats.acked.value.Add(1)
+	k.releaseDependents(ctx, tid)
+	t := handled(ctx, tid)
+	k.stats.acked.add(1, t)
+	k.stats.completed(t)
+	emit(ctx, k.events, TicketAcked{ID: tid, Ticket: copyOf(t)})
+	return nil
+}
+
+// AckAndAdd acknowledges a ticket as Ack does and adds the follow-up ticket next
+// in a single store transaction, so that next exists if and only if the ack
+// was written. Unlike Ack, the outcome is written before returning.
+// next is pending and created now; opts apply to the acked ticket.
+func (k *Kharon) AckAndAdd(ctx context.Context, tid TicketId, next Ticket, opts ...Option) (err error) {
+	ctx, end := k.traceOutcome(ctx, OpAck, tid)
+	defer func() { end(err) }()
+
+	o := toOpts(&Opts{keep: false, status: &status.Done, delay: InfinityDelay, outcome: OpAck}, opts...)
+	if err = k.settle(ctx, tid, o, next); err != nil {
+		return err
+	}
+	k.releaseDependents(ctx, tid)
+	t := handled(ctx, tid)
+	k.stats.acked.add(1, t)
+	k.stats.completed(t)
+	emit(ctx, k.events, TicketAcked{ID: tid, Ticket: copyOf(t)})
 	return nil
 }
 
 // Done marks a ticket as successfully completed.
 // It automatically adds the WithKeep option to retain the ticket in the store.
-func (k *Kharon) Done(ctx context.Context, tid TicketId, opts ...Option) error {
-	o := toOpts(&Opts{keep: true, status: &status.Done, delay: InfinityDelay}, opts...)
-	if err := k.save(ctx, tid, o); err != nil {
+func (k *Kharon) Done(ctx context.Context, tid TicketId, opts ...Option) (err error) {
+	ctx, end := k.traceOutcome(ctx, OpDone, tid)
+	defer func() { end(err) }()
+
+	o := toOpts(&Opts{keep: true, status: &status.Done, delay: InfinityDelay, outcome: OpDone}, opts...)
+	if err = k.save(ctx, tid, o); err != nil {
 		return err
 	}
-	k.stats.done.value.Add(1)
+	k.releaseDependents(ctx, tid)
+	t := handled(ctx, tid)
+	k.stats.done.add(1, t)
+	k.stats.completed(t)
 	return nil
 }
 
 // Cancel marks a ticket as cancelled.
-func (k *Kharon) Cancel(ctx context.Context, tid TicketId, opts ...Option) error {
-	o := toOpts(&Opts{keep: false, status: &status.Cancelled, delay: InfinityDelay}, opts...)
-	var err error
+func (k *Kharon) Cancel(ctx context.Context, tid TicketId, opts ...Option) (err error) {
+	ctx, end := k.traceOutcome(ctx, OpCancel, tid)
+	defer func() { end(err) }()
+
+	o := toOpts(&Opts{keep: false, status: &status.Cancelled, delay: InfinityDelay, outcome: OpCancel}, opts...)
 	if o.keep {
 		err = k.save(ctx, tid, o)
 	} else {
-		err = k.delete(ctx, tid)
+		err = k.delete(ctx, tid, o)
 	}
 	if err != nil {
 		return err
 	}
-	k.stats.canceled.value.Add(1)
+	k.stats.canceled.add(1, handled(ctx, tid))
 	return nil
 }
 
 // Fail marks a ticket as failed.
-func (k *Kharon) Fail(ctx context.Context, tid TicketId, opts ...Option) error {
-	o := toOpts(&Opts{keep: true, status: &status.Failed, delay: InfinityDelay}, opts...)
-	if err := k.save(ctx, tid, o); err != nil {
+func (k *Kharon) Fail(ctx context.Context, tid TicketId, opts ...Option) (err error) {
+	ctx, end := k.traceOutcome(ctx, OpFail, tid)
+	defer func() { end(err) }()
+
+	o := toOpts(&Opts{keep: true, status: &status.Failed, delay: InfinityDelay, outcome: OpFail}, opts...)
+	if err = k.save(ctx, tid, o); err != nil {
+		return err
+	}
+	t := handled(ctx, tid)
+	k.stats.failed.add(1, t)
+	emit(ctx, k.events, TicketFailed{ID: tid, Ticket: copyOf(t), ErrorReason: o.errorReason})
+	return nil
+}
+
+// FailAndAdd marks a ticket as failed as Fail does and adds the follow-up
+// ticket next atomically, e.g. a compensating job, see AckAndAdd.
+func (k *Kharon) FailAndAdd(ctx context.Context, tid TicketId, next Ticket, opts ...Option) (err error) {
+	ctx, end := k.traceOutcome(ctx, OpFail, tid)
+	defer func() { end(err) }()
+
+	o := toOpts(&Opts{keep: true, status: &status.Failed, delay: InfinityDelay, outcome: OpFail}, opts...)
+	if err = k.settle(ctx, tid, o, next); err != nil {
 		return err
 	}
-	k.stats.failed.value.Add(1)
+	t := handled(ctx, tid)
+	k.stats.failed.add(1, t)
+	emit(ctx, k.events, TicketFailed{ID: tid, Ticket: copyOf(t), ErrorReason: o.errorReason})
 	return nil
 }
 
 // Retry schedules a ticket for retry with updated parameters.
-func (k *Kharon) Retry(ctx context.Context, tid TicketId, opts ...Option) error {
-	o := toOpts(&Opts{keep: true}, opts...)
+func (k *Kharon) Retry(ctx context.Context, tid TicketId, opts ...Option) (err error) {
+	ctx, end := k.traceOutcome(ctx, OpRetry, tid)
+	defer func() { end(err) }()
+
+	o := toOpts(&Opts{keep: true, outcome: OpRetry}, opts...)
 	// do not update status, it should be already 'pending'
-	if err := k.save(ctx, tid, o); err != nil {
+	if err = k.save(ctx, tid, o); err != nil {
 		return err
 	}
-	k.stats.retried.value.Add(1)
+	k.stats.retried.add(1, handled(ctx, tid))
 	return nil
 }
 
+// Release returns a claimed ticket to the queue, due now unless WithDelay is
+// given, for handlers that can't process it right now, e.g. a dependency is
+// down, rather than having failed it. Unlike Retry, the claim doesn't count
+// as an attempt: its Attempts are given back, so that releasing a ticket
+// never exhausts it, and the run isn't logged in its AttemptLog. Its Lease is
+// cleared, so that a handler still holding it loses it.
+func (k *Kharon) Release(ctx context.Context, tid TicketId, opts ...Option) (err error) {
+	ctx, end := k.traceOutcome(ctx, OpRelease, tid)
+	defer func() { end(err) }()
+
+	o := toOpts(&Opts{keep: true, delay: FixedDelay(0)}, opts...)
+	update := o.update
+	o.update = func(ctx context.Context, t *Ticket) error {
+		t.Attempts = max(t.Attempts-1, 0)
+		t.Lease = ""
+		if update != nil {
+			return update(ctx, t)
+		}
+		return nil
+	}
+	return k.save(ctx, tid, o)
+}
+
 // Put adds a new ticket to the store with configured options.
-func (k *Kharon) Put(ctx context.Context, t Ticket, opts ...Option) error {
-	o := toOpts(&Opts{keep: true, status: &status.Pending}, opts...)
-	if err := beforeUpdate(ctx, &t, o); err != nil {
+// The ticket is Pending unless WithInitialStatus is given,
+// and its creation time is set to now unless WithCtime is given.
+// Returns ErrDuplicateTicket if the ticket has a UniqueKey already pending.
+func (k *Kharon) Put(ctx context.Context, t Ticket, opts ...Option) (err error) {
+	ctx, end := k.trace(ctx, OpAdd, &t)
+	defer func() { end(err) }()
+
+	o, err := putOpts(opts...)
+	if err != nil {
 		return err
 	}
-	if err := k.store.Put(ctx, t); err != nil {
+	if err = k.prepare(ctx, &t, o); err != nil {
 		return err
 	}
-	k.stats.added.value.Add(1)
+	k.inject(ctx, &t)
+	blob, err := k.offload(ctx, &t)
+	if err != nil {
+		return err
+	}
+	if err = k.store.Put(ctx, t); err != nil {
+		k.dropBlobs(ctx, blob)
+		return err
+	}
+	k.audit(ctx, t.Status, "", t.ID)
+	k.stats.added.add(1, &t)
+	emit(ctx, k.events, TicketAdded{Ticket: t})
+	return nil
+}
+
+// PutBatch adds the tickets as Put does, with opts applying to each of them,
+// in as few store round trips as the store allows, see Store.PutBatch.
+// errs is nil if every ticket was added, and otherwise errs[i] reports
+// why tickets[i] wasn't; err reports a failure of the whole batch.
+func (k *Kharon) PutBatch(ctx context.Context, tickets []Ticket, opts ...Option) (errs []error, err error) {
+	o, err := putOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := make([]Ticket, 0, len(tickets))
+	index := make([]int, 0, len(tickets)) // of batch tickets in tickets
+	blobs := make([]string, 0, len(tickets))
+	ends := make([]func(error), 0, len(tickets))
+	setErr := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(tickets))
+		}
+		errs[i] = err
+	}
+	for i, t := range tickets {
+		tctx, end := k.trace(ctx, OpAdd, &t)
+		if err := k.prepare(tctx, &t, o); err != nil {
+			end(err)
+			setErr(i, err)
+			continue
+		}
+		k.inject(tctx, &t)
+		blob, err := k.offload(tctx, &t)
+		if err != nil {
+			end(err)
+			setErr(i, err)
+			continue
+		}
+		batch = append(batch, t)
+		index = append(index, i)
+		blobs = append(blobs, blob)
+		ends = append(ends, end)
+	}
+	if len(batch) == 0 {
+		return errs, nil
+	}
+
+	putErrs, err := k.store.PutBatch(ctx, batch)
+	var added []int // of batch tickets
+	for j, i := range index {
+		switch {
+		case err != nil:
+			ends[j](err)
+			k.dropBlobs(ctx, blobs[j])
+		case putErrs != nil && putErrs[j] != nil:
+			ends[j](putErrs[j])
+			setErr(i, putErrs[j])
+			k.dropBlobs(ctx, blobs[j])
+		default:
+			ends[j](nil)
+			added = append(added, j)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]TicketId, len(added))
+	for n, j := range added {
+		ids[n] = batch[j].ID
+		k.stats.added.add(1, &batch[j])
+		emit(ctx, k.events, TicketAdded{Ticket: batch[j]})
+	}
+	if len(added) > 0 {
+		// all of them are put with the same status
+		k.audit(ctx, batch[added[0]].Status, "", ids...)
+	}
+	return errs, nil
+}
+
+// putOpts returns the options of Put and PutBatch.
+func putOpts(opts ...Option) (*Opts, error) {
+	o := toOpts(&Opts{keep: true, status: &status.Pending}, opts...)
+	if o.initialStatus != nil {
+		if _, err := status.FromString(o.initialStatus.String()); err != nil {
+			return nil, err
+		}
+		o.status = o.initialStatus
+	}
+	return o, nil
+}
+
+// prepare applies the options of Put to a new ticket.
+func (k *Kharon) prepare(ctx context.Context, t *Ticket, o *Opts) error {
+	if o.ctime != nil && !o.ctime.IsZero() {
+		t.Ctime = *o.ctime
+	} else {
+		t.Ctime = k.now()
+	}
+	if o.uniqueKey != nil {
+		t.UniqueKey = *o.uniqueKey
+	}
+	if o.deadline != nil && !o.deadline.IsZero() {
+		t.Deadline = o.deadline
+	}
+	switch {
+	case o.runAfter != nil:
+		t.Runat = k.now().Add(*o.runAfter)
+	case o.runAt != nil && !o.runAt.IsZero():
+		t.Runat = *o.runAt
+	}
+	if err := k.beforeUpdate(ctx, t, o); err != nil {
+		return err
+	}
+	dropReserved(t)
 	return nil
 }
 
 // Delete removes a ticket from the store.
 func (k *Kharon) Delete(ctx context.Context, tid TicketId) error {
+	var blob string
+	if k.settings.blobs != nil {
+		if t, err := k.store.Get(PrimaryContext(ctx), tid); err == nil {
+			blob, _ = blobOf(&t)
+		}
+	}
 	if err := k.store.Delete(ctx, tid); err != nil {
 		return err
 	}
+	k.dropBlobs(ctx, blob)
 	k.stats.deleted.value.Add(1)
 	return nil
 }
 
+// ReleaseOwned makes the tickets still held by the workers of the Kharon
+// with worker ID owner, see Settings.WithWorkerID, due now, and returns how
+// many it released, e.g. for a Kharon restarting after a crash to give back
+// the tickets of its previous run at once instead of waiting for their TTR.
+// Handlers still running them lose their lease, see WithLeaseCheck.
+func (k *Kharon) ReleaseOwned(ctx context.Context, owner string) (int, error) {
+	return k.store.ReleaseOwned(ctx, owner, k.now())
+}
+
+// ReleaseDependents removes the ticket tid from the DependsOn of the tickets
+// waiting for it, see Ticket.WithDependsOn, and returns how many there were.
+// Ack, AckAndAdd and Done call it once the ticket is settled, logging
+// failures; call it again to release the dependents of such a ticket.
+func (k *Kharon) ReleaseDependents(ctx context.Context, tid TicketId) (int, error) {
+	return k.store.ReleaseDependents(ctx, tid, k.now())
+}
+
+// releaseDependents releases the dependents of a ticket acked or done,
+// logging errors: the outcome of the ticket is written regardless.
+func (k *Kharon) releaseDependents(ctx context.Context, tid TicketId) {
+	if _, err := k.ReleaseDependents(ctx, tid); err != nil {
+		k.logger.ErrorContext(ctx, "error releasing dependent tickets",
+			"ticket_id", tid,
+			"error", err,
+		)
+	}
+}
+
+// Reschedule changes when a pending ticket becomes eligible for processing,
+// without cancelling and recreating it.
+// Returns ErrInvalidStatusTransition if the ticket is already in a terminal state.
+func (k *Kharon) Reschedule(ctx context.Context, tid TicketId, runat time.Time) error {
+	return k.store.Reschedule(ctx, tid, runat)
+}
+
+// Move redirects a misrouted ticket to the queue queue, "" being the default
+// one, see Settings.WithQueue, and to the type typ unless empty, keeping
+// everything else, e.g. its Ctime, Attempts and AttemptLog, unlike deleting
+// and putting it again. A handler still running it isn't stopped.
+// A pending ticket moved to a type whose pending tickets already hold its
+// UniqueKey isn't moved: ErrDuplicateTicket is returned instead.
+func (k *Kharon) Move(ctx context.Context, tid TicketId, queue, typ string) error {
+	return k.store.Update(ctx, tid, func(_ context.Context, t *Ticket) error {
+		now := k.now()
+		t.Queue = queue
+		if typ != "" {
+			t.Type = typ
+		}
+		t.Mtime = &now
+		return nil
+	})
+}
+
 // Get retrieves a ticket from the store.
 func (k *Kharon) Get(ctx context.Context, tid TicketId) (Ticket, error) {
 	return k.store.Get(ctx, tid)
 }
 
-func (k *Kharon) Stats() Stats {
-	return Stats{
-		Added:          k.stats.added.value.Load(),
-		Polled:         k.stats.polled.value.Load(),
-		Scheduled:      k.stats.scheduled.value.Load(),
-		Acked:          k.stats.acked.value.Load(),
-		Failed:         k.stats.failed.value.Load(),
-		Done:           k.stats.done.value.Load(),
-		Retried:        k.stats.retried.value.Load(),
-		Canceled:       k.stats.canceled.value.Load(),
-		Deleted:        k.stats.deleted.value.Load(),
-		Expired:        k.stats.expired.value.Load(),
-		Processed:      k.stats.processed.value.Load(),
-		RunningWorkers: k.stats.runningWorkers.value.Load(),
+// Exists reports whether a ticket is present in the store.
+// It is cheaper than Get as the ticket payload is never loaded.
+func (k *Kharon) Exists(ctx context.Context, tid TicketId) (bool, error) {
+	return k.store.Exists(ctx, tid)
+}
+
+// GetResult returns the result a ticket was settled with, see WithResult,
+// e.g. for the caller that put it to poll for its output; decode it with
+// DecodeResult. Returns ErrResultNotReady while the ticket is pending.
+func (k *Kharon) GetResult(ctx context.Context, tid TicketId) (any, error) {
+	return k.store.GetResult(ctx, tid)
+}
+
+// NextRetry returns the delay the backoff configured for the type of t
+// (see WithBackoff, WithTypeBackoff and WithBackoffBase) would apply to t,
+// and whether t is out of attempts per WithMaxAttempts or its WithRetryPolicy.
+// Useful to log or give up before calling Retry.
+func (k *Kharon) NextRetry(t *Ticket) (time.Duration, bool) {
+	n := k.settings.maxAttemptsOf(t.Type)
+	exhausted := n > 0 && t.Attempts >= n
+	return k.settings.pollRequest().BackoffFor(t.Type).Delay(t.Attempts), exhausted
+}
+
+// ListInFlight returns the tickets being processed right now across all
+// pollers sharing the store: polled, and whose time-to-run hasn't elapsed.
+// Tickets not started yet or waiting for a retry are not included.
+func (k *Kharon) ListInFlight(ctx context.Context) ([]Ticket, error) {
+	return k.store.ListInFlight(ctx, k.now())
+}
+
+// List returns the tickets selected by req, oldest first.
+func (k *Kharon) List(ctx context.Context, req ListRequest) ([]Ticket, error) {
+	return k.store.List(ctx, req)
+}
+
+// Search returns up to limit tickets whose payload matches the SQL/JSON path
+// predicate query, e.g. to find the ticket of an order:
+//
+//	kh.Search(ctx, `$.order_id == "A-42"`, 10)
+//
+// Returns ErrSearchUnsupported if the store doesn't implement Searcher.
+func (k *Kharon) Search(ctx context.Context, query string, limit int) ([]Ticket, error) {
+	s, ok := k.store.(Searcher)
+	if !ok {
+		return nil, ErrSearchUnsupported
+	}
+	return s.Search(ctx, query, limit)
+}
+
+// Vacuum checks the store for tickets in inconsistent states: pending tickets
+// whose Runat is past half of InfinityDelay from now, which are never polled,
+// pending tickets delivered more than their WithMaxAttempts or WithRetryPolicy
+// allows, and terminal
+// tickets without Mtime. If fix is set it also repairs them, ticket by ticket:
+// unreachable tickets are made due now, exhausted ones are dead-lettered as the poller
+// does (firing WithOnExhausted), and a missing Mtime is set to Ctime so that
+// retention keeps counting from it. Tickets changed meanwhile are left alone.
+func (k *Kharon) Vacuum(ctx context.Context, fix bool) (VacuumReport, error) {
+	now := k.now()
+	req := VacuumRequest{
+		UnreachableAfter: now.Add(InfinityDelay.fixed.duration / 2),
+		MaxAttempts:      k.settings.minMaxAttempts(),
+	}
+	report, err := k.store.Vacuum(ctx, req)
+	if err != nil {
+		return report, err
+	}
+	// the store checks the lowest max attempts of any type
+	exhausted := report.Exhausted[:0]
+	for _, t := range report.Exhausted {
+		if k.settings.exhausted(t) {
+			exhausted = append(exhausted, t)
+		}
+	}
+	report.Exhausted = exhausted
+	if !fix {
+		return report, nil
+	}
+
+	var errs []error
+	repair := func(tid TicketId, fn func(t *Ticket) bool) {
+		var fixed bool
+		err := k.store.Update(ctx, tid, func(_ context.Context, t *Ticket) error {
+			fixed = fn(t)
+			return nil
+		})
+		switch {
+		case errors.Is(err, ErrTicketNotFound):
+			// removed meanwhile
+		case err != nil:
+			errs = append(errs, fmt.Errorf("ticket %s: %w", tid, err))
+		case fixed:
+			report.Fixed++
+		}
+	}
+
+	for _, t := range report.Unreachable {
+		repair(t.ID, func(t *Ticket) bool {
+			if t.Status != status.Pending || !t.Runat.After(req.UnreachableAfter) {
+				return false
+			}
+			t.Runat = now
+			t.Mtime = &now
+			return true
+		})
+	}
+	for _, t := range report.MissingMtime {
+		repair(t.ID, func(t *Ticket) bool {
+			if t.Mtime != nil {
+				return false
+			}
+			mtime := t.Ctime
+			t.Mtime = &mtime
+			return true
+		})
+	}
+	for _, t := range report.Exhausted {
+		if err := k.exhaust(ctx, t); err != nil {
+			errs = append(errs, fmt.Errorf("ticket %s: %w", t.ID, err))
+			continue
+		}
+		report.Fixed++
+	}
+	return report, errors.Join(errs...)
+}
+
+// ExpireAll removes the tickets expired at before, as the expiration worker
+// does, in batches of batchSize until a batch removes fewer, so that a large
+// backlog is drained without a single long locking delete. It stops early
+// if ctx is cancelled between batches. Returns the number of tickets removed,
+// also on error. Returns ErrLimitInvalid if batchSize <= 0.
+func (k *Kharon) ExpireAll(ctx context.Context, batchSize int, before time.Time) (int, error) {
+	if batchSize <= 0 {
+		return 0, ErrLimitInvalid
+	}
+
+	total := 0
+	for {
+		n, err := k.store.ExpireTickets(ctx, ExpireRequest{
+			Limit:     batchSize,
+			Now:       before,
+			Retention: k.settings.retention,
+			Archive:   k.settings.archive,
+		})
+		total += int(n)
+		k.stats.expired.value.Add(n)
+		if n > 0 {
+			emit(ctx, k.events, TicketsExpired{Count: n})
+		}
+		if err != nil {
+			return total, err
+		}
+		if n < int64(batchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
 	}
 }
 
+// Stats returns a snapshot of the counters. Each counter is read atomically,
+// but not all of them at once, so they may be slightly out of step.
+func (k *Kharon) Stats() Stats {
+	s := k.totals()
+	s.ByType = k.stats.breakdown(func(c *counter) *sync.Map { return &c.byType })
+	s.ByQueue = k.stats.breakdown(func(c *counter) *sync.Map { return &c.byQueue })
+	s.StartLatency = k.stats.startLatency.snapshot()
+	s.HandlerDuration = k.stats.handlerDuration.snapshot()
+	s.Attempts = k.stats.attempts.snapshot()
+	return s
+}
+
+// totals returns the Stats without their breakdowns.
+func (k *Kharon) totals() Stats {
+	s := k.stats.snapshot(func(c *counter) int64 { return c.value.Load() })
+	s.RunningWorkers = k.stats.runningWorkers.value.Load()
+	return s
+}
+
 // Run starts the Kharon job processing system with the given context and router.
 // It spawns worker goroutines and begins polling for tickets to process.
-// Returns when ctx is cancelled or an error occurs.
+// Returns when ctx is cancelled or an error occurs, or nil once Shutdown
+// has stopped it.
 //
-// On shutdown, all goroutines exit immediately. In-flight tickets are not
-// drained since they are persisted and will be reprocessed on next startup.
+// When ctx is cancelled, all goroutines exit immediately. In-flight tickets
+// are not drained since they are persisted and will be reprocessed on next
+// startup; use Shutdown to drain them instead.
 func (k *Kharon) Run(ctx context.Context, r *Router) error {
-	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// intake is cancelled first on Shutdown, letting handlers finish
+	intake, stopIntake := context.WithCancelCause(ctx)
+	defer stopIntake(nil)
+
+	run := &running{stopIntake: stopIntake, cancel: cancel, done: make(chan struct{})}
+	k.mu.Lock()
+	k.run = run
+	k.mu.Unlock()
+	defer func() {
+		k.mu.Lock()
+		k.run = nil
+		k.mu.Unlock()
+		close(run.done)
+	}()
+
+	var wg, workers sync.WaitGroup
 
 	// Start pusher
 	wg.Add(1)
@@ -293,16 +892,41 @@ func (k *Kharon) Run(ctx context.Context, r *Router) error {
 
 	// Start workers
 	for i := 0; i < k.settings.workers; i++ {
-		wg.Add(1)
-		go k.runWorker(ctx, r, &wg)
+		workers.Add(1)
+		go k.runWorker(ctx, intake, r, fmt.Sprintf("%s/%d", k.settings.workerID, i), &workers)
 	}
 
+	// Start rates sampler
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		k.runRatesSampler(ctx)
+	}()
+
 	// Start expiration worker
 	if k.settings.enableExpiration {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			k.runExpirationWorker(ctx)
+			k.runExpirationWorker(intake)
+		}()
+	}
+
+	// Start store notifications listener
+	if n, ok := k.store.(Notifier); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.runListener(intake, n)
+		}()
+	}
+
+	// Start scheduler
+	if len(k.settings.schedules) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.runScheduler(intake)
 		}()
 	}
 
@@ -310,23 +934,31 @@ func (k *Kharon) Run(ctx context.Context, r *Router) error {
 		"workers", k.settings.workers,
 		"min_poll_timeout", k.settings.minReactionDelay.String(),
 		"max_poll_timeout", k.settings.maxReactionDelay.String(),
+		"poll_jitter", k.settings.reactionJitter.String(),
 		"batch_size", k.settings.batchSize,
 		"process_time", k.settings.processTime.String(),
 	)
 
-	// Run main polling loop (blocks until ctx cancelled)
-	pollErr := k.runPoller(ctx)
+	// Run main polling loop (blocks until ctx cancelled or Shutdown)
+	pollErr := k.runPoller(intake)
 
-	// Wait for all goroutines to exit
+	// Wait for the workers to finish their tickets, then for all goroutines to exit
+	workers.Wait()
+	if shuttingDown(intake) {
+		k.requeue(ctx, k.drainIncome())
+		pollErr = nil
+	}
+	cancel()
 	wg.Wait()
 
 	k.logger.InfoContext(ctx, "shutdown complete")
 	return pollErr
 }
 
-// runWorker processes tickets from income channel.
-// Exits when ctx is cancelled.
-func (k *Kharon) runWorker(ctx context.Context, r *Router, wg *sync.WaitGroup) {
+// runWorker processes tickets from income channel with ctx.
+// Exits when intake is cancelled, once done with the current ticket.
+// worker identifies it in the AttemptLog of its tickets.
+func (k *Kharon) runWorker(ctx, intake context.Context, r *Router, worker string, wg *sync.WaitGroup) {
 	k.stats.runningWorkers.value.Add(1)
 	defer wg.Done()
 	defer k.stats.runningWorkers.value.Add(-1)
@@ -334,11 +966,23 @@ func (k *Kharon) runWorker(ctx context.Context, r *Router, wg *sync.WaitGroup) {
 
 	for {
 		select {
-		case <-ctx.Done():
+		case <-intake.Done():
 			return
 		case t := <-k.income:
-			k.processTicket(ctx, r, t)
-			k.stats.processed.value.Add(1)
+			// both may be ready, select picking either: a ticket received
+			// once intake is cancelled is left unhandled, as drainIncome does
+			if intake.Err() != nil {
+				k.release(t)
+				if shuttingDown(intake) {
+					k.requeue(ctx, []*Ticket{t})
+				}
+				return
+			}
+			if k.throttle(ctx, intake, t) {
+				k.processTicket(ctx, r, t, worker)
+				k.stats.processed.add(1, t)
+			}
+			k.release(t)
 		}
 	}
 }
@@ -366,10 +1010,12 @@ func (k *Kharon) runPusher(ctx context.Context, wg *sync.WaitGroup) {
 
 		delIds := make([]TicketId, 0, len(batch))
 		upds := make([]UpdateSet, 0, len(batch))
+		var blobs []string
 
 		for _, m := range batch {
 			if m.upd == nil {
 				delIds = append(delIds, m.tid)
+				blobs = append(blobs, m.blob)
 			} else {
 				upds = append(upds, *m.upd)
 			}
@@ -386,6 +1032,8 @@ func (k *Kharon) runPusher(ctx context.Context, wg *sync.WaitGroup) {
 			if len(delIds) > 0 {
 				if err := k.store.DeleteBatch(flushCtx, delIds); err != nil {
 					k.logger.ErrorContext(flushCtx, "error deleting batch", "error", err)
+				} else {
+					k.dropBlobs(flushCtx, blobs...)
 				}
 			}
 			if len(upds) > 0 {
@@ -404,10 +1052,12 @@ func (k *Kharon) runPusher(ctx context.Context, wg *sync.WaitGroup) {
 
 		delIds := make([]TicketId, 0, len(batch))
 		upds := make([]UpdateSet, 0, len(batch))
+		var blobs []string
 
 		for _, m := range batch {
 			if m.upd == nil {
 				delIds = append(delIds, m.tid)
+				blobs = append(blobs, m.blob)
 			} else {
 				upds = append(upds, *m.upd)
 			}
@@ -417,6 +1067,8 @@ func (k *Kharon) runPusher(ctx context.Context, wg *sync.WaitGroup) {
 		if len(delIds) > 0 {
 			if err := k.store.DeleteBatch(flushCtx, delIds); err != nil {
 				k.logger.ErrorContext(flushCtx, "error deleting batch", "error", err)
+			} else {
+				k.dropBlobs(flushCtx, blobs...)
 			}
 		}
 		if len(upds) > 0 {
@@ -429,6 +1081,15 @@ func (k *Kharon) runPusher(ctx context.Context, wg *sync.WaitGroup) {
 	for {
 		select {
 		case <-ctx.Done():
+			// the outcomes of the last handlers may still be queued
+			for drained := false; !drained; {
+				select {
+				case m := <-k.outcome:
+					batch = append(batch, m)
+				default:
+					drained = true
+				}
+			}
 			// Final sync flush with fresh context
 			flushCtx, cancel := context.WithTimeout(context.Background(), k.settings.shutdownFlushTimeout)
 			syncFlush(flushCtx)
@@ -453,17 +1114,62 @@ func (k *Kharon) runPoller(ctx context.Context) error {
 	sleepDuration := k.settings.maxReactionDelay
 	timer := time.NewTimer(sleepDuration)
 	defer timer.Stop()
+	// in the time of the clock, that of notified tickets
+	deadline := k.now().Add(sleepDuration)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case runat := <-k.wake:
+			// poll earlier if the notified ticket is due before the next poll,
+			// no more often than minReactionDelay so bursts are coalesced
+			if runat.Before(deadline) {
+				d := max(runat.Sub(k.now()), k.settings.minReactionDelay)
+				timer.Reset(d)
+				deadline = k.now().Add(d)
+			}
 		case <-timer.C:
 			sleepDuration = k.poll(ctx)
 			if sleepDuration == 0 {
 				return ctx.Err()
 			}
 			timer.Reset(sleepDuration)
+			deadline = k.now().Add(sleepDuration)
+		}
+	}
+}
+
+// wakeBuffer is the number of notifications queued for the poller, more are
+// dropped while it is busy polling anyway.
+const wakeBuffer = 64
+
+// runListener subscribes to the notifications of store n until ctx is
+// cancelled, listening again after idleDelay if the subscription fails;
+// polling goes on meanwhile.
+func (k *Kharon) runListener(ctx context.Context, n Notifier) {
+	notify := func(runat time.Time) {
+		select {
+		case k.wake <- runat:
+		default:
+		}
+	}
+	for {
+		err := n.Listen(ctx, notify)
+		switch {
+		case ctx.Err() != nil:
+			k.logger.DebugContext(ctx, "store notifications listener exiting")
+			return
+		case errors.Is(err, ErrNotifyDisabled):
+			k.logger.DebugContext(ctx, "store notifications disabled, polling only")
+			return
+		}
+		k.logger.WarnContext(ctx, "store notifications lost, polling only until reconnected", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(k.idleDelay()):
 		}
 	}
 }
@@ -478,83 +1184,241 @@ func (k *Kharon) poll(ctx context.Context) time.Duration {
 		default:
 		}
 
-		result, err := k.store.PollPending(ctx, PollRequest{
-			Limit:           k.settings.batchSize,
-			Now:             time.Now(),
-			TTR:             k.settings.processTime,
-			BackoffBase:     k.settings.backoffBase,
-			MaxBackoffDelay: k.settings.maxBackoffDelay,
+		pctx, end := k.trace(ctx, OpPoll, nil)
+		result, err := k.store.PollPending(pctx, PollRequest{
+			Limit:              k.settings.batchSize,
+			Now:                k.now(),
+			TTR:                k.settings.processTime,
+			BackoffBase:        k.settings.backoffBase,
+			MaxBackoffDelay:    k.settings.maxBackoffDelay,
+			Backoff:            k.settings.backoff,
+			BackoffPerType:     k.settings.backoffPerType,
+			Jitter:             k.settings.backoffJitter,
+			Queue:              k.settings.queue,
+			Labels:             k.settings.labelSelector,
+			Tenants:            k.settings.tenants,
+			FairShare:          k.settings.fairShare,
+			TypeShare:          k.settings.typeShare,
+			CatchUp:            k.settings.catchUp,
+			MaxInFlightPerType: k.settings.maxInFlight,
+			LimitPerType:       k.freeSlots(),
+			Boost:              k.settings.boost,
+			Priority:           k.settings.priority,
+			Owner:              k.settings.workerID,
 		})
+		end(err)
 
 		if err != nil {
 			k.logger.ErrorContext(ctx, "error polling store", "error", err)
-			return k.settings.maxReactionDelay
+			if len(result.Tickets) == 0 {
+				return k.idleDelay()
+			}
+			// claimed before the store failed, e.g. from the other children of multi.Store
+		}
+
+		if result.Dropped > 0 {
+			k.stats.canceled.value.Add(int64(result.Dropped))
+			k.logger.WarnContext(ctx, "cancelled stale tickets", "count", result.Dropped)
+			if len(result.Tickets) == 0 {
+				// the rest of the backlog may be ready
+				continue
+			}
 		}
 
 		if result.SleepUntil != nil {
-			d := time.Until(*result.SleepUntil)
-			d = min(d, k.settings.maxReactionDelay)
-			d = max(d, k.settings.minReactionDelay)
-			return d
+			d := result.SleepUntil.Sub(k.now())
+			if d >= k.settings.maxReactionDelay {
+				return k.idleDelay()
+			}
+			return max(d, k.settings.minReactionDelay)
 		}
 
 		if len(result.Tickets) == 0 {
-			return k.settings.maxReactionDelay
+			return k.idleDelay()
 		}
 
-		k.stats.polled.value.Add(int64(len(result.Tickets)))
-
-		for _, t := range result.Tickets {
-			select {
-			case k.income <- &t:
-				k.stats.scheduled.value.Add(1)
-			case <-ctx.Done():
-				return 0
+		for i, t := range result.Tickets {
+			k.stats.polled.add(1, &t)
+			emit(ctx, k.events, TicketPolled{Ticket: t})
+			if k.settings.exhausted(t) {
+				k.exhaust(ctx, t)
+				continue
+			}
+			if now := k.now(); overdue(t, now) {
+				k.settleOverdue(ctx, t.ID, now)
+				continue
 			}
+			if k.acquire(ctx, &t) {
+				select {
+				case k.income <- &t:
+					k.stats.scheduled.add(1, &t)
+					continue
+				case <-ctx.Done():
+					k.release(&t)
+				}
+			}
+			if shuttingDown(ctx) {
+				unsent := make([]*Ticket, 0, len(result.Tickets)-i)
+				for j := i; j < len(result.Tickets); j++ {
+					unsent = append(unsent, &result.Tickets[j])
+				}
+				k.requeue(ctx, unsent)
+			}
+			return 0
 		}
 	}
 }
 
+// exhaust moves a ticket that was polled more than maxAttempts times to status.Dead.
+// The transition is written synchronously so that onExhausted fires only once
+// the ticket is terminal and can no longer be polled again.
+// Errors are logged, and returned for callers keeping count.
+func (k *Kharon) exhaust(ctx context.Context, t Ticket) error {
+	runat := k.now().Add(InfinityDelay.fixed.duration)
+	err := k.store.UpdateSet(ctx, UpdateSet{
+		Id:     t.ID,
+		Status: &status.Dead,
+		Runat:  &runat,
+	})
+	if err != nil {
+		k.logger.ErrorContext(ctx, "error dead-lettering exhausted ticket",
+			"ticket_id", t.ID,
+			"type", t.Type,
+			"error", err,
+		)
+		return err
+	}
+
+	k.audit(ctx, status.Dead, fmt.Sprintf("exhausted %d attempts", t.Attempts), t.ID)
+	k.stats.exhausted.add(1, &t)
+	k.logger.WarnContext(ctx, "ticket exhausted its attempts",
+		"ticket_id", t.ID,
+		"type", t.Type,
+		"attempts", t.Attempts,
+	)
+
+	t.Status = status.Dead
+	t.Runat = runat
+	if k.settings.onExhausted != nil {
+		k.settings.onExhausted(ctx, t)
+	}
+	return nil
+}
+
+// idleDelay returns the wait before the next poll when no ticket is due
+// within maxReactionDelay, shortened by a random jitter if configured.
+func (k *Kharon) idleDelay() time.Duration {
+	d := k.settings.maxReactionDelay
+	if k.settings.reactionJitter > 0 {
+		d -= rand.N(k.settings.reactionJitter)
+	}
+	return max(d, k.settings.minReactionDelay)
+}
+
+// RunExpirer runs the expiration worker alone until ctx is done, as Run does
+// with WithExpiration, e.g. in a dedicated process of a fleet whose Kharons
+// run without it. The expiration settings apply, WithLeader included.
+func (k *Kharon) RunExpirer(ctx context.Context) {
+	k.runExpirationWorker(ctx)
+}
+
 // runExpirationWorker runs a background worker that periodically expires old tickets.
 // This worker is independent from the main pipeline and uses ctx.Done() for shutdown.
 func (k *Kharon) runExpirationWorker(ctx context.Context) {
-	k.logger.InfoContext(ctx, "ticket expiration worker started")
-	ticker := time.NewTicker(k.settings.expirationInterval)
-	defer ticker.Stop()
+	k.logger.InfoContext(ctx, "ticket expiration worker started",
+		"interval", k.settings.expirationInterval.String(),
+		"batch_size", k.settings.expirationBatchSize,
+	)
+	timer := time.NewTimer(k.expirationDelay())
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			k.logger.DebugContext(ctx, "ticket expiration worker exiting")
 			return
-		case <-ticker.C:
-			if n, err := k.store.ExpireTickets(ctx, ExpirationBatchSize, time.Now()); err != nil {
-				k.logger.ErrorContext(ctx, "error expiring tickets", "error", err)
-			} else if n > 0 {
-				k.stats.expired.value.Add(n)
-				k.logger.DebugContext(ctx, "ticket expiration run completed", "expired_count", n)
-			}
+		case <-timer.C:
+		}
+		if k.leads(ctx, "expiration") {
+			k.expireOnce(ctx)
 		}
+		timer.Reset(k.expirationDelay())
 	}
 }
 
-// processTicket processes a single ticket with the appropriate handler.
-func (k *Kharon) processTicket(ctx context.Context, r *Router, t *Ticket) {
-	handler := r.Handler(t)
-	defer func() {
-		if r := recover(); r != nil {
-			k.logger.ErrorContext(ctx, "panic occurred while processing ticket",
-				"ticket_id", t.ID,
-				"type", t.Type,
-				"panic", r,
-			)
-			debug.PrintStack()
-		}
-	}()
+// expirationDelay returns the wait before the next expiration run,
+// lengthened by a random duration below the expiration jitter.
+func (k *Kharon) expirationDelay() time.Duration {
+	d := k.settings.expirationInterval
+	if k.settings.expirationJitter > 0 {
+		d += rand.N(k.settings.expirationJitter)
+	}
+	return d
+}
 
-	rctx, cancel := context.WithDeadline(ctx, t.Runat)
+// expireOnce runs a single pass of the expiration worker.
+func (k *Kharon) expireOnce(ctx context.Context) {
+	req := ExpireRequest{
+		Limit:     k.settings.expirationBatchSize,
+		Now:       k.now(),
+		Retention: k.settings.retention,
+		Archive:   k.settings.archive,
+	}
+	n, err := k.store.ExpireTickets(ctx, req)
+	if err != nil {
+		k.logger.ErrorContext(ctx, "error expiring tickets", "error", err)
+	}
+	if n > 0 {
+		k.stats.expired.value.Add(n)
+		emit(ctx, k.events, TicketsExpired{Count: n})
+		k.logger.DebugContext(ctx, "ticket expiration run completed", "expired_count", n)
+	}
+	if n, _ := k.expireOverdue(ctx, req.Now, k.settings.expirationBatchSize); n > 0 {
+		k.logger.DebugContext(ctx, "overdue tickets settled", "count", n)
+	}
+}
+
+// processTicket processes a single ticket with the appropriate handler.
+func (k *Kharon) processTicket(ctx context.Context, r *Router, t *Ticket, worker string) {
+	// removing t deletes its offloaded payload
+	if blob, ok := blobOf(t); ok {
+		ctx = withBlob(ctx, blob)
+	}
+	// the deadline is t.Runat, when the ticket is redelivered, unless touched
+	rctx, cancel := withReservation(ctx, t, k.now())
 	defer cancel()
-	err := handler.ProcessTicket(rctx, t)
+	if k.settings.leaseCheck {
+		rctx = withLease(rctx, t)
+	}
+	rctx = k.extract(rctx, t)
+	if c := k.settings.codecs[t.Type]; c != nil {
+		rctx = context.WithValue(rctx, codecKey{}, c)
+	}
+	// the attempt is timed by the clock of k, the handler duration by the wall clock
+	start, began := k.now(), time.Now()
+	run := &Attempt{Number: t.Attempts, Start: start, Worker: worker}
+	rctx, settled := withSettled(rctx, t, run)
+	defer k.track(t, settled)()
+	rctx, end := k.trace(rctx, OpProcess, t)
+	if t.Attempts == 1 {
+		k.stats.startLatency.observe(start.Sub(t.Ctime).Seconds())
+	}
+	err := k.LoadPayload(rctx, t)
+	if err == nil {
+		err = handle(rctx, r, t)
+	}
+	k.stats.handlerDuration.observe(time.Since(began).Seconds())
+	end(err)
+
+	// outcomes settled on behalf of the handler count for t in Stats
+	ctx, _ = withSettled(ctx, t, run)
+
+	// Shutdown claims the outcome of the tickets it reschedules
+	var p *PanicError
+	if errors.As(err, &p) {
+		k.recovered(ctx, t, p, !settled.CompareAndSwap(false, true))
+		return
+	}
 	if err != nil {
 		k.logger.ErrorContext(ctx, "error processing ticket",
 			"ticket_id", t.ID,
@@ -562,4 +1426,35 @@ func (k *Kharon) processTicket(ctx context.Context, r *Router, t *Ticket) {
 			"error", err,
 		)
 	}
+	if retryable := k.settings.retryPolicies[t.Type].Retryable; err != nil && retryable != nil {
+		if settled.CompareAndSwap(false, true) {
+			k.classify(ctx, t, err, retryable)
+		}
+		return
+	}
+	if k.settings.autoSettle && settled.CompareAndSwap(false, true) {
+		k.autoSettle(ctx, t, err)
+	}
+}
+
+// autoSettle acks or fails a ticket whose handler returned without reporting
+// an outcome, depending on the handler's error.
+// ctx is the worker's, the handler deadline may have passed already.
+func (k *Kharon) autoSettle(ctx context.Context, t *Ticket, herr error) {
+	if k.settings.leaseCheck {
+		ctx = withLease(ctx, t)
+	}
+	var err error
+	if herr == nil {
+		err = k.Ack(ctx, t.ID)
+	} else {
+		err = k.Fail(ctx, t.ID, WithErrorReason(herr))
+	}
+	if err != nil {
+		k.logger.ErrorContext(ctx, "error settling ticket",
+			"ticket_id", t.ID,
+			"type", t.Type,
+			"error", err,
+		)
+	}
 }
@@ -0,0 +1,27 @@
+package lymbo
+
+import "context"
+
+// Leader elects a single process of a fleet to run the singleton background
+// tasks of its Kharon, the expiration worker and the scheduler, see
+// Settings.WithLeader, or tasks of its own.
+type Leader interface {
+	// Elected reports whether this process is the leader, trying to become
+	// it if no other process is. It is called before every run of a task,
+	// so that a process takes over once the leader is gone.
+	Elected(ctx context.Context) (bool, error)
+}
+
+// leads reports whether this Kharon runs the singleton task, without a
+// Leader always, logging an election that failed.
+func (k *Kharon) leads(ctx context.Context, task string) bool {
+	if k.settings.leader == nil {
+		return true
+	}
+	elected, err := k.settings.leader.Elected(ctx)
+	if err != nil {
+		k.logger.WarnContext(ctx, "leader election failed", "task", task, "error", err)
+		return false
+	}
+	return elected
+}
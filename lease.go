@@ -0,0 +1,35 @@
+package lymbo
+
+import "context"
+
+type leaseKey struct{}
+
+// lease is the token of the poll that delivered a ticket to a handler.
+type lease struct {
+	tid   TicketId
+	token string
+}
+
+// withLease returns a handler context carrying the ticket's lease token.
+func withLease(ctx context.Context, t *Ticket) context.Context {
+	return context.WithValue(ctx, leaseKey{}, lease{tid: t.ID, token: t.Lease})
+}
+
+// LeaseContext returns ctx presenting the lease token stamped on ticket tid
+// by the poll that claimed it, e.g. the Lease of a ticket read from
+// Store.PollPending by a consumer of its own: Ack, Done, Fail, Cancel, Retry
+// and Touch called with it fail with ErrLeaseLost if the ticket was claimed
+// again meanwhile, instead of clobbering the newer attempt. Unlike
+// WithLeaseCheck, it checks outcomes reported outside of handlers too.
+func LeaseContext(ctx context.Context, tid TicketId, token string) context.Context {
+	return context.WithValue(ctx, leaseKey{}, lease{tid: tid, token: token})
+}
+
+// leaseFrom returns the lease token for tid if ctx belongs to a handler processing it.
+func leaseFrom(ctx context.Context, tid TicketId) (string, bool) {
+	l, ok := ctx.Value(leaseKey{}).(lease)
+	if !ok || l.tid != tid || l.token == "" {
+		return "", false
+	}
+	return l.token, true
+}
@@ -0,0 +1,211 @@
+// Package metrics exports the activity of a Kharon as Prometheus metrics.
+//
+// Usage:
+//
+//	kh := lymbo.NewKharon(store, settings, logger)
+//	prometheus.MustRegister(metrics.NewCollector(kh, metrics.Config{}))
+//	http.Handle("/metrics", promhttp.Handler())
+//
+// The counters and histograms mirror lymbo.Stats of the Kharon, so they count
+// the activity of this process only and restart from zero on ResetStats, which
+// Prometheus handles as a counter reset. The gauges describe the whole store, shared by
+// every Kharon, and are computed on each scrape by listing pending tickets.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultNamespace prefixes the metric names when Config.Namespace is empty.
+const DefaultNamespace = "lymbo"
+
+// DefaultStoreTimeout bounds the store queries of a scrape when Config.StoreTimeout is 0.
+const DefaultStoreTimeout = 5 * time.Second
+
+type Config struct {
+	// Namespace prefixes the metric names. Defaults to DefaultNamespace.
+	Namespace string
+
+	// ConstLabels are added to every metric, e.g. to tell queues apart.
+	ConstLabels prometheus.Labels
+
+	// StoreTimeout bounds the store queries made by each scrape for the gauges.
+	// Defaults to DefaultStoreTimeout. A negative value disables the store gauges,
+	// e.g. for stores too large to be listed on every scrape.
+	StoreTimeout time.Duration
+}
+
+// Collector is a prometheus.Collector of the metrics of a Kharon.
+type Collector struct {
+	kh           *lymbo.Kharon
+	storeTimeout time.Duration
+
+	counters       []counter
+	histograms     []histogram
+	runningWorkers *prometheus.Desc
+	pending        *prometheus.Desc
+	inFlight       *prometheus.Desc
+	latency        *prometheus.Desc
+	scrapeErrors   *prometheus.Desc
+}
+
+// counter is a Stats field exported as a counter.
+type counter struct {
+	desc  *prometheus.Desc
+	value func(lymbo.Stats) int64
+}
+
+// histogram is a Stats histogram exported as a histogram.
+type histogram struct {
+	desc  *prometheus.Desc
+	value func(lymbo.Stats) lymbo.Histogram
+}
+
+// Ensure Collector implements prometheus.Collector interface.
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a collector of the metrics of kh, to be registered
+// with a prometheus.Registerer.
+func NewCollector(kh *lymbo.Kharon, cfg Config) *Collector {
+	if cfg.Namespace == "" {
+		cfg.Namespace = DefaultNamespace
+	}
+	if cfg.StoreTimeout == 0 {
+		cfg.StoreTimeout = DefaultStoreTimeout
+	}
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(cfg.Namespace, "", name), help, labels, cfg.ConstLabels)
+	}
+	tickets := func(name, help string, value func(lymbo.Stats) int64) counter {
+		return counter{desc: desc("tickets_"+name+"_total", help), value: value}
+	}
+
+	return &Collector{
+		kh:           kh,
+		storeTimeout: cfg.StoreTimeout,
+		counters: []counter{
+			tickets("added", "Tickets added to the store.", func(s lymbo.Stats) int64 { return s.Added }),
+			tickets("polled", "Tickets claimed from the store by the poller.", func(s lymbo.Stats) int64 { return s.Polled }),
+			tickets("scheduled", "Tickets sent to workers.", func(s lymbo.Stats) int64 { return s.Scheduled }),
+			tickets("processed", "Tickets processed by workers.", func(s lymbo.Stats) int64 { return s.Processed }),
+			tickets("acked", "Tickets acknowledged.", func(s lymbo.Stats) int64 { return s.Acked }),
+			tickets("done", "Tickets marked as done.", func(s lymbo.Stats) int64 { return s.Done }),
+			tickets("failed", "Tickets marked as failed.", func(s lymbo.Stats) int64 { return s.Failed }),
+			tickets("retried", "Tickets rescheduled for retry.", func(s lymbo.Stats) int64 { return s.Retried }),
+			tickets("canceled", "Tickets canceled.", func(s lymbo.Stats) int64 { return s.Canceled }),
+			tickets("deleted", "Tickets deleted.", func(s lymbo.Stats) int64 { return s.Deleted }),
+			tickets("expired", "Tickets removed by expiration.", func(s lymbo.Stats) int64 { return s.Expired }),
+			tickets("exhausted", "Tickets dead-lettered for running out of attempts.", func(s lymbo.Stats) int64 { return s.Exhausted }),
+			{
+				desc:  desc("lease_conflicts_total", "Outcomes rejected because the ticket was claimed again meanwhile."),
+				value: func(s lymbo.Stats) int64 { return s.LeaseConflicts },
+			},
+		},
+		histograms: []histogram{
+			{
+				desc:  desc("ticket_start_latency_seconds", "Time from the creation of tickets to the start of their first handling."),
+				value: func(s lymbo.Stats) lymbo.Histogram { return s.StartLatency },
+			},
+			{
+				desc:  desc("handler_duration_seconds", "Time handlers ran for."),
+				value: func(s lymbo.Stats) lymbo.Histogram { return s.HandlerDuration },
+			},
+			{
+				desc:  desc("ticket_attempts", "Attempts of the tickets acked or marked done by their handlers."),
+				value: func(s lymbo.Stats) lymbo.Histogram { return s.Attempts },
+			},
+		},
+		runningWorkers: desc("running_workers", "Worker goroutines currently running."),
+		pending:        desc("pending_tickets", "Pending tickets in the store, in flight or not.", "type"),
+		inFlight:       desc("in_flight_tickets", "Pending tickets leased by a poller whose time-to-run hasn't elapsed.", "type"),
+		latency:        desc("queue_latency_seconds", "How long the oldest ready ticket not in flight has been due.", "type"),
+		scrapeErrors:   desc("store_scrape_error", "1 if the store gauges couldn't be computed on this scrape, 0 otherwise."),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.counters {
+		ch <- m.desc
+	}
+	for _, m := range c.histograms {
+		ch <- m.desc
+	}
+	ch <- c.runningWorkers
+	if c.storeTimeout > 0 {
+		ch <- c.pending
+		ch <- c.inFlight
+		ch <- c.latency
+		ch <- c.scrapeErrors
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.kh.Stats()
+	for _, m := range c.counters {
+		ch <- prometheus.MustNewConstMetric(m.desc, prometheus.CounterValue, float64(m.value(stats)))
+	}
+	for _, m := range c.histograms {
+		h := m.value(stats)
+		buckets := make(map[float64]uint64, len(h.Bounds))
+		for i, le := range h.Bounds {
+			buckets[le] = uint64(h.Counts[i])
+		}
+		ch <- prometheus.MustNewConstHistogram(m.desc, uint64(h.Count), h.Sum, buckets)
+	}
+	ch <- prometheus.MustNewConstMetric(c.runningWorkers, prometheus.GaugeValue, float64(stats.RunningWorkers))
+
+	if c.storeTimeout > 0 {
+		c.collectStore(ch)
+	}
+}
+
+// typeGauges are the store gauges of a ticket type.
+type typeGauges struct {
+	pending, inFlight int
+	oldest            time.Time
+}
+
+// collectStore computes the store gauges from the pending tickets.
+func (c *Collector) collectStore(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.storeTimeout)
+	defer cancel()
+
+	now := c.kh.Now()
+	tickets, err := c.kh.List(ctx, lymbo.ListRequest{Status: &status.Pending})
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(c.scrapeErrors, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.scrapeErrors, prometheus.GaugeValue, 0)
+
+	types := make(map[string]*typeGauges)
+	for _, t := range tickets {
+		g, ok := types[t.Type]
+		if !ok {
+			g = &typeGauges{}
+			types[t.Type] = g
+		}
+		g.pending++
+		switch {
+		case t.Attempts > 0 && t.Runat.After(now):
+			g.inFlight++
+		case !t.Runat.After(now) && (g.oldest.IsZero() || t.Runat.Before(g.oldest)):
+			g.oldest = t.Runat
+		}
+	}
+
+	for typ, g := range types {
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(g.pending), typ)
+		ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(g.inFlight), typ)
+		var latency time.Duration
+		if !g.oldest.IsZero() {
+			latency = now.Sub(g.oldest)
+		}
+		ch <- prometheus.MustNewConstMetric(c.latency, prometheus.GaugeValue, latency.Seconds(), typ)
+	}
+}
@@ -0,0 +1,284 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ochaton/lymbo"
+)
+
+// DualStore is a lymbo.Store reading from a primary store and writing to
+// both it and a secondary one, to cut a live queue over from the first to
+// the second: every ticket changed in the primary store is copied as it is
+// then to the secondary one, or deleted from it if no longer in the primary.
+// Operations changing tickets the DualStore doesn't know of, such as
+// ReleaseOwned, RetryFailed, Pause and ExpireTickets, are applied to both.
+// The result of an operation is that of the primary store: failures of the
+// secondary one are passed to the onError of NewDualStore, to fix later with
+// Backfill or Copy.
+type DualStore struct {
+	lymbo.Store
+
+	secondary lymbo.Store
+	onError   func(ctx context.Context, err error)
+
+	// locks serialize the copies of a ticket, so that the last one wins.
+	locks [64]sync.Mutex
+}
+
+var (
+	_ lymbo.Store    = (*DualStore)(nil)
+	_ lymbo.Notifier = (*DualStore)(nil)
+	_ lymbo.Searcher = (*DualStore)(nil)
+)
+
+// NewDualStore returns a DualStore over primary and secondary. onError is
+// called with the failures of the secondary store, which are logged with
+// slog if nil.
+func NewDualStore(primary, secondary lymbo.Store, onError func(ctx context.Context, err error)) *DualStore {
+	if onError == nil {
+		onError = func(ctx context.Context, err error) {
+			slog.ErrorContext(ctx, "failed to write to the secondary store", "error", err)
+		}
+	}
+	return &DualStore{Store: primary, secondary: secondary, onError: onError}
+}
+
+// Primary returns the store read from.
+func (d *DualStore) Primary() lymbo.Store { return d.Store }
+
+// Secondary returns the store the writes are copied to.
+func (d *DualStore) Secondary() lymbo.Store { return d.secondary }
+
+// Backfill copies the tickets of the primary store selected by cfg to the
+// secondary one, as Copy does, but as they are when copied, so that tickets
+// changed meanwhile by the Kharons running on d are never copied as of
+// before their change, and returns how many it copied. It stops at the first
+// ticket that couldn't be copied, returning a TicketError.
+func (d *DualStore) Backfill(ctx context.Context, cfg Config) (int, error) {
+	var n int
+	err := pages(ctx, d.Store, cfg, func(page []lymbo.Ticket) error {
+		for _, t := range page {
+			if err := d.copy(ctx, t.ID); err != nil {
+				return &TicketError{ID: t.ID, Err: err}
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// copy writes the ticket id to the secondary store as it is in the primary one.
+func (d *DualStore) copy(ctx context.Context, id lymbo.TicketId) error {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	mu := &d.locks[h.Sum32()%uint32(len(d.locks))]
+	mu.Lock()
+	defer mu.Unlock()
+
+	t, err := d.Store.Get(lymbo.PrimaryContext(ctx), id)
+	switch {
+	case errors.Is(err, lymbo.ErrTicketNotFound):
+		return d.secondary.Delete(ctx, id)
+	case err != nil:
+		return err
+	}
+	return d.secondary.Put(ctx, t)
+}
+
+// mirror copies the tickets ids to the secondary store, reporting failures.
+func (d *DualStore) mirror(ctx context.Context, ids ...lymbo.TicketId) {
+	for _, id := range ids {
+		if err := d.copy(ctx, id); err != nil {
+			d.onError(ctx, &TicketError{ID: id, Err: err})
+		}
+	}
+}
+
+// also reports the failure of an operation applied to the secondary store.
+func (d *DualStore) also(ctx context.Context, err error) {
+	if err != nil {
+		d.onError(ctx, err)
+	}
+}
+
+func (d *DualStore) Put(ctx context.Context, t lymbo.Ticket) error {
+	if err := d.Store.Put(ctx, t); err != nil {
+		return err
+	}
+	d.mirror(ctx, t.ID)
+	return nil
+}
+
+func (d *DualStore) PutBatch(ctx context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs, err := d.Store.PutBatch(ctx, tickets)
+	if err != nil {
+		return errs, err
+	}
+	for i, t := range tickets {
+		if errs == nil || errs[i] == nil {
+			d.mirror(ctx, t.ID)
+		}
+	}
+	return errs, nil
+}
+
+func (d *DualStore) Delete(ctx context.Context, id lymbo.TicketId) error {
+	if err := d.Store.Delete(ctx, id); err != nil {
+		return err
+	}
+	d.mirror(ctx, id)
+	return nil
+}
+
+func (d *DualStore) DeleteBatch(ctx context.Context, ids []lymbo.TicketId) error {
+	if err := d.Store.DeleteBatch(ctx, ids); err != nil {
+		return err
+	}
+	d.mirror(ctx, ids...)
+	return nil
+}
+
+func (d *DualStore) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.UpdateFunc) error {
+	if err := d.Store.Update(ctx, id, fn); err != nil {
+		return err
+	}
+	d.mirror(ctx, id)
+	return nil
+}
+
+func (d *DualStore) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
+	if err := d.Store.UpdateSet(ctx, us); err != nil {
+		return err
+	}
+	d.mirror(ctx, us.Id)
+	return nil
+}
+
+func (d *DualStore) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
+	if err := d.Store.UpdateBatch(ctx, updates); err != nil {
+		return err
+	}
+	for _, us := range updates {
+		d.mirror(ctx, us.Id)
+	}
+	return nil
+}
+
+func (d *DualStore) Settle(ctx context.Context, s lymbo.Settlement) error {
+	if err := d.Store.Settle(ctx, s); err != nil {
+		return err
+	}
+	d.mirror(ctx, s.Update.Id)
+	for _, t := range s.Next {
+		d.mirror(ctx, t.ID)
+	}
+	return nil
+}
+
+func (d *DualStore) Reschedule(ctx context.Context, id lymbo.TicketId, runat time.Time) error {
+	if err := d.Store.Reschedule(ctx, id, runat); err != nil {
+		return err
+	}
+	d.mirror(ctx, id)
+	return nil
+}
+
+func (d *DualStore) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	if err := d.Store.Touch(ctx, id, extendBy); err != nil {
+		return err
+	}
+	d.mirror(ctx, id)
+	return nil
+}
+
+// PollPending claims tickets from the primary store, copying their claim,
+// even the ones claimed before it failed.
+func (d *DualStore) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
+	res, err := d.Store.PollPending(ctx, req)
+	for _, t := range res.Tickets {
+		d.mirror(ctx, t.ID)
+	}
+	return res, err
+}
+
+func (d *DualStore) Pause(ctx context.Context, typ string) error {
+	if err := d.Store.Pause(ctx, typ); err != nil {
+		return err
+	}
+	d.also(ctx, d.secondary.Pause(ctx, typ))
+	return nil
+}
+
+func (d *DualStore) Resume(ctx context.Context, typ string) error {
+	if err := d.Store.Resume(ctx, typ); err != nil {
+		return err
+	}
+	d.also(ctx, d.secondary.Resume(ctx, typ))
+	return nil
+}
+
+func (d *DualStore) ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error) {
+	n, err := d.Store.ReleaseOwned(ctx, owner, now)
+	if err != nil {
+		return n, err
+	}
+	_, err = d.secondary.ReleaseOwned(ctx, owner, now)
+	d.also(ctx, err)
+	return n, nil
+}
+
+func (d *DualStore) ReleaseDependents(ctx context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	n, err := d.Store.ReleaseDependents(ctx, id, now)
+	if err != nil {
+		return n, err
+	}
+	_, err = d.secondary.ReleaseDependents(ctx, id, now)
+	d.also(ctx, err)
+	return n, nil
+}
+
+func (d *DualStore) RetryFailed(ctx context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	n, err := d.Store.RetryFailed(ctx, req)
+	if err != nil {
+		return n, err
+	}
+	_, err = d.secondary.RetryFailed(ctx, req)
+	d.also(ctx, err)
+	return n, nil
+}
+
+func (d *DualStore) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
+	n, err := d.Store.ExpireTickets(ctx, req)
+	if err != nil {
+		return n, err
+	}
+	_, err = d.secondary.ExpireTickets(ctx, req)
+	d.also(ctx, err)
+	return n, nil
+}
+
+// Listen passes the notifications of the primary store, if it is a
+// lymbo.Notifier, and returns lymbo.ErrNotifyDisabled otherwise.
+func (d *DualStore) Listen(ctx context.Context, notify func(runat time.Time)) error {
+	n, ok := d.Store.(lymbo.Notifier)
+	if !ok {
+		return lymbo.ErrNotifyDisabled
+	}
+	return n.Listen(ctx, notify)
+}
+
+// Search searches the primary store, if it is a lymbo.Searcher, and returns
+// lymbo.ErrSearchUnsupported otherwise.
+func (d *DualStore) Search(ctx context.Context, query string, limit int) ([]lymbo.Ticket, error) {
+	s, ok := d.Store.(lymbo.Searcher)
+	if !ok {
+		return nil, lymbo.ErrSearchUnsupported
+	}
+	return s.Search(ctx, query, limit)
+}
@@ -0,0 +1,241 @@
+// Package migrate copies the tickets of a lymbo.Store to another one, e.g. to
+// move a queue from memory to PostgreSQL or from PostgreSQL to Redis, and
+// verifies the copy.
+//
+// Usage, with the Kharons stopped:
+//
+//	n, err := migrate.Copy(ctx, src, dst, migrate.Config{Rate: 1000})
+//	report, err := migrate.Verify(ctx, src, dst, migrate.Config{})
+//
+// To migrate a live queue, run the Kharons on a DualStore writing to both
+// stores, backfill it, then switch them to the new store once verified:
+//
+//	dual := migrate.NewDualStore(src, dst, nil)
+//	kh := lymbo.NewKharon(dual, settings, logger)
+//	n, err := dual.Backfill(ctx, migrate.Config{Rate: 1000})
+//	report, err := migrate.Verify(ctx, src, dst, migrate.Config{})
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ochaton/lymbo"
+)
+
+// Config controls how tickets are copied and verified.
+type Config struct {
+	// Select selects the tickets copied or verified, every one by default.
+	// Its After and Limit are ignored.
+	Select lymbo.ListRequest
+
+	// BatchSize is the number of tickets listed and put at once.
+	// Defaults to lymbo.SnapshotBatchSize.
+	BatchSize int
+
+	// Rate caps the number of tickets copied or verified per second, so that
+	// the stores keep serving the Kharons meanwhile. 0 means no limit.
+	Rate float64
+}
+
+func (c Config) normalize() Config {
+	if c.BatchSize <= 0 {
+		c.BatchSize = lymbo.SnapshotBatchSize
+	}
+	c.Select.After = nil
+	c.Select.Limit = c.BatchSize
+	return c
+}
+
+// pages calls fn with the pages of the tickets of st selected by cfg,
+// throttled to cfg.Rate, until the last one or an error.
+func pages(ctx context.Context, st lymbo.Store, cfg Config, fn func([]lymbo.Ticket) error) error {
+	cfg = cfg.normalize()
+	req := cfg.Select
+	start := time.Now()
+	var n int
+	for {
+		page, err := st.List(ctx, req)
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		n += len(page)
+		if req.After = req.Next(page); req.After == nil {
+			return nil
+		}
+		if err := pace(ctx, start, n, cfg.Rate); err != nil {
+			return err
+		}
+	}
+}
+
+// pace waits until n tickets handled since start are within rate per second.
+func pace(ctx context.Context, start time.Time, n int, rate float64) error {
+	if rate <= 0 {
+		return nil
+	}
+	wait := time.Until(start.Add(time.Duration(float64(n) / rate * float64(time.Second))))
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Copy puts the tickets of src selected by cfg into dst as they are, their
+// Status, Attempts and times included, and returns how many it put. Tickets
+// changed in src meanwhile may be copied as of before their change: stop the
+// Kharons using src, or run them on a DualStore and use its Backfill.
+// Copy stops after the batch of a ticket that couldn't be put, e.g. with
+// lymbo.ErrDuplicateTicket, returning a TicketError of the first one.
+func Copy(ctx context.Context, src, dst lymbo.Store, cfg Config) (int, error) {
+	var n int
+	err := pages(ctx, src, cfg, func(page []lymbo.Ticket) error {
+		if len(page) == 0 {
+			return nil
+		}
+		errs, err := dst.PutBatch(ctx, page)
+		if err != nil {
+			return err
+		}
+		n += len(page)
+		var first error
+		for i, err := range errs {
+			if err != nil {
+				n--
+				if first == nil {
+					first = &TicketError{ID: page[i].ID, Err: err}
+				}
+			}
+		}
+		return first
+	})
+	return n, err
+}
+
+// TicketError is the error of a ticket that couldn't be copied.
+type TicketError struct {
+	ID  lymbo.TicketId
+	Err error
+}
+
+func (e *TicketError) Error() string { return "ticket " + string(e.ID) + ": " + e.Err.Error() }
+
+func (e *TicketError) Unwrap() error { return e.Err }
+
+// Report is the outcome of Verify.
+type Report struct {
+	// Checked is the number of tickets of the source store verified.
+	Checked int `json:"checked"`
+
+	// Missing are the tickets of the source store missing in the destination.
+	Missing []lymbo.TicketId `json:"missing,omitempty"`
+
+	// Mismatched are the tickets differing in the destination, see Equal.
+	Mismatched []lymbo.TicketId `json:"mismatched,omitempty"`
+}
+
+// OK reports whether every ticket checked was copied as it is.
+func (r Report) OK() bool {
+	return len(r.Missing) == 0 && len(r.Mismatched) == 0
+}
+
+// Verify checks that the tickets of src selected by cfg are in dst as they
+// are in src, see Equal, getting them one by one. Tickets of dst missing in
+// src aren't reported. Tickets changed meanwhile may be reported: verify
+// them again once the Kharons are stopped, or after a while on a DualStore.
+func Verify(ctx context.Context, src, dst lymbo.Store, cfg Config) (Report, error) {
+	var r Report
+	err := pages(ctx, src, cfg, func(page []lymbo.Ticket) error {
+		for _, t := range page {
+			got, err := dst.Get(ctx, t.ID)
+			switch {
+			case errors.Is(err, lymbo.ErrTicketNotFound):
+				r.Missing = append(r.Missing, t.ID)
+			case err != nil:
+				return err
+			case !Equal(t, got):
+				r.Mismatched = append(r.Mismatched, t.ID)
+			}
+			r.Checked++
+		}
+		return nil
+	})
+	return r, err
+}
+
+// Equal reports whether the tickets a and b are the same in two stores: their
+// fields are equal but for Mtime, Owner and AttemptLog, times within a
+// millisecond as stores keep them with different precisions, and payloads
+// and results once encoded as JSON, as stores serializing them read them
+// back, e.g. as the bytes of their JSON.
+func Equal(a, b lymbo.Ticket) bool {
+	return a.ID == b.ID &&
+		a.Status == b.Status &&
+		sameTime(a.Runat, b.Runat) &&
+		sameTimePtr(a.Deadline, b.Deadline) &&
+		a.Nice == b.Nice &&
+		a.Type == b.Type &&
+		a.Queue == b.Queue &&
+		a.TenantID == b.TenantID &&
+		sameTime(a.Ctime, b.Ctime) &&
+		a.Attempts == b.Attempts &&
+		a.Lease == b.Lease &&
+		a.UniqueKey == b.UniqueKey &&
+		sameJSON(a.Payload, b.Payload) &&
+		sameJSON(a.ErrorReason, b.ErrorReason) &&
+		sameJSON(a.Result, b.Result) &&
+		maps.Equal(a.Labels, b.Labels) &&
+		maps.Equal(a.Metadata, b.Metadata) &&
+		slices.Equal(a.DependsOn, b.DependsOn)
+}
+
+func sameTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func sameTimePtr(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return sameTime(*a, *b)
+}
+
+// sameJSON reports whether a and b encode to the same JSON value, decoding
+// them again so that the order of object keys doesn't matter.
+func sameJSON(a, b any) bool {
+	ja, erra := normalizeJSON(a)
+	jb, errb := normalizeJSON(b)
+	return erra == nil && errb == nil && string(ja) == string(jb)
+}
+
+// normalizeJSON encodes v as JSON. Bytes holding JSON, as stores keeping
+// payloads encoded read them back, are taken as is.
+func normalizeJSON(v any) ([]byte, error) {
+	data, ok := v.([]byte)
+	if !ok || !json.Valid(data) {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
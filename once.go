@@ -0,0 +1,92 @@
+package lymbo
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// completedKey is the metadata key recording the attempt of a ticket whose
+// handler completed, see Kharon.Once.
+const completedKey = reservedPrefix + "completed"
+
+// reservedPrefix prefixes the metadata keys recorded by lymbo, e.g.
+// completedKey, which tickets put by callers can't carry, see dropReserved.
+const reservedPrefix = "$lymbo_"
+
+// dropReserved removes the reserved metadata keys of a ticket to be put, so
+// that a producer can't forge them, e.g. to have Once ack it unhandled.
+func dropReserved(t *Ticket) {
+	for key := range t.Metadata {
+		if strings.HasPrefix(key, reservedPrefix) {
+			// copied, as the caller may share it
+			t.Metadata = maps.Clone(t.Metadata)
+			maps.DeleteFunc(t.Metadata, func(key, _ string) bool {
+				return strings.HasPrefix(key, reservedPrefix)
+			})
+			return
+		}
+	}
+}
+
+// Once adapts h into a Handler running it to completion at most once per
+// ticket, for handlers whose side effects can't be repeated: once h returns
+// nil, its attempt is recorded in the ticket metadata, synchronously, before
+// the ticket is acked with opts. A ticket delivered again afterwards, e.g.
+// because the ack wasn't written before the Kharon stopped, or because its
+// time-to-run elapsed meanwhile, is acked without calling h.
+// h must not report the outcome of the ticket; its errors are returned as
+// they are. A delivery running concurrently with h, its time-to-run having
+// elapsed while h ran, isn't detected: extend it with Touch, and set
+// WithLeaseCheck so that the completion of the stale one fails with
+// ErrLeaseLost instead.
+func (k *Kharon) Once(h Handler, opts ...Option) Handler {
+	return HandlerFunc(func(ctx context.Context, t *Ticket) error {
+		if attempt, ok := completedAttempt(t); ok {
+			k.logger.InfoContext(ctx, "ticket handler completed already, acking redelivered ticket",
+				"ticket_id", t.ID,
+				"attempt", attempt,
+			)
+			return k.Ack(ctx, t.ID, opts...)
+		}
+		if err := h.ProcessTicket(ctx, t); err != nil {
+			return err
+		}
+		if err := k.complete(ctx, t); err != nil {
+			return err
+		}
+		return k.Ack(ctx, t.ID, opts...)
+	})
+}
+
+// completedAttempt returns the attempt of t whose handler completed, if an
+// earlier one than the current did, see Kharon.Once. Attempts being reset by
+// RequeueDead or RetryFailed, a later one runs the handler again.
+func completedAttempt(t *Ticket) (int, bool) {
+	attempt, err := strconv.Atoi(t.Metadata[completedKey])
+	if err != nil || attempt >= t.Attempts {
+		return 0, false
+	}
+	return attempt, true
+}
+
+// complete records in the store that the handler of the current attempt of
+// t completed, see Kharon.Once.
+func (k *Kharon) complete(ctx context.Context, t *Ticket) error {
+	token, checked := leaseFrom(ctx, t.ID)
+	err := k.store.Update(ctx, t.ID, func(_ context.Context, cur *Ticket) error {
+		if checked && cur.Lease != token {
+			return ErrLeaseLost
+		}
+		// copied, as stores in memory share it with the tickets they returned
+		md := maps.Clone(cur.Metadata)
+		if md == nil {
+			md = make(map[string]string, 1)
+		}
+		md[completedKey] = strconv.Itoa(t.Attempts)
+		cur.Metadata = md
+		return nil
+	})
+	return k.leaseErr(ctx, t.ID, err)
+}
@@ -0,0 +1,35 @@
+package lymbo_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/store/memory"
+)
+
+// TestPutReservedMetadata puts a ticket forging the completion recorded by
+// Once: the reserved key must not reach the store.
+func TestPutReservedMetadata(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	kh := lymbo.NewKharon(store, lymbo.DefaultSettings().WithoutExpiration(), slog.New(slog.DiscardHandler))
+
+	md := map[string]string{"$lymbo_completed": "999", "trace": "abc"}
+	tk, _ := lymbo.NewTicket("t1", "once")
+	tk.WithMetadata(md)
+	if err := kh.Put(ctx, *tk); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(ctx, tk.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Metadata["$lymbo_completed"]; ok || got.Metadata["trace"] != "abc" {
+		t.Errorf("Metadata = %v, want only trace", got.Metadata)
+	}
+	if md["$lymbo_completed"] != "999" {
+		t.Errorf("the metadata of the caller was modified: %v", md)
+	}
+}
@@ -16,6 +16,7 @@ const (
 	delayUnset delayHow = iota
 	delayFixed
 	delayExponential
+	delayStrategy
 )
 
 type DelayStrategy struct {
@@ -26,6 +27,7 @@ type DelayStrategy struct {
 		maxDelay time.Duration
 		jitter   time.Duration
 	}
+	strategy Backoff
 }
 
 // Opts contains options for ticket operations.
@@ -41,7 +43,13 @@ type Opts struct {
 	keep bool
 
 	// errorReason stores the reason for failure (for Fail operations).
-	errorReason any
+	errorReason *ErrorInfo
+
+	// outcome is the operation reporting the outcome, logged as Attempt.Outcome.
+	outcome Op
+
+	// attempt is the run of the handler settled by the operation, if any.
+	attempt *Attempt
 
 	// nice sets the ticket's nice value (priority).
 	nice *int
@@ -49,6 +57,27 @@ type Opts struct {
 	// payload sets the ticket's payload data.
 	payload any
 
+	// result sets the ticket's result.
+	result any
+
+	// initialStatus overrides the Pending status set by Put.
+	initialStatus *status.Status
+
+	// ctime overrides the creation time set by Put.
+	ctime *time.Time
+
+	// uniqueKey sets the deduplication key of a ticket added by Put.
+	uniqueKey *string
+
+	// deadline sets the deadline of a ticket added by Put.
+	deadline *time.Time
+
+	// runAt sets when a ticket added by Put is first due.
+	runAt *time.Time
+
+	// runAfter delays the first run of a ticket added by Put.
+	runAfter *time.Duration
+
 	// update allows custom modification of the ticket.
 	update func(ctx context.Context, t *Ticket) error
 }
@@ -62,10 +91,13 @@ func WithKeep() Option {
 }
 
 // WithErrorReason sets an error reason for failed ticket operations.
-// The reason will be stored in the ticket's ErrorReason field.
+// The reason will be stored in the ticket's ErrorReason field, the previous
+// one moving to its History: an ErrorInfo is stored as is, an error wrapping
+// an *ErrorInfo keeps its Code and Stack, and anything else is the Message.
 func WithErrorReason(reason any) Option {
+	info := errorInfo(reason)
 	return func(o *Opts) {
-		o.errorReason = reason
+		o.errorReason = info
 	}
 }
 
@@ -80,6 +112,13 @@ func BackoffDelay(base float64, maxDelay time.Duration, jitter time.Duration) De
 	}
 }
 
+// StrategyDelay delays the ticket by b.Delay(attempts). Unlike BackoffDelay,
+// it is computed by Kharon, so the ticket is fetched and updated with
+// Store.Update rather than batched.
+func StrategyDelay(b Backoff) DelayStrategy {
+	return DelayStrategy{how: delayStrategy, strategy: b}
+}
+
 func FixedDelay(duration time.Duration) DelayStrategy {
 	return DelayStrategy{
 		how:   delayFixed,
@@ -101,6 +140,11 @@ func WithNice(nice int) Option {
 	}
 }
 
+// WithPriority marks the ticket urgent, setting its nice value to UrgentNice.
+func WithPriority() Option {
+	return WithNice(UrgentNice)
+}
+
 // WithUpdate allows custom modification of the ticket before storing.
 func WithUpdate(update func(ctx context.Context, t *Ticket) error) Option {
 	return func(o *Opts) {
@@ -113,3 +157,67 @@ func WithPayload(payload any) Option {
 		o.payload = payload
 	}
 }
+
+// WithCtime sets the ticket's creation time on Put instead of the current time.
+// Useful for imports and backfills; a zero time is ignored.
+func WithCtime(ctime time.Time) Option {
+	return func(o *Opts) {
+		o.ctime = &ctime
+	}
+}
+
+// WithUniqueKey sets the UniqueKey of a ticket added by Put, e.g. an order ID
+// to never enqueue two pending receipts for the same order:
+// Put returns ErrDuplicateTicket if one is already pending.
+func WithUniqueKey(key string) Option {
+	return func(o *Opts) {
+		o.uniqueKey = &key
+	}
+}
+
+// WithDeadline sets the Deadline of a ticket added by Put: if it is still
+// pending at d, it is no longer delivered, and the expiration worker fails it,
+// or cancels it, see Settings.WithDeadlineStatus, with ErrDeadlineExceeded as
+// ErrorReason. A zero time means no deadline.
+func WithDeadline(d time.Time) Option {
+	return func(o *Opts) {
+		o.deadline = &d
+	}
+}
+
+// WithRunAt schedules a ticket added by Put to be first polled at t,
+// instead of setting its Runat, which later also times its retries and leases.
+// A zero time is ignored.
+func WithRunAt(t time.Time) Option {
+	return func(o *Opts) {
+		o.runAt = &t
+	}
+}
+
+// WithRunAfter schedules a ticket added by Put to be first polled d after
+// it is put, the duration counterpart of WithRunAt. Unlike WithDelay, it
+// applies to Put only and takes a plain duration.
+func WithRunAfter(d time.Duration) Option {
+	return func(o *Opts) {
+		o.runAfter = &d
+	}
+}
+
+// WithResult sets the result of a ticket, e.g. the output of its handler,
+// for callers to read back with Kharon.GetResult. It is only stored if the
+// ticket is kept: Done and Fail keep it, Ack needs WithKeep.
+func WithResult(v any) Option {
+	return func(o *Opts) {
+		o.result = v
+	}
+}
+
+// WithInitialStatus sets the status of a ticket added by Put instead of Pending.
+// Useful for importing already completed tickets for history:
+// only Pending tickets are ever polled.
+// Put returns status.ErrStatusUnknown for a zero or unknown status.
+func WithInitialStatus(s status.Status) Option {
+	return func(o *Opts) {
+		o.initialStatus = &s
+	}
+}
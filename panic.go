@@ -0,0 +1,65 @@
+package lymbo
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error of a handler that panicked.
+type PanicError struct {
+	// Value is the value the handler panicked with.
+	Value any
+
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the value panicked with if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// handle calls the handler of t, middlewares included, recovering a panic
+// as a *PanicError.
+func handle(ctx context.Context, r *Router, t *Ticket) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Value: p, Stack: debug.Stack()}
+		}
+	}()
+	return r.Handler(t).ProcessTicket(ctx, t)
+}
+
+// recovered reports the panic of the handler of t and fails t with the
+// stack trace in its ErrorReason, unless the handler settled it before panicking.
+func (k *Kharon) recovered(ctx context.Context, t *Ticket, p *PanicError, settled bool) {
+	k.logger.ErrorContext(ctx, "panic occurred while processing ticket",
+		"ticket_id", t.ID,
+		"type", t.Type,
+		"panic", p.Value,
+		"stack", string(p.Stack),
+	)
+	if k.settings.onPanic != nil {
+		k.settings.onPanic(ctx, *t, p)
+	}
+	if settled {
+		return
+	}
+
+	if k.settings.leaseCheck {
+		ctx = withLease(ctx, t)
+	}
+	if err := k.Fail(ctx, t.ID, WithErrorReason(ErrorInfo{Message: p.Error(), Stack: string(p.Stack)})); err != nil {
+		k.logger.ErrorContext(ctx, "error failing panicked ticket",
+			"ticket_id", t.ID,
+			"type", t.Type,
+			"error", err,
+		)
+	}
+}
@@ -0,0 +1,29 @@
+package lymbo
+
+import "context"
+
+// Pause stops the tickets of type typ from being polled, by every Kharon
+// sharing the store, until Resume, e.g. during an outage of the downstream
+// they call, without cancelling them: they stay pending, and the tickets
+// already in flight run to completion. Returns ErrTypeEmpty if typ is empty.
+func (k *Kharon) Pause(ctx context.Context, typ string) error {
+	if typ == "" {
+		return ErrTypeEmpty
+	}
+	return k.store.Pause(ctx, typ)
+}
+
+// Resume lets the tickets of a type paused by Pause be polled again: those
+// that came due meanwhile are claimed by the next polls, as any overdue
+// ticket, see Settings.WithCatchUp.
+func (k *Kharon) Resume(ctx context.Context, typ string) error {
+	if typ == "" {
+		return ErrTypeEmpty
+	}
+	return k.store.Resume(ctx, typ)
+}
+
+// Paused returns the types paused by Pause, sorted.
+func (k *Kharon) Paused(ctx context.Context) ([]string, error) {
+	return k.store.Paused(ctx)
+}
@@ -0,0 +1,20 @@
+package lymbo
+
+import "context"
+
+type primaryKey struct{}
+
+// PrimaryContext returns ctx making stores serving reads from a replica,
+// e.g. PostgreSQL with a ReadReplica, read from their primary instead: a
+// ticket read to decide on a write, such as the blob to drop once it is
+// deleted, may otherwise be missing or stale on a lagging replica.
+func PrimaryContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// ReadsPrimary reports whether reads of ctx must be served by the primary,
+// see PrimaryContext.
+func ReadsPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
@@ -0,0 +1,69 @@
+package lymbo
+
+import (
+	"context"
+	"maps"
+)
+
+// Propagator carries values of the context a ticket is put with, e.g. a
+// request ID or a tenant, to the context of its handler through the ticket
+// Metadata, see Settings.WithPropagator. Package tracing adapts OpenTelemetry
+// propagators for the trace context.
+// Implementations must be safe for concurrent use.
+type Propagator interface {
+	// Inject stores the values of ctx in metadata.
+	Inject(ctx context.Context, metadata map[string]string)
+
+	// Extract returns ctx with the values stored in metadata by Inject.
+	Extract(ctx context.Context, metadata map[string]string) context.Context
+}
+
+type stringValue struct {
+	name string
+	key  any
+}
+
+// StringValue returns a Propagator carrying the string value of ctx under
+// key as the metadata name, e.g. StringValue("request_id", requestIDKey{}).
+// Contexts without a string under key leave the metadata unchanged.
+func StringValue(name string, key any) Propagator {
+	return stringValue{name: name, key: key}
+}
+
+func (p stringValue) Inject(ctx context.Context, metadata map[string]string) {
+	if v, ok := ctx.Value(p.key).(string); ok {
+		metadata[p.name] = v
+	}
+}
+
+func (p stringValue) Extract(ctx context.Context, metadata map[string]string) context.Context {
+	if v, ok := metadata[p.name]; ok {
+		return context.WithValue(ctx, p.key, v)
+	}
+	return ctx
+}
+
+// inject stores the values propagated from ctx in the Metadata of a new
+// ticket, copied so that the caller's map isn't changed.
+func (k *Kharon) inject(ctx context.Context, t *Ticket) {
+	if len(k.settings.propagators) == 0 {
+		return
+	}
+	metadata := make(map[string]string, len(t.Metadata))
+	maps.Copy(metadata, t.Metadata)
+	for _, p := range k.settings.propagators {
+		p.Inject(ctx, metadata)
+	}
+	if len(metadata) > 0 {
+		t.Metadata = metadata
+	}
+}
+
+// extract returns the context of the handler of t with the values propagated
+// from the context t was put with.
+func (k *Kharon) extract(ctx context.Context, t *Ticket) context.Context {
+	for _, p := range k.settings.propagators {
+		ctx = p.Extract(ctx, t.Metadata)
+	}
+	return ctx
+}
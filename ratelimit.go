@@ -0,0 +1,110 @@
+package lymbo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// rateLimit is the rate limit of a ticket type, see Settings.WithRateLimit.
+type rateLimit struct {
+	rps   float64
+	burst int
+}
+
+// bucket is a token bucket refilled at rps tokens per second, holding up to
+// burst of them. Tokens are reserved ahead of time, leaving the bucket in
+// debt, so that waiting workers are served in turn.
+type bucket struct {
+	mu     sync.Mutex
+	rps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(l rateLimit) *bucket {
+	return &bucket{rps: l.rps, burst: float64(l.burst), tokens: float64(l.burst)}
+}
+
+// reserve takes a token, returning how long after now it is available.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); !b.last.IsZero() && elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rps)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rps * float64(time.Second))
+}
+
+// release gives back a token reserved but not used.
+func (b *bucket) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+// newBuckets returns the buckets of the rate limits by ticket type.
+func newBuckets(limits map[string]rateLimit) map[string]*bucket {
+	if len(limits) == 0 {
+		return nil
+	}
+	buckets := make(map[string]*bucket, len(limits))
+	for typ, l := range limits {
+		buckets[typ] = newBucket(l)
+	}
+	return buckets
+}
+
+// throttle waits for the token of polled ticket t if its type is rate
+// limited, and reports whether t may be handled. A ticket whose token comes
+// after its Runat, when it would be redelivered, is rescheduled to then
+// instead, giving back the attempt of its claim, see unclaim; one whose wait is interrupted by intake is rescheduled to be due
+// now on Shutdown, as undelivered tickets are, and left for its TTR otherwise.
+func (k *Kharon) throttle(ctx, intake context.Context, t *Ticket) bool {
+	b := k.buckets[t.Type]
+	if b == nil {
+		return true
+	}
+	now := k.now()
+	wait := b.reserve(now)
+	if wait <= 0 {
+		return true
+	}
+
+	if at := now.Add(wait); at.After(t.Runat) {
+		b.release()
+		// not an attempt: given back, as are the lease and the in-flight slot
+		if err := k.unclaim(ctx, t, at); err != nil && !errors.Is(err, ErrLeaseLost) {
+			k.logger.ErrorContext(ctx, "error rescheduling rate limited ticket",
+				"ticket_id", t.ID,
+				"type", t.Type,
+				"error", err,
+			)
+		}
+		k.logger.DebugContext(ctx, "rate limited ticket rescheduled", "ticket_id", t.ID, "type", t.Type, "runat", at)
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-intake.Done():
+		b.release()
+		if shuttingDown(intake) {
+			k.requeue(ctx, []*Ticket{t})
+		}
+		return false
+	}
+}
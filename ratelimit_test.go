@@ -0,0 +1,95 @@
+package lymbo_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/memory"
+)
+
+// TestRateLimitAttempts throttles a ticket past its processing time with a
+// single attempt allowed: rescheduled without counting it, it must be
+// handled once its turn comes instead of being dead-lettered.
+func TestRateLimitAttempts(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := lymbo.NewFakeClock(start)
+	store := memory.NewStore().WithClock(clock)
+	ids := []lymbo.TicketId{"t1", "t2"}
+	for _, id := range ids {
+		tk, _ := lymbo.NewTicket(id, "slow")
+		tk.Runat = start
+		if err := store.Put(ctx, *tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var handled atomic.Int32
+	r := lymbo.NewRouter()
+	r.HandleFunc("slow", func(context.Context, *lymbo.Ticket) error {
+		handled.Add(1)
+		return nil
+	})
+	settings := lymbo.DefaultSettings().
+		WithClock(clock).
+		WithWorkers(1).
+		WithRateLimit("slow", 1.0/3600, 1). // one an hour
+		WithMaxAttempts(1).
+		WithMinReactionDelay(time.Millisecond).
+		WithMaxReactionDelay(5 * time.Millisecond).
+		WithAutoSettle().
+		WithoutExpiration()
+	kh := lymbo.NewKharon(store, settings, slog.New(slog.DiscardHandler))
+	run := make(chan error, 1)
+	go func() { run <- kh.Run(ctx, r) }()
+	defer func() {
+		if err := kh.Shutdown(ctx); err != nil {
+			t.Error(err)
+		}
+		<-run
+	}()
+
+	// one handled and acked, the other rescheduled to its turn an hour later
+	waitFor(t, func() bool {
+		var acked, throttled int
+		for _, id := range ids {
+			tk, err := store.Get(ctx, id)
+			switch {
+			case errors.Is(err, lymbo.ErrTicketNotFound):
+				acked++
+			case err != nil:
+				t.Fatal(err)
+			case !tk.Runat.Before(start.Add(time.Hour)):
+				if tk.Attempts != 0 || tk.Lease != "" {
+					t.Fatalf("throttled ticket has %d attempts and lease %q, want none", tk.Attempts, tk.Lease)
+				}
+				throttled++
+			}
+		}
+		return acked == 1 && throttled == 1
+	})
+
+	clock.Advance(time.Hour)
+	waitFor(t, func() bool { return handled.Load() == 2 })
+	if dead, err := store.List(ctx, lymbo.ListRequest{Status: &status.Dead}); err != nil || len(dead) != 0 {
+		t.Fatalf("dead tickets %v, %v, want none", dead, err)
+	}
+}
+
+// waitFor waits until cond holds, failing the test after 5 seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
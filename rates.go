@@ -0,0 +1,139 @@
+package lymbo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// RatesInterval is how often Run samples the stats for Rates.
+	RatesInterval = time.Second
+
+	// RatesHistory is the number of samples kept, bounding the Rates window.
+	RatesHistory = 600
+)
+
+// StatsRates contains per-second rates of the Stats counters over a window.
+type StatsRates struct {
+	// Window is the time actually covered by the rates, which may be shorter
+	// than requested if not enough samples were taken yet.
+	Window time.Duration `json:"window"`
+
+	Added          float64 `json:"added"`
+	Polled         float64 `json:"polled"`
+	Scheduled      float64 `json:"scheduled"`
+	Acked          float64 `json:"acked"`
+	Failed         float64 `json:"failed"`
+	Done           float64 `json:"done"`
+	Retried        float64 `json:"retried"`
+	Canceled       float64 `json:"canceled"`
+	Deleted        float64 `json:"deleted"`
+	Expired        float64 `json:"expired"`
+	Exhausted      float64 `json:"exhausted"`
+	LeaseConflicts float64 `json:"lease_conflicts"`
+	Processed      float64 `json:"processed"`
+}
+
+type sample struct {
+	at    time.Time
+	stats Stats
+}
+
+// ratesRing keeps the last RatesHistory stats samples.
+type ratesRing struct {
+	mu      sync.Mutex
+	samples [RatesHistory]sample
+	next    int
+	size    int
+}
+
+func (r *ratesRing) add(s sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % RatesHistory
+	r.size = min(r.size+1, RatesHistory)
+}
+
+// since returns the oldest sample not older than t.
+func (r *ratesRing) since(t time.Time) (sample, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.size {
+		s := r.samples[(r.next-r.size+i+RatesHistory)%RatesHistory]
+		if !s.at.Before(t) {
+			return s, true
+		}
+	}
+	return sample{}, false
+}
+
+func (r *ratesRing) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.next = 0
+	r.size = 0
+}
+
+// Rates returns the per-second rates of the stats counters over the last window,
+// e.g. Rates(time.Minute).Processed * 60 is the number of tickets processed in
+// the last minute. Rates are computed from samples taken every RatesInterval
+// while Run is active, over at most RatesHistory samples.
+// ResetStats also drops the samples.
+func (k *Kharon) Rates(window time.Duration) StatsRates {
+	now := time.Now()
+	cur := k.totals()
+
+	base, ok := k.rates.since(now.Add(-window))
+	if !ok {
+		return StatsRates{}
+	}
+	elapsed := now.Sub(base.at)
+	if elapsed <= 0 {
+		return StatsRates{}
+	}
+
+	rate := func(cur, prev int64) float64 {
+		return float64(cur-prev) / elapsed.Seconds()
+	}
+	prev := base.stats
+	return StatsRates{
+		Window:         elapsed,
+		Added:          rate(cur.Added, prev.Added),
+		Polled:         rate(cur.Polled, prev.Polled),
+		Scheduled:      rate(cur.Scheduled, prev.Scheduled),
+		Acked:          rate(cur.Acked, prev.Acked),
+		Failed:         rate(cur.Failed, prev.Failed),
+		Done:           rate(cur.Done, prev.Done),
+		Retried:        rate(cur.Retried, prev.Retried),
+		Canceled:       rate(cur.Canceled, prev.Canceled),
+		Deleted:        rate(cur.Deleted, prev.Deleted),
+		Expired:        rate(cur.Expired, prev.Expired),
+		Exhausted:      rate(cur.Exhausted, prev.Exhausted),
+		LeaseConflicts: rate(cur.LeaseConflicts, prev.LeaseConflicts),
+		Processed:      rate(cur.Processed, prev.Processed),
+	}
+}
+
+// runRatesSampler samples the stats for Rates every RatesInterval.
+// Exits when ctx is cancelled.
+func (k *Kharon) runRatesSampler(ctx context.Context) {
+	defer k.logger.DebugContext(ctx, "rates sampler exiting")
+
+	ticker := time.NewTicker(RatesInterval)
+	defer ticker.Stop()
+
+	k.rates.add(sample{at: time.Now(), stats: k.totals()})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case at := <-ticker.C:
+			k.rates.add(sample{at: at, stats: k.totals()})
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package lymbo
+
+import "context"
+
+// RetryPolicy is how tickets of a type are retried, see Settings.WithRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts overrides WithMaxAttempts: the number of deliveries a ticket
+	// gets before it is dead-lettered. 0 keeps WithMaxAttempts, a negative
+	// value means unlimited.
+	MaxAttempts int
+
+	// Backoff, if set, overrides WithBackoff as with WithTypeBackoff.
+	Backoff Backoff
+
+	// Retryable, if set, classifies the errors of handlers that return
+	// without settling their ticket: a retryable error retries the ticket
+	// after its backoff, any other fails it with the error as ErrorReason,
+	// whether WithAutoSettle is set or not.
+	Retryable func(error) bool
+}
+
+// maxAttemptsOf returns the max attempts of tickets of type typ, 0 for unlimited.
+func (s *Settings) maxAttemptsOf(typ string) int {
+	p, ok := s.retryPolicies[typ]
+	switch {
+	case !ok || p.MaxAttempts == 0:
+		return s.maxAttempts
+	case p.MaxAttempts < 0:
+		return 0
+	default:
+		return p.MaxAttempts
+	}
+}
+
+// minMaxAttempts returns the lowest max attempts of any type, 0 if every
+// type has unlimited attempts.
+func (s *Settings) minMaxAttempts() int {
+	n := s.maxAttempts
+	for typ := range s.retryPolicies {
+		if m := s.maxAttemptsOf(typ); m > 0 && (n == 0 || m < n) {
+			n = m
+		}
+	}
+	return n
+}
+
+// exhausted reports whether t was delivered more times than its type allows.
+func (s *Settings) exhausted(t Ticket) bool {
+	n := s.maxAttemptsOf(t.Type)
+	return n > 0 && t.Attempts > n
+}
+
+// pollRequest returns the backoffs of the settings as the ones of a PollRequest.
+func (s *Settings) pollRequest() PollRequest {
+	return PollRequest{
+		BackoffBase:     s.backoffBase,
+		MaxBackoffDelay: s.maxBackoffDelay,
+		Backoff:         s.backoff,
+		BackoffPerType:  s.backoffPerType,
+	}
+}
+
+// classify retries or fails a ticket whose handler returned herr without
+// settling it, by the retryable classifier of its retry policy.
+// ctx is the worker's, the handler deadline may have passed already.
+func (k *Kharon) classify(ctx context.Context, t *Ticket, herr error, retryable func(error) bool) {
+	if k.settings.leaseCheck {
+		ctx = withLease(ctx, t)
+	}
+	var err error
+	if retryable(herr) {
+		backoff := k.settings.pollRequest().BackoffFor(t.Type)
+		err = k.Retry(ctx, t.ID, WithErrorReason(herr), WithDelay(StrategyDelay(backoff)))
+	} else {
+		err = k.Fail(ctx, t.ID, WithErrorReason(herr))
+	}
+	if err != nil {
+		k.logger.ErrorContext(ctx, "error settling ticket",
+			"ticket_id", t.ID,
+			"type", t.Type,
+			"error", err,
+		)
+	}
+}
@@ -11,6 +11,7 @@ type Router struct {
 	mu              sync.RWMutex
 	routingTable    map[string]Handler
 	notFoundHandler Handler
+	middlewares     []Middleware
 }
 
 // NewRouter creates a new Router instance.
@@ -36,16 +37,42 @@ type Handler interface {
 	ProcessTicket(ctx context.Context, ticket *Ticket) error
 }
 
-// Handler returns the handler for the given ticket.
+// Middleware wraps a Handler, e.g. to log, recover, measure, trace or rate
+// limit the handling of tickets, as net/http middleware does for requests.
+type Middleware func(next Handler) Handler
+
+// Handler returns the handler for the given ticket, wrapped by the middlewares.
 // If no handler is registered for the ticket type, returns the not-found handler.
 func (r *Router) Handler(t *Ticket) Handler {
 	r.mu.RLock()
-	defer r.mu.RUnlock()
+	handler, exists := r.routingTable[t.Type]
+	mws := r.middlewares
+	r.mu.RUnlock()
+
+	if !exists {
+		handler = r.NotFoundHandler()
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
 
-	if handler, exists := r.routingTable[t.Type]; exists {
-		return handler
+// Use appends middlewares to the chain wrapping every handler of the router,
+// the not-found one included, whether registered before or after. The first
+// middleware is the outermost: it runs first and returns last. The chain is
+// built for each ticket, so middlewares keep their state, e.g. a rate limiter,
+// outside of the returned Handler.
+// Panics if a middleware is nil.
+func (r *Router) Use(mws ...Middleware) {
+	for _, mw := range mws {
+		if mw == nil {
+			panic("kharon: nil middleware")
+		}
 	}
-	return r.NotFoundHandler()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middlewares = append(r.middlewares, mws...)
 }
 
 // NotFoundHandler returns the handler to use when no route matches.
@@ -121,6 +148,11 @@ func HandleFunc(route string, handler func(context.Context, *Ticket) error) erro
 	return DefaultRouter.register(route, HandlerFunc(handler))
 }
 
+// Use appends middlewares to the chain of the default router.
+func Use(mws ...Middleware) {
+	DefaultRouter.Use(mws...)
+}
+
 // NotFound is the default not-found handler function.
 func NotFound(context.Context, *Ticket) error {
 	return ErrHandlerNotFound
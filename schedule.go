@@ -0,0 +1,301 @@
+package lymbo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ochaton/lymbo/status"
+)
+
+// Schedule computes the occurrences of a recurring ticket, see Settings.WithSchedule.
+type Schedule interface {
+	// Next returns the first occurrence strictly after t,
+	// or the zero time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// ErrCronInvalid is returned by ParseCron for malformed expressions.
+var ErrCronInvalid = errors.New("invalid cron expression")
+
+type every time.Duration
+
+// Every returns a schedule occurring every d, aligned on multiples of d
+// since the zero time, e.g. on the hour for time.Hour. d must be positive.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		panic("lymbo: Every requires a positive duration")
+	}
+	return every(d)
+}
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Truncate(time.Duration(e)).Add(time.Duration(e))
+}
+
+// cron is a parsed cron expression, a bit set per field.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny report a * day field: if both day fields are
+	// restricted, a day matching either of them occurs, as in crontab(5).
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// ParseCron parses a standard 5-field cron expression (minute, hour, day of
+// month, month, day of week) supporting *, lists, ranges, steps, month and
+// day names, 7 as Sunday and the @hourly, @daily, @weekly, @monthly and
+// @yearly macros. Occurrences are computed in the location of the time
+// passed to Next.
+func ParseCron(expr string) (Schedule, error) {
+	spec := strings.TrimSpace(expr)
+	if m, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = m
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: expected 5 fields, got %d", ErrCronInvalid, expr, len(fields))
+	}
+
+	var c cron
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("%w %q: minute: %w", ErrCronInvalid, expr, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("%w %q: hour: %w", ErrCronInvalid, expr, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("%w %q: day of month: %w", ErrCronInvalid, expr, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("%w %q: month: %w", ErrCronInvalid, expr, err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("%w %q: day of week: %w", ErrCronInvalid, expr, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+// MustParseCron is like ParseCron but panics if the expression is invalid.
+func MustParseCron(expr string) Schedule {
+	s, err := ParseCron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+// within [lo, hi] into a bit set.
+func parseCronField(field string, lo, hi int, names map[string]int) (uint64, error) {
+	value := func(s string) (int, error) {
+		if n, ok := names[strings.ToLower(s)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("%q is not in [%d, %d]", s, lo, hi)
+		}
+		return n, nil
+	}
+
+	var set uint64
+	for part := range strings.SplitSeq(field, ",") {
+		rng, step, stepped := part, 1, false
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step, stepped = part[:i], n, true
+		}
+
+		first, last := lo, hi
+		switch i := strings.IndexByte(rng, '-'); {
+		case rng == "*":
+		case i >= 0:
+			var err error
+			if first, err = value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if last, err = value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+			if first > last {
+				return 0, fmt.Errorf("empty range %q", rng)
+			}
+		default:
+			var err error
+			if first, err = value(rng); err != nil {
+				return 0, err
+			}
+			if !stepped {
+				// a single value, or the start of a stepped range with n/step
+				last = first
+			}
+		}
+		for n := first; n <= last; n += step {
+			set |= 1 << n
+		}
+	}
+	return set, nil
+}
+
+func (c cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// cronHorizon bounds the search of Next, e.g. for "0 0 30 2 *" which never occurs.
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+func (c cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.Add(cronHorizon)
+
+	for t.Before(end) {
+		if c.month&(1<<t.Month()) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			// jump to the next set minute of this hour, if any
+			if rest := c.minute >> (t.Minute() + 1); rest != 0 {
+				t = t.Add(time.Duration(bits.TrailingZeros64(rest)+1) * time.Minute)
+			} else {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			}
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// scheduled is a recurring ticket registered with Settings.WithSchedule.
+type scheduled struct {
+	typ      string
+	schedule Schedule
+	opts     []Option
+}
+
+// scheduleNamespace is the namespace of the UUIDs of ScheduleID.
+var scheduleNamespace = uuid.MustParse("e1c9f790-ce48-4481-9617-ee142389c658")
+
+// ScheduleID returns the ID of the tickets of type typ enqueued by its
+// schedule, a UUID derived from typ, as stores such as PostgreSQL require.
+func ScheduleID(typ string) TicketId {
+	return TicketId(uuid.NewSHA1(scheduleNamespace, []byte(typ)).String())
+}
+
+// runScheduler enqueues the recurring tickets at each occurrence of their
+// schedule until ctx is cancelled. An occurrence is skipped while the
+// ticket of the previous one is still pending, so that a slow or backed up
+// handler never piles up instances; occurrences missed meanwhile are not replayed.
+func (k *Kharon) runScheduler(ctx context.Context) {
+	k.logger.InfoContext(ctx, "ticket scheduler started", "schedules", len(k.settings.schedules))
+
+	next := make([]time.Time, len(k.settings.schedules))
+	now := k.now()
+	for i, s := range k.settings.schedules {
+		next[i] = s.schedule.Next(now)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		wake := time.Time{}
+		for _, t := range next {
+			if !t.IsZero() && (wake.IsZero() || t.Before(wake)) {
+				wake = t
+			}
+		}
+		if wake.IsZero() {
+			k.logger.InfoContext(ctx, "ticket scheduler exiting, no occurrences left")
+			return
+		}
+		timer.Reset(wake.Sub(k.now()))
+
+		select {
+		case <-ctx.Done():
+			k.logger.DebugContext(ctx, "ticket scheduler exiting")
+			return
+		case <-timer.C:
+		}
+
+		now := k.now()
+		for i, s := range k.settings.schedules {
+			if next[i].IsZero() || next[i].After(now) {
+				continue
+			}
+			if !k.leads(ctx, "scheduler") {
+				next[i] = s.schedule.Next(now)
+				continue
+			}
+			if err := k.enqueueScheduled(ctx, s, next[i]); err != nil {
+				k.logger.ErrorContext(ctx, "error enqueueing scheduled ticket", "type", s.typ, "error", err)
+			}
+			next[i] = s.schedule.Next(now)
+		}
+	}
+}
+
+// enqueueScheduled puts the ticket of s due at runat, unless the previous one is still pending.
+func (k *Kharon) enqueueScheduled(ctx context.Context, s scheduled, runat time.Time) error {
+	tid := ScheduleID(s.typ)
+	prev, err := k.store.Get(PrimaryContext(ctx), tid)
+	switch {
+	case err == nil && prev.Status == status.Pending:
+		k.logger.DebugContext(ctx, "skipping scheduled ticket, previous one still pending", "type", s.typ, "tid", tid)
+		return nil
+	case err != nil && !errors.Is(err, ErrTicketNotFound):
+		return err
+	}
+
+	t, err := NewTicket(tid, s.typ)
+	if err != nil {
+		return err
+	}
+	t.Runat = runat
+	t.Queue = k.settings.queue
+	return k.Put(ctx, *t, s.opts...)
+}
@@ -1,6 +1,11 @@
 package lymbo
 
-import "time"
+import (
+	"context"
+	"time"
+
+	"github.com/ochaton/lymbo/status"
+)
 
 // Settings contains configuration options for Kharon.
 type Settings struct {
@@ -15,10 +20,33 @@ type Settings struct {
 	// Defaults to DefaultBackoffBase.
 	backoffBase float64
 
+	// backoff, if set, replaces the exponential backoff of backoffBase.
+	backoff Backoff
+
+	// backoffPerType overrides backoff for the listed ticket types.
+	backoffPerType map[string]Backoff
+
+	// backoffJitter randomizes the backoff of each polled ticket.
+	backoffJitter Jitter
+
+	// codecs are the payload codecs of the listed ticket types, JSONCodec
+	// for the others.
+	codecs map[string]Codec
+
+	// blobs, if set, keeps the payloads whose JSON is larger than blobThreshold
+	// bytes, the tickets only referencing them.
+	blobs         BlobStore
+	blobThreshold int
+
 	// maxReactionDelay is the maximum time to wait between store polls.
 	// Defaults to MaxPollIntervalDefault.
 	maxReactionDelay time.Duration
 
+	// reactionJitter is the upper bound of a random amount subtracted from
+	// idle waits capped at maxReactionDelay, so that several Kharons sharing
+	// a store don't poll in lockstep. Defaults to 0 (no jitter).
+	reactionJitter time.Duration
+
 	// minReactionDelay is the minimum time to wait between store polls.
 	// Defaults to MinPollIntervalDefault.
 	minReactionDelay time.Duration
@@ -31,29 +59,128 @@ type Settings struct {
 	// Defaults to 1.
 	workers int
 
+	// catchUp is the policy for overdue tickets.
+	catchUp CatchUp
+
+	// queue is the queue polled, "" for the default one.
+	queue string
+
+	// tenants are the tenants polled, all of them if empty.
+	tenants []string
+
+	// fairShare shares polls among tenants, see PollRequest.FairShare.
+	fairShare bool
+
+	// typeShare shares polls among types, see PollRequest.TypeShare.
+	typeShare TypeShare
+
+	// labelSelector restricts polling to tickets having all of these labels.
+	labelSelector map[string]string
+
+	// maxInFlight caps the tickets of a type in flight across all pollers.
+	maxInFlight map[string]int
+
+	// rateLimits cap how fast this Kharon handles the tickets of a type.
+	rateLimits map[string]rateLimit
+
+	// concurrency caps the tickets of a type this Kharon handles at once.
+	concurrency map[string]int
+
+	// boost lets urgent tickets run ahead of schedule.
+	boost Boost
+
+	// priority orders ready tickets by Nice before Runat.
+	priority Priority
+
+	// leaseCheck makes outcomes reported from handlers conditional on the
+	// lease token of the poll that delivered the ticket.
+	leaseCheck bool
+
+	// autoSettle acks or fails tickets by the handler result when the
+	// handler reports no outcome itself.
+	autoSettle bool
+
+	// maxAttempts is the number of deliveries a ticket gets before it is failed
+	// instead of being dispatched again. 0 means unlimited.
+	maxAttempts int
+
+	// retryPolicies override maxAttempts and backoff for the listed ticket
+	// types, and classify their handler errors.
+	retryPolicies map[string]RetryPolicy
+
+	// onExhausted is called once a ticket is dead-lettered for running out of attempts.
+	onExhausted func(context.Context, Ticket)
+
+	// onPanic is called for every handler that panicked.
+	onPanic func(context.Context, Ticket, *PanicError)
+
+	// deadlineStatus is the status of tickets settled for exceeding their
+	// deadline, status.Failed or status.Cancelled. Defaults to status.Failed.
+	deadlineStatus status.Status
+
 	// enableExpiration enables automatic cleanup of expired tickets.
 	enableExpiration bool
 
-	// expirationInterval
+	// expirationInterval is how often the expiration worker runs.
 	expirationInterval time.Duration
 
+	// expirationBatchSize is the max number of tickets expired per run.
+	expirationBatchSize int
+
+	// expirationJitter is the upper bound of a random duration added to
+	// every expiration interval.
+	expirationJitter time.Duration
+
+	// leader elects the process running the singleton background tasks.
+	leader Leader
+
+	// retention maps a terminal status to how long tickets in that status
+	// are kept after their last modification before being expired.
+	// Statuses without an entry expire once their Runat has passed.
+	retention map[status.Status]time.Duration
+
+	// archive keeps the tickets removed by expiration.
+	archive ArchiveStore
+
+	// auditLog records the status changes of tickets.
+	auditLog AuditLog
+
+	// schedules are the recurring tickets enqueued by the scheduler.
+	schedules []scheduled
+
+	// tracer instruments the Kharon operations.
+	tracer Tracer
+
+	// propagators carry context values from putting to handling tickets.
+	propagators []Propagator
+
 	// shutdownFlushTimeout is the timeout for flushing remaining batch on shutdown.
 	shutdownFlushTimeout time.Duration
+
+	// workerID identifies the Kharon in the AttemptLog of tickets, and owns
+	// the tickets it claims. Defaults to the hostname and pid.
+	workerID string
+
+	// clock tells the time of tickets, see WithClock.
+	clock Clock
 }
 
 // DefaultSettings returns a Settings instance with sensible defaults.
 func DefaultSettings() *Settings {
 	return &Settings{
-		processTime:        30 * time.Second,
-		maxReactionDelay:   MaxPollIntervalDefault,
-		minReactionDelay:   MinPollIntervalDefault,
-		maxBackoffDelay:    MaxBackoffDelay,
-		backoffBase:        DefaultBackoffBase,
-		batchSize:          10,
-		workers:            4,
-		enableExpiration:   true,
-		expirationInterval: ExpirationInterval,
-		shutdownFlushTimeout:  5 * time.Second,
+		processTime:          30 * time.Second,
+		maxReactionDelay:     MaxPollIntervalDefault,
+		minReactionDelay:     MinPollIntervalDefault,
+		maxBackoffDelay:      MaxBackoffDelay,
+		backoffBase:          DefaultBackoffBase,
+		batchSize:            10,
+		workers:              4,
+		deadlineStatus:       status.Failed,
+		enableExpiration:     true,
+		expirationInterval:   ExpirationInterval,
+		expirationBatchSize:  ExpirationBatchSize,
+		shutdownFlushTimeout: 5 * time.Second,
+		clock:                SystemClock,
 	}
 }
 
@@ -67,6 +194,29 @@ func (s *Settings) WithExpirationInterval(d time.Duration) *Settings {
 	return s
 }
 
+// WithExpirationBatchSize sets the max number of tickets removed by each run
+// of the expiration worker. Defaults to ExpirationBatchSize.
+func (s *Settings) WithExpirationBatchSize(n int) *Settings {
+	s.expirationBatchSize = n
+	return s
+}
+
+// WithExpirationJitter lengthens each expiration interval by a random
+// duration in [0, d), so that the expiration workers of a fleet spread out.
+func (s *Settings) WithExpirationJitter(d time.Duration) *Settings {
+	s.expirationJitter = d
+	return s
+}
+
+// WithLeader runs the expiration worker and enqueues the occurrences of
+// schedules only while l elects this process, so that a single one of a
+// fleet runs them, another one taking over once it is gone, e.g. with the
+// Leader of the PostgreSQL store.
+func (s *Settings) WithLeader(l Leader) *Settings {
+	s.leader = l
+	return s
+}
+
 func (s *Settings) WithWorkers(workers int) *Settings {
 	s.workers = workers
 	return s
@@ -94,16 +244,350 @@ func (s *Settings) WithBackoffBase(base float64) *Settings {
 	return s
 }
 
+// WithBackoff sets the strategy delaying the redelivery of polled tickets
+// that are not resolved before their time-to-run elapses, replacing the
+// exponential backoff of WithBackoffBase, e.g. LinearBackoff or
+// WithJitter(ConstantBackoff(5*time.Second), time.Second). The Postgres
+// store samples the strategy for the first 32 attempts, later attempts
+// reusing the last delay, so it should reach its cap by then.
+func (s *Settings) WithBackoff(b Backoff) *Settings {
+	s.backoff = b
+	return s
+}
+
+// WithBackoffJitter randomizes the backoff delays of WithBackoff,
+// WithTypeBackoff or WithBackoffBase per polled ticket, so that tickets
+// polled together and left unresolved aren't all redelivered at once, see
+// Jitter. Defaults to JitterNone.
+func (s *Settings) WithBackoffJitter(j Jitter) *Settings {
+	s.backoffJitter = j
+	return s
+}
+
+// WithTypeBackoff sets the backoff of tickets of type typ, overriding WithBackoff.
+func (s *Settings) WithTypeBackoff(typ string, b Backoff) *Settings {
+	if s.backoffPerType == nil {
+		s.backoffPerType = make(map[string]Backoff)
+	}
+	s.backoffPerType[typ] = b
+	return s
+}
+
+// WithCodec sets the codec of the payloads of tickets of type typ, used by
+// Enqueue and Typed handlers given a nil codec. Defaults to JSONCodec.
+func (s *Settings) WithCodec(typ string, c Codec) *Settings {
+	if s.codecs == nil {
+		s.codecs = make(map[string]Codec)
+	}
+	s.codecs[typ] = c
+	return s
+}
+
+// WithBlobStore offloads to bs the payloads whose JSON is larger than
+// threshold bytes when tickets are put, the store keeping a reference only,
+// in the reserved "$lymbo_blob" metadata of the ticket, with a nil payload.
+// Workers load them before calling the handler, see Kharon.LoadPayload, and
+// delete them with the tickets they remove; those of expired tickets are
+// left behind, for the lifecycle rules of bs to collect.
+func (s *Settings) WithBlobStore(bs BlobStore, threshold int) *Settings {
+	s.blobs = bs
+	s.blobThreshold = threshold
+	return s
+}
+
+// WithMaxReactionDelay caps how long the poller sleeps between polls, even when
+// the next pending ticket is scheduled further out. It bounds how late newly
+// added immediate tickets are noticed by stores without push notifications.
 func (s *Settings) WithMaxReactionDelay(d time.Duration) *Settings {
 	s.maxReactionDelay = d
 	return s
 }
 
+// WithReactionJitter shortens each idle wait by a random duration in [0, d),
+// never going below the minimum reaction delay.
+func (s *Settings) WithReactionJitter(d time.Duration) *Settings {
+	s.reactionJitter = d
+	return s
+}
+
 func (s *Settings) WithMinReactionDelay(d time.Duration) *Settings {
 	s.minReactionDelay = d
 	return s
 }
 
+// WithMaxAttempts limits how many times a ticket is delivered to a handler.
+// A ticket polled for the (n+1)th time is moved to the dead-letter status
+// status.Dead instead, keeping its last ErrorReason, see ListDead.
+// 0 (the default) means unlimited. WithRetryPolicy overrides it per type.
+func (s *Settings) WithMaxAttempts(n int) *Settings {
+	s.maxAttempts = n
+	return s
+}
+
+// WithRetryPolicy sets how tickets of type typ are retried: their max
+// attempts, overriding WithMaxAttempts, their backoff, as WithTypeBackoff,
+// and which handler errors are worth retrying at all, e.g.
+//
+//	settings.WithRetryPolicy("email", lymbo.RetryPolicy{
+//		MaxAttempts: 10,
+//		Backoff:     lymbo.LinearBackoff{Step: time.Minute, MaxDelay: time.Hour},
+//		Retryable:   func(err error) bool { return !errors.Is(err, ErrBadAddress) },
+//	})
+func (s *Settings) WithRetryPolicy(typ string, p RetryPolicy) *Settings {
+	if s.retryPolicies == nil {
+		s.retryPolicies = make(map[string]RetryPolicy)
+	}
+	s.retryPolicies[typ] = p
+	if p.Backoff != nil {
+		s.WithTypeBackoff(typ, p.Backoff)
+	}
+	return s
+}
+
+// WithOnExhausted registers a callback fired exactly once for every ticket that
+// is dead-lettered for running out of attempts (see WithMaxAttempts). The ticket carries
+// the final ErrorReason and attempt count. The callback runs on the poller
+// goroutine and should not block.
+func (s *Settings) WithOnExhausted(fn func(context.Context, Ticket)) *Settings {
+	s.onExhausted = fn
+	return s
+}
+
+// WithOnPanic registers a callback fired for every handler that panicked,
+// e.g. to alert, before its ticket is failed with the stack trace as
+// ErrorReason. The callback runs on the worker goroutine and should not block.
+func (s *Settings) WithOnPanic(fn func(context.Context, Ticket, *PanicError)) *Settings {
+	s.onPanic = fn
+	return s
+}
+
+// WithLeaseCheck makes Ack, Done, Fail, Cancel and Retry called from a handler
+// fail with ErrLeaseLost, and count a lease conflict in Stats, if the ticket was
+// claimed again by another poll meanwhile, e.g. after its time-to-run elapsed.
+// Checked outcomes are written synchronously instead of in batches.
+func (s *Settings) WithLeaseCheck() *Settings {
+	s.leaseCheck = true
+	return s
+}
+
+// WithWorkerID sets the ID identifying this Kharon in the AttemptLog of the
+// tickets its workers run, followed by the number of the worker, e.g.
+// "billing-1/3", and as the Owner of the tickets it claims. Defaults to the
+// hostname and pid of the process; set a stable one, e.g. the pod name, for
+// a restarted Kharon to ReleaseOwned the tickets of its previous run.
+func (s *Settings) WithWorkerID(id string) *Settings {
+	s.workerID = id
+	return s
+}
+
+// WithClock sets the Clock telling the time of tickets: when they are due,
+// created, modified, polled or expired, e.g. a FakeClock advanced by tests
+// instead of sleeping, and the attempts of handlers and rate limits. Give
+// the same clock to the store, e.g. with memory.Store.WithClock or the Clock
+// of the Config of the others, which then touch tickets on it too.
+// Defaults to SystemClock.
+func (s *Settings) WithClock(c Clock) *Settings {
+	s.clock = c
+	return s
+}
+
+// WithAutoSettle makes Kharon settle tickets whose handler returns without
+// calling Ack, Done, Fail, Cancel or Retry: a nil error acks the ticket, any
+// other fails it with the error message as ErrorReason. Without it such
+// tickets are redelivered once their time-to-run elapses. Tickets of
+// panicking handlers are failed regardless, see WithOnPanic.
+func (s *Settings) WithAutoSettle() *Settings {
+	s.autoSettle = true
+	return s
+}
+
+// WithCatchUp sets the policy for tickets whose Runat is far in the past,
+// e.g. to smear or drop a backlog piled up while workers were down.
+// By default every overdue ticket is processed immediately.
+func (s *Settings) WithCatchUp(c CatchUp) *Settings {
+	s.catchUp = c
+	return s
+}
+
+// WithQueue makes Kharon poll only the tickets of queue, e.g. "billing",
+// so that several logical queues share a store, each with its own workers.
+// By default Kharon polls the default queue "", of tickets without a Queue.
+// Recurring tickets of WithSchedule are put to this queue.
+func (s *Settings) WithQueue(queue string) *Settings {
+	s.queue = queue
+	return s
+}
+
+// WithTenants makes Kharon poll only the tickets of tenants, see
+// Ticket.TenantID, e.g. to dedicate workers to a large tenant.
+// By default Kharon polls the tickets of every tenant.
+func (s *Settings) WithTenants(tenants ...string) *Settings {
+	s.tenants = tenants
+	return s
+}
+
+// WithFairShare makes each poll claim ready tickets round-robin across
+// tenants, so that a tenant with a large backlog doesn't starve the others
+// sharing the store: each gets a share of the batch, see PollRequest.FairShare.
+func (s *Settings) WithFairShare() *Settings {
+	s.fairShare = true
+	return s
+}
+
+// WithTypeShare makes each poll claim ready tickets round-robin across
+// types, so that a flood of tickets of one type doesn't starve the others:
+// each round claims weights[typ] tickets of the listed types, 1 of the others.
+// A nil weights shares the polls evenly, see PollRequest.TypeShare.
+func (s *Settings) WithTypeShare(weights map[string]int) *Settings {
+	s.typeShare = TypeShare{Enabled: true, Weights: weights}
+	return s
+}
+
+// WithLabelSelector makes Kharon poll only tickets having all the given labels,
+// e.g. {"tenant": "acme"} for a worker dedicated to one tenant.
+func (s *Settings) WithLabelSelector(labels map[string]string) *Settings {
+	s.labelSelector = labels
+	return s
+}
+
+// WithMaxInFlight caps how many tickets of type typ may be in flight at once
+// across every Kharon sharing the store, e.g. to never run more than n
+// exports concurrently however many workers poll. The store enforces it
+// when polling, see PollRequest.MaxInFlightPerType.
+func (s *Settings) WithMaxInFlight(typ string, n int) *Settings {
+	if s.maxInFlight == nil {
+		s.maxInFlight = make(map[string]int)
+	}
+	s.maxInFlight[typ] = max(n, 0)
+	return s
+}
+
+// WithRateLimit caps how many tickets of type typ this Kharon handles per
+// second at rps, in bursts of up to burst (at least 1), e.g. to stay within
+// the quota of an email provider however many tickets are ready at once.
+// Workers wait for the turn of a polled ticket before handling it, within its
+// processing time: one whose turn comes later is rescheduled to it, which
+// doesn't count an attempt, so that a throttled type is never dead-lettered
+// by WithMaxAttempts; keep WithProcessTime above WithWorkers / rps to spare
+// the round trips. The
+// limit is per Kharon, not shared with the others polling the store.
+// A rps <= 0 removes the limit.
+func (s *Settings) WithRateLimit(typ string, rps float64, burst int) *Settings {
+	if rps <= 0 {
+		delete(s.rateLimits, typ)
+		return s
+	}
+	if s.rateLimits == nil {
+		s.rateLimits = make(map[string]rateLimit)
+	}
+	s.rateLimits[typ] = rateLimit{rps: rps, burst: max(burst, 1)}
+	return s
+}
+
+// WithMaxConcurrency caps how many tickets of type typ this Kharon handles at
+// once at n, e.g. only 2 reports however many workers are idle, independently
+// of WithRateLimit. Polls claim only as many tickets of the type as it has
+// room for, so that the others are left to the other Kharons polling the
+// store, unlike WithMaxInFlight which is shared with them.
+// A n <= 0 removes the limit.
+func (s *Settings) WithMaxConcurrency(typ string, n int) *Settings {
+	if n <= 0 {
+		delete(s.concurrency, typ)
+		return s
+	}
+	if s.concurrency == nil {
+		s.concurrency = make(map[string]int)
+	}
+	s.concurrency[typ] = n
+	return s
+}
+
+// WithPriorityBoost lets urgent tickets (Nice <= UrgentNice, see WithPriority)
+// run up to grace ahead of their Runat when no other ticket is ready,
+// using idle capacity without abandoning the schedule.
+func (s *Settings) WithPriorityBoost(grace time.Duration) *Settings {
+	s.boost = Boost{MaxNice: UrgentNice, Grace: max(grace, 0)}
+	return s
+}
+
+// WithPriorityOrder makes Kharon claim ready tickets by Nice first rather
+// than by Runat, so that urgent tickets overtake a backlog of others. Every
+// aging a ticket has been due lowers the Nice it is ordered by by one, so that
+// low-priority tickets eventually run: with the DefaultNice, a ticket due for
+// DefaultNice*aging competes with UrgentNice ones. 0 disables aging.
+func (s *Settings) WithPriorityOrder(aging time.Duration) *Settings {
+	s.priority = Priority{Enabled: true, Aging: max(aging, 0)}
+	return s
+}
+
+// WithRetention keeps tickets in status s for d after their last modification
+// before the expiration worker removes them. It overrides the Runat-based
+// expiration for that status only, e.g. to keep failures longer than successes.
+func (s *Settings) WithRetention(st status.Status, d time.Duration) *Settings {
+	if s.retention == nil {
+		s.retention = make(map[status.Status]time.Duration)
+	}
+	s.retention[st] = d
+	return s
+}
+
+// WithArchive passes the tickets removed by the expiration worker to a
+// before they are removed, e.g. JSONLArchive or the table archive of the
+// PostgreSQL store: tickets a fails to archive are kept until the next run.
+func (s *Settings) WithArchive(a ArchiveStore) *Settings {
+	s.archive = a
+	return s
+}
+
+// WithAuditLog records every status change the Kharon makes to a ticket in
+// l, with the actor making it (see WithActor) and its reason, e.g. for
+// compliance, see Kharon.History: tickets put, settled, retried,
+// dead-lettered or past their deadline. Changes made by the store alone
+// aren't recorded: expiration, cancellations of CatchUp.DropAfter and
+// RetryFailed. Entries are recorded synchronously once the change is
+// written, or queued to be written, failures being logged.
+func (s *Settings) WithAuditLog(l AuditLog) *Settings {
+	s.auditLog = l
+	return s
+}
+
+// WithDeadlineStatus sets the status of the tickets still pending at their
+// Deadline, see WithDeadline: status.Failed (the default) or status.Cancelled.
+// Any other status is ignored.
+func (s *Settings) WithDeadlineStatus(st status.Status) *Settings {
+	if st == status.Failed || st == status.Cancelled {
+		s.deadlineStatus = st
+	}
+	return s
+}
+
+// WithSchedule makes Kharon enqueue a ticket of type typ, with ID ScheduleID(typ),
+// at each occurrence of s, e.g. Every(time.Hour) or MustParseCron("30 2 * * *").
+// opts apply as in Kharon.Put, e.g. WithPayload. An occurrence is skipped
+// while the previous ticket is still pending, so at most one instance is
+// pending at a time. Every Kharon running the schedule enqueues it: the check
+// is not atomic across them, so run schedules on a single one.
+func (s *Settings) WithSchedule(typ string, sched Schedule, opts ...Option) *Settings {
+	s.schedules = append(s.schedules, scheduled{typ: typ, schedule: sched, opts: opts})
+	return s
+}
+
+// WithTracer instruments adding, polling, processing and settling tickets
+// with t, e.g. tracing.New for OpenTelemetry. Outcomes written in batches
+// are traced when queued, not when written.
+func (s *Settings) WithTracer(t Tracer) *Settings {
+	s.tracer = t
+	return s
+}
+
+// WithPropagator adds propagators carrying values of the context of Put,
+// PutBatch, AckAndAdd and FailAndAdd to the context of the handlers of the
+// tickets they put, through the ticket Metadata.
+func (s *Settings) WithPropagator(p ...Propagator) *Settings {
+	s.propagators = append(s.propagators, p...)
+	return s
+}
+
 // WithShutdownFlushTimeout sets the timeout for flushing remaining batch on shutdown.
 func (s *Settings) WithShutdownFlushTimeout(d time.Duration) *Settings {
 	s.shutdownFlushTimeout = d
@@ -124,6 +608,12 @@ func (s *Settings) normalize() {
 	if s.maxReactionDelay < s.minReactionDelay {
 		s.maxReactionDelay = s.minReactionDelay
 	}
+	if s.maxAttempts < 0 {
+		s.maxAttempts = 0
+	}
+	if s.reactionJitter < 0 {
+		s.reactionJitter = 0
+	}
 	if s.batchSize <= 0 {
 		s.batchSize = 1
 	}
@@ -133,4 +623,22 @@ func (s *Settings) normalize() {
 	if s.backoffBase <= 0 {
 		s.backoffBase = DefaultBackoffBase
 	}
+	if s.workerID == "" {
+		s.workerID = defaultWorkerID()
+	}
+	if s.clock == nil {
+		s.clock = SystemClock
+	}
+	if s.expirationInterval <= 0 {
+		s.expirationInterval = ExpirationInterval
+	}
+	if s.expirationBatchSize <= 0 {
+		s.expirationBatchSize = ExpirationBatchSize
+	}
+	if s.expirationJitter < 0 {
+		s.expirationJitter = 0
+	}
+	if s.deadlineStatus != status.Cancelled {
+		s.deadlineStatus = status.Failed
+	}
 }
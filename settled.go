@@ -0,0 +1,37 @@
+package lymbo
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type settledKey struct{}
+
+// settled records whether a handler reported an outcome for its ticket.
+type settled struct {
+	ticket *Ticket
+	run    *Attempt // logged with the outcome, see attemptOf
+	done   *atomic.Bool
+}
+
+// withSettled returns a handler context recording outcomes reported for t
+// during run, and the flag they set.
+func withSettled(ctx context.Context, t *Ticket, run *Attempt) (context.Context, *atomic.Bool) {
+	done := new(atomic.Bool)
+	return context.WithValue(ctx, settledKey{}, settled{ticket: t, run: run, done: done}), done
+}
+
+// markSettled flags the outcome of tid as reported if ctx belongs to a handler processing it.
+func markSettled(ctx context.Context, tid TicketId) {
+	if s, ok := ctx.Value(settledKey{}).(settled); ok && s.ticket.ID == tid {
+		s.done.Store(true)
+	}
+}
+
+// handled returns the ticket tid if ctx belongs to a handler processing it, nil otherwise.
+func handled(ctx context.Context, tid TicketId) *Ticket {
+	if s, ok := ctx.Value(settledKey{}).(settled); ok && s.ticket.ID == tid {
+		return s.ticket
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package lymbo
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/ochaton/lymbo/status"
+)
+
+// errShutdown is the cause of the intake context cancelled by Shutdown.
+var errShutdown = errors.New("kharon shut down")
+
+// running is the state of a Run that Shutdown acts on.
+type running struct {
+	// stopIntake stops polling, scheduling and expiration with errShutdown.
+	stopIntake context.CancelCauseFunc
+
+	// cancel cancels the handlers still running.
+	cancel context.CancelFunc
+
+	// done is closed when Run returns.
+	done chan struct{}
+}
+
+// handling is a ticket being processed, with the flag its outcome sets.
+type handling struct {
+	ticket  *Ticket
+	settled *atomic.Bool
+}
+
+// Shutdown gracefully stops a running Kharon: polling stops at once, and the
+// handlers already running are waited for until ctx is done. The tickets
+// polled but not handled yet are rescheduled to be due now, so another Kharon
+// picks them up without waiting for their TTR to pass, and get back the
+// attempt their poll counted, as with Release. When ctx is done
+// first, the tickets of the handlers still running are rescheduled the same
+// way, unless they have reported an outcome, and the handlers are cancelled;
+// ctx's error is then returned.
+//
+// Run returns nil once shut down. Shutdown returns nil if Kharon isn't running.
+func (k *Kharon) Shutdown(ctx context.Context) error {
+	k.mu.Lock()
+	r := k.run
+	k.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+
+	r.stopIntake(errShutdown)
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	var unfinished []*Ticket
+	k.inflight.Range(func(_, v any) bool {
+		h := v.(handling)
+		// claiming the outcome keeps autoSettle from failing the cancelled handler
+		if h.settled.CompareAndSwap(false, true) {
+			unfinished = append(unfinished, h.ticket)
+		}
+		return true
+	})
+	k.requeue(ctx, unfinished)
+	r.cancel()
+	return ctx.Err()
+}
+
+// shuttingDown reports whether ctx was cancelled by Shutdown.
+func shuttingDown(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errShutdown)
+}
+
+// requeue makes the given claimed tickets due now, giving back the attempts
+// of their claims, see unclaim. Errors are logged, the tickets being retried
+// after their TTR anyway.
+func (k *Kharon) requeue(ctx context.Context, tickets []*Ticket) {
+	if len(tickets) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), k.settings.shutdownFlushTimeout)
+	defer cancel()
+
+	now := k.now()
+	var requeued int
+	for _, t := range tickets {
+		err := k.unclaim(ctx, t, now)
+		if errors.Is(err, ErrLeaseLost) {
+			// settled or claimed again meanwhile
+			continue
+		}
+		if err != nil {
+			k.logger.ErrorContext(ctx, "error rescheduling unfinished ticket",
+				"ticket_id", t.ID,
+				"type", t.Type,
+				"error", err,
+			)
+			continue
+		}
+		requeued++
+	}
+	k.logger.InfoContext(ctx, "rescheduled unfinished tickets on shutdown", "count", requeued)
+}
+
+// unclaim makes the claimed ticket t due at runat as Release does: the
+// attempt counted by its claim is given back, and its Lease and Owner are
+// cleared. It fails with ErrLeaseLost if t was settled or claimed again since.
+func (k *Kharon) unclaim(ctx context.Context, t *Ticket, runat time.Time) error {
+	return k.store.Update(ctx, t.ID, func(_ context.Context, cur *Ticket) error {
+		if cur.Status != status.Pending || cur.Lease != t.Lease {
+			return ErrLeaseLost
+		}
+		cur.Attempts = max(cur.Attempts-1, 0)
+		cur.Lease = ""
+		cur.Owner = ""
+		cur.Runat = runat
+		return nil
+	})
+}
+
+// drainIncome returns the tickets polled but never picked up by a worker.
+func (k *Kharon) drainIncome() []*Ticket {
+	var tickets []*Ticket
+	for {
+		select {
+		case t := <-k.income:
+			k.release(t)
+			tickets = append(tickets, t)
+		default:
+			return tickets
+		}
+	}
+}
+
+// track registers t as being handled until the returned function is called.
+func (k *Kharon) track(t *Ticket, settled *atomic.Bool) func() {
+	k.inflight.Store(t, handling{ticket: t, settled: settled})
+	return func() { k.inflight.Delete(t) }
+}
@@ -0,0 +1,117 @@
+package lymbo_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/memory"
+)
+
+// TestShutdownIncome shuts a Kharon down while its workers are busy and the
+// tickets of its next poll wait for them: those must be rescheduled, not handled,
+// without using up an attempt.
+func TestShutdownIncome(t *testing.T) {
+	// workers pick either of a ready ticket and the shutdown at random
+	for range 20 {
+		testShutdownIncome(t)
+	}
+}
+
+func testShutdownIncome(t *testing.T) {
+	const workers = 5
+	ctx := context.Background()
+	store := memory.NewStore()
+	var ids []lymbo.TicketId
+	for range 2 * workers {
+		tk, _ := lymbo.NewTicket(lymbo.TicketId(uuid.NewString()), "race")
+		if err := store.Put(ctx, *tk); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tk.ID)
+	}
+
+	var started, late atomic.Int32
+	var stopping atomic.Bool
+	unblock := make(chan struct{})
+	r := lymbo.NewRouter()
+	r.HandleFunc("race", func(context.Context, *lymbo.Ticket) error {
+		if stopping.Load() {
+			late.Add(1)
+		}
+		started.Add(1)
+		<-unblock
+		return nil
+	})
+
+	settings := lymbo.DefaultSettings().
+		WithWorkers(workers).
+		WithBatchSize(2 * workers).
+		WithMinReactionDelay(time.Millisecond).
+		WithMaxReactionDelay(10 * time.Millisecond).
+		WithAutoSettle().
+		WithoutExpiration()
+	kh := lymbo.NewKharon(store, settings, slog.New(slog.DiscardHandler))
+	run := make(chan error, 1)
+	go func() { run <- kh.Run(ctx, r) }()
+
+	// every worker is busy and every other ticket is claimed, waiting for them
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		inflight, err := store.ListInFlight(ctx, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if started.Load() == workers && len(inflight) == 2*workers {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d handlers started and %d tickets claimed, want %d and %d",
+				started.Load(), len(inflight), workers, 2*workers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stopping.Store(true)
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- kh.Shutdown(ctx) }()
+	// let Shutdown stop the intake before the workers are done
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := <-run; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if n := late.Load(); n != 0 {
+		t.Fatalf("%d handlers started after Shutdown, want none", n)
+	}
+	var acked, pending int
+	for _, id := range ids {
+		tk, err := store.Get(ctx, id)
+		switch {
+		case errors.Is(err, lymbo.ErrTicketNotFound):
+			acked++
+		case err != nil:
+			t.Fatal(err)
+		case tk.Status == status.Pending && !tk.Runat.After(time.Now()):
+			// never handled, the attempt of their claim is given back
+			if tk.Attempts != 0 || tk.Lease != "" || tk.Owner != "" {
+				t.Fatalf("requeued ticket has %d attempts, lease %q and owner %q, want none",
+					tk.Attempts, tk.Lease, tk.Owner)
+			}
+			pending++
+		}
+	}
+	if acked != workers || pending != workers {
+		t.Fatalf("%d tickets acked and %d pending due now, want %d of each", acked, pending, workers)
+	}
+}
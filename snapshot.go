@@ -0,0 +1,87 @@
+package lymbo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SnapshotBatchSize is the number of tickets Export lists and Import puts at once.
+const SnapshotBatchSize = 1000
+
+// Export writes every ticket of st to w as a line of JSON, in the order of
+// Store.List, and returns how many it wrote, e.g. to back up a queue or to
+// migrate it to another backend with Import. Tickets are listed by pages of
+// SnapshotBatchSize, so that tickets changed meanwhile may be written as of
+// before or after their change: stop the Kharons using st for a consistent
+// snapshot. Payloads and results are written as JSON, and read back as the
+// values they decode to, as from stores serializing them, e.g. PostgreSQL.
+// Offloaded payloads, see Settings.WithBlobStore, are written as references.
+func Export(ctx context.Context, st Store, w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	req := ListRequest{Limit: SnapshotBatchSize}
+	var n int
+	for {
+		page, err := st.List(ctx, req)
+		if err != nil {
+			return n, err
+		}
+		for _, t := range page {
+			if err := enc.Encode(t); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if req.After = req.Next(page); req.After == nil {
+			return n, nil
+		}
+	}
+}
+
+// Import puts the tickets read from r, as written by Export or JSONLArchive,
+// into st as they are, their Status, Attempts and times included, and returns
+// how many it put. Tickets are put by batches of SnapshotBatchSize with
+// Store.PutBatch; Import stops at the first ticket that couldn't be put, e.g.
+// with ErrDuplicateTicket, the tickets of the earlier batches staying put.
+func Import(ctx context.Context, st Store, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	batch := make([]Ticket, 0, SnapshotBatchSize)
+	var n int
+	put := func() error {
+		errs, err := st.PutBatch(ctx, batch)
+		if err != nil {
+			return err
+		}
+		for i, err := range errs {
+			if err != nil {
+				return fmt.Errorf("ticket %s: %w", batch[i].ID, err)
+			}
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		var t Ticket
+		err := dec.Decode(&t)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return n, fmt.Errorf("decoding ticket %d: %w", n+len(batch)+1, err)
+		}
+		if batch = append(batch, t); len(batch) == SnapshotBatchSize {
+			if err := put(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := put(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
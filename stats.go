@@ -1,9 +1,49 @@
 package lymbo
 
-import "sync/atomic"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 type counter struct {
 	value atomic.Int64
+
+	// byType and byQueue hold the *atomic.Int64 counts by Type and by queue.
+	byType  sync.Map
+	byQueue sync.Map
+}
+
+// add adds n to the counter and, if t is known, to the counts of its Type and queue.
+func (c *counter) add(n int64, t *Ticket) {
+	c.value.Add(n)
+	if t == nil {
+		return
+	}
+	group(&c.byType, t.Type).Add(n)
+	group(&c.byQueue, t.Queue).Add(n)
+}
+
+// group returns the count of key in m, created on first use.
+func group(m *sync.Map, key string) *atomic.Int64 {
+	v, ok := m.Load(key)
+	if !ok {
+		v, _ = m.LoadOrStore(key, new(atomic.Int64))
+	}
+	return v.(*atomic.Int64)
+}
+
+// groupValue returns the count of key in m.
+func groupValue(m *sync.Map, key string) int64 {
+	if v, ok := m.Load(key); ok {
+		return v.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+func (c *counter) reset() {
+	c.value.Store(0)
+	c.byType.Clear()
+	c.byQueue.Clear()
 }
 
 type stats struct {
@@ -17,8 +57,14 @@ type stats struct {
 	canceled       *counter
 	deleted        *counter
 	expired        *counter
+	exhausted      *counter
+	leaseConflicts *counter
 	processed      *counter
 	runningWorkers *counter
+
+	startLatency    *histogram
+	handlerDuration *histogram
+	attempts        *histogram
 }
 
 // Stats contains counters for tracking ticket processing activity.
@@ -44,11 +90,33 @@ type Stats struct {
 	Deleted int64 `json:"deleted"`
 	// Expired is the number of tickets removed due to expiration.
 	Expired int64 `json:"expired"`
+	// Exhausted is the number of tickets dead-lettered because they ran out of attempts.
+	Exhausted int64 `json:"exhausted"`
+	// LeaseConflicts is the number of outcomes rejected because the ticket
+	// was claimed again by another poll meanwhile (see WithLeaseCheck).
+	LeaseConflicts int64 `json:"lease_conflicts"`
 	// Processed is the number of tickets that have been processed by workers.
 	Processed int64 `json:"processed"`
 	// RunningWorkers is the current number of active worker goroutines.
 	// This is a gauge (current state), not a cumulative counter, and is not affected by ResetStats().
 	RunningWorkers int64 `json:"runningWorkers"`
+
+	// StartLatency is the time, in seconds, from the creation of tickets to
+	// the start of their first handling, delays given at Put included.
+	StartLatency Histogram `json:"start_latency,omitzero"`
+	// HandlerDuration is the time, in seconds, handlers ran for.
+	HandlerDuration Histogram `json:"handler_duration,omitzero"`
+	// Attempts is the number of attempts tickets took, counted when their
+	// handlers acked them or marked them done.
+	Attempts Histogram `json:"attempts,omitzero"`
+
+	// ByType and ByQueue break the counters down by ticket Type and by queue
+	// ("" for the default one). Their RunningWorkers and histograms are zero,
+	// and outcomes reported by ID outside of a handler of the ticket, as well
+	// as stale tickets dropped by catch-up, expired and deleted ones, count in
+	// the totals only.
+	ByType  map[string]Stats `json:"by_type,omitempty"`
+	ByQueue map[string]Stats `json:"by_queue,omitempty"`
 }
 
 func newStats() *stats {
@@ -63,21 +131,74 @@ func newStats() *stats {
 		canceled:       &counter{},
 		deleted:        &counter{},
 		expired:        &counter{},
+		exhausted:      &counter{},
+		leaseConflicts: &counter{},
 		processed:      &counter{},
 		runningWorkers: &counter{},
+
+		startLatency:    newHistogram(DurationBuckets),
+		handlerDuration: newHistogram(DurationBuckets),
+		attempts:        newHistogram(AttemptsBuckets),
+	}
+}
+
+func (s *stats) counters() []*counter {
+	return []*counter{
+		s.added, s.polled, s.scheduled, s.acked, s.failed, s.done, s.retried,
+		s.canceled, s.deleted, s.expired, s.exhausted, s.leaseConflicts, s.processed,
 	}
 }
 
 func (s *stats) reset() {
-	s.added.value.Store(0)
-	s.polled.value.Store(0)
-	s.scheduled.value.Store(0)
-	s.acked.value.Store(0)
-	s.failed.value.Store(0)
-	s.done.value.Store(0)
-	s.retried.value.Store(0)
-	s.canceled.value.Store(0)
-	s.deleted.value.Store(0)
-	s.expired.value.Store(0)
-	s.processed.value.Store(0)
+	for _, c := range s.counters() {
+		c.reset()
+	}
+	s.startLatency.reset()
+	s.handlerDuration.reset()
+	s.attempts.reset()
+}
+
+// completed observes the attempts of the ticket t acked or done by its handler, if known.
+func (s *stats) completed(t *Ticket) {
+	if t != nil {
+		s.attempts.observe(float64(t.Attempts))
+	}
+}
+
+// snapshot returns the counters read by value.
+func (s *stats) snapshot(value func(c *counter) int64) Stats {
+	return Stats{
+		Added:          value(s.added),
+		Polled:         value(s.polled),
+		Scheduled:      value(s.scheduled),
+		Acked:          value(s.acked),
+		Failed:         value(s.failed),
+		Done:           value(s.done),
+		Retried:        value(s.retried),
+		Canceled:       value(s.canceled),
+		Deleted:        value(s.deleted),
+		Expired:        value(s.expired),
+		Exhausted:      value(s.exhausted),
+		LeaseConflicts: value(s.leaseConflicts),
+		Processed:      value(s.processed),
+	}
+}
+
+// breakdown returns the snapshots of every key counted in the maps m returns.
+func (s *stats) breakdown(m func(c *counter) *sync.Map) map[string]Stats {
+	keys := make(map[string]bool)
+	for _, c := range s.counters() {
+		m(c).Range(func(k, _ any) bool {
+			keys[k.(string)] = true
+			return true
+		})
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	groups := make(map[string]Stats, len(keys))
+	for key := range keys {
+		groups[key] = s.snapshot(func(c *counter) int64 { return groupValue(m(c), key) })
+	}
+	return groups
 }
@@ -45,6 +45,9 @@ var (
 	Done      = Status{slug: "done"}
 	Failed    = Status{slug: "failed"}
 	Cancelled = Status{slug: "cancelled"}
+
+	// Dead is the dead-letter status of tickets that ran out of attempts.
+	Dead = Status{slug: "dead"}
 )
 
 // FromString converts a string to a Status.
@@ -59,6 +62,8 @@ func FromString(s string) (Status, error) {
 		return Failed, nil
 	case Cancelled.slug:
 		return Cancelled, nil
+	case Dead.slug:
+		return Dead, nil
 	default:
 		return Status{}, errors.Join(ErrStatusUnknown, fmt.Errorf("unknown status: %s", s))
 	}
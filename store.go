@@ -9,14 +9,267 @@ import (
 
 type UpdateFunc func(context.Context, *Ticket) error
 
+// PollRequest describes a single poll for ready tickets.
+// Every store claims a polled ticket by stamping it with a new random Lease
+// token, incrementing its Attempts and moving
+// its Runat to Now + TTR + BackoffFor(Type).Delay(attempts), attempts being
+// the count before the increment, so that the ticket is redelivered if it
+// isn't resolved in time.
 type PollRequest struct {
 	Limit           int
 	Now             time.Time
 	TTR             time.Duration
 	BackoffBase     float64
 	MaxBackoffDelay time.Duration
+
+	// Backoff, if set, replaces the exponential backoff described by
+	// BackoffBase and MaxBackoffDelay.
+	Backoff Backoff
+
+	// BackoffPerType overrides Backoff for tickets of the listed Types.
+	BackoffPerType map[string]Backoff
+
+	// Queue restricts the poll to the tickets of this queue,
+	// "" being the default queue of tickets without one.
+	Queue string
+
+	// Labels restricts the poll to tickets having all of these labels.
+	// Empty matches every ticket.
+	Labels map[string]string
+
+	// Tenants restricts the poll to the tickets of these tenants, see
+	// Ticket.TenantID. Empty matches every ticket.
+	Tenants []string
+
+	// FairShare orders ready tickets round-robin across tenants, each in the
+	// order of the poll, so that Limit is shared among the tenants having
+	// ready tickets and one tenant's backlog doesn't starve the others.
+	FairShare bool
+
+	// TypeShare shares Limit among the types having ready tickets.
+	TypeShare TypeShare
+
+	// CatchUp controls how overdue tickets are handled, e.g. after an outage.
+	CatchUp CatchUp
+
+	// MaxInFlightPerType caps how many tickets of each listed Type may be in
+	// flight at once (see ListInFlight) across every poller sharing the store:
+	// a poll claims only the capacity left, oldest first. Unlisted types are
+	// uncapped.
+	MaxInFlightPerType map[string]int
+
+	// LimitPerType caps how many tickets of each listed Type this poll
+	// claims, within Limit, e.g. the workers the poller has left for them:
+	// a type at 0 isn't claimed at all. Unlike MaxInFlightPerType, the
+	// tickets in flight aren't counted. Unlisted types are only capped by Limit.
+	LimitPerType map[string]int
+
+	// Boost lets urgent tickets use the capacity left by ready ones.
+	Boost Boost
+
+	// Priority orders ready tickets by Nice before Runat.
+	Priority Priority
+
+	// Owner is stamped as the Owner of the claimed tickets, see ReleaseOwned.
+	Owner string
+
+	// Jitter randomizes the backoff of each claimed ticket, see Jitter.Delay.
+	Jitter Jitter
+}
+
+// BackoffFor returns the backoff applied when claiming tickets of type typ.
+func (r PollRequest) BackoffFor(typ string) Backoff {
+	if b, ok := r.BackoffPerType[typ]; ok && b != nil {
+		return b
+	}
+	if r.Backoff != nil {
+		return r.Backoff
+	}
+	return ExponentialBackoff{Base: r.BackoffBase, MaxDelay: r.MaxBackoffDelay}
+}
+
+// Boost is a policy claiming urgent tickets ahead of schedule: if a poll has
+// capacity left after every ready ticket, pending tickets never attempted yet
+// with Nice <= MaxNice and Runat within Grace from now are claimed next,
+// earliest first. Retries keep their backoff. The zero value disables it.
+type Boost struct {
+	MaxNice int
+	Grace   time.Duration
+}
+
+// Priority is a policy claiming ready tickets by Nice first, earliest first
+// among equal ones, instead of by Runat with Nice only breaking ties, so that
+// urgent tickets overtake a backlog. Early tickets of Boost still come after
+// every ready one. The zero value keeps the Runat order.
+type Priority struct {
+	Enabled bool
+
+	// Aging guards low-priority tickets against starvation: the Nice a ready
+	// ticket is ordered by is lowered by one for every Aging it has been due.
+	// 0 disables it, so that a steady flow of urgent tickets may starve the others.
+	Aging time.Duration
+}
+
+// Nice returns the Nice t is ordered by at now.
+func (p Priority) Nice(t Ticket, now time.Time) int {
+	if p.Aging <= 0 || !t.Runat.Before(now) {
+		return t.Nice
+	}
+	return t.Nice - int(now.Sub(t.Runat)/p.Aging)
 }
 
+// TypeShare is a policy claiming ready tickets round-robin across types,
+// each in the order of the poll, so that a type flooding the store doesn't
+// starve the others: every round claims up to Weight tickets of each type.
+// With PollRequest.FairShare, tenants take turns first, and types within
+// the share of each tenant. The zero value keeps the order of the poll.
+type TypeShare struct {
+	Enabled bool
+
+	// Weights are the tickets claimed per round for the listed types,
+	// 1 for the others.
+	Weights map[string]int
+}
+
+// Weight returns the tickets of typ claimed per round, at least 1.
+func (s TypeShare) Weight(typ string) int {
+	return max(s.Weights[typ], 1)
+}
+
+// StaleReason is the ErrorReason of tickets cancelled by CatchUp.DropAfter.
+const StaleReason = "too stale"
+
+// CatchUp is a policy for tickets whose Runat is far in the past, so that a
+// backlog piled up while workers were down doesn't hit downstreams at once.
+// The zero value processes every overdue ticket immediately.
+type CatchUp struct {
+	// OverdueAfter is how late a ticket must be to count as overdue.
+	OverdueAfter time.Duration
+
+	// MaxOverduePerType caps how many overdue tickets of each Type a single
+	// poll claims, oldest first, smearing the backlog over successive polls.
+	// Tickets that aren't overdue are not capped. 0 means unlimited.
+	MaxOverduePerType int
+
+	// DropAfter cancels tickets late by more than this instead of claiming
+	// them, with StaleReason as ErrorReason. 0 means never.
+	DropAfter time.Duration
+}
+
+// ExpireRequest describes a single expiration pass over terminal tickets.
+type ExpireRequest struct {
+	Limit int
+	Now   time.Time
+
+	// Retention maps a terminal status to how long tickets in that status
+	// are kept after their last modification (Mtime, falling back to Ctime).
+	// Statuses without an entry expire once their Runat has passed.
+	Retention map[status.Status]time.Duration
+
+	// Archive, if set, is given the expired tickets before they are removed,
+	// and none are removed if it fails. A ticket modified meanwhile may be
+	// archived and yet kept, to be archived again once it expires.
+	Archive ArchiveStore
+}
+
+// VacuumRequest describes an integrity check of the store.
+type VacuumRequest struct {
+	// UnreachableAfter is the Runat past which a pending ticket is considered
+	// never to be polled, e.g. left at the InfinityDelay runat of a failed update.
+	UnreachableAfter time.Time
+
+	// MaxAttempts reports pending tickets with more Attempts as exhausted.
+	// 0 disables the check.
+	MaxAttempts int
+}
+
+// VacuumReport lists the anomalous tickets found by a vacuum.
+type VacuumReport struct {
+	// Unreachable are pending tickets with Runat after UnreachableAfter.
+	Unreachable []Ticket
+
+	// MissingMtime are terminal tickets without Mtime, which expire by Ctime.
+	MissingMtime []Ticket
+
+	// Exhausted are pending tickets with more Attempts than MaxAttempts.
+	Exhausted []Ticket
+
+	// Fixed is the number of tickets repaired by Kharon.Vacuum.
+	Fixed int
+}
+
+// RetryFailedRequest selects the failed tickets Store.RetryFailed makes
+// pending again.
+type RetryFailedRequest struct {
+	// Types, if set, retries only tickets of these types.
+	Types []string
+
+	// Tenants, if set, retries only tickets of these tenants.
+	Tenants []string
+
+	// Created retries only tickets whose Ctime is within the range.
+	Created TimeRange
+
+	// Failed retries only tickets that failed within the range, i.e. whose
+	// Mtime (falling back to Ctime) is, e.g. during an incident.
+	Failed TimeRange
+
+	// Now is the Runat and Mtime of the retried tickets.
+	Now time.Time
+
+	// KeepAttempts keeps the Attempts of the retried tickets, which count
+	// towards Settings.WithMaxAttempts, instead of resetting them to 0.
+	KeepAttempts bool
+}
+
+// ListRequest selects the tickets returned by Store.List,
+// oldest first (by Ctime, then ID).
+type ListRequest struct {
+	// Status, if set, returns only tickets in that status.
+	Status *status.Status
+
+	// Types, if set, returns only tickets of these types.
+	Types []string
+
+	// Tenants, if set, returns only tickets of these tenants.
+	Tenants []string
+
+	// Created returns only tickets whose Ctime is within the range.
+	Created TimeRange
+
+	// Runat returns only tickets whose Runat is within the range.
+	Runat TimeRange
+
+	// After, if set, returns only tickets following it in the list order,
+	// e.g. the Next cursor of the previous page.
+	After *Cursor
+
+	// Limit caps the number of tickets returned. 0 means all of them.
+	Limit int
+}
+
+// Next returns the cursor of the page following tickets, listed by r, or nil
+// if tickets is the last page.
+func (r ListRequest) Next(tickets []Ticket) *Cursor {
+	if r.Limit <= 0 || len(tickets) < r.Limit {
+		return nil
+	}
+	c := CursorOf(tickets[len(tickets)-1])
+	return &c
+}
+
+// TimeRange is the half-open interval [From, To). A zero bound is unbounded.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains reports whether t is within the range.
+func (r TimeRange) Contains(t time.Time) bool {
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || t.Before(r.To))
+}
+
+// DelayBackoff moves Runat to now + Jitter + min(Base^attempts seconds, MaxDelay).
 type DelayBackoff struct {
 	Base     float64
 	Jitter   time.Duration
@@ -30,43 +283,162 @@ type UpdateSet struct {
 	Runat       *time.Time
 	Backoff     *DelayBackoff
 	Payload     any
-	ErrorReason any
+	ErrorReason *ErrorInfo // recorded as Ticket.SetErrorReason does
+	Result      any
+	Attempt     *Attempt // appended to Ticket.AttemptLog
+
+	// Lease, if set, makes the update conditional: it is applied only if the
+	// ticket still holds this lease token, and ErrLeaseLost is returned otherwise.
+	Lease string
+}
+
+// Settlement is the outcome of a ticket written atomically with the tickets
+// following it: either all of it takes effect or none of it does.
+type Settlement struct {
+	// Update is applied to the ticket Update.Id, honoring Update.Lease.
+	Update UpdateSet
+
+	// Func, if set, modifies the ticket as Store.Update does, instead of
+	// applying the fields of Update.
+	Func UpdateFunc
+
+	// Delete removes the ticket instead of modifying it.
+	Delete bool
+
+	// Next are the follow-up tickets, put as Store.Put does.
+	Next []Ticket
 }
 
 // Store defines the interface for ticket storage and management.
 // Implementations must be safe for concurrent use.
+// Every method takes the caller's context, which stores that do I/O honor
+// for cancellation and deadlines; in-process stores may ignore it.
 type Store interface {
 	// Get retrieves a ticket by ID.
 	// Returns ErrTicketNotFound if the ticket doesn't exist.
 	Get(context.Context, TicketId) (Ticket, error)
 
+	// Exists reports whether a ticket with the given ID is in the store,
+	// without fetching the ticket itself.
+	Exists(context.Context, TicketId) (bool, error)
+
+	// GetResult returns the Result of a settled ticket, nil if none was set,
+	// in the form Get returns it.
+	// Returns ErrTicketNotFound if the ticket doesn't exist, e.g. it was acked
+	// without WithKeep or expired, and ErrResultNotReady if it is still pending.
+	GetResult(context.Context, TicketId) (any, error)
+
 	// Put adds a new ticket to the store or updates an existing one.
-	// The ticket status will be set to Pending.
-	// Returns ErrTicketIDEmpty if the ticket ID is empty.
+	// A zero Status is stored as Pending, and a zero Ctime as the current time.
+	// Returns ErrTicketIDEmpty if the ticket ID is empty, and
+	// ErrDuplicateTicket if the ticket is pending with a UniqueKey held by
+	// another pending ticket of its Type, in which case nothing is written.
 	Put(context.Context, Ticket) error
 
+	// PutBatch puts tickets as Put does, in as few round trips as the store
+	// allows. errs is nil if every ticket was put, and otherwise has an entry
+	// per ticket, errs[i] being why tickets[i] wasn't put, e.g. ErrTicketIDEmpty;
+	// the other tickets are put regardless. err reports a failure of the batch
+	// as a whole, after which transactional stores have put none of them.
+	// Of several tickets with the same ID, the last one wins; of several
+	// with the same UniqueKey and Type, the first one does.
+	PutBatch(ctx context.Context, tickets []Ticket) (errs []error, err error)
+
 	// Delete removes a ticket from the store.
 	// This operation is idempotent and won't return an error if the ticket doesn't exist.
 	Delete(context.Context, TicketId) error
 
 	// Update modifies an existing ticket using the provided UpdateFunc.
 	// The UpdateFunc receives a pointer to the ticket to modify.
+	// If the modified ticket is pending and holds the UniqueKey of another
+	// pending ticket of its type, nothing is written and ErrDuplicateTicket is
+	// returned.
 	Update(context.Context, TicketId, UpdateFunc) error
 
 	// UpdateSet modifies an existing ticket using the provided UpdateSet.
 	// it does not fetch the ticket, the request is only Update.
 	UpdateSet(context.Context, UpdateSet) error
 
+	// Settle applies the outcome of a ticket and puts its follow-up tickets atomically.
+	// Returns ErrTicketNotFound if the ticket doesn't exist, and ErrLeaseLost if
+	// Update.Lease is set and no longer held, or ErrDuplicateTicket as Put
+	// does for a follow-up ticket, in which case nothing is written.
+	Settle(context.Context, Settlement) error
+
+	// Reschedule moves a pending ticket's Runat to the given time and refreshes its Mtime.
+	// Returns ErrTicketNotFound if the ticket doesn't exist and
+	// ErrInvalidStatusTransition if the ticket is no longer pending.
+	Reschedule(ctx context.Context, id TicketId, runat time.Time) error
+
+	// Touch moves a pending ticket's Runat to now + extendBy and refreshes its
+	// Mtime, so that a handler still working on it keeps it from being
+	// redelivered once its time-to-run elapses.
+	// Returns ErrTicketNotFound and ErrInvalidStatusTransition as Reschedule does.
+	Touch(ctx context.Context, id TicketId, extendBy time.Duration) error
+
 	// PollPending retrieves pending tickets ready for processing.
-	// Returns up to limit tickets sorted by priority (Runat, then Nice).
-	// The backoffBase parameter controls the exponential backoff calculation.
-	// Returns ErrLimitInvalid if limit <= 0.
+	// Returns up to req.Limit tickets sorted by priority (Runat, then Nice).
+	// Tickets with DependsOn left are skipped, and don't count for SleepUntil.
+	// req.BackoffFor controls the backoff of the claimed tickets.
+	// Returns ErrLimitInvalid if req.Limit <= 0.
+	// A store failing after claiming some tickets returns them along with
+	// the error, so that they are processed rather than left to redeliver.
 	PollPending(context.Context, PollRequest) (PollResult, error)
 
+	// Pause stops PollPending from claiming the tickets of type typ, for
+	// every poller sharing the store, until Resume: they stay pending, and
+	// don't count for SleepUntil. Pausing a paused type is a no-op.
+	// Returns ErrTypeEmpty if typ is empty.
+	Pause(ctx context.Context, typ string) error
+
+	// Resume lets PollPending claim the tickets of a paused type again.
+	// Resuming a type that isn't paused is a no-op.
+	// Returns ErrTypeEmpty if typ is empty.
+	Resume(ctx context.Context, typ string) error
+
+	// Paused returns the paused types, sorted.
+	Paused(ctx context.Context) ([]string, error)
+
+	// ReleaseOwned makes the tickets in flight (see ListInFlight) claimed by
+	// polls of owner, see PollRequest.Owner, due at now again, clearing their
+	// Owner and Lease, and returns how many it released. Tickets settled since
+	// their claim no longer have an owner.
+	ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error)
+
+	// ReleaseDependents removes id from the DependsOn of the pending tickets
+	// waiting for it, and returns how many there were. Tickets left waiting
+	// for none are due at now at the latest, so that the time spent waiting
+	// doesn't count as late, see CatchUp.
+	ReleaseDependents(ctx context.Context, id TicketId, now time.Time) (int, error)
+
+	// RetryFailed makes the failed tickets selected by req pending, due at
+	// req.Now, in a single transaction where the store allows, and returns
+	// how many it retried. Tickets whose UniqueKey is held by a pending
+	// ticket of their Type stay failed, as do all but one of several failed
+	// tickets sharing one.
+	RetryFailed(ctx context.Context, req RetryFailedRequest) (int, error)
+
+	// ListInFlight returns the pending tickets currently leased by a poller,
+	// i.e. polled at least once (Attempts > 0) and not yet due for redelivery (Runat > now).
+	ListInFlight(ctx context.Context, now time.Time) ([]Ticket, error)
+
+	// ListOverdue returns up to limit pending tickets whose Deadline is at or
+	// before now, earliest deadline first.
+	// Returns ErrLimitInvalid if limit <= 0.
+	ListOverdue(ctx context.Context, now time.Time, limit int) ([]Ticket, error)
+
+	// List returns the tickets selected by ListRequest.
+	List(context.Context, ListRequest) ([]Ticket, error)
+
+	// Vacuum scans the store for the anomalies described by VacuumRequest.
+	// It only reports them, see Kharon.Vacuum for repairs.
+	Vacuum(context.Context, VacuumRequest) (VacuumReport, error)
+
 	// ExpireTickets removes expired tickets from the store.
-	// Only removes non-pending tickets where Runat is before now.
-	// Deletes up to limit tickets.
-	ExpireTickets(ctx context.Context, limit int, now time.Time) (int64, error)
+	// Only removes non-pending tickets whose retention has elapsed: either
+	// Mtime + Retention[status] for statuses with a configured retention,
+	// or Runat for the rest. Deletes up to Limit tickets.
+	ExpireTickets(context.Context, ExpireRequest) (int64, error)
 
 	// DeleteBatch removes multiple tickets from the store.
 	// This operation is idempotent and won't return an error if some tickets don't exist.
@@ -77,6 +449,29 @@ type Store interface {
 	UpdateBatch(ctx context.Context, updates []UpdateSet) error
 }
 
+// Notifier is implemented by stores that can push the Runat of tickets becoming
+// pending, so that Kharon polls as soon as they are due instead of waiting
+// for its next poll. Notifications are a hint: polling still happens, and
+// catches up with tickets whose notification was lost.
+type Notifier interface {
+	// Listen calls notify with the Runat of tickets put or rescheduled as
+	// pending, until ctx is cancelled or the subscription fails, e.g. on
+	// connection loss, and returns why. notify must not block.
+	// Returns ErrNotifyDisabled if the store isn't configured for notifications.
+	Listen(ctx context.Context, notify func(runat time.Time)) error
+}
+
+// Searcher is implemented by stores that can select tickets by the content of
+// their payload, see Kharon.Search.
+type Searcher interface {
+	// Search returns up to limit tickets whose JSON payload matches the
+	// SQL/JSON path predicate query, e.g. `$.order_id == "A-42"`, oldest
+	// first (by Ctime, then ID). Payloads compressed, encoded by a
+	// non-JSON codec or offloaded to a BlobStore don't match.
+	// Returns ErrLimitInvalid if limit <= 0.
+	Search(ctx context.Context, query string, limit int) ([]Ticket, error)
+}
+
 // PollResult contains the result of a store polling operation.
 type PollResult struct {
 	// SleepUntil indicates when the next poll should occur.
@@ -87,4 +482,13 @@ type PollResult struct {
 	// Tickets contains the tickets ready for processing.
 	// Will be empty if SleepUntil is non-nil.
 	Tickets []Ticket
+
+	// Dropped is the number of tickets cancelled as stale by CatchUp.DropAfter.
+	Dropped int
+
+	// Due holds the Runat each of Tickets was due at before the poll claimed
+	// it, which moved it to the time of redelivery, so that the polls of
+	// several stores can be merged in order, see store/multi. Stores may
+	// leave it nil.
+	Due []time.Time
 }
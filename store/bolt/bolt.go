@@ -0,0 +1,784 @@
+// Package bolt provides a bbolt implementation of the lymbo.Store interface,
+// for single-node services that need durable tickets without running a
+// database server.
+//
+// Usage:
+//
+//	db, _ := bbolt.Open("lymbo.db", 0o600, &bbolt.Options{Timeout: time.Second})
+//	store, err := bolt.NewStore(bolt.Config{DB: db})
+//
+// Tickets are kept serialized in a bucket keyed by ID. Pending tickets are
+// indexed by a bucket of keys sorted by queue, then Runat, so polls read
+// only the ready tickets of their queue, and the UniqueKeys of pending tickets
+// by a bucket of keys to IDs. bbolt runs a single write transaction at a
+// time, so a poll claims its tickets alone; the file is locked by the process
+// that opened it.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/internal/storeutil"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultBucket is the bucket used when Config.Bucket is empty.
+const DefaultBucket = "lymbo"
+
+type Config struct {
+	DB *bolt.DB
+
+	// Bucket is the top-level bucket holding the buckets of the store.
+	// Defaults to DefaultBucket.
+	Bucket string
+
+	// Clock tells the time of the tickets put or updated, e.g. the
+	// lymbo.FakeClock of the Kharon, see lymbo.Settings.WithClock.
+	// Defaults to lymbo.SystemClock.
+	Clock lymbo.Clock
+}
+
+// Store is a bbolt backed ticket store.
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+	clock  lymbo.Clock
+}
+
+// Ensure Store implements lymbo.Store interface.
+var _ lymbo.Store = (*Store)(nil)
+
+var (
+	ticketsBucket = []byte("tickets")
+	pendingBucket = []byte("pending")
+	uniqueBucket  = []byte("unique")
+	pausedBucket  = []byte("paused")
+)
+
+// NewStore returns a store on top of db, creating its buckets if they don't exist.
+func NewStore(cfg Config) (*Store, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("db cannot be nil")
+	}
+	if cfg.Bucket == "" {
+		cfg.Bucket = DefaultBucket
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = lymbo.SystemClock
+	}
+
+	s := &Store{db: cfg.DB, bucket: []byte(cfg.Bucket), clock: cfg.Clock}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{ticketsBucket, pendingBucket, uniqueBucket, pausedBucket} {
+			if _, err := root.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+	return s, nil
+}
+
+// buckets are the buckets of a store within a transaction.
+type buckets struct {
+	tickets *bolt.Bucket
+	pending *bolt.Bucket
+	unique  *bolt.Bucket
+	paused  *bolt.Bucket
+}
+
+func (s *Store) buckets(tx *bolt.Tx) buckets {
+	root := tx.Bucket(s.bucket)
+	return buckets{
+		tickets: root.Bucket(ticketsBucket),
+		pending: root.Bucket(pendingBucket),
+		unique:  root.Bucket(uniqueBucket),
+		paused:  root.Bucket(pausedBucket),
+	}
+}
+
+// view runs fn in a read-only transaction.
+func (s *Store) view(fn func(b buckets) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(s.buckets(tx))
+	})
+}
+
+// update runs fn in a write transaction, committed if fn succeeds.
+func (s *Store) update(fn func(b buckets) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(s.buckets(tx))
+	})
+}
+
+// queuePrefix returns the prefix of the pending index keys of queue.
+func queuePrefix(queue string) []byte {
+	return append([]byte(queue), 0)
+}
+
+// pendingKey returns the key indexing t in the pending bucket: its queue,
+// then its Runat as unix milliseconds, sign flipped to sort as bytes, then its ID.
+func pendingKey(t lymbo.Ticket) []byte {
+	key := queuePrefix(t.Queue)
+	key = runatKey(key, t.Runat)
+	return append(key, t.ID...)
+}
+
+func runatKey(prefix []byte, runat time.Time) []byte {
+	return binary.BigEndian.AppendUint64(prefix, uint64(runat.UnixMilli())^(1<<63))
+}
+
+func get(b buckets, id lymbo.TicketId) (lymbo.Ticket, bool, error) {
+	data := b.tickets.Get([]byte(id))
+	if data == nil {
+		return lymbo.Ticket{}, false, nil
+	}
+	t, err := decode(data)
+	return t, err == nil, err
+}
+
+func load(b buckets, id lymbo.TicketId) (lymbo.Ticket, error) {
+	if id == "" {
+		return lymbo.Ticket{}, lymbo.ErrTicketIDEmpty
+	}
+	t, ok, err := get(b, id)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	if !ok {
+		return lymbo.Ticket{}, lymbo.ErrTicketNotFound
+	}
+	return t, nil
+}
+
+func decode(data []byte) (lymbo.Ticket, error) {
+	t, err := storeutil.Unmarshal(data)
+	if err != nil {
+		return lymbo.Ticket{}, fmt.Errorf("failed to decode ticket: %w", err)
+	}
+	return t, nil
+}
+
+// duplicate reports whether another pending ticket holds the UniqueKey of t.
+func duplicate(b buckets, t lymbo.Ticket) bool {
+	u, ok := storeutil.Unique(t)
+	if !ok {
+		return false
+	}
+	owner := b.unique.Get([]byte(u.String()))
+	return owner != nil && lymbo.TicketId(owner) != t.ID
+}
+
+// save writes t, keeping the indexes up to date, or fails with
+// ErrDuplicateTicket if another pending ticket holds its UniqueKey.
+func save(b buckets, t lymbo.Ticket) error {
+	if duplicate(b, t) {
+		return lymbo.ErrDuplicateTicket
+	}
+	data, err := storeutil.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := unindex(b, t.ID); err != nil {
+		return err
+	}
+	if err := b.tickets.Put([]byte(t.ID), data); err != nil {
+		return err
+	}
+	if t.Status != status.Pending {
+		return nil
+	}
+	if err := b.pending.Put(pendingKey(t), nil); err != nil {
+		return err
+	}
+	if u, ok := storeutil.Unique(t); ok {
+		return b.unique.Put([]byte(u.String()), []byte(t.ID))
+	}
+	return nil
+}
+
+// remove deletes the ticket id, if any.
+func remove(b buckets, id lymbo.TicketId) error {
+	if err := unindex(b, id); err != nil {
+		return err
+	}
+	return b.tickets.Delete([]byte(id))
+}
+
+// unindex removes the stored ticket id, if any, from the indexes.
+func unindex(b buckets, id lymbo.TicketId) error {
+	old, ok, err := get(b, id)
+	if err != nil || !ok || old.Status != status.Pending {
+		return err
+	}
+	if err := b.pending.Delete(pendingKey(old)); err != nil {
+		return err
+	}
+	if u, ok := storeutil.Unique(old); ok && lymbo.TicketId(b.unique.Get([]byte(u.String()))) == id {
+		return b.unique.Delete([]byte(u.String()))
+	}
+	return nil
+}
+
+// modify applies fn to the ticket and writes it back in a single transaction.
+func (s *Store) modify(id lymbo.TicketId, fn func(*lymbo.Ticket) error) error {
+	return s.update(func(b buckets) error {
+		t, err := load(b, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(&t); err != nil {
+			return err
+		}
+		return save(b, t)
+	})
+}
+
+// all returns every stored ticket.
+func all(b buckets) ([]lymbo.Ticket, error) {
+	var tickets []lymbo.Ticket
+	err := b.tickets.ForEach(func(_, data []byte) error {
+		t, err := decode(data)
+		if err != nil {
+			return err
+		}
+		tickets = append(tickets, t)
+		return nil
+	})
+	return tickets, err
+}
+
+// scan returns every stored ticket in a read-only transaction.
+func (s *Store) scan() ([]lymbo.Ticket, error) {
+	var tickets []lymbo.Ticket
+	err := s.view(func(b buckets) (err error) {
+		tickets, err = all(b)
+		return err
+	})
+	return tickets, err
+}
+
+// values iterates over tickets.
+func values(tickets []lymbo.Ticket) iter.Seq[lymbo.Ticket] {
+	return func(yield func(lymbo.Ticket) bool) {
+		for _, t := range tickets {
+			if !yield(t) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Store) Get(_ context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
+	var t lymbo.Ticket
+	err := s.view(func(b buckets) (err error) {
+		t, err = load(b, id)
+		return err
+	})
+	return t, err
+}
+
+func (s *Store) Exists(_ context.Context, id lymbo.TicketId) (bool, error) {
+	if id == "" {
+		return false, lymbo.ErrTicketIDEmpty
+	}
+	var exists bool
+	err := s.view(func(b buckets) error {
+		exists = b.tickets.Get([]byte(id)) != nil
+		return nil
+	})
+	return exists, err
+}
+
+// GetResult returns the Result of a settled ticket.
+func (s *Store) GetResult(ctx context.Context, id lymbo.TicketId) (any, error) {
+	t, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.Result(t)
+}
+
+func (s *Store) Put(_ context.Context, t lymbo.Ticket) error {
+	if t.ID == "" {
+		return lymbo.ErrTicketIDEmpty
+	}
+
+	storeutil.Defaults(&t, s.clock.Now())
+	return s.update(func(b buckets) error {
+		return save(b, t)
+	})
+}
+
+// PutBatch puts the tickets in a single transaction.
+func (s *Store) PutBatch(_ context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs := storeutil.NewBatchErrors(len(tickets))
+	now := s.clock.Now()
+	err := s.update(func(b buckets) error {
+		for i, t := range tickets {
+			if t.ID == "" {
+				errs.Set(i, lymbo.ErrTicketIDEmpty)
+				continue
+			}
+			storeutil.Defaults(&t, now)
+			err := save(b, t)
+			switch {
+			case errors.Is(err, lymbo.ErrDuplicateTicket):
+				// nothing was written for the ticket
+				errs.Set(i, err)
+			case err != nil:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs.Errs(), nil
+}
+
+func (s *Store) Delete(ctx context.Context, id lymbo.TicketId) error {
+	return s.DeleteBatch(ctx, []lymbo.TicketId{id})
+}
+
+func (s *Store) DeleteBatch(_ context.Context, ids []lymbo.TicketId) error {
+	return s.update(func(b buckets) error {
+		for _, id := range ids {
+			if err := remove(b, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.UpdateFunc) error {
+	return s.modify(id, func(t *lymbo.Ticket) error {
+		return fn(ctx, t)
+	})
+}
+
+func (s *Store) UpdateSet(_ context.Context, us lymbo.UpdateSet) error {
+	return s.modify(us.Id, func(t *lymbo.Ticket) error {
+		if err := storeutil.CheckLease(*t, us); err != nil {
+			return err
+		}
+		storeutil.Apply(t, us, s.clock.Now())
+		return nil
+	})
+}
+
+// UpdateBatch applies every update in a single transaction: if one fails,
+// none is written.
+func (s *Store) UpdateBatch(_ context.Context, updates []lymbo.UpdateSet) error {
+	return s.update(func(b buckets) error {
+		now := s.clock.Now()
+		for _, us := range updates {
+			t, err := load(b, us.Id)
+			if err != nil {
+				return err
+			}
+			if err := storeutil.CheckLease(t, us); err != nil {
+				return err
+			}
+			storeutil.Apply(&t, us, now)
+			if err := save(b, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Settle writes the outcome and the follow-up tickets in a single transaction.
+func (s *Store) Settle(ctx context.Context, st lymbo.Settlement) error {
+	for _, next := range st.Next {
+		if next.ID == "" {
+			return lymbo.ErrTicketIDEmpty
+		}
+	}
+
+	return s.update(func(b buckets) error {
+		t, err := load(b, st.Update.Id)
+		if err != nil {
+			return err
+		}
+		now := s.clock.Now()
+		if err := storeutil.Settle(ctx, &t, st, now); err != nil {
+			return err
+		}
+
+		if st.Delete {
+			err = remove(b, t.ID)
+		} else {
+			err = save(b, t)
+		}
+		if err != nil {
+			return err
+		}
+		for _, next := range st.Next {
+			storeutil.Defaults(&next, now)
+			if err := save(b, next); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Reschedule(_ context.Context, id lymbo.TicketId, runat time.Time) error {
+	return s.modify(id, func(t *lymbo.Ticket) error {
+		if t.Status != status.Pending {
+			return lymbo.ErrInvalidStatusTransition
+		}
+		now := s.clock.Now()
+		t.Runat = runat
+		t.Mtime = &now
+		return nil
+	})
+}
+
+// Touch reschedules the ticket to now + extendBy, see Reschedule.
+func (s *Store) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	return s.Reschedule(ctx, id, s.clock.Now().Add(extendBy))
+}
+
+// PollPending selects and claims ready tickets in a single write transaction.
+// Unless the request filters or orders tickets beyond their runat (labels,
+// catch-up, in-flight caps or priority), only the first req.Limit ready
+// tickets of the index are read.
+func (s *Store) PollPending(_ context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
+	if req.Limit <= 0 {
+		return lymbo.PollResult{}, lymbo.ErrLimitInvalid
+	}
+
+	var res lymbo.PollResult
+	err := s.update(func(b buckets) error {
+		res = lymbo.PollResult{}
+		paused, err := pausedTypes(b)
+		if err != nil {
+			return err
+		}
+		candidates, err := candidates(b, req, paused)
+		if err != nil {
+			return err
+		}
+
+		ready, sleepUntil := storeutil.Select(storeutil.Unpaused(values(candidates), paused), req)
+		if len(ready) == 0 {
+			res.SleepUntil = sleepUntil
+			return nil
+		}
+
+		ready, stale := storeutil.CatchUp(ready, req)
+		for _, t := range stale {
+			storeutil.Drop(&t, req.Now)
+			if err := save(b, t); err != nil {
+				return err
+			}
+		}
+		res.Dropped = len(stale)
+
+		ready = ready[:min(req.Limit, len(ready))]
+		res.Due = make([]time.Time, len(ready))
+		for i := range ready {
+			res.Due[i] = storeutil.Claim(&ready[i], req)
+			if err := save(b, ready[i]); err != nil {
+				return err
+			}
+		}
+		res.Tickets = ready
+		return nil
+	})
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+	return res, nil
+}
+
+// candidates reads the pending tickets storeutil.Select needs for req:
+// the ones of req.Queue due by the boost horizon and the earliest later one,
+// for SleepUntil, or every pending ticket if in-flight tickets must be counted
+// for the caps. A limited read goes on past req.Limit tickets while they share
+// a Runat, as Select orders those by nice, and skips the blocked ones and
+// those of the paused types, which Select leaves out.
+func candidates(b buckets, req lymbo.PollRequest, paused []string) ([]lymbo.Ticket, error) {
+	if len(req.MaxInFlightPerType) > 0 {
+		return indexed(b)
+	}
+
+	limit := req.Limit
+	if len(req.Labels) > 0 || len(req.Tenants) > 0 || req.FairShare || req.TypeShare.Enabled || len(req.LimitPerType) > 0 ||
+		req.CatchUp.MaxOverduePerType > 0 || req.CatchUp.DropAfter > 0 || req.Priority.Enabled {
+		// -1 is no limit
+		limit = -1
+	}
+	prefix := queuePrefix(req.Queue)
+	horizon := runatKey(prefix, req.Now.Add(max(req.Boost.Grace, 0)))
+
+	var tickets []lymbo.Ticket
+	var last []byte
+	c := b.pending.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		runat := k[:len(horizon)]
+		due := bytes.Compare(runat, horizon) <= 0
+		if due && len(tickets) == limit && !bytes.Equal(runat, last) {
+			break
+		}
+		t, err := load(b, lymbo.TicketId(k[len(horizon):]))
+		if err != nil {
+			return nil, err
+		}
+		if storeutil.Blocked(t) || slices.Contains(paused, t.Type) {
+			continue
+		}
+		tickets = append(tickets, t)
+		if !due {
+			break
+		}
+		last = runat
+	}
+	return tickets, nil
+}
+
+// pausedTypes returns the paused types, sorted.
+func pausedTypes(b buckets) ([]string, error) {
+	var types []string
+	err := b.paused.ForEach(func(k, _ []byte) error {
+		types = append(types, string(k))
+		return nil
+	})
+	return types, err
+}
+
+// Pause adds typ to the paused bucket.
+func (s *Store) Pause(_ context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	return s.update(func(b buckets) error {
+		return b.paused.Put([]byte(typ), []byte{})
+	})
+}
+
+// Resume removes typ from the paused bucket.
+func (s *Store) Resume(_ context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	return s.update(func(b buckets) error {
+		return b.paused.Delete([]byte(typ))
+	})
+}
+
+func (s *Store) Paused(_ context.Context) ([]string, error) {
+	var types []string
+	err := s.view(func(b buckets) (err error) {
+		types, err = pausedTypes(b)
+		return err
+	})
+	return types, err
+}
+
+// indexed returns every pending ticket.
+func indexed(b buckets) ([]lymbo.Ticket, error) {
+	var tickets []lymbo.Ticket
+	err := b.pending.ForEach(func(k, _ []byte) error {
+		// skip the queue and the runat
+		id := k[bytes.IndexByte(k, 0)+1+8:]
+		t, err := load(b, lymbo.TicketId(id))
+		if err != nil {
+			return err
+		}
+		tickets = append(tickets, t)
+		return nil
+	})
+	return tickets, err
+}
+
+func (s *Store) ListInFlight(_ context.Context, now time.Time) ([]lymbo.Ticket, error) {
+	var pending []lymbo.Ticket
+	err := s.view(func(b buckets) (err error) {
+		pending, err = indexed(b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var tickets []lymbo.Ticket
+	for _, t := range pending {
+		if storeutil.InFlight(t, now) {
+			tickets = append(tickets, t)
+		}
+	}
+	return tickets, nil
+}
+
+// ReleaseOwned releases the in-flight tickets of owner in a single transaction.
+func (s *Store) ReleaseOwned(_ context.Context, owner string, now time.Time) (int, error) {
+	var n int
+	err := s.update(func(b buckets) error {
+		n = 0
+		pending, err := indexed(b)
+		if err != nil {
+			return err
+		}
+		for _, t := range pending {
+			if !storeutil.Owned(t, owner, now) {
+				continue
+			}
+			storeutil.Release(&t, now)
+			if err := save(b, t); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ReleaseDependents unblocks the tickets waiting for id in a single transaction.
+func (s *Store) ReleaseDependents(_ context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	var n int
+	err := s.update(func(b buckets) error {
+		n = 0
+		pending, err := indexed(b)
+		if err != nil {
+			return err
+		}
+		for _, t := range pending {
+			if !storeutil.Waits(t, id) {
+				continue
+			}
+			storeutil.Unblock(&t, id, now)
+			if err := save(b, t); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RetryFailed makes the selected failed tickets pending in a single transaction.
+func (s *Store) RetryFailed(_ context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	var n int
+	err := s.update(func(b buckets) error {
+		n = 0
+		tickets, err := all(b)
+		if err != nil {
+			return err
+		}
+		for _, t := range tickets {
+			if !storeutil.Retried(t, req) {
+				continue
+			}
+			storeutil.Requeue(&t, req)
+			if duplicate(b, t) {
+				continue
+			}
+			if err := save(b, t); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *Store) ListOverdue(_ context.Context, now time.Time, limit int) ([]lymbo.Ticket, error) {
+	if limit <= 0 {
+		return nil, lymbo.ErrLimitInvalid
+	}
+	var pending []lymbo.Ticket
+	err := s.view(func(b buckets) (err error) {
+		pending, err = indexed(b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.ListOverdue(values(pending), now, limit), nil
+}
+
+func (s *Store) List(_ context.Context, req lymbo.ListRequest) ([]lymbo.Ticket, error) {
+	tickets, err := s.scan()
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.List(values(tickets), req), nil
+}
+
+func (s *Store) Vacuum(_ context.Context, req lymbo.VacuumRequest) (lymbo.VacuumReport, error) {
+	tickets, err := s.scan()
+	if err != nil {
+		return lymbo.VacuumReport{}, err
+	}
+	return storeutil.Vacuum(values(tickets), req), nil
+}
+
+// ExpireTickets removes up to req.Limit expired non-pending tickets in a
+// single transaction.
+func (s *Store) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
+	var count int64
+	err := s.update(func(b buckets) error {
+		count = 0
+		var (
+			expired [][]byte
+			tickets []lymbo.Ticket
+		)
+		c := b.tickets.Cursor()
+		for id, data := c.First(); id != nil && len(expired) != req.Limit; id, data = c.Next() {
+			t, err := decode(data)
+			if err != nil {
+				return err
+			}
+			if t.Status != status.Pending && !storeutil.ExpiresAt(t, req.Retention).After(req.Now) {
+				expired = append(expired, bytes.Clone(id))
+				tickets = append(tickets, t)
+			}
+		}
+		if err := storeutil.Archive(ctx, req, tickets); err != nil {
+			return err
+		}
+		// the bucket can't be modified while iterated
+		for _, id := range expired {
+			if err := b.tickets.Delete(id); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
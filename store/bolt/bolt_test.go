@@ -0,0 +1,69 @@
+package bolt_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/store/bolt"
+	"github.com/ochaton/lymbo/store/storetest"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// openDB returns a bbolt database closed when the test ends.
+func openDB(t *testing.T) *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "lymbo.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestStore(t *testing.T) {
+	db := openDB(t)
+
+	var n int
+	storetest.TestStore(t, func() lymbo.Store {
+		// a bucket of its own per subtest, so that each store starts empty
+		n++
+		s, err := bolt.NewStore(bolt.Config{DB: db, Bucket: fmt.Sprintf("storetest%d", n)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestClock(t *testing.T) {
+	ctx := context.Background()
+	clock := lymbo.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	s, err := bolt.NewStore(bolt.Config{DB: openDB(t), Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket, _ := lymbo.NewTicket("t1", "email")
+	ticket.Ctime = time.Time{}
+	if err := s.Put(ctx, *ticket); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if err := s.Touch(ctx, ticket.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Get(ctx, ticket.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := clock.Now().Add(-time.Minute); !got.Ctime.Equal(want) {
+		t.Errorf("Ctime = %v, want %v", got.Ctime, want)
+	}
+	if want := clock.Now().Add(time.Hour); !got.Runat.Equal(want) {
+		t.Errorf("Runat = %v, want %v", got.Runat, want)
+	}
+}
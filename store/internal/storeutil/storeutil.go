@@ -0,0 +1,674 @@
+// Package storeutil holds the ticket bookkeeping shared by stores that keep
+// whole tickets as values (memory, key-value backends), so that they all
+// apply updates, select and claim tickets the same way.
+package storeutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+)
+
+// Apply applies an UpdateSet to the ticket.
+func Apply(t *lymbo.Ticket, us lymbo.UpdateSet, now time.Time) {
+	// exponential backoff support
+	if us.Backoff != nil {
+		delay := backoff(us.Backoff.Base, t.Attempts, us.Backoff.MaxDelay) + max(us.Backoff.Jitter, 0)
+		runat := now.Add(delay)
+		us.Runat = &runat
+	}
+
+	// Mirror the Postgres mtime trigger: touch mtime on status or runat change.
+	if (us.Status != nil && *us.Status != t.Status) || (us.Runat != nil && !us.Runat.Equal(t.Runat)) {
+		t.Mtime = &now
+	}
+	if us.Status != nil || us.Runat != nil {
+		// settled, no longer held by the poll that claimed it
+		t.Owner = ""
+	}
+	if us.Status != nil {
+		t.Status = *us.Status
+	}
+	if us.Nice != nil {
+		t.Nice = *us.Nice
+	}
+	if us.Runat != nil {
+		t.Runat = *us.Runat
+	}
+	if us.Payload != nil {
+		t.Payload = us.Payload
+	}
+	t.SetErrorReason(us.ErrorReason)
+	if us.Result != nil {
+		t.Result = us.Result
+	}
+	t.LogAttempt(us.Attempt)
+}
+
+// Result returns the Result of t as Store.GetResult does.
+func Result(t lymbo.Ticket) (any, error) {
+	if t.Status == status.Pending {
+		return nil, lymbo.ErrResultNotReady
+	}
+	return t.Result, nil
+}
+
+// backoff returns min(base^attempts seconds, maxDelay), as computed by the Postgres store.
+func backoff(base float64, attempts int, maxDelay time.Duration) time.Duration {
+	delay, _ := lymbo.NextRetry(attempts, lymbo.BackoffConfig{Base: base, MaxDelay: maxDelay})
+	return delay
+}
+
+// Less orders tickets by runat, then by priority (nice value).
+func Less(a, b lymbo.Ticket) bool {
+	if a.Runat.Equal(b.Runat) {
+		return a.Nice < b.Nice
+	}
+	return a.Runat.Before(b.Runat)
+}
+
+// Select returns the pending tickets ready at req.Now, ordered by Less or by
+// req.Priority, followed by the ones req.Boost claims early, earliest first.
+// All of them are returned so that callers racing with other pollers can
+// skip the ones they fail to claim; callers stop at req.Limit claims.
+// Tickets beyond req.MaxInFlightPerType or req.LimitPerType are left out,
+// as are Blocked ones.
+// If none is returned, sleepUntil is the earliest future runat, if any.
+// tickets is iterated once, so that stores needn't copy their tickets out.
+func Select(tickets iter.Seq[lymbo.Ticket], req lymbo.PollRequest) (ready []lymbo.Ticket, sleepUntil *time.Time) {
+	var (
+		closest  time.Time
+		early    []lymbo.Ticket
+		inflight map[string]int
+	)
+	if len(req.MaxInFlightPerType) > 0 {
+		inflight = make(map[string]int)
+	}
+	for t := range tickets {
+		if t.Status != status.Pending {
+			continue
+		}
+		if inflight != nil && InFlight(t, req.Now) {
+			// counted regardless of queue and labels, the cap is global
+			inflight[t.Type]++
+		}
+		if t.Queue != req.Queue || !MatchLabels(t.Labels, req.Labels) || !MatchTenant(t, req.Tenants) || Blocked(t) {
+			continue
+		}
+
+		if t.Runat.After(req.Now) {
+			if closest.IsZero() || t.Runat.Before(closest) {
+				closest = t.Runat
+			}
+			if boosted(t, req) {
+				early = append(early, t)
+			}
+			continue
+		}
+
+		if ready == nil {
+			ready = make([]lymbo.Ticket, 0, req.Limit)
+		}
+		ready = append(ready, t)
+	}
+
+	if req.Priority.Enabled {
+		sortByPriority(ready, req.Priority, req.Now)
+	} else {
+		sortTickets(ready)
+	}
+	// types share the turn of each tenant
+	if req.TypeShare.Enabled {
+		shareFairly(ready, func(t lymbo.Ticket) string { return t.Type }, req.TypeShare.Weight)
+	}
+	if req.FairShare {
+		shareFairly(ready, func(t lymbo.Ticket) string { return t.TenantID }, func(string) int { return 1 })
+	}
+	if early != nil {
+		sortTickets(early)
+		ready = append(ready, early...)
+	}
+	if inflight != nil {
+		ready = capInFlight(ready, inflight, req.MaxInFlightPerType)
+	}
+	if len(req.LimitPerType) > 0 {
+		ready = capInFlight(ready, make(map[string]int), req.LimitPerType)
+	}
+
+	if len(ready) == 0 {
+		if closest.IsZero() {
+			return nil, nil
+		}
+		return nil, &closest
+	}
+	return ready, nil
+}
+
+// Unpaused iterates over the tickets whose type isn't one of paused, see
+// lymbo.Store.Pause, for Select to leave the paused ones out.
+func Unpaused(tickets iter.Seq[lymbo.Ticket], paused []string) iter.Seq[lymbo.Ticket] {
+	if len(paused) == 0 {
+		return tickets
+	}
+	return func(yield func(lymbo.Ticket) bool) {
+		for t := range tickets {
+			if !slices.Contains(paused, t.Type) {
+				if !yield(t) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// MatchTenant reports whether t is of one of tenants, any being matched if empty.
+func MatchTenant(t lymbo.Ticket, tenants []string) bool {
+	return len(tenants) == 0 || slices.Contains(tenants, t.TenantID)
+}
+
+// shareFairly reorders sorted tickets round-robin across their groups by
+// key: the first weight(group) tickets of each group, then the next ones,
+// and so on, keeping their order otherwise.
+func shareFairly(tickets []lymbo.Ticket, key func(lymbo.Ticket) string, weight func(string) int) {
+	rank := make(map[lymbo.TicketId]int, len(tickets))
+	seen := make(map[string]int)
+	for _, t := range tickets {
+		k := key(t)
+		rank[t.ID] = seen[k] / weight(k)
+		seen[k]++
+	}
+	if len(seen) < 2 {
+		return
+	}
+	slices.SortStableFunc(tickets, func(a, b lymbo.Ticket) int {
+		return rank[a.ID] - rank[b.ID]
+	})
+}
+
+// boosted reports whether a future ticket may be claimed early by req.Boost.
+func boosted(t lymbo.Ticket, req lymbo.PollRequest) bool {
+	b := req.Boost
+	return b.Grace > 0 && t.Attempts == 0 && t.Nice <= b.MaxNice && !t.Runat.After(req.Now.Add(b.Grace))
+}
+
+func sortTickets(tickets []lymbo.Ticket) {
+	slices.SortFunc(tickets, compare)
+}
+
+// sortByPriority orders ready tickets by their aged Nice, then by Less.
+func sortByPriority(tickets []lymbo.Ticket, p lymbo.Priority, now time.Time) {
+	slices.SortFunc(tickets, func(a, b lymbo.Ticket) int {
+		if na, nb := p.Nice(a, now), p.Nice(b, now); na != nb {
+			return na - nb
+		}
+		return compare(a, b)
+	})
+}
+
+// compare is Less as a comparison function.
+func compare(a, b lymbo.Ticket) int {
+	switch {
+	case Less(a, b):
+		return -1
+	case Less(b, a):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// capInFlight drops the ready tickets of types with no in-flight capacity left.
+func capInFlight(ready []lymbo.Ticket, inflight, limits map[string]int) []lymbo.Ticket {
+	kept := ready[:0]
+	for _, t := range ready {
+		if limit, ok := limits[t.Type]; ok {
+			if inflight[t.Type] >= limit {
+				continue
+			}
+			inflight[t.Type]++
+		}
+		kept = append(kept, t)
+	}
+	return kept
+}
+
+// CatchUp applies req.CatchUp to ready, as returned by Select: it splits out
+// the stale tickets to cancel, and drops overdue tickets beyond the per-type cap.
+func CatchUp(ready []lymbo.Ticket, req lymbo.PollRequest) (claimable, stale []lymbo.Ticket) {
+	c := req.CatchUp
+	if c.DropAfter <= 0 && c.MaxOverduePerType <= 0 {
+		return ready, nil
+	}
+
+	var overdue map[string]int
+	if c.MaxOverduePerType > 0 {
+		overdue = make(map[string]int)
+	}
+
+	claimable = ready[:0:0]
+	for _, t := range ready {
+		late := req.Now.Sub(t.Runat)
+		if c.DropAfter > 0 && late > c.DropAfter {
+			stale = append(stale, t)
+			continue
+		}
+		if overdue != nil && late > c.OverdueAfter {
+			if overdue[t.Type] >= c.MaxOverduePerType {
+				continue
+			}
+			overdue[t.Type]++
+		}
+		claimable = append(claimable, t)
+	}
+	return claimable, stale
+}
+
+// Drop cancels a stale ticket.
+func Drop(t *lymbo.Ticket, now time.Time) {
+	t.Status = status.Cancelled
+	t.SetErrorReason(&lymbo.ErrorInfo{Message: lymbo.StaleReason, OccurredAt: now})
+	t.Mtime = &now
+}
+
+// MatchLabels reports whether labels contain every key/value pair of selector.
+func MatchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckLease returns ErrLeaseLost if the update is conditional on a lease
+// the ticket no longer holds.
+func CheckLease(t lymbo.Ticket, us lymbo.UpdateSet) error {
+	if us.Lease != "" && us.Lease != t.Lease {
+		return lymbo.ErrLeaseLost
+	}
+	return nil
+}
+
+// Settle checks the lease of a settled ticket and, unless s.Delete is set,
+// applies s.Func or s.Update to it.
+func Settle(ctx context.Context, t *lymbo.Ticket, s lymbo.Settlement, now time.Time) error {
+	if err := CheckLease(*t, s.Update); err != nil {
+		return err
+	}
+	switch {
+	case s.Delete:
+		return nil
+	case s.Func != nil:
+		return s.Func(ctx, t)
+	default:
+		Apply(t, s.Update, now)
+		return nil
+	}
+}
+
+// Defaults fills in the fields Put defaults: a zero Status is Pending
+// and a zero Ctime is now.
+func Defaults(t *lymbo.Ticket, now time.Time) {
+	if t.Status == (status.Status{}) {
+		t.Status = status.Pending
+	}
+	if t.Ctime.IsZero() {
+		t.Ctime = now
+	}
+}
+
+// UniqueKey identifies the pending tickets deduplicated by Ticket.UniqueKey.
+type UniqueKey struct {
+	Type, Key string
+}
+
+// String encodes the key for stores indexing it by string.
+func (u UniqueKey) String() string {
+	return u.Type + "\x00" + u.Key
+}
+
+// Unique returns the UniqueKey of t, if t is pending with one.
+func Unique(t lymbo.Ticket) (UniqueKey, bool) {
+	if t.UniqueKey == "" || (t.Status != status.Pending && t.Status != (status.Status{})) {
+		return UniqueKey{}, false
+	}
+	return UniqueKey{Type: t.Type, Key: t.UniqueKey}, true
+}
+
+// BatchErrors collects the per-ticket errors of PutBatch, allocated on the first one.
+type BatchErrors struct {
+	errs []error
+	n    int
+}
+
+// NewBatchErrors returns the errors of a batch of n tickets.
+func NewBatchErrors(n int) *BatchErrors {
+	return &BatchErrors{n: n}
+}
+
+// Set records err for the ticket i.
+func (b *BatchErrors) Set(i int, err error) {
+	if b.errs == nil {
+		b.errs = make([]error, b.n)
+	}
+	b.errs[i] = err
+}
+
+// Failed reports whether the ticket i has an error.
+func (b *BatchErrors) Failed(i int) bool {
+	return b.errs != nil && b.errs[i] != nil
+}
+
+// Errs returns the errors, nil if there are none.
+func (b *BatchErrors) Errs() []error {
+	return b.errs
+}
+
+// InFlight reports whether the ticket is leased by a poller at now.
+func InFlight(t lymbo.Ticket, now time.Time) bool {
+	return t.Status == status.Pending && t.Attempts > 0 && t.Runat.After(now)
+}
+
+// Claim leases a polled ticket: it stamps a new lease token, counts the attempt
+// and pushes runat past the time-to-run plus backoff, so that it is redelivered
+// if never resolved. Returns the runat it was due at, see PollResult.Due.
+func Claim(t *lymbo.Ticket, req lymbo.PollRequest) (due time.Time) {
+	due = t.Runat
+	t.Lease = rand.Text()
+	t.Owner = req.Owner
+	delay := req.Jitter.Delay(req.BackoffFor(t.Type), t.Attempts, req.MaxBackoffDelay) + max(req.TTR, 0)
+	t.Runat = req.Now.Add(delay)
+	t.Attempts++
+	return due
+}
+
+// Owned reports whether t is in flight at now, claimed by a poll of owner.
+func Owned(t lymbo.Ticket, owner string, now time.Time) bool {
+	return owner != "" && t.Owner == owner && InFlight(t, now)
+}
+
+// Release makes an Owned ticket due at now again, as Store.ReleaseOwned does.
+func Release(t *lymbo.Ticket, now time.Time) {
+	t.Runat = now
+	t.Owner = ""
+	t.Lease = ""
+	t.Mtime = &now
+}
+
+// Blocked reports whether t waits for some of its DependsOn.
+func Blocked(t lymbo.Ticket) bool {
+	return len(t.DependsOn) > 0
+}
+
+// Waits reports whether t is pending, waiting for the ticket id.
+func Waits(t lymbo.Ticket, id lymbo.TicketId) bool {
+	return t.Status == status.Pending && slices.Contains(t.DependsOn, id)
+}
+
+// Unblock removes id from the DependsOn of a ticket that Waits for it, as
+// Store.ReleaseDependents does.
+func Unblock(t *lymbo.Ticket, id lymbo.TicketId, now time.Time) {
+	t.DependsOn = slices.DeleteFunc(slices.Clone(t.DependsOn), func(d lymbo.TicketId) bool {
+		return d == id
+	})
+	if len(t.DependsOn) > 0 {
+		return
+	}
+	t.DependsOn = nil
+	if t.Runat.Before(now) {
+		t.Runat = now
+		t.Mtime = &now
+	}
+}
+
+// Retried reports whether t is a failed ticket selected by req.
+func Retried(t lymbo.Ticket, req lymbo.RetryFailedRequest) bool {
+	failedAt := t.Ctime
+	if t.Mtime != nil {
+		failedAt = *t.Mtime
+	}
+	switch {
+	case t.Status != status.Failed:
+		return false
+	case len(req.Types) > 0 && !slices.Contains(req.Types, t.Type):
+		return false
+	case !MatchTenant(t, req.Tenants):
+		return false
+	case !req.Created.Contains(t.Ctime), !req.Failed.Contains(failedAt):
+		return false
+	}
+	return true
+}
+
+// Requeue makes a Retried ticket pending, due at req.Now, as
+// Store.RetryFailed does.
+func Requeue(t *lymbo.Ticket, req lymbo.RetryFailedRequest) {
+	now := req.Now
+	t.Status = status.Pending
+	t.Runat = now
+	t.Mtime = &now
+	t.Owner = ""
+	t.Lease = ""
+	if !req.KeepAttempts {
+		t.Attempts = 0
+	}
+}
+
+// Overdue reports whether t is pending past its deadline at now.
+func Overdue(t lymbo.Ticket, now time.Time) bool {
+	return t.Status == status.Pending && t.Deadline != nil && !t.Deadline.After(now)
+}
+
+// ListOverdue returns up to limit of the tickets Overdue at now, earliest
+// deadline first.
+func ListOverdue(tickets iter.Seq[lymbo.Ticket], now time.Time, limit int) []lymbo.Ticket {
+	var list []lymbo.Ticket
+	for t := range tickets {
+		if Overdue(t, now) {
+			list = append(list, t)
+		}
+	}
+	slices.SortFunc(list, func(a, b lymbo.Ticket) int {
+		return a.Deadline.Compare(*b.Deadline)
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// Vacuum classifies the anomalous tickets described by req.
+func Vacuum(tickets iter.Seq[lymbo.Ticket], req lymbo.VacuumRequest) lymbo.VacuumReport {
+	var report lymbo.VacuumReport
+	for t := range tickets {
+		if t.Status != status.Pending {
+			if t.Mtime == nil {
+				report.MissingMtime = append(report.MissingMtime, t)
+			}
+			continue
+		}
+		if t.Runat.After(req.UnreachableAfter) {
+			report.Unreachable = append(report.Unreachable, t)
+		}
+		if req.MaxAttempts > 0 && t.Attempts > req.MaxAttempts {
+			report.Exhausted = append(report.Exhausted, t)
+		}
+	}
+	return report
+}
+
+// List returns the tickets selected by req, sorted by Ctime, then ID.
+func List(tickets iter.Seq[lymbo.Ticket], req lymbo.ListRequest) []lymbo.Ticket {
+	var list []lymbo.Ticket
+	for t := range tickets {
+		if Listed(t, req) {
+			list = append(list, t)
+		}
+	}
+	slices.SortFunc(list, func(a, b lymbo.Ticket) int {
+		if c := a.Ctime.Compare(b.Ctime); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	if req.Limit > 0 && len(list) > req.Limit {
+		list = list[:req.Limit]
+	}
+	return list
+}
+
+// Listed reports whether t is selected by req, regardless of req.Limit.
+func Listed(t lymbo.Ticket, req lymbo.ListRequest) bool {
+	switch {
+	case req.Status != nil && t.Status != *req.Status:
+		return false
+	case len(req.Types) > 0 && !slices.Contains(req.Types, t.Type):
+		return false
+	case !MatchTenant(t, req.Tenants):
+		return false
+	case !req.Created.Contains(t.Ctime), !req.Runat.Contains(t.Runat):
+		return false
+	case req.After != nil && !req.After.Precedes(t):
+		return false
+	}
+	return true
+}
+
+// ExpiresAt returns the moment a terminal ticket becomes eligible for expiration.
+func ExpiresAt(t lymbo.Ticket, retention map[status.Status]time.Duration) time.Time {
+	d, ok := retention[t.Status]
+	if !ok {
+		return t.Runat
+	}
+	if t.Mtime != nil {
+		return t.Mtime.Add(d)
+	}
+	return t.Ctime.Add(d)
+}
+
+// Archive passes the expired tickets about to be removed to req.Archive, if set.
+func Archive(ctx context.Context, req lymbo.ExpireRequest, tickets []lymbo.Ticket) error {
+	if req.Archive == nil || len(tickets) == 0 {
+		return nil
+	}
+	return req.Archive.Archive(ctx, tickets)
+}
+
+// record is the serialized form of a ticket.
+// Payload and Result are kept as raw JSON, and read back as []byte,
+// the same way JSONB columns are returned by the Postgres store.
+type record struct {
+	ID          lymbo.TicketId    `json:"id"`
+	Status      status.Status     `json:"status"`
+	Runat       time.Time         `json:"runat"`
+	Deadline    *time.Time        `json:"deadline,omitempty"`
+	Nice        int               `json:"nice"`
+	Type        string            `json:"type"`
+	Queue       string            `json:"queue,omitempty"`
+	TenantID    string            `json:"tenant_id,omitempty"`
+	UniqueKey   string            `json:"unique_key,omitempty"`
+	Ctime       time.Time         `json:"ctime"`
+	Mtime       *time.Time        `json:"mtime,omitempty"`
+	Attempts    int               `json:"attempts"`
+	Lease       string            `json:"lease,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Payload     json.RawMessage   `json:"payload,omitempty"`
+	ErrorReason *lymbo.ErrorInfo  `json:"error_reason,omitempty"`
+	Result      json.RawMessage   `json:"result,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	AttemptLog  []lymbo.Attempt   `json:"attempt_log,omitempty"`
+	DependsOn   []lymbo.TicketId  `json:"depends_on,omitempty"`
+}
+
+// Marshal serializes a ticket for storage.
+func Marshal(t lymbo.Ticket) ([]byte, error) {
+	rec := record{
+		ID:          t.ID,
+		Status:      t.Status,
+		Runat:       t.Runat,
+		Deadline:    t.Deadline,
+		Nice:        t.Nice,
+		Type:        t.Type,
+		Queue:       t.Queue,
+		TenantID:    t.TenantID,
+		UniqueKey:   t.UniqueKey,
+		Ctime:       t.Ctime,
+		Mtime:       t.Mtime,
+		Attempts:    t.Attempts,
+		Lease:       t.Lease,
+		Owner:       t.Owner,
+		ErrorReason: t.ErrorReason,
+		Labels:      t.Labels,
+		Metadata:    t.Metadata,
+		AttemptLog:  t.AttemptLog,
+		DependsOn:   t.DependsOn,
+	}
+
+	var err error
+	if rec.Payload, err = rawJSON(t.Payload); err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	if rec.Result, err = rawJSON(t.Result); err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return json.Marshal(rec)
+}
+
+// Unmarshal deserializes a ticket produced by Marshal.
+func Unmarshal(data []byte) (lymbo.Ticket, error) {
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return lymbo.Ticket{}, err
+	}
+
+	t := lymbo.Ticket{
+		ID:          rec.ID,
+		Status:      rec.Status,
+		Runat:       rec.Runat,
+		Deadline:    rec.Deadline,
+		Nice:        rec.Nice,
+		Type:        rec.Type,
+		Queue:       rec.Queue,
+		TenantID:    rec.TenantID,
+		UniqueKey:   rec.UniqueKey,
+		Ctime:       rec.Ctime,
+		Mtime:       rec.Mtime,
+		Attempts:    rec.Attempts,
+		Lease:       rec.Lease,
+		Owner:       rec.Owner,
+		ErrorReason: rec.ErrorReason,
+		Labels:      rec.Labels,
+		Metadata:    rec.Metadata,
+		AttemptLog:  rec.AttemptLog,
+		DependsOn:   rec.DependsOn,
+	}
+	if rec.Payload != nil {
+		t.Payload = []byte(rec.Payload)
+	}
+	if rec.Result != nil {
+		t.Result = []byte(rec.Result)
+	}
+	return t, nil
+}
+
+func rawJSON(v any) (json.RawMessage, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		// already raw JSON, as read back by Unmarshal
+		if json.Valid(v) {
+			return v, nil
+		}
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,215 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+)
+
+// ChanStore is a Store pushing the tickets becoming pending to the Kharons
+// running on it over Go channels, see lymbo.Notifier, so that they are
+// handled as soon as they are due rather than at the next poll, e.g. in unit
+// tests and single-process pipelines. Like Store, it keeps nothing once the
+// process exits. Kharons still poll no more often than their
+// WithMinReactionDelay: lower it for the lowest latency.
+type ChanStore struct {
+	*Store
+
+	mu   sync.Mutex
+	subs map[chan time.Time]struct{}
+}
+
+var (
+	_ lymbo.Store    = (*ChanStore)(nil)
+	_ lymbo.Notifier = (*ChanStore)(nil)
+)
+
+// NewChanStore creates a new in-memory ticket store dispatching tickets to
+// the Kharons running on it as soon as they are put.
+func NewChanStore() *ChanStore {
+	return &ChanStore{
+		Store: NewStore(),
+		subs:  make(map[chan time.Time]struct{}),
+	}
+}
+
+// WithClock sets the Clock telling the time of the tickets put or updated,
+// see Store.WithClock.
+func (c *ChanStore) WithClock(clock lymbo.Clock) *ChanStore {
+	c.Store.WithClock(clock)
+	return c
+}
+
+// Listen implements lymbo.Notifier, receiving the Runat of the tickets
+// becoming pending over a channel of its own until ctx is cancelled.
+func (c *ChanStore) Listen(ctx context.Context, notify func(runat time.Time)) error {
+	ch := make(chan time.Time, 1)
+	c.mu.Lock()
+	c.subs[ch] = struct{}{}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.subs, ch)
+		c.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case runat := <-ch:
+			notify(runat)
+		}
+	}
+}
+
+// publish sends runat to every listener. A listener yet to receive an
+// earlier notification keeps the earliest of both.
+func (c *ChanStore) publish(runat time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ch := range c.subs {
+		select {
+		case ch <- runat:
+		case prev := <-ch:
+			if prev.Before(runat) {
+				runat = prev
+			}
+			ch <- runat
+		}
+	}
+}
+
+// publishPending publishes the Runat of the tickets ids that are pending.
+func (c *ChanStore) publishPending(ids ...lymbo.TicketId) {
+	var first time.Time
+	var found bool
+	c.Store.mu.RLock()
+	for _, id := range ids {
+		t, ok := c.Store.data[id]
+		if ok && t.Status == status.Pending && (!found || t.Runat.Before(first)) {
+			first, found = t.Runat, true
+		}
+	}
+	c.Store.mu.RUnlock()
+	if found {
+		c.publish(first)
+	}
+}
+
+// Put adds a new ticket to the store, publishing it.
+func (c *ChanStore) Put(ctx context.Context, t lymbo.Ticket) error {
+	if err := c.Store.Put(ctx, t); err != nil {
+		return err
+	}
+	c.publishPending(t.ID)
+	return nil
+}
+
+// PutBatch puts the tickets as Store does, publishing those put.
+func (c *ChanStore) PutBatch(ctx context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs, err := c.Store.PutBatch(ctx, tickets)
+	if err != nil {
+		return errs, err
+	}
+	ids := make([]lymbo.TicketId, 0, len(tickets))
+	for i, t := range tickets {
+		if errs == nil || errs[i] == nil {
+			ids = append(ids, t.ID)
+		}
+	}
+	c.publishPending(ids...)
+	return errs, nil
+}
+
+// Update modifies a ticket as Store does, publishing it if still pending.
+func (c *ChanStore) Update(ctx context.Context, tid lymbo.TicketId, fn lymbo.UpdateFunc) error {
+	if err := c.Store.Update(ctx, tid, fn); err != nil {
+		return err
+	}
+	c.publishPending(tid)
+	return nil
+}
+
+// UpdateSet applies us as Store does, publishing the ticket if still pending.
+func (c *ChanStore) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
+	if err := c.Store.UpdateSet(ctx, us); err != nil {
+		return err
+	}
+	c.publishPending(us.Id)
+	return nil
+}
+
+// UpdateBatch applies the updates as Store does, publishing the tickets still pending.
+func (c *ChanStore) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
+	if err := c.Store.UpdateBatch(ctx, updates); err != nil {
+		return err
+	}
+	ids := make([]lymbo.TicketId, len(updates))
+	for i, us := range updates {
+		ids[i] = us.Id
+	}
+	c.publishPending(ids...)
+	return nil
+}
+
+// Settle applies s as Store does, publishing the follow-up tickets.
+func (c *ChanStore) Settle(ctx context.Context, s lymbo.Settlement) error {
+	if err := c.Store.Settle(ctx, s); err != nil {
+		return err
+	}
+	ids := []lymbo.TicketId{s.Update.Id}
+	for _, t := range s.Next {
+		ids = append(ids, t.ID)
+	}
+	c.publishPending(ids...)
+	return nil
+}
+
+// Reschedule updates the runat of a pending ticket, publishing it.
+func (c *ChanStore) Reschedule(ctx context.Context, id lymbo.TicketId, runat time.Time) error {
+	if err := c.Store.Reschedule(ctx, id, runat); err != nil {
+		return err
+	}
+	c.publish(runat)
+	return nil
+}
+
+// ReleaseOwned makes the in-flight tickets of owner due at now, publishing them.
+func (c *ChanStore) ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error) {
+	n, err := c.Store.ReleaseOwned(ctx, owner, now)
+	if n > 0 {
+		c.publish(now)
+	}
+	return n, err
+}
+
+// ReleaseDependents unblocks the tickets waiting for id, publishing them.
+func (c *ChanStore) ReleaseDependents(ctx context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	n, err := c.Store.ReleaseDependents(ctx, id, now)
+	if n > 0 {
+		c.publish(now)
+	}
+	return n, err
+}
+
+// RetryFailed makes the selected failed tickets pending, publishing them.
+func (c *ChanStore) RetryFailed(ctx context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	n, err := c.Store.RetryFailed(ctx, req)
+	if n > 0 {
+		c.publish(req.Now)
+	}
+	return n, err
+}
+
+// Resume makes PollPending claim the tickets of typ again, publishing now.
+func (c *ChanStore) Resume(ctx context.Context, typ string) error {
+	if err := c.Store.Resume(ctx, typ); err != nil {
+		return err
+	}
+	c.publish(c.clock.Now())
+	return nil
+}
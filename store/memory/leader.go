@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ochaton/lymbo"
+)
+
+// Election is an in-process stand-in for the leader election of a fleet,
+// e.g. to test several Kharons sharing a Store: of its candidates, the first
+// one asking leads until it resigns, the next one asking then taking over.
+type Election struct {
+	mu     sync.Mutex
+	leader *Candidate
+}
+
+// NewElection returns an election without candidates.
+func NewElection() *Election {
+	return &Election{}
+}
+
+// Candidate is a lymbo.Leader taking part in an Election.
+type Candidate struct {
+	e *Election
+}
+
+var _ lymbo.Leader = (*Candidate)(nil)
+
+// Candidate returns a new candidate of the election.
+func (e *Election) Candidate() *Candidate {
+	return &Candidate{e: e}
+}
+
+// Elected makes c the leader if there is none, and reports whether it is.
+func (c *Candidate) Elected(context.Context) (bool, error) {
+	c.e.mu.Lock()
+	defer c.e.mu.Unlock()
+	if c.e.leader == nil {
+		c.e.leader = c
+	}
+	return c.e.leader == c, nil
+}
+
+// Resign gives up the leadership of c, if it leads.
+func (c *Candidate) Resign() {
+	c.e.mu.Lock()
+	defer c.e.mu.Unlock()
+	if c.e.leader == c {
+		c.e.leader = nil
+	}
+}
@@ -2,19 +2,29 @@ package memory
 
 import (
 	"context"
-	"math"
-	"sort"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/ochaton/lymbo"
 	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/internal/storeutil"
 )
 
 // Store is an in-memory implementation of the lymbo.Store interface.
 type Store struct {
 	mu   sync.RWMutex
 	data map[lymbo.TicketId]lymbo.Ticket
+
+	// unique indexes the pending tickets with a UniqueKey.
+	unique map[storeutil.UniqueKey]lymbo.TicketId
+
+	// paused are the paused types.
+	paused map[string]struct{}
+
+	// clock tells the time of the tickets put or updated, see WithClock.
+	clock lymbo.Clock
 }
 
 // Ensure Store implements lymbo.Store interface.
@@ -23,10 +33,21 @@ var _ lymbo.Store = (*Store)(nil)
 // NewStore creates a new in-memory ticket store.
 func NewStore() *Store {
 	return &Store{
-		data: make(map[lymbo.TicketId]lymbo.Ticket),
+		data:   make(map[lymbo.TicketId]lymbo.Ticket),
+		unique: make(map[storeutil.UniqueKey]lymbo.TicketId),
+		paused: make(map[string]struct{}),
+		clock:  lymbo.SystemClock,
 	}
 }
 
+// WithClock sets the Clock telling the time of the tickets put or updated,
+// e.g. the lymbo.FakeClock of the Kharon, see lymbo.Settings.WithClock.
+// Polls and expiration take the time of the Kharon.
+func (m *Store) WithClock(c lymbo.Clock) *Store {
+	m.clock = c
+	return m
+}
+
 // Get retrieves a ticket by ID.
 func (m *Store) Get(_ context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
 	m.mu.RLock()
@@ -39,6 +60,24 @@ func (m *Store) Get(_ context.Context, id lymbo.TicketId) (lymbo.Ticket, error)
 	return ticket, nil
 }
 
+// Exists reports whether a ticket with the given ID is in the store.
+func (m *Store) Exists(_ context.Context, id lymbo.TicketId) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, exists := m.data[id]
+	return exists, nil
+}
+
+// GetResult returns the Result of a settled ticket.
+func (m *Store) GetResult(ctx context.Context, id lymbo.TicketId) (any, error) {
+	t, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.Result(t)
+}
+
 // Put adds a new ticket to the store.
 func (m *Store) Put(_ context.Context, t lymbo.Ticket) error {
 	if t.ID == "" {
@@ -48,18 +87,98 @@ func (m *Store) Put(_ context.Context, t lymbo.Ticket) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	t.Status = status.Pending
-	m.data[t.ID] = t
+	return m.put(t, m.clock.Now())
+}
 
+// PutBatch puts all the tickets under a single lock.
+func (m *Store) PutBatch(_ context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs := storeutil.NewBatchErrors(len(tickets))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	for i, t := range tickets {
+		if t.ID == "" {
+			errs.Set(i, lymbo.ErrTicketIDEmpty)
+			continue
+		}
+		if err := m.put(t, now); err != nil {
+			errs.Set(i, err)
+		}
+	}
+	return errs.Errs(), nil
+}
+
+func (m *Store) put(t lymbo.Ticket, now time.Time) error {
+	storeutil.Defaults(&t, now)
+	if m.duplicate(t) {
+		return lymbo.ErrDuplicateTicket
+	}
+	// don't share the maps with the caller
+	t.Labels = maps.Clone(t.Labels)
+	t.Metadata = maps.Clone(t.Metadata)
+	t.DependsOn = slices.Clone(t.DependsOn)
+	m.set(t)
 	return nil
 }
 
+// duplicate reports whether another pending ticket holds the UniqueKey of t.
+func (m *Store) duplicate(t lymbo.Ticket) bool {
+	u, ok := storeutil.Unique(t)
+	if !ok {
+		return false
+	}
+	owner, held := m.unique[u]
+	return held && owner != t.ID
+}
+
+// duplicates reports whether putting tickets in turn would fail with ErrDuplicateTicket.
+func (m *Store) duplicates(tickets []lymbo.Ticket, now time.Time) bool {
+	held := make(map[storeutil.UniqueKey]lymbo.TicketId)
+	for _, t := range tickets {
+		storeutil.Defaults(&t, now)
+		u, ok := storeutil.Unique(t)
+		if !ok {
+			continue
+		}
+		if owner, dup := held[u]; (dup && owner != t.ID) || m.duplicate(t) {
+			return true
+		}
+		held[u] = t.ID
+	}
+	return false
+}
+
+// set stores t, keeping the unique index up to date.
+func (m *Store) set(t lymbo.Ticket) {
+	m.unindex(t.ID)
+	m.data[t.ID] = t
+	if u, ok := storeutil.Unique(t); ok {
+		if _, held := m.unique[u]; !held {
+			m.unique[u] = t.ID
+		}
+	}
+}
+
+// remove deletes the ticket id, if any.
+func (m *Store) remove(id lymbo.TicketId) {
+	m.unindex(id)
+	delete(m.data, id)
+}
+
+// unindex releases the UniqueKey held by the stored ticket id, if any.
+func (m *Store) unindex(id lymbo.TicketId) {
+	if u, ok := storeutil.Unique(m.data[id]); ok && m.unique[u] == id {
+		delete(m.unique, u)
+	}
+}
+
 // Delete removes a ticket from the store.
 func (m *Store) Delete(_ context.Context, id lymbo.TicketId) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.data, id)
+	m.remove(id)
 	return nil
 }
 
@@ -68,26 +187,11 @@ func (m *Store) DeleteBatch(_ context.Context, ids []lymbo.TicketId) error {
 	defer m.mu.Unlock()
 
 	for _, id := range ids {
-		delete(m.data, id)
+		m.remove(id)
 	}
 	return nil
 }
 
-func updateOne(t *lymbo.Ticket, us lymbo.UpdateSet) {
-	if us.Nice != nil {
-		t.Nice = *us.Nice
-	}
-	if us.Runat != nil {
-		t.Runat = *us.Runat
-	}
-	if us.Payload != nil {
-		t.Payload = us.Payload
-	}
-	if us.ErrorReason != nil {
-		t.ErrorReason = us.ErrorReason
-	}
-}
-
 func (m *Store) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -97,9 +201,12 @@ func (m *Store) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) erro
 		if !exists {
 			return lymbo.ErrTicketNotFound
 		}
+		if err := storeutil.CheckLease(t, us); err != nil {
+			return err
+		}
 
-		updateOne(&t, us)
-		m.data[t.ID] = t
+		storeutil.Apply(&t, us, m.clock.Now())
+		m.set(t)
 	}
 
 	return nil
@@ -117,8 +224,11 @@ func (m *Store) Update(ctx context.Context, tid lymbo.TicketId, fn lymbo.UpdateF
 	if err := fn(ctx, &t); err != nil {
 		return err
 	}
+	if m.duplicate(t) {
+		return lymbo.ErrDuplicateTicket
+	}
 
-	m.data[tid] = t
+	m.set(t)
 	return nil
 }
 
@@ -126,25 +236,80 @@ func (m *Store) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// exponential backoff support
-	if us.Backoff != nil {
-		delay := time.Duration(math.Pow(us.Backoff.Base, float64(m.data[us.Id].Attempts)))
-		delay = min(delay, us.Backoff.MaxDelay)
-		delay += us.Backoff.Jitter
-		newRunat := time.Now().Add(delay)
-		us.Runat = &newRunat
+	t, exists := m.data[us.Id]
+	if !exists {
+		return lymbo.ErrTicketNotFound
+	}
+	if err := storeutil.CheckLease(t, us); err != nil {
+		return err
 	}
 
-	t, exists := m.data[us.Id]
+	storeutil.Apply(&t, us, m.clock.Now())
+	m.set(t)
+	return nil
+}
+
+// Settle applies the outcome and puts the follow-up tickets under a single lock.
+func (m *Store) Settle(ctx context.Context, s lymbo.Settlement) error {
+	for _, next := range s.Next {
+		if next.ID == "" {
+			return lymbo.ErrTicketIDEmpty
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, exists := m.data[s.Update.Id]
 	if !exists {
 		return lymbo.ErrTicketNotFound
 	}
+	now := m.clock.Now()
+	prev := t
+	if err := storeutil.Settle(ctx, &t, s, now); err != nil {
+		return err
+	}
 
-	updateOne(&t, us)
-	m.data[us.Id] = t
+	if s.Delete {
+		m.remove(t.ID)
+	} else {
+		m.set(t)
+	}
+	if m.duplicates(s.Next, now) {
+		m.set(prev)
+		return lymbo.ErrDuplicateTicket
+	}
+	for _, next := range s.Next {
+		m.put(next, now)
+	}
 	return nil
 }
 
+// Reschedule updates the runat of a pending ticket.
+func (m *Store) Reschedule(_ context.Context, id lymbo.TicketId, runat time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, exists := m.data[id]
+	if !exists {
+		return lymbo.ErrTicketNotFound
+	}
+	if t.Status != status.Pending {
+		return lymbo.ErrInvalidStatusTransition
+	}
+
+	now := m.clock.Now()
+	t.Runat = runat
+	t.Mtime = &now
+	m.set(t)
+	return nil
+}
+
+// Touch reschedules the ticket to now + extendBy, see Reschedule.
+func (m *Store) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	return m.Reschedule(ctx, id, m.clock.Now().Add(extendBy))
+}
+
 // PollPending retrieves pending tickets ready for processing.
 // It returns up to limit tickets that are ready to run, sorted by priority.
 func (m *Store) PollPending(_ context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
@@ -155,67 +320,172 @@ func (m *Store) PollPending(_ context.Context, req lymbo.PollRequest) (lymbo.Pol
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	var closest *time.Time
-	var ready []lymbo.Ticket
-
-	for _, t := range m.data {
-		if t.Status != status.Pending {
-			continue
-		}
-
-		if t.Runat.After(req.Now) {
-			if closest == nil || t.Runat.Before(*closest) {
-				runat := t.Runat
-				closest = &runat
-			}
-			continue
-		}
-
-		ready = append(ready, t)
-	}
-
+	paused := slices.Collect(maps.Keys(m.paused))
+	ready, sleepUntil := storeutil.Select(storeutil.Unpaused(maps.Values(m.data), paused), req)
 	if len(ready) == 0 {
 		return lymbo.PollResult{
 			Tickets:    nil,
-			SleepUntil: closest,
+			SleepUntil: sleepUntil,
 		}, nil
 	}
 
-	// Sort by runat time, then by priority (nice value).
-	sort.Slice(ready, func(i, j int) bool {
-		if ready[i].Runat.Equal(ready[j].Runat) {
-			return ready[i].Nice < ready[j].Nice
-		}
-		return ready[i].Runat.Before(ready[j].Runat)
-	})
+	ready, stale := storeutil.CatchUp(ready, req)
+	for _, t := range stale {
+		storeutil.Drop(&t, req.Now)
+		m.set(t)
+	}
 
 	ready = ready[:min(req.Limit, len(ready))]
 
 	// Update tickets with exponential backoff for next attempt.
-	for _, t := range ready {
-		delay := time.Duration(math.Pow(req.BackoffBase, float64(t.Attempts)))
-		delay = min(delay, req.MaxBackoffDelay)
-		delay += req.TTR
-		t.Runat = req.Now.Add(delay)
-		t.Attempts++
-		m.data[t.ID] = t
+	due := make([]time.Time, len(ready))
+	for i := range ready {
+		due[i] = storeutil.Claim(&ready[i], req)
+		m.set(ready[i])
 	}
 
 	return lymbo.PollResult{
 		Tickets:    ready,
 		SleepUntil: nil,
+		Dropped:    len(stale),
+		Due:        due,
 	}, nil
 }
 
+// Pause makes PollPending skip the tickets of typ.
+func (m *Store) Pause(_ context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.paused[typ] = struct{}{}
+	return nil
+}
+
+// Resume makes PollPending claim the tickets of typ again.
+func (m *Store) Resume(_ context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.paused, typ)
+	return nil
+}
+
+// Paused returns the paused types, sorted.
+func (m *Store) Paused(_ context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return slices.Sorted(maps.Keys(m.paused)), nil
+}
+
+// ListInFlight returns the pending tickets leased by a poller.
+func (m *Store) ListInFlight(_ context.Context, now time.Time) ([]lymbo.Ticket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tickets []lymbo.Ticket
+	for _, t := range m.data {
+		if storeutil.InFlight(t, now) {
+			tickets = append(tickets, t)
+		}
+	}
+	return tickets, nil
+}
+
+// ReleaseOwned makes the in-flight tickets of owner due at now again.
+func (m *Store) ReleaseOwned(_ context.Context, owner string, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, t := range m.data {
+		if storeutil.Owned(t, owner, now) {
+			storeutil.Release(&t, now)
+			m.set(t)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ReleaseDependents unblocks the tickets waiting for id under a single lock.
+func (m *Store) ReleaseDependents(_ context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, t := range m.data {
+		if storeutil.Waits(t, id) {
+			storeutil.Unblock(&t, id, now)
+			m.set(t)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// RetryFailed makes the selected failed tickets pending under a single lock.
+func (m *Store) RetryFailed(_ context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, t := range m.data {
+		if !storeutil.Retried(t, req) {
+			continue
+		}
+		storeutil.Requeue(&t, req)
+		if m.duplicate(t) {
+			continue
+		}
+		m.set(t)
+		n++
+	}
+	return n, nil
+}
+
+// ListOverdue returns the pending tickets past their deadline under the read lock.
+func (m *Store) ListOverdue(_ context.Context, now time.Time, limit int) ([]lymbo.Ticket, error) {
+	if limit <= 0 {
+		return nil, lymbo.ErrLimitInvalid
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return storeutil.ListOverdue(maps.Values(m.data), now, limit), nil
+}
+
+// List returns the selected tickets under the read lock.
+func (m *Store) List(_ context.Context, req lymbo.ListRequest) ([]lymbo.Ticket, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return storeutil.List(maps.Values(m.data), req), nil
+}
+
+// Vacuum scans every ticket under the read lock.
+func (m *Store) Vacuum(_ context.Context, req lymbo.VacuumRequest) (lymbo.VacuumReport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return storeutil.Vacuum(maps.Values(m.data), req), nil
+}
+
 // ExpireTickets removes expired non-pending tickets from the store.
-// It deletes up to limit tickets that have expired (runat is before now).
-func (m *Store) ExpireTickets(_ context.Context, limit int, now time.Time) (int64, error) {
+// It deletes up to limit tickets whose retention has elapsed.
+func (m *Store) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	count := 0
-	for tid, t := range m.data {
-		if count == limit {
+	var expired []lymbo.Ticket
+	for _, t := range m.data {
+		if len(expired) == req.Limit {
 			break
 		}
 
@@ -223,13 +493,18 @@ func (m *Store) ExpireTickets(_ context.Context, limit int, now time.Time) (int6
 			continue
 		}
 
-		if t.Runat.After(now) {
+		if storeutil.ExpiresAt(t, req.Retention).After(req.Now) {
 			continue
 		}
 
-		delete(m.data, tid)
-		count++
+		expired = append(expired, t)
 	}
 
-	return int64(count), nil
+	if err := storeutil.Archive(ctx, req, expired); err != nil {
+		return 0, err
+	}
+	for _, t := range expired {
+		m.remove(t.ID)
+	}
+	return int64(len(expired)), nil
 }
@@ -0,0 +1,55 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/store/memory"
+	"github.com/ochaton/lymbo/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func() lymbo.Store {
+		return memory.NewStore()
+	})
+}
+
+func TestChanStore(t *testing.T) {
+	storetest.TestStore(t, func() lymbo.Store {
+		return memory.NewChanStore()
+	})
+}
+
+func BenchmarkPollPending(b *testing.B) {
+	now := time.Now()
+	for _, bc := range []struct {
+		name  string
+		runat time.Time
+	}{
+		// every ticket is claimed again by the next polls, as they're due once claimed
+		{"ready", now},
+		{"idle", now.Add(time.Hour)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			s := memory.NewStore()
+			for range 1000 {
+				t, _ := lymbo.NewTicket(lymbo.TicketId(uuid.NewString()), "bench")
+				t.Runat = bc.runat
+				if err := s.Put(context.Background(), *t); err != nil {
+					b.Fatal(err)
+				}
+			}
+			req := lymbo.PollRequest{Limit: 10, Now: now, Backoff: lymbo.ConstantBackoff(0)}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := s.PollPending(context.Background(), req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
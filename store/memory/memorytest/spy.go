@@ -0,0 +1,378 @@
+// Package memorytest provides an in-memory store for unit-testing handlers.
+package memorytest
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/memory"
+)
+
+// Call is a store method call recorded by SpyStore.
+type Call struct {
+	// Method is the name of the lymbo.Store method, e.g. "UpdateBatch".
+	Method string
+
+	// Args are the arguments following the context.
+	// For Update, the only argument is the ticket as left by the UpdateFunc.
+	Args []any
+
+	// Err is the error returned by the call.
+	Err error
+}
+
+// SpyStore is a memory.Store recording every call made to it, for
+// asserting on what handlers did in unit tests:
+//
+//	store := memorytest.NewSpyStore()
+//	kh := lymbo.NewKharon(store, settings, logger)
+//	// ... run the handler, stop kh to flush pending outcomes
+//	store.AssertAcked(t, tid)
+//	store.AssertFailCount(t, 0)
+//
+// Outcomes are written by Kharon in batches, so assert after Run returns.
+// Safe for concurrent use.
+type SpyStore struct {
+	mu    sync.Mutex
+	store *memory.Store
+	calls []Call
+}
+
+// Ensure SpyStore implements lymbo.Store interface.
+var _ lymbo.Store = (*SpyStore)(nil)
+
+// NewSpyStore creates an empty spy store.
+func NewSpyStore() *SpyStore {
+	return &SpyStore{store: memory.NewStore()}
+}
+
+func (s *SpyStore) backend() *memory.Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store
+}
+
+func (s *SpyStore) record(method string, err error, args ...any) {
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Method: method, Args: args, Err: err})
+	s.mu.Unlock()
+}
+
+// Calls returns a copy of the recorded calls, in call order.
+func (s *SpyStore) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.calls)
+}
+
+// Reset forgets the recorded calls and drops all tickets.
+func (s *SpyStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+	s.store = memory.NewStore()
+}
+
+func (s *SpyStore) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
+	t, err := s.backend().Get(ctx, id)
+	s.record("Get", err, id)
+	return t, err
+}
+
+func (s *SpyStore) Exists(ctx context.Context, id lymbo.TicketId) (bool, error) {
+	ok, err := s.backend().Exists(ctx, id)
+	s.record("Exists", err, id)
+	return ok, err
+}
+
+func (s *SpyStore) GetResult(ctx context.Context, id lymbo.TicketId) (any, error) {
+	result, err := s.backend().GetResult(ctx, id)
+	s.record("GetResult", err, id)
+	return result, err
+}
+
+func (s *SpyStore) Put(ctx context.Context, t lymbo.Ticket) error {
+	err := s.backend().Put(ctx, t)
+	s.record("Put", err, t)
+	return err
+}
+
+func (s *SpyStore) PutBatch(ctx context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs, err := s.backend().PutBatch(ctx, tickets)
+	s.record("PutBatch", err, slices.Clone(tickets))
+	return errs, err
+}
+
+func (s *SpyStore) Delete(ctx context.Context, id lymbo.TicketId) error {
+	err := s.backend().Delete(ctx, id)
+	s.record("Delete", err, id)
+	return err
+}
+
+func (s *SpyStore) DeleteBatch(ctx context.Context, ids []lymbo.TicketId) error {
+	err := s.backend().DeleteBatch(ctx, ids)
+	s.record("DeleteBatch", err, slices.Clone(ids))
+	return err
+}
+
+func (s *SpyStore) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.UpdateFunc) error {
+	var updated lymbo.Ticket
+	err := s.backend().Update(ctx, id, func(ctx context.Context, t *lymbo.Ticket) error {
+		if err := fn(ctx, t); err != nil {
+			return err
+		}
+		updated = *t
+		return nil
+	})
+	s.record("Update", err, updated)
+	return err
+}
+
+func (s *SpyStore) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
+	err := s.backend().UpdateSet(ctx, us)
+	s.record("UpdateSet", err, us)
+	return err
+}
+
+func (s *SpyStore) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
+	err := s.backend().UpdateBatch(ctx, updates)
+	s.record("UpdateBatch", err, slices.Clone(updates))
+	return err
+}
+
+// Settle records the Settlement, and the resulting ticket if Func is set.
+func (s *SpyStore) Settle(ctx context.Context, st lymbo.Settlement) error {
+	var updated lymbo.Ticket
+	if fn := st.Func; fn != nil {
+		st.Func = func(ctx context.Context, t *lymbo.Ticket) error {
+			if err := fn(ctx, t); err != nil {
+				return err
+			}
+			updated = *t
+			return nil
+		}
+	}
+	err := s.backend().Settle(ctx, st)
+	s.record("Settle", err, st, updated)
+	return err
+}
+
+func (s *SpyStore) Reschedule(ctx context.Context, id lymbo.TicketId, runat time.Time) error {
+	err := s.backend().Reschedule(ctx, id, runat)
+	s.record("Reschedule", err, id, runat)
+	return err
+}
+
+func (s *SpyStore) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	err := s.backend().Touch(ctx, id, extendBy)
+	s.record("Touch", err, id, extendBy)
+	return err
+}
+
+func (s *SpyStore) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
+	res, err := s.backend().PollPending(ctx, req)
+	s.record("PollPending", err, req)
+	return res, err
+}
+
+func (s *SpyStore) Pause(ctx context.Context, typ string) error {
+	err := s.backend().Pause(ctx, typ)
+	s.record("Pause", err, typ)
+	return err
+}
+
+func (s *SpyStore) Resume(ctx context.Context, typ string) error {
+	err := s.backend().Resume(ctx, typ)
+	s.record("Resume", err, typ)
+	return err
+}
+
+func (s *SpyStore) Paused(ctx context.Context) ([]string, error) {
+	types, err := s.backend().Paused(ctx)
+	s.record("Paused", err)
+	return types, err
+}
+
+func (s *SpyStore) ListInFlight(ctx context.Context, now time.Time) ([]lymbo.Ticket, error) {
+	tickets, err := s.backend().ListInFlight(ctx, now)
+	s.record("ListInFlight", err, now)
+	return tickets, err
+}
+
+func (s *SpyStore) ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error) {
+	n, err := s.backend().ReleaseOwned(ctx, owner, now)
+	s.record("ReleaseOwned", err, owner, now)
+	return n, err
+}
+
+func (s *SpyStore) ReleaseDependents(ctx context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	n, err := s.backend().ReleaseDependents(ctx, id, now)
+	s.record("ReleaseDependents", err, id, now)
+	return n, err
+}
+
+func (s *SpyStore) RetryFailed(ctx context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	n, err := s.backend().RetryFailed(ctx, req)
+	s.record("RetryFailed", err, req)
+	return n, err
+}
+
+func (s *SpyStore) ListOverdue(ctx context.Context, now time.Time, limit int) ([]lymbo.Ticket, error) {
+	tickets, err := s.backend().ListOverdue(ctx, now, limit)
+	s.record("ListOverdue", err, now, limit)
+	return tickets, err
+}
+
+func (s *SpyStore) List(ctx context.Context, req lymbo.ListRequest) ([]lymbo.Ticket, error) {
+	tickets, err := s.backend().List(ctx, req)
+	s.record("List", err, req)
+	return tickets, err
+}
+
+func (s *SpyStore) Vacuum(ctx context.Context, req lymbo.VacuumRequest) (lymbo.VacuumReport, error) {
+	report, err := s.backend().Vacuum(ctx, req)
+	s.record("Vacuum", err, req)
+	return report, err
+}
+
+func (s *SpyStore) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
+	n, err := s.backend().ExpireTickets(ctx, req)
+	s.record("ExpireTickets", err, req)
+	return n, err
+}
+
+// outcome is a successful write settling a ticket, flattened out of batches.
+type outcome struct {
+	id      lymbo.TicketId
+	deleted bool
+	status  *status.Status
+	reason  *lymbo.ErrorInfo
+}
+
+func (s *SpyStore) outcomes() []outcome {
+	var out []outcome
+	for _, c := range s.Calls() {
+		if c.Err != nil {
+			continue
+		}
+		switch c.Method {
+		case "Delete":
+			out = append(out, outcome{id: c.Args[0].(lymbo.TicketId), deleted: true})
+		case "DeleteBatch":
+			for _, id := range c.Args[0].([]lymbo.TicketId) {
+				out = append(out, outcome{id: id, deleted: true})
+			}
+		case "Update":
+			t := c.Args[0].(lymbo.Ticket)
+			out = append(out, outcome{id: t.ID, status: &t.Status, reason: t.ErrorReason})
+		case "UpdateSet":
+			us := c.Args[0].(lymbo.UpdateSet)
+			out = append(out, outcome{id: us.Id, status: us.Status, reason: us.ErrorReason})
+		case "Settle":
+			st := c.Args[0].(lymbo.Settlement)
+			switch {
+			case st.Delete:
+				out = append(out, outcome{id: st.Update.Id, deleted: true})
+			case st.Func != nil:
+				t := c.Args[1].(lymbo.Ticket)
+				out = append(out, outcome{id: t.ID, status: &t.Status, reason: t.ErrorReason})
+			default:
+				us := st.Update
+				out = append(out, outcome{id: us.Id, status: us.Status, reason: us.ErrorReason})
+			}
+		case "UpdateBatch":
+			for _, us := range c.Args[0].([]lymbo.UpdateSet) {
+				out = append(out, outcome{id: us.Id, status: us.Status, reason: us.ErrorReason})
+			}
+		}
+	}
+	return out
+}
+
+// CallCount returns the number of recorded calls of the given method.
+func (s *SpyStore) CallCount(method string) int {
+	n := 0
+	for _, c := range s.Calls() {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// AssertAcked checks that the ticket was acked: deleted, or marked done.
+func (s *SpyStore) AssertAcked(tb testing.TB, id lymbo.TicketId) {
+	tb.Helper()
+	for _, o := range s.outcomes() {
+		if o.id == id && (o.deleted || (o.status != nil && *o.status == status.Done)) {
+			return
+		}
+	}
+	tb.Errorf("ticket %s was not acked", id)
+}
+
+// AssertFailed checks that the ticket was marked failed with the given error reason.
+// A nil reason matches any, a string the Message of the ErrorReason, and an
+// ErrorInfo its Message and Code.
+func (s *SpyStore) AssertFailed(tb testing.TB, id lymbo.TicketId, reason any) {
+	tb.Helper()
+	var got []any
+	for _, o := range s.outcomes() {
+		if o.id != id || o.status == nil || *o.status != status.Failed {
+			continue
+		}
+		if matchReason(o.reason, reason) {
+			return
+		}
+		got = append(got, o.reason)
+	}
+	if len(got) > 0 {
+		tb.Errorf("ticket %s was failed with reasons %v, want %v", id, got, reason)
+		return
+	}
+	tb.Errorf("ticket %s was not failed", id)
+}
+
+// matchReason reports whether got matches the reason given to AssertFailed.
+func matchReason(got *lymbo.ErrorInfo, want any) bool {
+	switch want := want.(type) {
+	case nil:
+		return true
+	case string:
+		return got != nil && got.Message == want
+	case lymbo.ErrorInfo:
+		return got != nil && got.Message == want.Message && got.Code == want.Code
+	case *lymbo.ErrorInfo:
+		return got != nil && want != nil && got.Message == want.Message && got.Code == want.Code
+	default:
+		return reflect.DeepEqual(got, want)
+	}
+}
+
+// AssertFailCount checks the number of times tickets were marked failed.
+func (s *SpyStore) AssertFailCount(tb testing.TB, n int) {
+	tb.Helper()
+	count := 0
+	for _, o := range s.outcomes() {
+		if o.status != nil && *o.status == status.Failed {
+			count++
+		}
+	}
+	if count != n {
+		tb.Errorf("fail count is %d, want %d", count, n)
+	}
+}
+
+// AssertCallCount checks the number of recorded calls of the given method.
+func (s *SpyStore) AssertCallCount(tb testing.TB, method string, n int) {
+	tb.Helper()
+	if count := s.CallCount(method); count != n {
+		tb.Errorf("%s was called %d times, want %d", method, count, n)
+	}
+}
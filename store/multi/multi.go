@@ -0,0 +1,736 @@
+// Package multi provides a lymbo.Store that fans out over several child stores,
+// so a single Kharon can poll them as one logical queue.
+//
+// Usage:
+//
+//	store := multi.NewStore(memory.NewStore(), pgStore)
+//	kh := lymbo.NewKharon(store, settings, logger)
+//
+// New tickets are placed by Config.Route (the first store by default).
+// Every other operation is routed to the child that owns the ticket, and
+// follow-up tickets of Settle go to the child owning the settled one.
+// Unique keys are enforced by each child, so tickets routed to different
+// children may share one.
+package multi
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/internal/storeutil"
+)
+
+// RouteFunc returns the index of the child store a new ticket is put into.
+type RouteFunc func(lymbo.Ticket) int
+
+type Config struct {
+	// Stores are the child stores, polled in turn.
+	Stores []lymbo.Store
+
+	// Route selects the child store for tickets that aren't owned yet.
+	// Defaults to the first store.
+	Route RouteFunc
+}
+
+// Store is a lymbo.Store composed of several child stores.
+type Store struct {
+	stores []lymbo.Store
+	route  RouteFunc
+
+	mu     sync.RWMutex
+	owners map[lymbo.TicketId]int
+	next   int
+}
+
+// Ensure Store implements the lymbo.Store and lymbo.Searcher interfaces.
+var (
+	_ lymbo.Store    = (*Store)(nil)
+	_ lymbo.Searcher = (*Store)(nil)
+)
+
+// NewStore creates a store over the given children; new tickets go to the first one.
+// Panics if no stores are given.
+func NewStore(stores ...lymbo.Store) *Store {
+	s, err := NewStoreWithConfig(Config{Stores: stores})
+	if err != nil {
+		panic("multi: " + err.Error())
+	}
+	return s
+}
+
+func NewStoreWithConfig(cfg Config) (*Store, error) {
+	if len(cfg.Stores) == 0 {
+		return nil, errors.New("at least one store is required")
+	}
+	for _, s := range cfg.Stores {
+		if s == nil {
+			return nil, errors.New("store cannot be nil")
+		}
+	}
+	if cfg.Route == nil {
+		cfg.Route = func(lymbo.Ticket) int { return 0 }
+	}
+
+	return &Store{
+		stores: cfg.Stores,
+		route:  cfg.Route,
+		owners: make(map[lymbo.TicketId]int),
+	}, nil
+}
+
+func (m *Store) remember(id lymbo.TicketId, idx int) {
+	m.mu.Lock()
+	m.owners[id] = idx
+	m.mu.Unlock()
+}
+
+func (m *Store) forget(id lymbo.TicketId) {
+	m.mu.Lock()
+	delete(m.owners, id)
+	m.mu.Unlock()
+}
+
+// track records the child owning a ticket of status st while it is pending,
+// and forgets it otherwise: only pending tickets are tracked, so that the
+// ones removed by ExpireTickets leave no record behind. The tickets a child
+// cancels as stale while polling, see lymbo.CatchUp, keep theirs until deleted.
+func (m *Store) track(id lymbo.TicketId, idx int, st status.Status) {
+	if st == status.Pending || st == (status.Status{}) {
+		m.remember(id, idx)
+	} else {
+		m.forget(id)
+	}
+}
+
+// owner returns the index of the child store holding the ticket.
+// Tickets not tracked are looked up in every child.
+// Returns ErrTicketNotFound if no child has it.
+func (m *Store) owner(ctx context.Context, id lymbo.TicketId) (int, error) {
+	m.mu.RLock()
+	idx, ok := m.owners[id]
+	m.mu.RUnlock()
+	if ok {
+		return idx, nil
+	}
+
+	for i, s := range m.stores {
+		t, err := s.Get(ctx, id)
+		if errors.Is(err, lymbo.ErrTicketNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		m.track(id, i, t.Status)
+		return i, nil
+	}
+	return 0, lymbo.ErrTicketNotFound
+}
+
+// untrack drops the ownership record of tickets leaving the pending state.
+// Terminal tickets are rarely touched again, and are looked up on demand.
+func (m *Store) untrack(us lymbo.UpdateSet) {
+	if us.Status != nil && *us.Status != status.Pending {
+		m.forget(us.Id)
+	}
+}
+
+func (m *Store) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
+	idx, err := m.owner(ctx, id)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	return m.stores[idx].Get(ctx, id)
+}
+
+func (m *Store) GetResult(ctx context.Context, id lymbo.TicketId) (any, error) {
+	idx, err := m.owner(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return m.stores[idx].GetResult(ctx, id)
+}
+
+func (m *Store) Exists(ctx context.Context, id lymbo.TicketId) (bool, error) {
+	_, err := m.owner(ctx, id)
+	if errors.Is(err, lymbo.ErrTicketNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put stores the ticket in the child known to own it, or in the one chosen by Route.
+// Children are not probed, so Route should be deterministic for tickets that
+// may be put again after their ownership record was dropped.
+func (m *Store) Put(ctx context.Context, t lymbo.Ticket) error {
+	m.mu.RLock()
+	idx, ok := m.owners[t.ID]
+	m.mu.RUnlock()
+	if !ok {
+		idx = m.route(t)
+		if idx < 0 || idx >= len(m.stores) {
+			return errors.New("multi: route returned an invalid store index")
+		}
+	}
+
+	if err := m.stores[idx].Put(ctx, t); err != nil {
+		return err
+	}
+	m.track(t.ID, idx, t.Status)
+	return nil
+}
+
+// PutBatch puts every ticket as Put does, with a single PutBatch per child.
+// Children are written independently: the tickets of a child whose batch
+// failed as a whole get its error, the others are put regardless.
+func (m *Store) PutBatch(ctx context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs := storeutil.NewBatchErrors(len(tickets))
+	groups := make([][]int, len(m.stores))
+
+	m.mu.RLock()
+	for i, t := range tickets {
+		idx, ok := m.owners[t.ID]
+		if !ok {
+			idx = m.route(t)
+		}
+		if idx < 0 || idx >= len(m.stores) {
+			errs.Set(i, errors.New("multi: route returned an invalid store index"))
+			continue
+		}
+		groups[idx] = append(groups[idx], i)
+	}
+	m.mu.RUnlock()
+
+	for idx, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		batch := make([]lymbo.Ticket, len(group))
+		for j, i := range group {
+			batch[j] = tickets[i]
+		}
+		childErrs, err := m.stores[idx].PutBatch(ctx, batch)
+		for j, i := range group {
+			switch {
+			case err != nil:
+				errs.Set(i, err)
+			case childErrs != nil && childErrs[j] != nil:
+				errs.Set(i, childErrs[j])
+			default:
+				m.track(tickets[i].ID, idx, tickets[i].Status)
+			}
+		}
+	}
+	return errs.Errs(), nil
+}
+
+func (m *Store) Delete(ctx context.Context, id lymbo.TicketId) error {
+	idx, err := m.owner(ctx, id)
+	if errors.Is(err, lymbo.ErrTicketNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.stores[idx].Delete(ctx, id); err != nil {
+		return err
+	}
+	m.forget(id)
+	return nil
+}
+
+func (m *Store) DeleteBatch(ctx context.Context, ids []lymbo.TicketId) error {
+	groups := make(map[int][]lymbo.TicketId)
+	for _, id := range ids {
+		idx, err := m.owner(ctx, id)
+		if errors.Is(err, lymbo.ErrTicketNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		groups[idx] = append(groups[idx], id)
+	}
+
+	var errs []error
+	for idx, group := range groups {
+		if err := m.stores[idx].DeleteBatch(ctx, group); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, id := range group {
+			m.forget(id)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Store) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.UpdateFunc) error {
+	idx, err := m.owner(ctx, id)
+	if err != nil {
+		return err
+	}
+	var st status.Status
+	err = m.stores[idx].Update(ctx, id, func(ctx context.Context, t *lymbo.Ticket) error {
+		if err := fn(ctx, t); err != nil {
+			return err
+		}
+		st = t.Status
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.track(id, idx, st)
+	return nil
+}
+
+func (m *Store) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
+	idx, err := m.owner(ctx, us.Id)
+	if err != nil {
+		return err
+	}
+	if err := m.stores[idx].UpdateSet(ctx, us); err != nil {
+		return err
+	}
+	m.untrack(us)
+	return nil
+}
+
+func (m *Store) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
+	groups := make(map[int][]lymbo.UpdateSet)
+	for _, us := range updates {
+		idx, err := m.owner(ctx, us.Id)
+		if err != nil {
+			return err
+		}
+		groups[idx] = append(groups[idx], us)
+	}
+
+	var errs []error
+	for idx, group := range groups {
+		if err := m.stores[idx].UpdateBatch(ctx, group); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, us := range group {
+			m.untrack(us)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Settle is routed to the child owning the settled ticket, which also gets the
+// follow-up tickets so that it can write all of them atomically.
+func (m *Store) Settle(ctx context.Context, s lymbo.Settlement) error {
+	idx, err := m.owner(ctx, s.Update.Id)
+	if err != nil {
+		return err
+	}
+	if err := m.stores[idx].Settle(ctx, s); err != nil {
+		return err
+	}
+
+	if s.Delete || s.Func != nil {
+		// the resulting status isn't known, it is looked up on demand
+		m.forget(s.Update.Id)
+	} else {
+		m.untrack(s.Update)
+	}
+	for _, next := range s.Next {
+		m.track(next.ID, idx, next.Status)
+	}
+	return nil
+}
+
+func (m *Store) Reschedule(ctx context.Context, id lymbo.TicketId, runat time.Time) error {
+	idx, err := m.owner(ctx, id)
+	if err != nil {
+		return err
+	}
+	return m.stores[idx].Reschedule(ctx, id, runat)
+}
+
+func (m *Store) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	idx, err := m.owner(ctx, id)
+	if err != nil {
+		return err
+	}
+	return m.stores[idx].Touch(ctx, id, extendBy)
+}
+
+// PollPending polls the children in turn, starting from a different child on
+// every call, each for its share of req.Limit and of req.LimitPerType, so
+// that the tickets held in one child can't starve the older ones of the
+// others. The capacity left by children with too few ready tickets goes to
+// those which claimed their whole share. req.MaxInFlightPerType caps the
+// tickets in flight in every child together: they are counted by
+// ListInFlight before polling, so concurrent pollers may briefly exceed it.
+// The claimed tickets are merged by the Runat they were due at, then Nice,
+// by Nice first with req.Priority, keeping the order of each child, see
+// lymbo.PollResult.Due; if a child doesn't report it, the children take
+// turns instead.
+// A failing child doesn't hold the others up: its error is returned along
+// with the tickets claimed from the others, for them to be processed.
+// If nothing is ready, SleepUntil is the earliest one reported by any child.
+func (m *Store) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
+	if req.Limit <= 0 {
+		return lymbo.PollResult{}, lymbo.ErrLimitInvalid
+	}
+
+	// the per-type limits left for the next children
+	limits := maps.Clone(req.LimitPerType)
+	if len(req.MaxInFlightPerType) > 0 {
+		var err error
+		if limits, err = m.capInFlight(ctx, req, limits); err != nil {
+			return lymbo.PollResult{}, err
+		}
+	}
+
+	m.mu.Lock()
+	start := m.next
+	m.next = (m.next + 1) % len(m.stores)
+	m.mu.Unlock()
+
+	var (
+		// claimed are the tickets claimed from each child, by turn, and due
+		// the runat they were due at, unless a child didn't report it
+		claimed    = make([][]lymbo.Ticket, len(m.stores))
+		due        = make([][]time.Time, len(m.stores))
+		unordered  bool
+		total      int
+		sleepUntil *time.Time
+		dropped    int
+		errs       []error
+	)
+	// poll claims up to limit tickets from the child of turn, reporting
+	// whether it claimed them all.
+	poll := func(turn, limit int) bool {
+		idx := (start + turn) % len(m.stores)
+		sub := req
+		sub.Limit = limit
+		sub.LimitPerType = limits
+		res, err := m.stores[idx].PollPending(ctx, sub)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, t := range res.Tickets {
+			m.remember(t.ID, idx)
+			if n, ok := limits[t.Type]; ok {
+				limits[t.Type] = n - 1
+			}
+		}
+		claimed[turn] = append(claimed[turn], res.Tickets...)
+		due[turn] = append(due[turn], res.Due...)
+		unordered = unordered || len(res.Due) != len(res.Tickets)
+		total += len(res.Tickets)
+		dropped += res.Dropped
+		if res.SleepUntil != nil && (sleepUntil == nil || res.SleepUntil.Before(*sleepUntil)) {
+			sleepUntil = res.SleepUntil
+		}
+		return err == nil && len(res.Tickets) == limit
+	}
+
+	// children which claimed their whole share may have more ready tickets
+	var full []int
+	for turn := range m.stores {
+		left := req.Limit - total
+		if left == 0 {
+			break
+		}
+		share := (left + len(m.stores) - turn - 1) / (len(m.stores) - turn)
+		if poll(turn, share) {
+			full = append(full, turn)
+		}
+	}
+	for _, turn := range full {
+		left := req.Limit - total
+		if left == 0 {
+			break
+		}
+		poll(turn, left)
+	}
+
+	err := errors.Join(errs...)
+	if total == 0 {
+		if err != nil {
+			return lymbo.PollResult{Dropped: dropped}, err
+		}
+		if dropped > 0 {
+			// stale tickets were dropped, more may be ready
+			sleepUntil = nil
+		}
+		return lymbo.PollResult{SleepUntil: sleepUntil, Dropped: dropped}, nil
+	}
+	res := lymbo.PollResult{Dropped: dropped}
+	if unordered {
+		res.Tickets = interleave(claimed, total)
+	} else {
+		res.Tickets, res.Due = merge(claimed, due, total, req)
+	}
+	return res, err
+}
+
+// capInFlight lowers limits to the capacity req.MaxInFlightPerType leaves
+// to each listed type, counting the tickets in flight in every child.
+func (m *Store) capInFlight(ctx context.Context, req lymbo.PollRequest, limits map[string]int) (map[string]int, error) {
+	inflight := make(map[string]int)
+	for _, s := range m.stores {
+		tickets, err := s.ListInFlight(ctx, req.Now)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tickets {
+			inflight[t.Type]++
+		}
+	}
+
+	if limits == nil {
+		limits = make(map[string]int, len(req.MaxInFlightPerType))
+	}
+	for typ, limit := range req.MaxInFlightPerType {
+		left := max(limit-inflight[typ], 0)
+		if n, ok := limits[typ]; !ok || left < n {
+			limits[typ] = left
+		}
+	}
+	return limits, nil
+}
+
+// interleave merges the tickets claimed from each child, the children
+// taking turns.
+func interleave(claimed [][]lymbo.Ticket, total int) []lymbo.Ticket {
+	tickets := make([]lymbo.Ticket, 0, total)
+	for i := 0; len(tickets) < total; i++ {
+		for _, ts := range claimed {
+			if i < len(ts) {
+				tickets = append(tickets, ts[i])
+			}
+		}
+	}
+	return tickets
+}
+
+// merge merges the tickets claimed from each child by the runat they were
+// due at, see PollPending, returning them along with it.
+func merge(claimed [][]lymbo.Ticket, due [][]time.Time, total int, req lymbo.PollRequest) ([]lymbo.Ticket, []time.Time) {
+	// the ticket of the child i at its head, as it was due
+	head := func(i int, heads []int) lymbo.Ticket {
+		t := claimed[i][heads[i]]
+		t.Runat = due[i][heads[i]]
+		return t
+	}
+
+	tickets := make([]lymbo.Ticket, 0, total)
+	dues := make([]time.Time, 0, total)
+	heads := make([]int, len(claimed))
+	for len(tickets) < total {
+		best := -1
+		for i := range claimed {
+			if heads[i] < len(claimed[i]) && (best < 0 || before(head(i, heads), head(best, heads), req)) {
+				best = i
+			}
+		}
+		tickets = append(tickets, claimed[best][heads[best]])
+		dues = append(dues, due[best][heads[best]])
+		heads[best]++
+	}
+	return tickets, dues
+}
+
+// before reports whether the due ticket a is claimed before b, as
+// storeutil.Select orders them: the ready ones before the ones req.Boost
+// claims early, by Nice first with req.Priority, then by Runat and Nice.
+func before(a, b lymbo.Ticket, req lymbo.PollRequest) bool {
+	if ea, eb := a.Runat.After(req.Now), b.Runat.After(req.Now); ea != eb {
+		return eb
+	}
+	if req.Priority.Enabled {
+		if na, nb := req.Priority.Nice(a, req.Now), req.Priority.Nice(b, req.Now); na != nb {
+			return na < nb
+		}
+	}
+	return storeutil.Less(a, b)
+}
+
+// Pause pauses typ in every child, as its tickets may be routed to any.
+func (m *Store) Pause(ctx context.Context, typ string) error {
+	for _, s := range m.stores {
+		if err := s.Pause(ctx, typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resume resumes typ in every child.
+func (m *Store) Resume(ctx context.Context, typ string) error {
+	for _, s := range m.stores {
+		if err := s.Resume(ctx, typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Paused returns the types paused in any child, sorted.
+func (m *Store) Paused(ctx context.Context) ([]string, error) {
+	var types []string
+	for _, s := range m.stores {
+		ts, err := s.Paused(ctx)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, ts...)
+	}
+	slices.Sort(types)
+	return slices.Compact(types), nil
+}
+
+func (m *Store) ListInFlight(ctx context.Context, now time.Time) ([]lymbo.Ticket, error) {
+	var tickets []lymbo.Ticket
+	for _, s := range m.stores {
+		ts, err := s.ListInFlight(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ts...)
+	}
+	return tickets, nil
+}
+
+// ReleaseOwned releases the tickets of owner in every child, summing their counts.
+func (m *Store) ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error) {
+	var n int
+	for _, s := range m.stores {
+		c, err := s.ReleaseOwned(ctx, owner, now)
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReleaseDependents releases the dependents of id in every child, summing
+// their counts, as they may be routed to another child than id.
+func (m *Store) ReleaseDependents(ctx context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	var n int
+	for _, s := range m.stores {
+		c, err := s.ReleaseDependents(ctx, id, now)
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// RetryFailed retries the selected failed tickets of every child, summing
+// their counts.
+func (m *Store) RetryFailed(ctx context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	var n int
+	for _, s := range m.stores {
+		c, err := s.RetryFailed(ctx, req)
+		n += c
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ListOverdue merges the overdue tickets of every child, earliest deadline first.
+func (m *Store) ListOverdue(ctx context.Context, now time.Time, limit int) ([]lymbo.Ticket, error) {
+	var tickets []lymbo.Ticket
+	for _, s := range m.stores {
+		ts, err := s.ListOverdue(ctx, now, limit)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ts...)
+	}
+	return storeutil.ListOverdue(slices.Values(tickets), now, limit), nil
+}
+
+// List merges the tickets listed by every child, in order.
+func (m *Store) List(ctx context.Context, req lymbo.ListRequest) ([]lymbo.Ticket, error) {
+	var tickets []lymbo.Ticket
+	for _, s := range m.stores {
+		ts, err := s.List(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ts...)
+	}
+	return storeutil.List(slices.Values(tickets), req), nil
+}
+
+// Search merges the tickets found by every child implementing
+// lymbo.Searcher, in order. Returns lymbo.ErrSearchUnsupported if none does.
+func (m *Store) Search(ctx context.Context, query string, limit int) ([]lymbo.Ticket, error) {
+	if limit <= 0 {
+		return nil, lymbo.ErrLimitInvalid
+	}
+	var (
+		tickets  []lymbo.Ticket
+		searched bool
+	)
+	for _, s := range m.stores {
+		searcher, ok := s.(lymbo.Searcher)
+		if !ok {
+			continue
+		}
+		ts, err := searcher.Search(ctx, query, limit)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, ts...)
+		searched = true
+	}
+	if !searched {
+		return nil, lymbo.ErrSearchUnsupported
+	}
+	return storeutil.List(slices.Values(tickets), lymbo.ListRequest{Limit: limit}), nil
+}
+
+func (m *Store) Vacuum(ctx context.Context, req lymbo.VacuumRequest) (lymbo.VacuumReport, error) {
+	var report lymbo.VacuumReport
+	for _, s := range m.stores {
+		r, err := s.Vacuum(ctx, req)
+		if err != nil {
+			return lymbo.VacuumReport{}, err
+		}
+		report.Unreachable = append(report.Unreachable, r.Unreachable...)
+		report.MissingMtime = append(report.MissingMtime, r.MissingMtime...)
+		report.Exhausted = append(report.Exhausted, r.Exhausted...)
+	}
+	return report, nil
+}
+
+// ExpireTickets expires tickets in every child, up to req.Limit in total.
+func (m *Store) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
+	var total int64
+	for _, s := range m.stores {
+		remaining := int64(req.Limit) - total
+		if remaining <= 0 {
+			break
+		}
+
+		sub := req
+		sub.Limit = int(remaining)
+		n, err := s.ExpireTickets(ctx, sub)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
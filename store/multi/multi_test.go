@@ -0,0 +1,134 @@
+package multi_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/store/memory"
+	"github.com/ochaton/lymbo/store/multi"
+	"github.com/ochaton/lymbo/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.TestStore(t, func() lymbo.Store {
+		return multi.NewStore(memory.NewStore(), memory.NewStore())
+	})
+}
+
+// newStore returns a store over two memory stores, routing the tickets of
+// type "b" to the second one.
+func newStore(t *testing.T, children ...lymbo.Store) *multi.Store {
+	if children == nil {
+		children = []lymbo.Store{memory.NewStore(), memory.NewStore()}
+	}
+	s, err := multi.NewStoreWithConfig(multi.Config{
+		Stores: children,
+		Route: func(t lymbo.Ticket) int {
+			if t.Type == "b" {
+				return 1
+			}
+			return 0
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func put(t *testing.T, s lymbo.Store, typ string, runat time.Time) lymbo.Ticket {
+	return putTicket(t, s, lymbo.Ticket{ID: lymbo.TicketId(uuid.NewString()), Type: typ, Runat: runat, Ctime: runat})
+}
+
+func putTicket(t *testing.T, s lymbo.Store, tk lymbo.Ticket) lymbo.Ticket {
+	if err := s.Put(context.Background(), tk); err != nil {
+		t.Fatal(err)
+	}
+	return tk
+}
+
+func TestPollOrder(t *testing.T) {
+	s := newStore(t)
+	now := time.Now().Truncate(time.Millisecond)
+	// by runat across the children, then by nice
+	var want []lymbo.TicketId
+	for i, typ := range []string{"a", "b", "b", "a", "b"} {
+		runat := now.Add(time.Duration(min(i, 3)-4) * time.Minute)
+		want = append(want, putTicket(t, s, lymbo.Ticket{
+			ID: lymbo.TicketId(uuid.NewString()), Type: typ, Runat: runat, Nice: i, Attempts: 4 - i,
+		}).ID)
+	}
+
+	res, err := s.PollPending(context.Background(), lymbo.PollRequest{Limit: 5, Now: now, TTR: time.Minute, BackoffBase: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []lymbo.TicketId
+	for _, tk := range res.Tickets {
+		got = append(got, tk.ID)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("polled %v, want them by runat, then nice %v", got, want)
+	}
+}
+
+func TestPollMaxInFlight(t *testing.T) {
+	s := newStore(t)
+	now := time.Now()
+	for _, typ := range []string{"a", "a", "b", "b"} {
+		put(t, s, typ, now)
+	}
+	req := lymbo.PollRequest{Limit: 10, Now: now, TTR: time.Minute, MaxInFlightPerType: map[string]int{"a": 3, "b": 3}}
+
+	res, err := s.PollPending(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tickets) != 4 {
+		t.Fatalf("polled %d tickets, want 4", len(res.Tickets))
+	}
+	for _, typ := range []string{"a", "a", "b"} {
+		put(t, s, typ, now)
+	}
+	res, err = s.PollPending(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tickets) != 2 {
+		t.Errorf("polled %d tickets with 2 of each type in flight, want 2", len(res.Tickets))
+	}
+}
+
+// failing is a store whose polls fail.
+type failing struct {
+	lymbo.Store
+}
+
+var errPoll = errors.New("poll failed")
+
+func (failing) PollPending(context.Context, lymbo.PollRequest) (lymbo.PollResult, error) {
+	return lymbo.PollResult{}, errPoll
+}
+
+func TestPollFailingChild(t *testing.T) {
+	s := newStore(t, memory.NewStore(), failing{memory.NewStore()})
+	now := time.Now()
+	tk := put(t, s, "a", now)
+
+	for range 2 {
+		// whichever child is polled first
+		res, err := s.PollPending(context.Background(), lymbo.PollRequest{Limit: 2, Now: now, TTR: time.Minute})
+		if !errors.Is(err, errPoll) {
+			t.Errorf("PollPending returned %v, want %v", err, errPoll)
+		}
+		if len(res.Tickets) == 1 && res.Tickets[0].ID == tk.ID {
+			return
+		}
+	}
+	t.Errorf("the ticket of the other child wasn't polled")
+}
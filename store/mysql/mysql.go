@@ -0,0 +1,904 @@
+// Package mysql provides a MySQL implementation of the lymbo.Store interface,
+// for MySQL 8.0+ and MariaDB 10.6+. It works with any database/sql MySQL
+// driver, such as github.com/go-sql-driver/mysql, which the application
+// imports and opens itself.
+//
+// Usage:
+//
+//	db, _ := sql.Open("mysql", "user:password@tcp(localhost:3306)/app")
+//	store, err := mysql.NewStore(mysql.Config{DB: db})
+//	err = store.Migrate(ctx)
+//
+// Polls lock their candidates with SELECT ... FOR UPDATE SKIP LOCKED in a
+// READ COMMITTED transaction, so concurrent pollers claim disjoint tickets
+// without waiting on each other, as with the PostgreSQL store.
+//
+// Tickets are kept serialized in a data column, next to the columns that
+// polling, expiration and the unique keys of pending tickets index.
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/internal/storeutil"
+)
+
+type Config struct {
+	DB *sql.DB
+
+	// TableName is the name of the tickets table. Defaults to "tickets".
+	TableName string
+
+	// Clock tells the time of the tickets put or updated, e.g. the
+	// lymbo.FakeClock of the Kharon, see lymbo.Settings.WithClock.
+	// Defaults to lymbo.SystemClock.
+	Clock lymbo.Clock
+}
+
+// Store is a MySQL backed ticket store.
+type Store struct {
+	db      *sql.DB
+	queries queries
+	clock   lymbo.Clock
+}
+
+// Ensure Store implements lymbo.Store interface.
+var _ lymbo.Store = (*Store)(nil)
+
+// NewStore returns a store on top of db. Run Migrate to create the table.
+func NewStore(cfg Config) (*Store, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("db cannot be nil")
+	}
+	if cfg.TableName == "" {
+		cfg.TableName = "tickets"
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = lymbo.SystemClock
+	}
+
+	q, err := newQueries(cfg.TableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize query templates: %w", err)
+	}
+	return &Store{db: cfg.DB, queries: q, clock: cfg.Clock}, nil
+}
+
+type queries struct {
+	migrate  string
+	get      string
+	lock     string
+	exists   string
+	insert   string
+	update   string
+	delete   string
+	poll     string
+	next     string
+	inflight string
+	waiting  string
+	overdue  string
+	failed   string
+	list     string
+	all      string
+	expired  string
+
+	migratePaused string
+	pause         string
+	resume        string
+	paused        string
+}
+
+// The templates are single statements: drivers don't run several at once
+// unless configured to.
+const templates = `
+{{define "migrate"}}
+CREATE TABLE IF NOT EXISTS {{.}} (
+	id          VARCHAR(255) NOT NULL PRIMARY KEY,
+	status      VARCHAR(32) NOT NULL,
+	runat       BIGINT NOT NULL, -- unix milliseconds
+	nice        INT NOT NULL,
+	type        VARCHAR(255) NOT NULL,
+	queue       VARCHAR(255) NOT NULL DEFAULT '',
+	ctime       BIGINT NOT NULL,
+	modified    BIGINT NOT NULL, -- mtime, or ctime if never modified
+	deadline    BIGINT NULL,
+	pending_key VARCHAR(255) NULL, -- the unique key of pending tickets
+	data        JSON NOT NULL,
+	INDEX {{.}}_pending_queue (status, queue, runat, nice),
+	INDEX {{.}}_status_runat (status, runat),
+	INDEX {{.}}_status_modified (status, modified),
+	INDEX {{.}}_status_deadline (status, deadline),
+	INDEX {{.}}_ctime (ctime, id),
+	UNIQUE INDEX {{.}}_unique (type, pending_key)
+)
+{{end}}
+{{define "migrate_paused"}}CREATE TABLE IF NOT EXISTS {{.}}_paused (type VARCHAR(255) NOT NULL PRIMARY KEY){{end}}
+{{define "get"}}SELECT data FROM {{.}} WHERE id = ?{{end}}
+{{define "lock"}}SELECT data FROM {{.}} WHERE id = ? FOR UPDATE{{end}}
+{{define "exists"}}SELECT EXISTS (SELECT 1 FROM {{.}} WHERE id = ?){{end}}
+{{define "insert"}}
+INSERT INTO {{.}} (status, runat, nice, type, queue, ctime, modified, deadline, pending_key, data, id)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+{{end}}
+{{define "update"}}
+UPDATE {{.}} SET
+	status = ?, runat = ?, nice = ?, type = ?, queue = ?, ctime = ?, modified = ?,
+	deadline = ?, pending_key = ?, data = ?
+WHERE id = ?
+{{end}}
+{{define "delete"}}DELETE FROM {{.}} WHERE id = ?{{end}}
+{{define "poll"}}
+SELECT data FROM {{.}}
+WHERE status = 'pending' AND queue = ? AND runat <= ? AND JSON_EXTRACT(data, '$.depends_on') IS NULL
+	AND type NOT IN (SELECT type FROM {{.}}_paused)
+ORDER BY runat, nice
+LIMIT ?
+FOR UPDATE SKIP LOCKED
+{{end}}
+{{define "next"}}
+SELECT data FROM {{.}}
+WHERE status = 'pending' AND queue = ? AND runat > ? AND JSON_EXTRACT(data, '$.depends_on') IS NULL
+	AND type NOT IN (SELECT type FROM {{.}}_paused)
+ORDER BY runat, nice
+LIMIT 1
+{{end}}
+{{define "inflight"}}SELECT data FROM {{.}} WHERE status = 'pending' AND runat > ?{{end}}
+{{define "waiting"}}
+SELECT data FROM {{.}}
+WHERE status = 'pending' AND JSON_CONTAINS(data, JSON_QUOTE(?), '$.depends_on')
+FOR UPDATE
+{{end}}
+{{define "overdue"}}
+SELECT data FROM {{.}}
+WHERE status = 'pending' AND deadline <= ?
+ORDER BY deadline
+LIMIT ?
+{{end}}
+{{define "failed"}}SELECT data FROM {{.}} WHERE status = 'failed' FOR UPDATE{{end}}
+{{define "list"}}
+SELECT data FROM {{.}}
+WHERE (? IS NULL OR status = ?)
+	AND (? IS NULL OR JSON_CONTAINS(CAST(? AS JSON), JSON_QUOTE(type)))
+	AND (? IS NULL OR ctime >= ?)
+	AND (? IS NULL OR ctime < ?)
+	AND (? IS NULL OR runat >= ?)
+	AND (? IS NULL OR runat < ?)
+	AND (? IS NULL OR (ctime, id) > (?, ?))
+	AND (? IS NULL OR JSON_CONTAINS(CAST(? AS JSON), JSON_QUOTE(IFNULL(JSON_UNQUOTE(JSON_EXTRACT(data, '$.tenant_id')), ''))))
+ORDER BY ctime, id
+LIMIT ?
+{{end}}
+{{define "all"}}SELECT data FROM {{.}}{{end}}
+{{define "pause"}}INSERT IGNORE INTO {{.}}_paused (type) VALUES (?){{end}}
+{{define "resume"}}DELETE FROM {{.}}_paused WHERE type = ?{{end}}
+{{define "paused"}}SELECT type FROM {{.}}_paused ORDER BY type{{end}}
+{{define "expired"}}
+SELECT data FROM {{.}}
+WHERE status <> 'pending' AND (runat <= ? OR modified <= ?)
+ORDER BY modified
+FOR UPDATE SKIP LOCKED
+{{end}}
+`
+
+func newQueries(tableName string) (queries, error) {
+	tmpl, err := template.New("queries").Parse(templates)
+	if err != nil {
+		return queries{}, err
+	}
+
+	var q queries
+	for name, dst := range map[string]*string{
+		"migrate":  &q.migrate,
+		"get":      &q.get,
+		"lock":     &q.lock,
+		"exists":   &q.exists,
+		"insert":   &q.insert,
+		"update":   &q.update,
+		"delete":   &q.delete,
+		"poll":     &q.poll,
+		"next":     &q.next,
+		"inflight": &q.inflight,
+		"waiting":  &q.waiting,
+		"overdue":  &q.overdue,
+		"failed":   &q.failed,
+		"list":     &q.list,
+		"all":      &q.all,
+		"expired":  &q.expired,
+
+		"migrate_paused": &q.migratePaused,
+		"pause":          &q.pause,
+		"resume":         &q.resume,
+		"paused":         &q.paused,
+	} {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, name, tableName); err != nil {
+			return queries{}, fmt.Errorf("failed to render %s: %w", name, err)
+		}
+		*dst = buf.String()
+	}
+	return q, nil
+}
+
+// Migrate creates the tickets table, its indexes and the table of paused
+// types if they don't exist.
+func (s *Store) Migrate(ctx context.Context) error {
+	for _, query := range []string{s.queries.migrate, s.queries.migratePaused} {
+		if _, err := s.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+	return nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// write runs fn in a READ COMMITTED transaction, committed if fn succeeds.
+// Unlike REPEATABLE READ, it takes no gap locks, which would block the
+// inserts of concurrent transactions.
+func (s *Store) write(ctx context.Context, fn func(q querier) error) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+// load reads the ticket with query, get or lock.
+func (s *Store) load(ctx context.Context, q querier, query string, id lymbo.TicketId) (lymbo.Ticket, error) {
+	if id == "" {
+		return lymbo.Ticket{}, lymbo.ErrTicketIDEmpty
+	}
+
+	var data []byte
+	err := q.QueryRowContext(ctx, query, id.String()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lymbo.Ticket{}, lymbo.ErrTicketNotFound
+	}
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	return decode(data)
+}
+
+func decode(data []byte) (lymbo.Ticket, error) {
+	t, err := storeutil.Unmarshal(data)
+	if err != nil {
+		return lymbo.Ticket{}, fmt.Errorf("failed to decode ticket: %w", err)
+	}
+	return t, nil
+}
+
+// save writes t, updating its row if it exists. An upsert isn't used, as
+// ON DUPLICATE KEY UPDATE would also update the row of another ticket
+// holding the unique key of t.
+func (s *Store) save(ctx context.Context, q querier, t lymbo.Ticket) error {
+	data, err := storeutil.Marshal(t)
+	if err != nil {
+		return err
+	}
+	modified := t.Ctime
+	if t.Mtime != nil {
+		modified = *t.Mtime
+	}
+	var deadline sql.NullInt64
+	if t.Deadline != nil {
+		deadline = sql.NullInt64{Int64: t.Deadline.UnixMilli(), Valid: true}
+	}
+	var pendingKey sql.NullString
+	if t.Status == status.Pending && t.UniqueKey != "" {
+		pendingKey = sql.NullString{String: t.UniqueKey, Valid: true}
+	}
+	args := []any{
+		t.Status.String(), t.Runat.UnixMilli(), t.Nice, t.Type, t.Queue, t.Ctime.UnixMilli(), modified.UnixMilli(),
+		deadline, pendingKey, string(data), t.ID.String(),
+	}
+
+	var row []byte
+	err = q.QueryRowContext(ctx, s.queries.lock, t.ID.String()).Scan(&row)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = q.ExecContext(ctx, s.queries.insert, args...)
+		if !duplicate(err) || !strings.HasSuffix(err.Error(), "PRIMARY'") {
+			return uniqueErr(err)
+		}
+		// a concurrent transaction inserted the ticket meanwhile
+	} else if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, s.queries.update, args...)
+	return uniqueErr(err)
+}
+
+// duplicate reports whether err is an ER_DUP_ENTRY error: a conflict on the
+// primary key, or on the UniqueKey index otherwise.
+func duplicate(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Error 1062")
+}
+
+// uniqueErr maps the ER_DUP_ENTRY error of a write whose id doesn't conflict
+// to lymbo.ErrDuplicateTicket.
+func uniqueErr(err error) error {
+	if duplicate(err) {
+		return lymbo.ErrDuplicateTicket
+	}
+	return err
+}
+
+// query returns the tickets selected by query.
+func (s *Store) query(ctx context.Context, q querier, query string, args ...any) ([]lymbo.Ticket, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []lymbo.Ticket
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		t, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
+
+// modify applies fn to the locked ticket and writes it back in a single transaction.
+func (s *Store) modify(ctx context.Context, id lymbo.TicketId, fn func(*lymbo.Ticket) error) error {
+	return s.write(ctx, func(q querier) error {
+		t, err := s.load(ctx, q, s.queries.lock, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(&t); err != nil {
+			return err
+		}
+		return s.save(ctx, q, t)
+	})
+}
+
+func (s *Store) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
+	return s.load(ctx, s.db, s.queries.get, id)
+}
+
+func (s *Store) Exists(ctx context.Context, id lymbo.TicketId) (bool, error) {
+	if id == "" {
+		return false, lymbo.ErrTicketIDEmpty
+	}
+	var exists bool
+	err := s.db.QueryRowContext(ctx, s.queries.exists, id.String()).Scan(&exists)
+	return exists, err
+}
+
+// GetResult returns the Result of a settled ticket.
+func (s *Store) GetResult(ctx context.Context, id lymbo.TicketId) (any, error) {
+	t, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.Result(t)
+}
+
+func (s *Store) Put(ctx context.Context, t lymbo.Ticket) error {
+	if t.ID == "" {
+		return lymbo.ErrTicketIDEmpty
+	}
+
+	storeutil.Defaults(&t, s.clock.Now())
+	return s.write(ctx, func(q querier) error {
+		return s.save(ctx, q, t)
+	})
+}
+
+// PutBatch puts the tickets in a single transaction.
+func (s *Store) PutBatch(ctx context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs := storeutil.NewBatchErrors(len(tickets))
+	now := s.clock.Now()
+	err := s.write(ctx, func(q querier) error {
+		for i, t := range tickets {
+			if t.ID == "" {
+				errs.Set(i, lymbo.ErrTicketIDEmpty)
+				continue
+			}
+			storeutil.Defaults(&t, now)
+			err := s.save(ctx, q, t)
+			switch {
+			case errors.Is(err, lymbo.ErrDuplicateTicket):
+				// only the failed statement is rolled back
+				errs.Set(i, err)
+			case err != nil:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs.Errs(), nil
+}
+
+func (s *Store) Delete(ctx context.Context, id lymbo.TicketId) error {
+	return s.DeleteBatch(ctx, []lymbo.TicketId{id})
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, ids []lymbo.TicketId) error {
+	return s.write(ctx, func(q querier) error {
+		for _, id := range ids {
+			if _, err := q.ExecContext(ctx, s.queries.delete, id.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.UpdateFunc) error {
+	return s.modify(ctx, id, func(t *lymbo.Ticket) error {
+		return fn(ctx, t)
+	})
+}
+
+func (s *Store) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
+	return s.modify(ctx, us.Id, func(t *lymbo.Ticket) error {
+		if err := storeutil.CheckLease(*t, us); err != nil {
+			return err
+		}
+		storeutil.Apply(t, us, s.clock.Now())
+		return nil
+	})
+}
+
+// UpdateBatch applies every update in a single transaction: if one fails,
+// none is written.
+func (s *Store) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
+	return s.write(ctx, func(q querier) error {
+		now := s.clock.Now()
+		for _, us := range updates {
+			t, err := s.load(ctx, q, s.queries.lock, us.Id)
+			if err != nil {
+				return err
+			}
+			if err := storeutil.CheckLease(t, us); err != nil {
+				return err
+			}
+			storeutil.Apply(&t, us, now)
+			if err := s.save(ctx, q, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Settle writes the outcome and the follow-up tickets in a single transaction.
+func (s *Store) Settle(ctx context.Context, st lymbo.Settlement) error {
+	for _, next := range st.Next {
+		if next.ID == "" {
+			return lymbo.ErrTicketIDEmpty
+		}
+	}
+
+	return s.write(ctx, func(q querier) error {
+		t, err := s.load(ctx, q, s.queries.lock, st.Update.Id)
+		if err != nil {
+			return err
+		}
+		now := s.clock.Now()
+		if err := storeutil.Settle(ctx, &t, st, now); err != nil {
+			return err
+		}
+
+		if st.Delete {
+			_, err = q.ExecContext(ctx, s.queries.delete, t.ID.String())
+		} else {
+			err = s.save(ctx, q, t)
+		}
+		if err != nil {
+			return err
+		}
+		for _, next := range st.Next {
+			storeutil.Defaults(&next, now)
+			if err := s.save(ctx, q, next); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Reschedule(ctx context.Context, id lymbo.TicketId, runat time.Time) error {
+	return s.modify(ctx, id, func(t *lymbo.Ticket) error {
+		if t.Status != status.Pending {
+			return lymbo.ErrInvalidStatusTransition
+		}
+		now := s.clock.Now()
+		t.Runat = runat
+		t.Mtime = &now
+		return nil
+	})
+}
+
+// Touch reschedules the ticket to now + extendBy, see Reschedule.
+func (s *Store) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	return s.Reschedule(ctx, id, s.clock.Now().Add(extendBy))
+}
+
+// PollPending locks ready tickets with FOR UPDATE SKIP LOCKED and claims
+// them in a single transaction. Unless the request filters or orders tickets
+// beyond their runat (labels, catch-up or priority), only the first
+// req.Limit ready tickets are locked.
+func (s *Store) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
+	if req.Limit <= 0 {
+		return lymbo.PollResult{}, lymbo.ErrLimitInvalid
+	}
+
+	var res lymbo.PollResult
+	err := s.write(ctx, func(q querier) error {
+		res = lymbo.PollResult{}
+		candidates, err := s.candidates(ctx, q, req)
+		if err != nil {
+			return err
+		}
+
+		ready, sleepUntil := storeutil.Select(values(candidates), req)
+		if len(ready) == 0 {
+			res.SleepUntil = sleepUntil
+			return nil
+		}
+
+		ready, stale := storeutil.CatchUp(ready, req)
+		for _, t := range stale {
+			storeutil.Drop(&t, req.Now)
+			if err := s.save(ctx, q, t); err != nil {
+				return err
+			}
+		}
+		res.Dropped = len(stale)
+
+		ready = ready[:min(req.Limit, len(ready))]
+		res.Due = make([]time.Time, len(ready))
+		for i := range ready {
+			res.Due[i] = storeutil.Claim(&ready[i], req)
+			if err := s.save(ctx, q, ready[i]); err != nil {
+				return err
+			}
+		}
+		res.Tickets = ready
+		return nil
+	})
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+	return res, nil
+}
+
+// Pause inserts typ into the paused table, which the poll queries skip.
+func (s *Store) Pause(ctx context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	_, err := s.db.ExecContext(ctx, s.queries.pause, typ)
+	return err
+}
+
+// Resume deletes typ from the paused table.
+func (s *Store) Resume(ctx context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	_, err := s.db.ExecContext(ctx, s.queries.resume, typ)
+	return err
+}
+
+func (s *Store) Paused(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.queries.paused)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []string
+	for rows.Next() {
+		var typ string
+		if err := rows.Scan(&typ); err != nil {
+			return nil, err
+		}
+		types = append(types, typ)
+	}
+	return types, rows.Err()
+}
+
+// candidates reads the pending tickets storeutil.Select needs for req: the
+// unlocked ones of req.Queue due by the boost horizon, locked, and the
+// earliest later one, for SleepUntil. If in-flight tickets must be counted
+// for the caps, they are read too, unlocked: they are never ready, so only
+// locked tickets are claimed.
+func (s *Store) candidates(ctx context.Context, q querier, req lymbo.PollRequest) ([]lymbo.Ticket, error) {
+	limit := int64(req.Limit)
+	if len(req.Labels) > 0 || len(req.Tenants) > 0 || req.FairShare || req.TypeShare.Enabled || len(req.MaxInFlightPerType) > 0 || len(req.LimitPerType) > 0 ||
+		req.CatchUp.MaxOverduePerType > 0 || req.CatchUp.DropAfter > 0 || req.Priority.Enabled {
+		limit = math.MaxInt64
+	}
+	horizon := req.Now.Add(max(req.Boost.Grace, 0)).UnixMilli()
+	tickets, err := s.query(ctx, q, s.queries.poll, req.Queue, horizon, limit)
+	if err != nil {
+		return nil, err
+	}
+	next, err := s.query(ctx, q, s.queries.next, req.Queue, horizon)
+	if err != nil {
+		return nil, err
+	}
+	tickets = append(tickets, next...)
+	if len(req.MaxInFlightPerType) == 0 {
+		return tickets, nil
+	}
+
+	pending, err := s.query(ctx, q, s.queries.inflight, req.Now.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[lymbo.TicketId]bool, len(tickets))
+	for _, t := range tickets {
+		seen[t.ID] = true
+	}
+	for _, t := range pending {
+		if !seen[t.ID] && storeutil.InFlight(t, req.Now) {
+			tickets = append(tickets, t)
+		}
+	}
+	return tickets, nil
+}
+
+// values iterates over tickets.
+func values(tickets []lymbo.Ticket) iter.Seq[lymbo.Ticket] {
+	return func(yield func(lymbo.Ticket) bool) {
+		for _, t := range tickets {
+			if !yield(t) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Store) ListInFlight(ctx context.Context, now time.Time) ([]lymbo.Ticket, error) {
+	pending, err := s.query(ctx, s.db, s.queries.inflight, now.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	var tickets []lymbo.Ticket
+	for _, t := range pending {
+		if storeutil.InFlight(t, now) {
+			tickets = append(tickets, t)
+		}
+	}
+	return tickets, nil
+}
+
+// ReleaseOwned releases the in-flight tickets of owner, each one locked and
+// checked again before, in a single transaction.
+func (s *Store) ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, func(q querier) error {
+		n = 0
+		pending, err := s.query(ctx, q, s.queries.inflight, now.UnixMilli())
+		if err != nil {
+			return err
+		}
+		for _, p := range pending {
+			if !storeutil.Owned(p, owner, now) {
+				continue
+			}
+			t, err := s.load(ctx, q, s.queries.lock, p.ID)
+			if errors.Is(err, lymbo.ErrTicketNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !storeutil.Owned(t, owner, now) {
+				continue
+			}
+			storeutil.Release(&t, now)
+			if err := s.save(ctx, q, t); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ReleaseDependents locks and unblocks the tickets waiting for id in a
+// single transaction.
+func (s *Store) ReleaseDependents(ctx context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, func(q querier) error {
+		n = 0
+		waiting, err := s.query(ctx, q, s.queries.waiting, id.String())
+		if err != nil {
+			return err
+		}
+		for _, t := range waiting {
+			storeutil.Unblock(&t, id, now)
+			if err := s.save(ctx, q, t); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RetryFailed locks the failed tickets and makes the selected ones pending
+// in a single transaction.
+func (s *Store) RetryFailed(ctx context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	var n int
+	err := s.write(ctx, func(q querier) error {
+		n = 0
+		failed, err := s.query(ctx, q, s.queries.failed)
+		if err != nil {
+			return err
+		}
+		for _, t := range failed {
+			if !storeutil.Retried(t, req) {
+				continue
+			}
+			storeutil.Requeue(&t, req)
+			err := s.save(ctx, q, t)
+			if errors.Is(err, lymbo.ErrDuplicateTicket) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *Store) ListOverdue(ctx context.Context, now time.Time, limit int) ([]lymbo.Ticket, error) {
+	if limit <= 0 {
+		return nil, lymbo.ErrLimitInvalid
+	}
+	return s.query(ctx, s.db, s.queries.overdue, now.UnixMilli(), limit)
+}
+
+func (s *Store) List(ctx context.Context, req lymbo.ListRequest) ([]lymbo.Ticket, error) {
+	var st sql.NullString
+	if req.Status != nil {
+		st = sql.NullString{String: req.Status.String(), Valid: true}
+	}
+	limit := int64(req.Limit)
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	var types sql.NullString
+	if len(req.Types) > 0 {
+		data, err := json.Marshal(req.Types)
+		if err != nil {
+			return nil, err
+		}
+		types = sql.NullString{String: string(data), Valid: true}
+	}
+	var tenants sql.NullString
+	if len(req.Tenants) > 0 {
+		data, err := json.Marshal(req.Tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenants = sql.NullString{String: string(data), Valid: true}
+	}
+	var afterCtime sql.NullInt64
+	var afterID sql.NullString
+	if req.After != nil {
+		afterCtime = millis(req.After.Ctime)
+		afterID = sql.NullString{String: req.After.ID.String(), Valid: true}
+	}
+	// the placeholders are positional, those tested for NULL are repeated
+	createdFrom, createdTo := millis(req.Created.From), millis(req.Created.To)
+	runatFrom, runatTo := millis(req.Runat.From), millis(req.Runat.To)
+	return s.query(ctx, s.db, s.queries.list,
+		st, st, types, types,
+		createdFrom, createdFrom, createdTo, createdTo,
+		runatFrom, runatFrom, runatTo, runatTo,
+		afterCtime, afterCtime, afterID,
+		tenants, tenants,
+		limit,
+	)
+}
+
+// millis returns t in the unix milliseconds of the columns, NULL if zero.
+func millis(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: !t.IsZero()}
+}
+
+func (s *Store) Vacuum(ctx context.Context, req lymbo.VacuumRequest) (lymbo.VacuumReport, error) {
+	tickets, err := s.query(ctx, s.db, s.queries.all)
+	if err != nil {
+		return lymbo.VacuumReport{}, err
+	}
+	return storeutil.Vacuum(values(tickets), req), nil
+}
+
+// ExpireTickets removes expired non-pending tickets in a single transaction,
+// skipping the ones locked by others. Candidates are the terminal tickets
+// whose Runat has passed or that weren't modified since the shortest retention.
+func (s *Store) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
+	modifiedBefore := int64(math.MinInt64)
+	if len(req.Retention) > 0 {
+		shortest := time.Duration(-1)
+		for _, d := range req.Retention {
+			if shortest < 0 || d < shortest {
+				shortest = d
+			}
+		}
+		modifiedBefore = req.Now.Add(-shortest).UnixMilli()
+	}
+
+	var count int64
+	err := s.write(ctx, func(q querier) error {
+		count = 0
+		tickets, err := s.query(ctx, q, s.queries.expired, req.Now.UnixMilli(), modifiedBefore)
+		if err != nil {
+			return err
+		}
+		var expired []lymbo.Ticket
+		for _, t := range tickets {
+			if len(expired) == req.Limit {
+				break
+			}
+			if !storeutil.ExpiresAt(t, req.Retention).After(req.Now) {
+				expired = append(expired, t)
+			}
+		}
+		if err := storeutil.Archive(ctx, req, expired); err != nil {
+			return err
+		}
+		for _, t := range expired {
+			if _, err := q.ExecContext(ctx, s.queries.delete, t.ID.String()); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
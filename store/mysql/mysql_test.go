@@ -0,0 +1,48 @@
+package mysql_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/store/mysql"
+	"github.com/ochaton/lymbo/store/storetest"
+)
+
+// tables numbers the tables of the stores.
+var tables atomic.Int64
+
+func TestStore(t *testing.T) {
+	dsn := os.Getenv("LYMBO_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("LYMBO_TEST_MYSQL_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	storetest.TestStore(t, func() lymbo.Store {
+		// tables of its own per store, dropped when the test ends
+		table := fmt.Sprintf("storetest_%d_%d", os.Getpid(), tables.Add(1))
+		t.Cleanup(func() {
+			if _, err := db.Exec("DROP TABLE IF EXISTS " + table + ", " + table + "_paused"); err != nil {
+				t.Error(err)
+			}
+		})
+		s, err := mysql.NewStore(mysql.Config{DB: db, TableName: table})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
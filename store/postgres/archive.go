@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ochaton/lymbo"
+)
+
+// Archive is a lymbo.ArchiveStore keeping the expired tickets in a table
+// with the columns of the tickets table and the time they were archived,
+// e.g. for compliance or analytics. Given to the Kharon of a Tickets store
+// on the same pool, expired tickets are moved to it by a single statement,
+// and the partitions of a table partitioned by month are copied to it before
+// being dropped. Ticket IDs must be UUIDs, as in the tickets table.
+type Archive struct {
+	db        *pgxpool.Pool
+	tableName string
+	migrate   string
+	insert    string
+}
+
+var _ lymbo.ArchiveStore = &Archive{}
+
+// NewArchive returns the archive of table tableName, "tickets_archive" if empty.
+func NewArchive(pool *pgxpool.Pool, tableName string) (*Archive, error) {
+	if tableName == "" {
+		tableName = "tickets_archive"
+	}
+	args := renderArgs{Archive: tableName}
+	migrate, err := render(migrateArchive, args)
+	if err != nil {
+		return nil, err
+	}
+	insert, err := render(archiveBatch, args)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{db: pool, tableName: tableName, migrate: migrate, insert: insert}, nil
+}
+
+// Migrate creates the archive table, and the ticket_status type of the
+// search path if missing.
+func (a *Archive) Migrate(ctx context.Context) error {
+	if err := migrateSearchPathStatus(ctx, a.db); err != nil {
+		return err
+	}
+	if _, err := a.db.Exec(ctx, a.migrate); err != nil {
+		return fmt.Errorf("failed to create archive table: %w", err)
+	}
+	return nil
+}
+
+// Archive inserts the tickets with a single multi-row statement.
+func (a *Archive) Archive(ctx context.Context, tickets []lymbo.Ticket) error {
+	if len(tickets) == 0 {
+		return nil
+	}
+	var cols putBatchColumns
+	now := time.Now()
+	for _, t := range tickets {
+		args, err := putArgs(t, now)
+		if err != nil {
+			return fmt.Errorf("ticket %s: %w", t.ID, err)
+		}
+		cols.add(args)
+	}
+	_, err := a.db.Exec(ctx, a.insert, cols.args()...)
+	return err
+}
+
+// native returns the archive if it is a table of the database of r,
+// tickets then being moved to it without reading them.
+func (r *Tickets) native(archive lymbo.ArchiveStore) (*Archive, bool) {
+	a, ok := archive.(*Archive)
+	return a, ok && a.db == r.db
+}
+
+// moveExpired moves up to limit expired tickets to the archive table a.
+func (r *Tickets) moveExpired(ctx context.Context, a *Archive, expireArgs []any, limit int32) (int64, error) {
+	query, err := render(moveExpired, renderArgs{TableName: r.tableName, Qualifier: qualifier(r.schema), Archive: a.tableName})
+	if err != nil {
+		return 0, err
+	}
+	tag, err := r.db.Exec(ctx, query, append(expireArgs, limit)...)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// archiveExpired passes up to limit expired tickets to archive and removes
+// them, keeping them locked meanwhile.
+func (r *Tickets) archiveExpired(ctx context.Context, archive lymbo.ArchiveStore, expireArgs []any, limit int32) (int64, error) {
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	tickets, err := queryTickets(ctx, tx, r.queries.lockExpired, append(expireArgs, limit)...)
+	if err != nil || len(tickets) == 0 {
+		return 0, err
+	}
+	if err := archive.Archive(ctx, tickets); err != nil {
+		return 0, fmt.Errorf("failed to archive tickets: %w", err)
+	}
+	ids := make([]string, len(tickets))
+	for i, t := range tickets {
+		ids[i] = t.ID.String()
+	}
+	tag, err := tx.Exec(ctx, r.queries.deleteAll, ids)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
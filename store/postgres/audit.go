@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+)
+
+// AuditLog is a lymbo.AuditLog keeping the entries in a table indexed by
+// ticket ID, e.g. for compliance. Entries outlive their tickets: the table
+// is never cleaned up by the store.
+type AuditLog struct {
+	db      *pgxpool.Pool
+	migrate string
+	insert  string
+	history string
+}
+
+var _ lymbo.AuditLog = &AuditLog{}
+
+// NewAuditLog returns the audit log of table tableName, "tickets_audit" if empty.
+func NewAuditLog(pool *pgxpool.Pool, tableName string) (*AuditLog, error) {
+	if tableName == "" {
+		tableName = "tickets_audit"
+	}
+	args := renderArgs{TableName: tableName}
+	migrate, err := render(migrateAudit, args)
+	if err != nil {
+		return nil, err
+	}
+	insert, err := render(auditBatch, args)
+	if err != nil {
+		return nil, err
+	}
+	history, err := render(auditHistory, args)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{db: pool, migrate: migrate, insert: insert, history: history}, nil
+}
+
+// Migrate creates the audit table, and the ticket_status type of the
+// search path if missing.
+func (l *AuditLog) Migrate(ctx context.Context) error {
+	if err := migrateSearchPathStatus(ctx, l.db); err != nil {
+		return err
+	}
+	if _, err := l.db.Exec(ctx, l.migrate); err != nil {
+		return fmt.Errorf("failed to create audit table: %w", err)
+	}
+	return nil
+}
+
+// Record inserts the entries with a single multi-row statement.
+func (l *AuditLog) Record(ctx context.Context, entries []lymbo.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ids := make([]string, len(entries))
+	statuses := make([]string, len(entries))
+	actors := make([]string, len(entries))
+	reasons := make([]string, len(entries))
+	ats := make([]time.Time, len(entries))
+	for i, e := range entries {
+		ids[i] = e.TicketID.String()
+		statuses[i] = e.Status.String()
+		actors[i] = e.Actor
+		reasons[i] = e.Reason
+		ats[i] = e.At
+	}
+	_, err := l.db.Exec(ctx, l.insert, ids, statuses, actors, reasons, ats)
+	return err
+}
+
+// History returns the entries of the ticket id, oldest first.
+func (l *AuditLog) History(ctx context.Context, id lymbo.TicketId) ([]lymbo.AuditEntry, error) {
+	rows, err := l.db.Query(ctx, l.history, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []lymbo.AuditEntry
+	for rows.Next() {
+		var e lymbo.AuditEntry
+		var tid, st string
+		if err := rows.Scan(&tid, &st, &e.Actor, &e.Reason, &e.At); err != nil {
+			return nil, err
+		}
+		if e.Status, err = status.FromString(st); err != nil {
+			return nil, err
+		}
+		e.TicketID = lymbo.TicketId(tid)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
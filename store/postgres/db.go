@@ -0,0 +1,242 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// DB is the database a store runs its queries on: a *pgxpool.Pool, or a
+// database/sql pool of the pgx stdlib driver wrapped by SQLDB.
+type DB interface {
+	Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, query string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Begin(ctx context.Context) (pgx.Tx, error)
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+var (
+	_ DB = (*pgxpool.Pool)(nil)
+	_ DB = (*sqlDB)(nil)
+)
+
+// SQLDB returns the DB of db, a database/sql pool of the pgx stdlib driver,
+// e.g. opened with sql.Open("pgx", dsn) or stdlib.OpenDBFromPool, for
+// applications pooling their connections with database/sql. Each query
+// takes a connection of db and runs with pgx on it, so that batches and
+// Listen work as on a *pgxpool.Pool. Queries fail on a db of another driver.
+func SQLDB(db *sql.DB) DB {
+	return &sqlDB{db: db}
+}
+
+type sqlDB struct {
+	db *sql.DB
+}
+
+// conn takes a connection of d, returned to it by the returned func.
+func (d *sqlDB) conn(ctx context.Context) (*pgx.Conn, func(), error) {
+	c, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// pc is used beyond Raw, which is safe as long as c is held: database/sql
+	// neither hands it out nor closes it meanwhile.
+	var pc *pgx.Conn
+	err = c.Raw(func(dc any) error {
+		sc, ok := dc.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("connection %T is not of the pgx stdlib driver", dc)
+		}
+		pc = sc.Conn()
+		return nil
+	})
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	var once sync.Once
+	return pc, func() { once.Do(func() { c.Close() }) }, nil
+}
+
+func (d *sqlDB) Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error) {
+	pc, release, err := d.conn(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer release()
+	return pc.Exec(ctx, query, args...)
+}
+
+func (d *sqlDB) Query(ctx context.Context, query string, args ...any) (pgx.Rows, error) {
+	pc, release, err := d.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := pc.Query(ctx, query, args...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &sqlRows{Rows: rows, release: release}, nil
+}
+
+func (d *sqlDB) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
+	pc, release, err := d.conn(ctx)
+	if err != nil {
+		return errRow{err: err}
+	}
+	return &sqlRow{Row: pc.QueryRow(ctx, query, args...), release: release}
+}
+
+func (d *sqlDB) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	pc, release, err := d.conn(ctx)
+	if err != nil {
+		return errBatch{err: err}
+	}
+	return &sqlBatch{BatchResults: pc.SendBatch(ctx, b), release: release}
+}
+
+func (d *sqlDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return d.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (d *sqlDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	pc, release, err := d.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := pc.BeginTx(ctx, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, release: release}, nil
+}
+
+// sqlRows returns its connection once closed.
+type sqlRows struct {
+	pgx.Rows
+	release func()
+}
+
+func (r *sqlRows) Close() {
+	r.Rows.Close()
+	r.release()
+}
+
+// sqlRow returns its connection once scanned.
+type sqlRow struct {
+	pgx.Row
+	release func()
+}
+
+func (r *sqlRow) Scan(dest ...any) error {
+	defer r.release()
+	return r.Row.Scan(dest...)
+}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...any) error { return r.err }
+
+// sqlBatch returns its connection once closed.
+type sqlBatch struct {
+	pgx.BatchResults
+	release func()
+}
+
+func (b *sqlBatch) Close() error {
+	defer b.release()
+	return b.BatchResults.Close()
+}
+
+type errBatch struct {
+	err error
+}
+
+func (b errBatch) Exec() (pgconn.CommandTag, error) { return pgconn.CommandTag{}, b.err }
+func (b errBatch) Query() (pgx.Rows, error)         { return nil, b.err }
+func (b errBatch) QueryRow() pgx.Row                { return errRow{err: b.err} }
+func (b errBatch) Close() error                     { return b.err }
+
+// sqlTx returns its connection once committed or rolled back.
+type sqlTx struct {
+	pgx.Tx
+	release func()
+}
+
+func (t *sqlTx) Commit(ctx context.Context) error {
+	defer t.release()
+	return t.Tx.Commit(ctx)
+}
+
+func (t *sqlTx) Rollback(ctx context.Context) error {
+	defer t.release()
+	return t.Tx.Rollback(ctx)
+}
+
+// maxConns returns the maximum number of connections of db, 0 if unlimited
+// or unknown.
+func maxConns(db DB) int {
+	switch db := db.(type) {
+	case *pgxpool.Pool:
+		return int(db.Config().MaxConns)
+	case *sqlDB:
+		return db.db.Stats().MaxOpenConnections
+	}
+	return 0
+}
+
+// dedicatedConn takes a connection out of db for a session, e.g. holding a
+// session-level lock. The returned func returns it to db.
+func dedicatedConn(ctx context.Context, db DB) (*pgx.Conn, func(), error) {
+	switch db := db.(type) {
+	case *pgxpool.Pool:
+		pc, err := db.Acquire(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pc.Conn(), pc.Release, nil
+	case *sqlDB:
+		return db.conn(ctx)
+	}
+	return nil, nil, fmt.Errorf("%T has no dedicated connections", db)
+}
+
+// listenConn takes a connection out of db for LISTEN. The returned func
+// closes it, or returns it unlistened to a database/sql pool.
+func listenConn(ctx context.Context, db DB) (*pgx.Conn, func(), error) {
+	switch db := db.(type) {
+	case *pgxpool.Pool:
+		pc, err := db.Acquire(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		// hijacked, so that a connection left listening never goes back to the pool
+		conn := pc.Hijack()
+		return conn, func() { conn.Close(context.Background()) }, nil
+	case *sqlDB:
+		conn, release, err := db.conn(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() {
+			if _, err := conn.Exec(context.Background(), "UNLISTEN *"); err != nil {
+				// closed, so that database/sql discards it
+				conn.Close(context.Background())
+			}
+			release()
+		}, nil
+	}
+	return nil, nil, fmt.Errorf("%T has no dedicated connections", db)
+}
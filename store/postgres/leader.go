@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ochaton/lymbo"
+)
+
+// Leader is a lymbo.Leader holding a session advisory lock: the process
+// whose Leader takes the lock leads until it resigns or its connection is
+// lost, the lock being released with the session, and the next one asking
+// takes over. A leading Leader holds a connection of the pool for itself.
+type Leader struct {
+	pool *pgxpool.Pool
+	key  string
+
+	mu   sync.Mutex
+	conn *pgxpool.Conn
+}
+
+var _ lymbo.Leader = (*Leader)(nil)
+
+// NewLeader returns a candidate of the election name, e.g. "expiration":
+// processes electing the leader of the same tasks must use the same name.
+func NewLeader(pool *pgxpool.Pool, name string) *Leader {
+	return &Leader{pool: pool, key: "lymbo/leader/" + name}
+}
+
+// Elected reports whether l holds the lock, checking that its connection is
+// alive, and tries to take it otherwise.
+func (l *Leader) Elected(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		if err := l.conn.Ping(ctx); err == nil {
+			return true, nil
+		}
+		l.drop()
+	}
+
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, l.key).Scan(&locked); err != nil {
+		conn.Release()
+		return false, err
+	}
+	if !locked {
+		conn.Release()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Resign gives up the leadership of l, if it leads, unlocking the lock and
+// returning its connection to the pool.
+func (l *Leader) Resign(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	if _, err := l.conn.Exec(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, l.key); err != nil {
+		l.drop()
+		return err
+	}
+	l.conn.Release()
+	l.conn = nil
+	return nil
+}
+
+// drop closes the connection of l, which may still hold the lock, rather
+// than returning it to the pool.
+func (l *Leader) drop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l.conn.Conn().Close(ctx)
+	l.conn.Release()
+	l.conn = nil
+}
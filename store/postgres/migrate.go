@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"text/template"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Migration is a version of the schema of the tickets table, as applied by
+// Migrate, e.g. for DBAs applying them with their own tools.
+type Migration struct {
+	// Version is the schema version the migration migrates to, from the
+	// previous one, starting at 1.
+	Version int
+
+	// Up is the SQL migrating to Version.
+	Up string
+
+	// Down is the SQL reverting Up, back to the previous version.
+	Down string
+
+	// NoTx is set if Up and Down can't run in a transaction block, e.g. to
+	// add a value to an enum: each is then a single statement.
+	NoTx bool
+}
+
+// Migrations returns the versions of the schema of the table, oldest first,
+// the last being the one the store requires.
+func (r *Tickets) Migrations() []Migration {
+	return append([]Migration(nil), r.queries.migrations...)
+}
+
+// SchemaVersion returns the version of the schema of the table applied by
+// Migrate or MigrateTo, 0 if none was, e.g. for a table created by an older
+// version of lymbo, which Migrate takes over.
+func (r *Tickets) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := r.db.QueryRow(ctx, r.queries.schemaVersion).Scan(&version)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" {
+		// undefined_table, no migration was applied
+		return 0, nil
+	}
+	return version, err
+}
+
+// Migrate migrates the schema of the table to the latest version, see
+// Migrations, then installs what the Config asks for: the notify trigger,
+// the payload index and the partitions of the coming months. Each version
+// is applied in a transaction of its own, unless NoTx, recorded in the table
+// {TableName}_schema_version with it, and serialized with the Migrate of
+// other processes. Open runs it unless WithoutMigrate is given.
+func (r *Tickets) Migrate(ctx context.Context) error {
+	if r.partitioned {
+		var kind string
+		err := r.db.QueryRow(ctx, r.queries.tableKind).Scan(&kind)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to inspect table %s: %w", r.tableName, err)
+		}
+		if err == nil && kind != "p" {
+			return fmt.Errorf("table %s exists and is not partitioned", r.tableName)
+		}
+	}
+	if err := r.MigrateTo(ctx, len(r.queries.migrations)); err != nil {
+		return err
+	}
+	if r.notify {
+		if _, err := r.db.Exec(ctx, r.queries.migrateNotify); err != nil {
+			return fmt.Errorf("failed to install notify trigger: %w", err)
+		}
+	}
+	if r.payloadIndex {
+		if _, err := r.db.Exec(ctx, r.queries.payloadIndex); err != nil {
+			return fmt.Errorf("failed to create payload index: %w", err)
+		}
+	}
+	if r.partitioned {
+		if err := r.createPartitions(ctx, r.clock.Now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateTo migrates the schema of the table up or down to version, one
+// version at a time as Migrate does, e.g. to roll back an upgrade of lymbo.
+// If a version fails, the table is left at the previous one. Version 0 drops
+// the table and its tickets.
+func (r *Tickets) MigrateTo(ctx context.Context, version int) error {
+	latest := len(r.queries.migrations)
+	if version < 0 || version > latest {
+		return fmt.Errorf("unknown schema version %d, the latest is %d", version, latest)
+	}
+
+	// the versions are applied in transactions of their own, serialized by a
+	// session-level lock on a connection held meanwhile
+	conn, release, err := dedicatedConn(ctx, r.db)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer release()
+
+	if _, err := conn.Exec(ctx, r.queries.lockMigrations); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), r.queries.unlockMigrations); err != nil {
+			// closed, so that the lock goes with the session
+			conn.Close(context.Background())
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, r.queries.migrateVersions); err != nil {
+		return fmt.Errorf("failed to create schema version table: %w", err)
+	}
+	var current int
+	if err := conn.QueryRow(ctx, r.queries.schemaVersion).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > latest {
+		return fmt.Errorf("schema of table %s is at version %d, newer than the latest known %d", r.tableName, current, latest)
+	}
+
+	for v := current + 1; v <= version; v++ {
+		slog.InfoContext(ctx, "Applying migration", "table", r.tableName, "version", v)
+		m := r.queries.migrations[v-1]
+		if err := r.applyMigration(ctx, conn, m.NoTx, m.Up, r.queries.setVersion, v); err != nil {
+			return fmt.Errorf("failed to migrate to version %d: %w", v, err)
+		}
+	}
+	for v := current; v > version; v-- {
+		slog.InfoContext(ctx, "Reverting migration", "table", r.tableName, "version", v)
+		m := r.queries.migrations[v-1]
+		if err := r.applyMigration(ctx, conn, m.NoTx, m.Down, r.queries.unsetVersion, v); err != nil {
+			return fmt.Errorf("failed to revert version %d: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the SQL of a version and records it with record, in
+// a transaction unless noTx. A noTx version applied but not recorded, e.g.
+// on a crash, is applied again: it must be idempotent.
+func (r *Tickets) applyMigration(ctx context.Context, conn *pgx.Conn, noTx bool, sql, record string, version int) error {
+	if noTx {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			return err
+		}
+		_, err := conn.Exec(ctx, record, version)
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, record, version); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// migrateSearchPathStatus creates the ticket_status type of the search path,
+// used by the tables of Archive and AuditLog, as versions 1 and 2 do in the
+// schema of a Tickets store.
+func migrateSearchPathStatus(ctx context.Context, db DB) error {
+	for _, tmpl := range []*template.Template{migrateStatus, migrateDead} {
+		query, err := render(tmpl, renderArgs{})
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to create ticket_status type: %w", err)
+		}
+	}
+	return nil
+}
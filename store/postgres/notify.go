@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ochaton/lymbo"
+)
+
+var _ lymbo.Notifier = &Tickets{}
+
+// Listen implements lymbo.Notifier with LISTEN on a connection taken out of
+// the pool for the subscription, and closed when Listen returns, or
+// returned unlistened to the pool of a SQLDB.
+// Returns lymbo.ErrNotifyDisabled unless Config.Notify is set.
+func (r *Tickets) Listen(ctx context.Context, notify func(runat time.Time)) error {
+	if !r.notify {
+		return lymbo.ErrNotifyDisabled
+	}
+
+	conn, release, err := listenConn(ctx, r.db)
+	if err != nil {
+		return fmt.Errorf("failed to acquire listen connection: %w", err)
+	}
+	defer release()
+
+	channel := pgx.Identifier{qualifier(r.schema) + r.tableName + "_ready"}.Sanitize()
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+		ms, err := strconv.ParseInt(n.Payload, 10, 64)
+		if err != nil {
+			// not ours, e.g. a manual NOTIFY: poll now
+			notify(r.clock.Now())
+			continue
+		}
+		notify(time.UnixMilli(ms))
+	}
+}
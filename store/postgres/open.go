@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ochaton/lymbo"
+)
+
+// OpenOption configures a store created by Open.
+type OpenOption func(*openConfig)
+
+type openConfig struct {
+	tableName       string
+	schema          string
+	maxConcurrentTx int
+	skipMigrate     bool
+	notify          bool
+	partitioned     bool
+	payloadIndex    bool
+	replica         *pgxpool.Pool
+	clock           lymbo.Clock
+	pool            []func(*pgxpool.Config)
+}
+
+// WithTableName sets the tickets table name. Defaults to "tickets".
+func WithTableName(name string) OpenOption {
+	return func(c *openConfig) {
+		c.tableName = name
+	}
+}
+
+// WithSchema sets Config.Schema, the schema of the tables.
+func WithSchema(schema string) OpenOption {
+	return func(c *openConfig) {
+		c.schema = schema
+	}
+}
+
+// WithMaxConcurrentTx sets Config.MaxConcurrentTx.
+func WithMaxConcurrentTx(n int) OpenOption {
+	return func(c *openConfig) {
+		c.maxConcurrentTx = n
+	}
+}
+
+// WithReadReplica sets Config.ReadReplica. The replica pool is owned by
+// the caller and left open by Close.
+func WithReadReplica(pool *pgxpool.Pool) OpenOption {
+	return func(c *openConfig) {
+		c.replica = pool
+	}
+}
+
+// WithNotify sets Config.Notify.
+func WithNotify() OpenOption {
+	return func(c *openConfig) {
+		c.notify = true
+	}
+}
+
+// WithPartitionByMonth sets Config.PartitionByMonth.
+func WithPartitionByMonth() OpenOption {
+	return func(c *openConfig) {
+		c.partitioned = true
+	}
+}
+
+// WithPayloadIndex sets Config.PayloadIndex.
+func WithPayloadIndex() OpenOption {
+	return func(c *openConfig) {
+		c.payloadIndex = true
+	}
+}
+
+// WithClock sets Config.Clock.
+func WithClock(clock lymbo.Clock) OpenOption {
+	return func(c *openConfig) {
+		c.clock = clock
+	}
+}
+
+// WithMaxConns sets the maximum size of the pool.
+func WithMaxConns(n int32) OpenOption {
+	return WithPoolConfig(func(pc *pgxpool.Config) {
+		pc.MaxConns = n
+	})
+}
+
+// WithMaxConnLifetime sets the duration after which a connection is closed and replaced.
+func WithMaxConnLifetime(d time.Duration) OpenOption {
+	return WithPoolConfig(func(pc *pgxpool.Config) {
+		pc.MaxConnLifetime = d
+	})
+}
+
+// WithMaxConnIdleTime sets the duration after which an idle connection is closed.
+func WithMaxConnIdleTime(d time.Duration) OpenOption {
+	return WithPoolConfig(func(pc *pgxpool.Config) {
+		pc.MaxConnIdleTime = d
+	})
+}
+
+// WithPoolConfig modifies the pool configuration parsed from the DSN
+// before the pool is created, for settings without a dedicated option.
+func WithPoolConfig(fn func(*pgxpool.Config)) OpenOption {
+	return func(c *openConfig) {
+		c.pool = append(c.pool, fn)
+	}
+}
+
+// WithoutMigrate skips running Migrate, for schemas managed elsewhere.
+func WithoutMigrate() OpenOption {
+	return func(c *openConfig) {
+		c.skipMigrate = true
+	}
+}
+
+// Open connects to the database at dsn, checks the connection and runs
+// Migrate. The store owns the pool, which is closed by Close.
+//
+// Pool settings default to the pgxpool ones, which the DSN can override
+// with pool_max_conns, pool_max_conn_lifetime, pool_max_conn_idle_time etc.
+// Options take precedence over both.
+func Open(ctx context.Context, dsn string, opts ...OpenOption) (*Tickets, error) {
+	pc, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dsn: %w", err)
+	}
+
+	oc := &openConfig{}
+	for _, opt := range opts {
+		opt(oc)
+	}
+	for _, fn := range oc.pool {
+		fn(pc)
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, pc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	store, err := NewTicketsRepositoryWithConfig(Config{
+		TableName:        oc.tableName,
+		Schema:           oc.schema,
+		Pool:             pool,
+		MaxConcurrentTx:  oc.maxConcurrentTx,
+		ReadReplica:      oc.replica,
+		Notify:           oc.notify,
+		PartitionByMonth: oc.partitioned,
+		PayloadIndex:     oc.payloadIndex,
+		Clock:            oc.clock,
+	})
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	store.ownsPool = true
+
+	if !oc.skipMigrate {
+		if err := store.Migrate(ctx); err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Close closes the pool if the store was created by Open.
+// Stores created from a caller's pool leave it open.
+func (r *Tickets) Close() {
+	if r.ownsPool {
+		r.db.(*pgxpool.Pool).Close()
+	}
+}
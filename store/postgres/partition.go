@@ -0,0 +1,159 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ochaton/lymbo"
+)
+
+// monthsAhead is the number of monthly partitions kept created,
+// the one of the current month included, see Config.PartitionByMonth.
+const monthsAhead = 3
+
+// partitionMonth is the layout of the month suffixing partition names.
+const partitionMonth = "200601"
+
+// monthOf returns the start of the month of t, in UTC.
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// partitionPrefix prefixes the names of the monthly partitions,
+// followed by the month in the partitionMonth layout.
+func (r *Tickets) partitionPrefix() string {
+	return strings.ToLower(r.tableName) + "_p"
+}
+
+// createPartitions creates the partitions of the month of now and of the
+// following ones, so that tickets are never put in the default partition,
+// which would fail creating the partition of their month.
+func (r *Tickets) createPartitions(ctx context.Context, now time.Time) error {
+	from := monthOf(now)
+	for range monthsAhead {
+		to := from.AddDate(0, 1, 0)
+		name := r.partitionPrefix() + from.Format(partitionMonth)
+		query, err := render(createPartition, renderArgs{
+			TableName: r.tableName,
+			Qualifier: qualifier(r.schema),
+			Partition: name,
+			From:      from,
+			To:        to,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := r.db.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", name, err)
+		}
+		from = to
+	}
+	return nil
+}
+
+// dropPartitions drops the partitions of the months ended by now whose
+// tickets have all expired, expireArgs being the arguments of the expired
+// query fragment, and returns how many tickets they held. With an archive,
+// only a native one, see Archive, lets partitions be dropped: their tickets
+// are copied to it first.
+func (r *Tickets) dropPartitions(ctx context.Context, now time.Time, expireArgs []any, archive lymbo.ArchiveStore) (int64, error) {
+	a, native := r.native(archive)
+	if archive != nil && !native {
+		return 0, nil
+	}
+
+	rows, err := r.db.Query(ctx, r.queries.partitions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	var (
+		dropped int64
+		errs    []error
+	)
+	for _, name := range names {
+		suffix, ok := strings.CutPrefix(name, r.partitionPrefix())
+		if !ok {
+			continue
+		}
+		month, err := time.Parse(partitionMonth, suffix)
+		if err != nil || month.AddDate(0, 1, 0).After(now) {
+			continue
+		}
+		n, err := r.dropPartition(ctx, name, expireArgs, a)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to drop partition %s: %w", name, err))
+			continue
+		}
+		dropped += n
+	}
+	return dropped, errors.Join(errs...)
+}
+
+// dropPartition detaches and drops the partition if all its tickets have
+// expired, copying them to the archive a if not nil, and returns how many it
+// held. Writes to the partition are blocked while it is checked and copied,
+// the rest of the table only while it is detached.
+func (r *Tickets) dropPartition(ctx context.Context, name string, expireArgs []any, a *Archive) (int64, error) {
+	args := renderArgs{TableName: r.tableName, Qualifier: qualifier(r.schema), Partition: name}
+	if a != nil {
+		args.Archive = a.tableName
+	}
+	lock, err := render(lockPartition, args)
+	if err != nil {
+		return 0, err
+	}
+	count, err := render(countPartition, args)
+	if err != nil {
+		return 0, err
+	}
+	drop, err := render(dropPartition, args)
+	if err != nil {
+		return 0, err
+	}
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, lock); err != nil {
+		return 0, err
+	}
+	var total, live int64
+	if err := tx.QueryRow(ctx, count, expireArgs...).Scan(&total, &live); err != nil {
+		return 0, err
+	}
+	if live > 0 {
+		return 0, nil
+	}
+	if a != nil {
+		archived, err := render(archivePartition, args)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(ctx, archived); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec(ctx, drop); err != nil {
+		return 0, err
+	}
+	return total, tx.Commit(ctx)
+}
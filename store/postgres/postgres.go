@@ -14,6 +14,18 @@
 //		Pool:      pool,
 //	})
 //	store.Migrate(ctx)
+//
+// Or let the store manage its own pool:
+//
+//	store, err := postgres.Open(ctx, dsn)  // connects and migrates
+//	defer store.Close()
+//
+// Or run it on a database/sql pool of the pgx stdlib driver:
+//
+//	db, _ := sql.Open("pgx", dsn)
+//	store, err := postgres.NewTicketsRepositoryWithConfig(postgres.Config{
+//		DB: postgres.SQLDB(db),
+//	})
 package postgres
 
 import (
@@ -23,28 +35,104 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/ochaton/lymbo"
 	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/internal/storeutil"
 )
 
 type Config struct {
 	TableName string
 	Pool      *pgxpool.Pool
+
+	// DB, if Pool is nil, is the database the store runs its queries on,
+	// e.g. a database/sql pool wrapped by SQLDB.
+	DB DB
+
+	// Schema is the schema of the tables of the store, created by Migrate
+	// if missing, so that several applications can share a database with
+	// tables of the same name. Defaults to the first schema of the search
+	// path, usually public. The ticket_status type is created in the schema
+	// too, while the tables of Archive and AuditLog stay in the search path
+	// with its ticket_status type unless their names are qualified.
+	Schema string
+
+	// MaxConcurrentTx limits how many connections lymbo holds at once for
+	// transactions, batches and polls, so it can't starve other users of a
+	// shared pool. Defaults to half of the pool's MaxConns, or of the
+	// MaxOpenConns of a SQLDB if limited; negative means unlimited.
+	MaxConcurrentTx int
+
+	// ReadReplica, if set, serves the read-only queries Get, List and ListInFlight,
+	// offloading dashboards and the like from Pool. Replication lag applies:
+	// a ticket may be read back missing or stale right after a write.
+	// Polls, writes and Exists, which routes writes in multi stores, stay on
+	// Pool, as do the reads of a lymbo.PrimaryContext.
+	ReadReplica *pgxpool.Pool
+
+	// Notify makes Migrate install a trigger notifying the {TableName}_ready
+	// channel whenever a ticket becomes pending, and enables Listen, so that
+	// Kharon polls as soon as a ticket is due instead of at its next poll.
+	Notify bool
+
+	// PartitionByMonth makes Migrate create the table partitioned by month of
+	// ctime, and ExpireTickets create the partitions of the coming months and
+	// drop those of past months once all their tickets have expired, instead
+	// of deleting their rows. Only new tables are partitioned: Migrate fails
+	// on an existing table that isn't. The primary key is then (id, ctime):
+	// IDs and the unique keys of pending tickets are kept unique across
+	// partitions by the tables {TableName}_ids and {TableName}_keys, and a
+	// ticket put again keeps the Ctime it was first put with.
+	// Requires PostgreSQL 13+.
+	PartitionByMonth bool
+
+	// PayloadIndex makes Migrate create a GIN index of the payloads, so that
+	// Search finds tickets by their content without scanning the table.
+	// The index is built while locking the table against writes, which may
+	// take a while on large tables: create it concurrently beforehand as
+	// idx_{TableName}_payload to avoid it.
+	PayloadIndex bool
+
+	// Clock tells the time of the tickets put or updated, e.g. the
+	// lymbo.FakeClock of the Kharon, see lymbo.Settings.WithClock.
+	// Defaults to lymbo.SystemClock. Timestamps set by the database, e.g.
+	// the mtime of a ticket whose status is changed by a query, are unaffected.
+	Clock lymbo.Clock
 }
 
 type Tickets struct {
-	db        *pgxpool.Pool
+	db        DB
+	replica   *pgxpool.Pool
 	queries   *Queries
 	tableName string
+	schema    string
+	sem       chan struct{}
+	notify    bool
+	clock     lymbo.Clock
+
+	// payloadIndex is set by Config.PayloadIndex.
+	payloadIndex bool
+
+	// partitioned is set by Config.PartitionByMonth.
+	partitioned bool
+
+	// ownsPool is set by Open, the pool is then closed by Close.
+	ownsPool bool
 }
 
-var _ lymbo.Store = &Tickets{}
+var (
+	_ lymbo.Store    = &Tickets{}
+	_ lymbo.Searcher = &Tickets{}
+)
 
 func NewTicketsRepository(pool *pgxpool.Pool) *Tickets {
 	t, err := NewTicketsRepositoryWithConfig(Config{
@@ -61,38 +149,71 @@ func NewTicketsRepositoryWithConfig(cfg Config) (*Tickets, error) {
 	if cfg.TableName == "" {
 		cfg.TableName = `tickets`
 	}
+	if cfg.Clock == nil {
+		cfg.Clock = lymbo.SystemClock
+	}
 
-	queries, err := newQueries(cfg.TableName)
+	queries, err := newQueries(cfg.Schema, cfg.TableName, cfg.PartitionByMonth)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize query templates: %w", err)
 	}
 
+	db := cfg.DB
+	if cfg.Pool != nil {
+		db = cfg.Pool
+	}
+
+	if n := maxConns(db); cfg.MaxConcurrentTx == 0 && n > 0 {
+		cfg.MaxConcurrentTx = max(1, n/2)
+	}
+
+	var sem chan struct{}
+	if cfg.MaxConcurrentTx > 0 {
+		sem = make(chan struct{}, cfg.MaxConcurrentTx)
+	}
+
 	return &Tickets{
-		db:        cfg.Pool,
+		db:        db,
+		replica:   cfg.ReadReplica,
 		tableName: cfg.TableName,
+		schema:    cfg.Schema,
 		queries:   queries,
+		sem:       sem,
+		notify:    cfg.Notify,
+		clock:     cfg.Clock,
+
+		payloadIndex: cfg.PayloadIndex,
+		partitioned:  cfg.PartitionByMonth,
 	}, nil
 }
 
-// Migrate runs the embedded migrations to set up the database schema
-// All migrations are executed in a single transaction to ensure atomicity
-func (r *Tickets) Migrate(ctx context.Context) error {
-	slog.InfoContext(ctx, "Applying migration", "sql", r.queries.migrate)
-	_, err := r.db.Exec(ctx, r.queries.migrate)
-	if err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+// reader returns the pool serving the read-only queries of ctx, the
+// primary one if ctx asks for it, see lymbo.PrimaryContext.
+func (r *Tickets) reader(ctx context.Context) DB {
+	if r.replica != nil && !lymbo.ReadsPrimary(ctx) {
+		return r.replica
 	}
-
-	return nil
+	return r.db
 }
 
-func (r *Tickets) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
-	ticketUUID, err := uuid.Parse(id.String())
-	if err != nil {
-		return lymbo.Ticket{}, lymbo.ErrTicketIDInvalid
+// acquire takes a slot for an operation holding a pool connection across
+// several round trips. The returned func releases it.
+func (r *Tickets) acquire(ctx context.Context) (func(), error) {
+	if r.sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case r.sem <- struct{}{}:
+		return func() { <-r.sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
 
+// scanTicket scans a row of the columns selected by the `get` query.
+func scanTicket(row pgx.Row) (lymbo.Ticket, error) {
 	var (
+		id          uuid.UUID
 		statusStr   string
 		runat       pgtype.Timestamptz
 		nice        int16
@@ -102,10 +223,21 @@ func (r *Tickets) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, err
 		attempts    int32
 		payload     []byte
 		errorReason []byte
+		labelsJSON  []byte
+		metaJSON    []byte
+		lease       pgtype.Text
+		queue       string
+		uniqueKey   pgtype.Text
+		result      []byte
+		deadline    pgtype.Timestamptz
+		attemptLog  []byte
+		owner       pgtype.Text
+		dependsOn   []byte
+		tenantID    string
 	)
 
-	err = r.db.QueryRow(ctx, r.queries.get, ticketUUID).Scan(
-		&ticketUUID,
+	err := row.Scan(
+		&id,
 		&statusStr,
 		&runat,
 		&nice,
@@ -115,11 +247,19 @@ func (r *Tickets) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, err
 		&attempts,
 		&payload,
 		&errorReason,
+		&labelsJSON,
+		&metaJSON,
+		&lease,
+		&queue,
+		&uniqueKey,
+		&result,
+		&deadline,
+		&attemptLog,
+		&owner,
+		&dependsOn,
+		&tenantID,
 	)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return lymbo.Ticket{}, lymbo.ErrTicketNotFound
-		}
 		return lymbo.Ticket{}, err
 	}
 
@@ -128,36 +268,352 @@ func (r *Tickets) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, err
 		return lymbo.Ticket{}, err
 	}
 
+	labels, err := unmarshalMap("labels", labelsJSON)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	metadata, err := unmarshalMap("metadata", metaJSON)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	reason, err := unmarshalReason(errorReason)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	log, err := unmarshalAttemptLog(attemptLog)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	deps, err := unmarshalDependsOn(dependsOn)
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+
 	var mtimePtr *time.Time
 	if mtime.Valid {
 		mtimePtr = &mtime.Time
 	}
 
 	return lymbo.Ticket{
-		ID:          lymbo.TicketId(ticketUUID.String()),
+		ID:          lymbo.TicketId(id.String()),
 		Status:      s,
 		Runat:       runat.Time,
 		Nice:        int(nice),
 		Type:        ticketType,
+		Queue:       queue,
+		UniqueKey:   uniqueKey.String,
 		Ctime:       ctime.Time,
 		Mtime:       mtimePtr,
 		Attempts:    int(attempts),
+		Lease:       lease.String,
+		Owner:       owner.String,
 		Payload:     payload,
-		ErrorReason: errorReason,
+		ErrorReason: reason,
+		Labels:      labels,
+		Metadata:    metadata,
+		Result:      resultValue(result),
+		Deadline:    timeValue(deadline),
+		AttemptLog:  log,
+		DependsOn:   deps,
+		TenantID:    tenantID,
 	}, nil
 }
 
+// timeValue maps a NULL timestamp to a nil time.
+func timeValue(t pgtype.Timestamptz) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// resultValue maps a NULL result to a nil Result.
+func resultValue(data []byte) any {
+	if data == nil {
+		return nil
+	}
+	return data
+}
+
+// leaseParam maps an empty lease to NULL.
+func leaseParam(lease string) pgtype.Text {
+	return pgtype.Text{String: lease, Valid: lease != ""}
+}
+
+// marshalMap encodes m for a NOT NULL JSONB column, labels or metadata.
+func marshalMap(column string, m map[string]string) ([]byte, error) {
+	if len(m) == 0 {
+		return []byte("{}"), nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", column, err)
+	}
+	return data, nil
+}
+
+func unmarshalMap(column string, data []byte) (map[string]string, error) {
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", column, err)
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// unmarshalReason decodes the nullable error_reason column.
+func unmarshalReason(data []byte) (*lymbo.ErrorInfo, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var reason *lymbo.ErrorInfo
+	if err := json.Unmarshal(data, &reason); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal error_reason: %w", err)
+	}
+	return reason, nil
+}
+
+// unmarshalAttemptLog decodes the nullable attempt_log column.
+func unmarshalAttemptLog(data []byte) ([]lymbo.Attempt, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var log []lymbo.Attempt
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal attempt_log: %w", err)
+	}
+	return log, nil
+}
+
+// unmarshalDependsOn decodes the nullable depends_on column.
+func unmarshalDependsOn(data []byte) ([]lymbo.TicketId, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var ids []lymbo.TicketId
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal depends_on: %w", err)
+	}
+	return ids, nil
+}
+
+func (r *Tickets) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
+	ticketUUID, err := uuid.Parse(id.String())
+	if err != nil {
+		return lymbo.Ticket{}, lymbo.ErrTicketIDInvalid
+	}
+
+	ticket, err := scanTicket(r.reader(ctx).QueryRow(ctx, r.queries.get, ticketUUID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return lymbo.Ticket{}, lymbo.ErrTicketNotFound
+	}
+	return ticket, err
+}
+
+// GetResult reads only the status and result of the ticket.
+func (r *Tickets) GetResult(ctx context.Context, id lymbo.TicketId) (any, error) {
+	ticketUUID, err := uuid.Parse(id.String())
+	if err != nil {
+		return nil, lymbo.ErrTicketIDInvalid
+	}
+
+	var (
+		statusStr string
+		result    []byte
+	)
+	err = r.reader(ctx).QueryRow(ctx, r.queries.getResult, ticketUUID).Scan(&statusStr, &result)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, lymbo.ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if statusStr == status.Pending.String() {
+		return nil, lymbo.ErrResultNotReady
+	}
+	return resultValue(result), nil
+}
+
+func (r *Tickets) Exists(ctx context.Context, id lymbo.TicketId) (bool, error) {
+	ticketUUID, err := uuid.Parse(id.String())
+	if err != nil {
+		return false, lymbo.ErrTicketIDInvalid
+	}
+
+	var exists bool
+	if err := r.db.QueryRow(ctx, r.queries.exists, ticketUUID).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
 func (r *Tickets) Put(ctx context.Context, ticket lymbo.Ticket) error {
+	args, err := putArgs(ticket, r.clock.Now())
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(ctx, r.queries.put, args...)
+	return r.duplicate(err)
+}
+
+// PutBatch upserts the tickets with a single multi-row statement.
+// Tickets failing validation, e.g. with an ID that isn't a UUID, are
+// reported in errs and left out, as are duplicates of a pending UniqueKey.
+func (r *Tickets) PutBatch(ctx context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs := storeutil.NewBatchErrors(len(tickets))
+	cols, index := batchColumns(tickets, r.clock.Now(), errs.Set)
+	if len(cols.id) == 0 {
+		return errs.Errs(), nil
+	}
+
+	rows, err := r.db.Query(ctx, r.queries.putBatch, cols.args()...)
+	if err != nil {
+		return nil, r.duplicate(err)
+	}
+	written, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, r.duplicate(err)
+	}
+	if len(written) < len(cols.id) {
+		ok := make(map[string]struct{}, len(written))
+		for _, id := range written {
+			ok[id.String()] = struct{}{}
+		}
+		for j, id := range cols.id {
+			if _, put := ok[id]; !put {
+				errs.Set(index[j], lymbo.ErrDuplicateTicket)
+			}
+		}
+	}
+	return errs.Errs(), nil
+}
+
+// batchColumns returns the columns of the tickets to put with the `put_batch`
+// query, and the index in tickets of each row. The last of several tickets
+// with the same ID wins, as with successive Puts, and the first of several
+// pending with the same UniqueKey and Type: fail is called with the index and
+// error of every ticket left out.
+func batchColumns(tickets []lymbo.Ticket, now time.Time, fail func(int, error)) (putBatchColumns, []int) {
+	var cols putBatchColumns
+	seen := make(map[lymbo.TicketId]int, len(tickets))
+	for i := len(tickets) - 1; i >= 0; i-- {
+		if _, dup := seen[tickets[i].ID]; dup {
+			continue
+		}
+		if _, err := uuid.Parse(tickets[i].ID.String()); err != nil {
+			fail(i, lymbo.ErrTicketIDInvalid)
+			continue
+		}
+		seen[tickets[i].ID] = i
+	}
+
+	held := make(map[storeutil.UniqueKey]struct{})
+	var index []int
+	for i, t := range tickets {
+		if last, ok := seen[t.ID]; !ok || last != i {
+			continue
+		}
+		if u, ok := storeutil.Unique(t); ok {
+			if _, dup := held[u]; dup {
+				fail(i, lymbo.ErrDuplicateTicket)
+				continue
+			}
+			held[u] = struct{}{}
+		}
+		args, err := putArgs(t, now)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		cols.add(args)
+		index = append(index, i)
+	}
+	return cols, index
+}
+
+// duplicate maps a violation of the unique key index, or of the one of a
+// partition, to ErrDuplicateTicket.
+func (r *Tickets) duplicate(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+	prefix := "idx_" + strings.ToLower(r.tableName) + "_"
+	name := pgErr.ConstraintName
+	if name == prefix+"unique" || r.partitioned && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, "_unique") {
+		return lymbo.ErrDuplicateTicket
+	}
+	return err
+}
+
+// putBatchColumns are the arrays of the `put_batch` query.
+type putBatchColumns struct {
+	id, status, typ, queue      []string
+	tenant                      []string
+	labels, metadata            []string
+	runat, ctime, mtime         []pgtype.Timestamptz
+	deadline                    []pgtype.Timestamptz
+	nice                        []int16
+	attempts                    []int32
+	payload, errorReason, lease []pgtype.Text
+	uniqueKey, result           []pgtype.Text
+	attemptLog, owner           []pgtype.Text
+	dependsOn                   []pgtype.Text
+}
+
+// add appends a ticket given by its putArgs.
+func (c *putBatchColumns) add(args []any) {
+	jsonText := func(data any) pgtype.Text {
+		b := data.([]byte)
+		return pgtype.Text{String: string(b), Valid: b != nil}
+	}
+	c.id = append(c.id, args[0].(uuid.UUID).String())
+	c.status = append(c.status, args[1].(string))
+	c.runat = append(c.runat, args[2].(pgtype.Timestamptz))
+	c.nice = append(c.nice, args[3].(int16))
+	c.typ = append(c.typ, args[4].(string))
+	c.ctime = append(c.ctime, args[5].(pgtype.Timestamptz))
+	c.mtime = append(c.mtime, args[6].(pgtype.Timestamptz))
+	c.attempts = append(c.attempts, args[7].(int32))
+	c.payload = append(c.payload, jsonText(args[8]))
+	c.errorReason = append(c.errorReason, jsonText(args[9]))
+	c.labels = append(c.labels, string(args[10].([]byte)))
+	c.metadata = append(c.metadata, string(args[11].([]byte)))
+	c.lease = append(c.lease, args[12].(pgtype.Text))
+	c.queue = append(c.queue, args[13].(string))
+	c.uniqueKey = append(c.uniqueKey, args[14].(pgtype.Text))
+	c.result = append(c.result, jsonText(args[15]))
+	c.deadline = append(c.deadline, args[16].(pgtype.Timestamptz))
+	c.attemptLog = append(c.attemptLog, jsonText(args[17]))
+	c.owner = append(c.owner, args[18].(pgtype.Text))
+	c.dependsOn = append(c.dependsOn, jsonText(args[19]))
+	c.tenant = append(c.tenant, args[20].(string))
+}
+
+// args returns the arguments of the `put_batch` query.
+func (c *putBatchColumns) args() []any {
+	return []any{
+		c.id, c.status, c.runat, c.nice, c.typ, c.ctime,
+		c.mtime, c.attempts, c.payload, c.errorReason, c.labels, c.metadata, c.lease, c.queue, c.uniqueKey, c.result,
+		c.deadline, c.attemptLog, c.owner, c.dependsOn, c.tenant,
+	}
+}
+
+// putArgs returns the arguments of the `put` query, with a zero Status
+// stored as pending and a zero Ctime as now.
+func putArgs(ticket lymbo.Ticket, now time.Time) ([]any, error) {
 	ticketUUID, err := uuid.Parse(ticket.ID.String())
 	if err != nil {
-		return lymbo.ErrTicketIDInvalid
+		return nil, lymbo.ErrTicketIDInvalid
 	}
 
 	var payload []byte
 	if ticket.Payload != nil {
 		payload, err = json.Marshal(ticket.Payload)
 		if err != nil {
-			return fmt.Errorf("failed to marshal payload: %w", err)
+			return nil, fmt.Errorf("failed to marshal payload: %w", err)
 		}
 	}
 
@@ -165,16 +621,59 @@ func (r *Tickets) Put(ctx context.Context, ticket lymbo.Ticket) error {
 	if ticket.ErrorReason != nil {
 		errorReason, err = json.Marshal(ticket.ErrorReason)
 		if err != nil {
-			return fmt.Errorf("failed to marshal error_reason: %w", err)
+			return nil, fmt.Errorf("failed to marshal error_reason: %w", err)
+		}
+	}
+
+	var result []byte
+	if ticket.Result != nil {
+		result, err = json.Marshal(ticket.Result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal result: %w", err)
+		}
+	}
+
+	var attemptLog []byte
+	if len(ticket.AttemptLog) > 0 {
+		attemptLog, err = json.Marshal(ticket.AttemptLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal attempt_log: %w", err)
+		}
+	}
+
+	var dependsOn []byte
+	if len(ticket.DependsOn) > 0 {
+		dependsOn, err = json.Marshal(ticket.DependsOn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal depends_on: %w", err)
 		}
 	}
 
-	var mtime pgtype.Timestamptz
+	var mtime, deadline pgtype.Timestamptz
 	if ticket.Mtime != nil {
 		mtime = pgtype.Timestamptz{Time: *ticket.Mtime, Valid: true}
 	}
+	if ticket.Deadline != nil {
+		deadline = pgtype.Timestamptz{Time: *ticket.Deadline, Valid: true}
+	}
+
+	labels, err := marshalMap("labels", ticket.Labels)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := marshalMap("metadata", ticket.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if ticket.Status == (status.Status{}) {
+		ticket.Status = status.Pending
+	}
+	if ticket.Ctime.IsZero() {
+		ticket.Ctime = now
+	}
 
-	_, err = r.db.Exec(ctx, r.queries.put,
+	return []any{
 		ticketUUID,
 		ticket.Status.String(),
 		pgtype.Timestamptz{Time: ticket.Runat, Valid: true},
@@ -185,8 +684,18 @@ func (r *Tickets) Put(ctx context.Context, ticket lymbo.Ticket) error {
 		int32(ticket.Attempts),
 		payload,
 		errorReason,
-	)
-	return err
+		labels,
+		metadata,
+		leaseParam(ticket.Lease),
+		ticket.Queue,
+		pgtype.Text{String: ticket.UniqueKey, Valid: ticket.UniqueKey != ""},
+		result,
+		deadline,
+		attemptLog,
+		pgtype.Text{String: ticket.Owner, Valid: ticket.Owner != ""},
+		dependsOn,
+		ticket.TenantID,
+	}, nil
 }
 
 func (r *Tickets) Delete(ctx context.Context, id lymbo.TicketId) error {
@@ -220,6 +729,12 @@ func (r *Tickets) DeleteBatch(ctx context.Context, ids []lymbo.TicketId) error {
 		batch.Queue(r.queries.delete, ticketUUID)
 	}
 
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	br := r.db.SendBatch(ctx, batch)
 	defer br.Close()
 
@@ -238,36 +753,19 @@ func (r *Tickets) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.Update
 		return lymbo.ErrTicketIDInvalid
 	}
 
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	var (
-		statusStr   string
-		runat       pgtype.Timestamptz
-		nice        int16
-		ticketType  string
-		ctime       pgtype.Timestamptz
-		mtime       pgtype.Timestamptz
-		attempts    int32
-		payload     []byte
-		errorReason []byte
-	)
-
-	err = tx.QueryRow(ctx, r.queries.get, ticketUUID).Scan(
-		&ticketUUID,
-		&statusStr,
-		&runat,
-		&nice,
-		&ticketType,
-		&ctime,
-		&mtime,
-		&attempts,
-		&payload,
-		&errorReason,
-	)
+	ticket, err := scanTicket(tx.QueryRow(ctx, r.queries.get, ticketUUID))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return lymbo.ErrTicketNotFound
@@ -275,70 +773,17 @@ func (r *Tickets) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.Update
 		return err
 	}
 
-	s, err := status.FromString(statusStr)
-	if err != nil {
-		return err
-	}
-
-	var mtimePtr *time.Time
-	if mtime.Valid {
-		mtimePtr = &mtime.Time
-	}
-
-	ticket := lymbo.Ticket{
-		ID:          lymbo.TicketId(ticketUUID.String()),
-		Status:      s,
-		Runat:       runat.Time,
-		Nice:        int(nice),
-		Type:        ticketType,
-		Ctime:       ctime.Time,
-		Mtime:       mtimePtr,
-		Attempts:    int(attempts),
-		Payload:     payload,
-		ErrorReason: errorReason,
-	}
-
 	if err := fn(ctx, &ticket); err != nil {
 		return err
 	}
 
-	// Re-marshal payload and error_reason
-	var updatedPayload []byte
-	if ticket.Payload != nil {
-		updatedPayload, err = json.Marshal(ticket.Payload)
-		if err != nil {
-			return fmt.Errorf("failed to marshal payload: %w", err)
-		}
-	}
-
-	var updatedErrorReason []byte
-	if ticket.ErrorReason != nil {
-		updatedErrorReason, err = json.Marshal(ticket.ErrorReason)
-		if err != nil {
-			return fmt.Errorf("failed to marshal error_reason: %w", err)
-		}
-	}
-
-	var updatedMtime pgtype.Timestamptz
-	if ticket.Mtime != nil {
-		updatedMtime = pgtype.Timestamptz{Time: *ticket.Mtime, Valid: true}
-	}
-
-	_, err = tx.Exec(ctx, r.queries.put,
-		ticketUUID,
-		ticket.Status.String(),
-		pgtype.Timestamptz{Time: ticket.Runat, Valid: true},
-		int16(ticket.Nice),
-		ticket.Type,
-		pgtype.Timestamptz{Time: ticket.Ctime, Valid: true},
-		updatedMtime,
-		int32(ticket.Attempts),
-		updatedPayload,
-		updatedErrorReason,
-	)
+	args, err := putArgs(ticket, r.clock.Now())
 	if err != nil {
 		return err
 	}
+	if _, err := tx.Exec(ctx, r.queries.put, args...); err != nil {
+		return r.duplicate(err)
+	}
 
 	return tx.Commit(ctx)
 }
@@ -350,11 +795,15 @@ type updateSetParams struct {
 	runat        sql.NullTime   // $4
 	payload      []byte         // $5
 	error_reason []byte         // $6
+	lease        pgtype.Text    // $7 for update, $9 for backoff
+	result       []byte         // $8 for update, $10 for backoff
+	attempt      []byte         // $9 for update, $11 for backoff
 }
 
 func updateOne(tid uuid.UUID, us lymbo.UpdateSet) (*updateSetParams, error) {
 	usp := &updateSetParams{
-		id: tid,
+		id:    tid,
+		lease: leaseParam(us.Lease),
 	}
 
 	if us.Status != nil {
@@ -380,44 +829,175 @@ func updateOne(tid uuid.UUID, us lymbo.UpdateSet) (*updateSetParams, error) {
 		}
 		usp.error_reason = errorReason
 	}
+	if us.Result != nil {
+		result, err := json.Marshal(us.Result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal result: %w", err)
+		}
+		usp.result = result
+	}
+	if us.Attempt != nil {
+		attempt, err := json.Marshal(us.Attempt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal attempt: %w", err)
+		}
+		usp.attempt = attempt
+	}
 	return usp, nil
 }
 
 func (r *Tickets) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
-	ticketUUID, err := uuid.Parse(us.Id.String())
+	query, args, err := r.updateArgs(us)
 	if err != nil {
-		return lymbo.ErrTicketIDInvalid
+		return err
+	}
+	tag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return r.duplicate(err)
+	}
+	return checkLease(us, tag)
+}
+
+// updateArgs returns the `update` query, or `backoff` if us.Backoff is set,
+// with its arguments.
+func (r *Tickets) updateArgs(us lymbo.UpdateSet) (string, []any, error) {
+	ticketUUID, err := uuid.Parse(us.Id.String())
+	if err != nil {
+		return "", nil, lymbo.ErrTicketIDInvalid
 	}
 
 	usp, err := updateOne(ticketUUID, us)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
-	switch {
-	case us.Backoff != nil:
-		_, err = r.db.Exec(ctx, r.queries.backoff,
+	if us.Backoff != nil {
+		return r.queries.backoff, []any{
 			usp.id,
 			usp.status,
 			usp.nice,
-			us.Backoff.Jitter.Milliseconds(),
+			us.Backoff.Jitter.Seconds(),
 			us.Backoff.Base,
 			int32(us.Backoff.MaxDelay.Seconds()),
 			usp.payload,
 			usp.error_reason,
-		)
+			usp.lease,
+			usp.result,
+			usp.attempt,
+		}, nil
+	}
+	return r.queries.update, []any{
+		usp.id,
+		usp.status,
+		usp.nice,
+		usp.runat,
+		usp.payload,
+		usp.error_reason,
+		usp.lease,
+		usp.result,
+		usp.attempt,
+	}, nil
+}
+
+// Settle writes the outcome and the follow-up tickets in a single transaction.
+func (r *Tickets) Settle(ctx context.Context, s lymbo.Settlement) error {
+	ticketUUID, err := uuid.Parse(s.Update.Id.String())
+	if err != nil {
+		return lymbo.ErrTicketIDInvalid
+	}
+
+	now := r.clock.Now()
+	next := make([][]any, 0, len(s.Next))
+	for _, t := range s.Next {
+		args, err := putArgs(t, now)
+		if err != nil {
+			return err
+		}
+		next = append(next, args)
+	}
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	switch {
+	case s.Delete:
+		tag, err := tx.Exec(ctx, r.queries.deleteLeased, ticketUUID, leaseParam(s.Update.Lease))
+		if err != nil {
+			return err
+		}
+		if err := checkSettled(s.Update, tag); err != nil {
+			return err
+		}
+	case s.Func != nil:
+		ticket, err := scanTicket(tx.QueryRow(ctx, r.queries.lock, ticketUUID))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return lymbo.ErrTicketNotFound
+			}
+			return err
+		}
+		if s.Update.Lease != "" && ticket.Lease != s.Update.Lease {
+			return lymbo.ErrLeaseLost
+		}
+		if err := s.Func(ctx, &ticket); err != nil {
+			return err
+		}
+		args, err := putArgs(ticket, now)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, r.queries.put, args...); err != nil {
+			return r.duplicate(err)
+		}
 	default:
-		_, err = r.db.Exec(ctx, r.queries.update,
-			usp.id,
-			usp.status,
-			usp.nice,
-			usp.runat,
-			usp.payload,
-			usp.error_reason,
-		)
+		query, args, err := r.updateArgs(s.Update)
+		if err != nil {
+			return err
+		}
+		tag, err := tx.Exec(ctx, query, args...)
+		if err != nil {
+			return r.duplicate(err)
+		}
+		if err := checkSettled(s.Update, tag); err != nil {
+			return err
+		}
 	}
 
-	return err
+	for _, args := range next {
+		if _, err := tx.Exec(ctx, r.queries.put, args...); err != nil {
+			return r.duplicate(err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// checkSettled reports a settlement that matched no row.
+func checkSettled(us lymbo.UpdateSet, tag pgconn.CommandTag) error {
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+	if us.Lease != "" {
+		return lymbo.ErrLeaseLost
+	}
+	return lymbo.ErrTicketNotFound
+}
+
+// checkLease reports a lease-conditional update that matched no row:
+// the ticket was claimed again, or removed.
+func checkLease(us lymbo.UpdateSet, tag pgconn.CommandTag) error {
+	if us.Lease != "" && tag.RowsAffected() == 0 {
+		return lymbo.ErrLeaseLost
+	}
+	return nil
 }
 
 func (r *Tickets) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
@@ -425,45 +1005,109 @@ func (r *Tickets) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) er
 		return nil
 	}
 	batch := &pgx.Batch{}
-
 	for _, us := range updates {
-		ticketUUID, err := uuid.Parse(us.Id.String())
-		if err != nil {
-			return lymbo.ErrTicketIDInvalid
-		}
-
-		usp, err := updateOne(ticketUUID, us)
+		query, args, err := r.updateArgs(us)
 		if err != nil {
 			return err
 		}
+		batch.Queue(query, args...)
+	}
 
-		batch.Queue(r.queries.update,
-			usp.id,
-			usp.status,
-			usp.nice,
-			usp.runat,
-			usp.payload,
-			usp.error_reason,
-		)
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	br := r.db.SendBatch(ctx, batch)
 	defer br.Close()
 
-	_, err := br.Exec()
-	if err != nil {
+	var errs []error
+	for _, us := range updates {
+		tag, err := br.Exec()
+		if err != nil {
+			return r.duplicate(err)
+		}
+		if err := checkLease(us, tag); err != nil {
+			errs = append(errs, fmt.Errorf("ticket %s: %w", us.Id, err))
+		}
+	}
+	if err := br.Close(); err != nil {
 		return err
 	}
+	return errors.Join(errs...)
+}
+
+func (r *Tickets) Reschedule(ctx context.Context, id lymbo.TicketId, runat time.Time) error {
+	ticketUUID, err := uuid.Parse(id.String())
+	if err != nil {
+		return lymbo.ErrTicketIDInvalid
+	}
 
+	var (
+		statusStr   string
+		rescheduled bool
+	)
+	err = r.db.QueryRow(ctx, r.queries.reschedule,
+		ticketUUID,
+		pgtype.Timestamptz{Time: runat, Valid: true},
+	).Scan(&statusStr, &rescheduled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return lymbo.ErrTicketNotFound
+		}
+		return err
+	}
+	if !rescheduled {
+		return lymbo.ErrInvalidStatusTransition
+	}
 	return nil
 }
 
+// Touch reschedules the ticket to now + extendBy, see Reschedule.
+func (r *Tickets) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	return r.Reschedule(ctx, id, r.clock.Now().Add(extendBy))
+}
+
+// dropStaleBatchSize bounds how many stale tickets a single poll cancels,
+// so that a large backlog is dropped over several polls.
+const dropStaleBatchSize = 1000
+
+// backoffSamples is the number of attempts for which the delays of a
+// custom backoff are passed to the poll query; later attempts reuse the last one.
+const backoffSamples = 32
+
+// backoffDelays samples the custom backoffs of req in milliseconds by attempts,
+// by type, "" standing for the unlisted types if req.Backoff is set.
+// The exponential backoff of the other types is computed by the poll query.
+func backoffDelays(req lymbo.PollRequest) map[string][]int64 {
+	sample := func(b lymbo.Backoff) []int64 {
+		delays := make([]int64, backoffSamples)
+		for i := range delays {
+			delays[i] = b.Delay(i).Milliseconds()
+		}
+		return delays
+	}
+	delays := make(map[string][]int64)
+	if req.Backoff != nil {
+		delays[""] = sample(req.Backoff)
+	}
+	for typ, b := range req.BackoffPerType {
+		if b != nil {
+			delays[typ] = sample(b)
+		}
+	}
+	return delays
+}
+
 type pollPendingParams struct {
 	now         pgtype.Timestamptz
 	ttr         int32
 	maxDelay    int32
 	backoffBase float64
 	limit       int32
+	labels      []byte
+	tenants     []string // NULL if empty
 }
 
 func (r *Tickets) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
@@ -474,20 +1118,153 @@ func (r *Tickets) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo
 		backoffBase: req.BackoffBase,
 		limit:       int32(req.Limit),
 	}
-	rows, err := r.db.Query(ctx, r.queries.poll,
+
+	var err error
+	if dto.labels, err = marshalMap("labels", req.Labels); err != nil {
+		return lymbo.PollResult{}, err
+	}
+	if len(req.Tenants) > 0 {
+		dto.tenants = req.Tenants
+	}
+
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+	defer release()
+
+	dropped := 0
+	if req.CatchUp.DropAfter > 0 {
+		stale, err := json.Marshal(lymbo.ErrorInfo{Message: lymbo.StaleReason, OccurredAt: req.Now})
+		if err != nil {
+			return lymbo.PollResult{}, fmt.Errorf("failed to marshal error_reason: %w", err)
+		}
+		tag, err := r.db.Exec(ctx, r.queries.dropStale,
+			dto.now,
+			req.CatchUp.DropAfter.Milliseconds(),
+			dto.labels,
+			dropStaleBatchSize,
+			stale,
+			req.Queue,
+			dto.tenants,
+		)
+		if err != nil {
+			return lymbo.PollResult{}, fmt.Errorf("failed to drop stale tickets: %w", err)
+		}
+		dropped = int(tag.RowsAffected())
+	}
+
+	mode := pollMode{
+		smear:    req.CatchUp.MaxOverduePerType > 0,
+		capped:   len(req.MaxInFlightPerType) > 0,
+		boost:    req.Boost.Grace > 0,
+		delays:   req.Backoff != nil || len(req.BackoffPerType) > 0,
+		priority: req.Priority.Enabled,
+		limited:  len(req.LimitPerType) > 0,
+		fair:     req.FairShare,
+		shared:   req.TypeShare.Enabled,
+	}
+	args := []any{
 		dto.now,
 		dto.ttr,
 		dto.maxDelay,
 		dto.backoffBase,
 		dto.limit,
-	)
+		dto.labels,
+		req.Queue,
+		req.Owner,
+		dto.tenants,
+		int16(req.Jitter),
+	}
+	// in the order expected by newQueries
+	if mode.smear {
+		args = append(args, req.CatchUp.OverdueAfter.Milliseconds(), int64(req.CatchUp.MaxOverduePerType))
+	}
+	if mode.capped {
+		caps, err := json.Marshal(req.MaxInFlightPerType)
+		if err != nil {
+			return lymbo.PollResult{}, fmt.Errorf("failed to marshal in-flight caps: %w", err)
+		}
+		args = append(args, caps)
+	}
+	if mode.boost {
+		args = append(args, int32(req.Boost.MaxNice), req.Boost.Grace.Milliseconds())
+	}
+	if mode.delays {
+		delays, err := json.Marshal(backoffDelays(req))
+		if err != nil {
+			return lymbo.PollResult{}, fmt.Errorf("failed to marshal backoff delays: %w", err)
+		}
+		args = append(args, delays)
+	}
+	if mode.priority {
+		args = append(args, req.Priority.Aging.Milliseconds())
+	}
+	if mode.limited {
+		limits, err := json.Marshal(req.LimitPerType)
+		if err != nil {
+			return lymbo.PollResult{}, fmt.Errorf("failed to marshal per-type limits: %w", err)
+		}
+		args = append(args, limits)
+	}
+	if mode.shared {
+		weights, err := json.Marshal(req.TypeShare.Weights)
+		if err != nil {
+			return lymbo.PollResult{}, fmt.Errorf("failed to marshal type weights: %w", err)
+		}
+		args = append(args, weights)
+	}
+
+	if !mode.capped {
+		tickets, due, sleepUntil, err := r.claim(ctx, r.db, r.queries.poll[mode], args, req.Limit)
+		if err != nil {
+			return lymbo.PollResult{}, err
+		}
+		return lymbo.PollResult{SleepUntil: sleepUntil, Tickets: tickets, Dropped: dropped, Due: due}, nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted})
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Pollers of a capped type take turns, so that each one counts the
+	// tickets claimed by the previous one. Sorted to avoid deadlocks.
+	for _, typ := range slices.Sorted(maps.Keys(req.MaxInFlightPerType)) {
+		if _, err := tx.Exec(ctx, r.queries.lockType, typ); err != nil {
+			return lymbo.PollResult{}, fmt.Errorf("failed to lock type %q: %w", typ, err)
+		}
+	}
+
+	tickets, due, sleepUntil, err := r.claim(ctx, tx, r.queries.poll[mode], args, req.Limit)
 	if err != nil {
 		return lymbo.PollResult{}, err
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return lymbo.PollResult{}, err
+	}
+	return lymbo.PollResult{SleepUntil: sleepUntil, Tickets: tickets, Dropped: dropped, Due: due}, nil
+}
+
+// querier is a pool or a transaction.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// claim runs a poll query for up to limit tickets, returning the claimed
+// ones and the runat they were due at, or the runat of the next future
+// ticket if none was.
+func (r *Tickets) claim(ctx context.Context, db querier, query string, args []any, limit int) ([]lymbo.Ticket, []time.Time, *time.Time, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	defer rows.Close()
 
 	var sleepUntil *time.Time
-	tickets := make([]lymbo.Ticket, 0)
+	tickets := make([]lymbo.Ticket, 0, limit)
+	dues := make([]time.Time, 0, limit)
 
 	for rows.Next() {
 		var (
@@ -502,6 +1279,18 @@ func (r *Tickets) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo
 			attempts    int32
 			payload     []byte
 			errorReason []byte
+			labelsJSON  []byte
+			metaJSON    []byte
+			lease       pgtype.Text
+			queue       string
+			uniqueKey   pgtype.Text
+			result      []byte
+			deadline    pgtype.Timestamptz
+			attemptLog  []byte
+			owner       pgtype.Text
+			dependsOn   []byte
+			tenantID    string
+			due         pgtype.Timestamptz
 		)
 
 		err := rows.Scan(
@@ -516,9 +1305,21 @@ func (r *Tickets) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo
 			&attempts,
 			&payload,
 			&errorReason,
+			&labelsJSON,
+			&metaJSON,
+			&lease,
+			&queue,
+			&uniqueKey,
+			&result,
+			&deadline,
+			&attemptLog,
+			&owner,
+			&dependsOn,
+			&tenantID,
+			&due,
 		)
 		if err != nil {
-			return lymbo.PollResult{}, err
+			return nil, nil, nil, err
 		}
 
 		switch rowType {
@@ -528,6 +1329,31 @@ func (r *Tickets) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo
 				slog.WarnContext(ctx, "failed to convert ticket in PollPending", "error", err, "ticket_id", id.String())
 				continue
 			}
+			labels, err := unmarshalMap("labels", labelsJSON)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to convert ticket in PollPending", "error", err, "ticket_id", id.String())
+				continue
+			}
+			metadata, err := unmarshalMap("metadata", metaJSON)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to convert ticket in PollPending", "error", err, "ticket_id", id.String())
+				continue
+			}
+			reason, err := unmarshalReason(errorReason)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to convert ticket in PollPending", "error", err, "ticket_id", id.String())
+				continue
+			}
+			log, err := unmarshalAttemptLog(attemptLog)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to convert ticket in PollPending", "error", err, "ticket_id", id.String())
+				continue
+			}
+			deps, err := unmarshalDependsOn(dependsOn)
+			if err != nil {
+				slog.WarnContext(ctx, "failed to convert ticket in PollPending", "error", err, "ticket_id", id.String())
+				continue
+			}
 			var mtimePtr *time.Time
 			if mtime.Valid {
 				mtimePtr = &mtime.Time
@@ -538,12 +1364,24 @@ func (r *Tickets) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo
 				Runat:       runat.Time,
 				Nice:        int(nice),
 				Type:        ticketType,
+				Queue:       queue,
+				UniqueKey:   uniqueKey.String,
 				Ctime:       ctime.Time,
 				Mtime:       mtimePtr,
 				Attempts:    int(attempts),
+				Lease:       lease.String,
+				Owner:       owner.String,
 				Payload:     payload,
-				ErrorReason: errorReason,
+				ErrorReason: reason,
+				Labels:      labels,
+				Metadata:    metadata,
+				Result:      resultValue(result),
+				Deadline:    timeValue(deadline),
+				AttemptLog:  log,
+				DependsOn:   deps,
+				TenantID:    tenantID,
 			})
+			dues = append(dues, due.Time)
 		case "future_ticket":
 			sleepUntil = &runat.Time
 		default:
@@ -552,22 +1390,227 @@ func (r *Tickets) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo
 	}
 
 	if err := rows.Err(); err != nil {
-		return lymbo.PollResult{}, err
+		return nil, nil, nil, err
 	}
+	return tickets, dues, sleepUntil, nil
+}
 
-	return lymbo.PollResult{
-		SleepUntil: sleepUntil,
-		Tickets:    tickets,
-	}, nil
+// Pause inserts typ into the paused table, which the poll queries skip.
+func (r *Tickets) Pause(ctx context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	_, err := r.db.Exec(ctx, r.queries.pause, typ)
+	return err
 }
 
-func (r *Tickets) ExpireTickets(ctx context.Context, limit int, now time.Time) (int64, error) {
-	res, err := r.db.Exec(ctx, r.queries.expire,
-		pgtype.Timestamptz{Time: now, Valid: true},
-		int32(limit),
+// Resume deletes typ from the paused table.
+func (r *Tickets) Resume(ctx context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	_, err := r.db.Exec(ctx, r.queries.resume, typ)
+	return err
+}
+
+func (r *Tickets) Paused(ctx context.Context) ([]string, error) {
+	rows, err := r.db.Query(ctx, r.queries.paused)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (r *Tickets) ListInFlight(ctx context.Context, now time.Time) ([]lymbo.Ticket, error) {
+	return queryTickets(ctx, r.reader(ctx), r.queries.inflight, pgtype.Timestamptz{Time: now, Valid: true})
+}
+
+// ReleaseOwned releases the in-flight tickets of owner in a single statement.
+func (r *Tickets) ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error) {
+	if owner == "" {
+		return 0, nil
+	}
+	tag, err := r.db.Exec(ctx, r.queries.releaseOwned, owner, pgtype.Timestamptz{Time: now, Valid: true})
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// ReleaseDependents unblocks the tickets waiting for id in a single statement.
+func (r *Tickets) ReleaseDependents(ctx context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	tag, err := r.db.Exec(ctx, r.queries.releaseDeps, id.String(), pgtype.Timestamptz{Time: now, Valid: true})
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// RetryFailed makes the selected failed tickets pending in a single statement.
+func (r *Tickets) RetryFailed(ctx context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	var types []string // NULL if empty
+	if len(req.Types) > 0 {
+		types = req.Types
+	}
+	var tenants []string // NULL if empty
+	if len(req.Tenants) > 0 {
+		tenants = req.Tenants
+	}
+	tag, err := r.db.Exec(ctx, r.queries.retryFailed,
+		pgtype.Timestamptz{Time: req.Now, Valid: true}, types, tenants,
+		timestamptz(req.Created.From), timestamptz(req.Created.To),
+		timestamptz(req.Failed.From), timestamptz(req.Failed.To),
+		req.KeepAttempts,
 	)
 	if err != nil {
 		return 0, err
 	}
-	return res.RowsAffected(), nil
+	return int(tag.RowsAffected()), nil
+}
+
+// ListOverdue reads the primary, the overdue tickets are settled right after.
+func (r *Tickets) ListOverdue(ctx context.Context, now time.Time, limit int) ([]lymbo.Ticket, error) {
+	if limit <= 0 {
+		return nil, lymbo.ErrLimitInvalid
+	}
+	return queryTickets(ctx, r.db, r.queries.overdue, pgtype.Timestamptz{Time: now, Valid: true}, limit)
+}
+
+// queryTickets runs a query selecting the columns of the `get` query.
+func queryTickets(ctx context.Context, db querier, query string, args ...any) ([]lymbo.Ticket, error) {
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []lymbo.Ticket
+	for rows.Next() {
+		t, err := scanTicket(rows)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
+
+// List is served by the read replica, if any.
+func (r *Tickets) List(ctx context.Context, req lymbo.ListRequest) ([]lymbo.Ticket, error) {
+	var st sql.NullString
+	if req.Status != nil {
+		st = sql.NullString{String: req.Status.String(), Valid: true}
+	}
+	var limit sql.NullInt64
+	if req.Limit > 0 {
+		limit = sql.NullInt64{Int64: int64(req.Limit), Valid: true}
+	}
+	var types []string // NULL if empty
+	if len(req.Types) > 0 {
+		types = req.Types
+	}
+	var tenants []string // NULL if empty
+	if len(req.Tenants) > 0 {
+		tenants = req.Tenants
+	}
+	var afterCtime pgtype.Timestamptz
+	var afterID sql.NullString
+	if req.After != nil {
+		afterCtime = timestamptz(req.After.Ctime)
+		afterID = sql.NullString{String: req.After.ID.String(), Valid: true}
+	}
+	return queryTickets(ctx, r.reader(ctx), r.queries.list, st, limit, types,
+		timestamptz(req.Created.From), timestamptz(req.Created.To),
+		timestamptz(req.Runat.From), timestamptz(req.Runat.To),
+		afterCtime, afterID, tenants,
+	)
+}
+
+// Search reads the replica, if any, as List does. The payload index of
+// Config.PayloadIndex serves the query.
+func (r *Tickets) Search(ctx context.Context, query string, limit int) ([]lymbo.Ticket, error) {
+	if limit <= 0 {
+		return nil, lymbo.ErrLimitInvalid
+	}
+	return queryTickets(ctx, r.reader(ctx), r.queries.search, query, limit)
+}
+
+// timestamptz returns t as a parameter, NULL if zero.
+func timestamptz(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: !t.IsZero()}
+}
+
+// Vacuum runs a query per check on the primary, so that repairs act on current data.
+func (r *Tickets) Vacuum(ctx context.Context, req lymbo.VacuumRequest) (lymbo.VacuumReport, error) {
+	var (
+		report lymbo.VacuumReport
+		err    error
+	)
+	report.Unreachable, err = queryTickets(ctx, r.db, r.queries.unreachable,
+		pgtype.Timestamptz{Time: req.UnreachableAfter, Valid: true},
+	)
+	if err != nil {
+		return lymbo.VacuumReport{}, fmt.Errorf("failed to find unreachable tickets: %w", err)
+	}
+	report.MissingMtime, err = queryTickets(ctx, r.db, r.queries.missingMtime)
+	if err != nil {
+		return lymbo.VacuumReport{}, fmt.Errorf("failed to find tickets missing mtime: %w", err)
+	}
+	if req.MaxAttempts > 0 {
+		report.Exhausted, err = queryTickets(ctx, r.db, r.queries.exhausted, int32(req.MaxAttempts))
+		if err != nil {
+			return lymbo.VacuumReport{}, fmt.Errorf("failed to find exhausted tickets: %w", err)
+		}
+	}
+	return report, nil
+}
+
+func (r *Tickets) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
+	retention := func(s status.Status) sql.NullInt64 {
+		d, ok := req.Retention[s]
+		if !ok {
+			return sql.NullInt64{}
+		}
+		return sql.NullInt64{Int64: d.Milliseconds(), Valid: true}
+	}
+
+	args := []any{
+		pgtype.Timestamptz{Time: req.Now, Valid: true},
+		retention(status.Done),
+		retention(status.Failed),
+		retention(status.Cancelled),
+	}
+
+	var (
+		dropped int64
+		errs    []error
+	)
+	if r.partitioned {
+		if err := r.createPartitions(ctx, req.Now); err != nil {
+			errs = append(errs, err)
+		}
+		n, err := r.dropPartitions(ctx, req.Now, args, req.Archive)
+		dropped += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	var (
+		n   int64
+		err error
+	)
+	if a, ok := r.native(req.Archive); ok {
+		n, err = r.moveExpired(ctx, a, args, int32(req.Limit))
+	} else if req.Archive != nil {
+		n, err = r.archiveExpired(ctx, req.Archive, args, int32(req.Limit))
+	} else {
+		var tag pgconn.CommandTag
+		tag, err = r.db.Exec(ctx, r.queries.expire, append(args, int32(req.Limit))...)
+		n = tag.RowsAffected()
+	}
+	if err != nil {
+		return dropped, errors.Join(append(errs, err)...)
+	}
+	return dropped + n, errors.Join(errs...)
 }
@@ -0,0 +1,155 @@
+package postgres_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/postgres"
+	"github.com/ochaton/lymbo/store/storetest"
+)
+
+// dsn returns the database the tests run on, set by LYMBO_TEST_POSTGRES_DSN,
+// skipping them if unset.
+func dsn(t testing.TB) string {
+	dsn := os.Getenv("LYMBO_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LYMBO_TEST_POSTGRES_DSN is not set")
+	}
+	return dsn
+}
+
+// open returns a store on dsn in a schema of its own, dropped when the test ends.
+func open(t testing.TB, dsn string, opts ...postgres.OpenOption) *postgres.Tickets {
+	ctx := context.Background()
+	schema := fmt.Sprintf("storetest_%d_%d", os.Getpid(), schemas.Add(1))
+	store, err := postgres.Open(ctx, dsn, append(opts, postgres.WithSchema(schema))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		conn, err := pgx.Connect(ctx, dsn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close(ctx)
+		if _, err := conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Error(err)
+		}
+	})
+	return store
+}
+
+// schemas numbers the schemas of the stores.
+var schemas atomic.Int64
+
+func TestStore(t *testing.T) {
+	dsn := dsn(t)
+	storetest.TestStore(t, func() lymbo.Store {
+		return open(t, dsn)
+	})
+}
+
+func TestStorePartitioned(t *testing.T) {
+	dsn := dsn(t)
+	storetest.TestStore(t, func() lymbo.Store {
+		return open(t, dsn, postgres.WithPartitionByMonth())
+	})
+}
+
+func TestPartitionedAcrossMonths(t *testing.T) {
+	ctx := context.Background()
+	store := open(t, dsn(t), postgres.WithPartitionByMonth())
+	now := time.Now()
+
+	ticket, _ := lymbo.NewTicket(lymbo.TicketId(uuid.NewString()), "email")
+	ticket.Ctime = now
+	ticket.UniqueKey = "user-1"
+	if err := store.Put(ctx, *ticket); err != nil {
+		t.Fatal(err)
+	}
+
+	// put again as created another month: updated in place
+	again := *ticket
+	again.Ctime = now.AddDate(0, -2, 0)
+	again.Nice = 7
+	if err := store.Put(ctx, again); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.List(ctx, lymbo.ListRequest{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Nice != 7 || !got[0].Ctime.Equal(ticket.Ctime.Truncate(time.Microsecond)) {
+		t.Fatalf("List() = %+v, want the ticket updated with its first Ctime", got)
+	}
+
+	// the unique key is held in every partition
+	dup, _ := lymbo.NewTicket(lymbo.TicketId(uuid.NewString()), "email")
+	dup.Ctime = now.AddDate(0, 1, 0)
+	dup.UniqueKey = "user-1"
+	if err := store.Put(ctx, *dup); !errors.Is(err, lymbo.ErrDuplicateTicket) {
+		t.Fatalf("Put(duplicate) = %v, want %v", err, lymbo.ErrDuplicateTicket)
+	}
+}
+
+func TestMigrateTo(t *testing.T) {
+	ctx := context.Background()
+	store := open(t, dsn(t))
+	latest := len(store.Migrations())
+
+	// down to nothing and back up, every version on its own
+	for _, version := range []int{0, latest} {
+		if err := store.MigrateTo(ctx, version); err != nil {
+			t.Fatalf("MigrateTo(%d): %v", version, err)
+		}
+		got, err := store.SchemaVersion(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != version {
+			t.Fatalf("SchemaVersion() = %d, want %d", got, version)
+		}
+	}
+
+	// the 'dead' status added outside a transaction is usable
+	ticket, _ := lymbo.NewTicket(lymbo.TicketId(uuid.NewString()), "migrate")
+	ticket.Status = status.Dead
+	if err := store.Put(ctx, *ticket); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkPollPending(b *testing.B) {
+	ctx := context.Background()
+	store := open(b, dsn(b))
+	now := time.Now()
+	tickets := make([]lymbo.Ticket, 1000)
+	for i := range tickets {
+		t, _ := lymbo.NewTicket(lymbo.TicketId(uuid.NewString()), "bench")
+		t.Runat = now
+		tickets[i] = *t
+	}
+	if _, err := store.PutBatch(ctx, tickets); err != nil {
+		b.Fatal(err)
+	}
+	// every ticket is claimed again by the next polls, as they're due once claimed
+	req := lymbo.PollRequest{Limit: 10, Now: now, Backoff: lymbo.ConstantBackoff(0)}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := store.PollPending(ctx, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
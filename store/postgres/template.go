@@ -4,37 +4,132 @@ import (
 	"bytes"
 	"fmt"
 	"text/template"
+	"time"
 )
 
-var migrate = template.Must(template.New("migrate").Parse(`
-BEGIN;
--- Create ticket_status enum if it doesn't exist
+// migrations are the versions of the schema of the tickets table, applied in
+// order by Migrate, each with the template migrating to it from the previous
+// one and the one reverting it, and noTx set if they can't run in a
+// transaction block. Released versions are never changed: add one.
+var migrations = []struct {
+	up, down *template.Template
+	noTx     bool
+}{
+	{up: migrateStatus, down: migrateStatusDown},
+	{up: migrateDead, down: migrateDeadDown, noTx: true},
+	{up: migrate, down: migrateDown},
+	{up: migrateKeys, down: migrateKeysDown},
+}
+
+// migrateStatus is version 1, the ticket_status enum of the schema.
+var migrateStatus = template.Must(template.New("migrate_status").Parse(`
 DO $$ BEGIN
-	CREATE TYPE ticket_status AS ENUM ('pending', 'done', 'failed', 'cancelled');
+	CREATE TYPE {{.Qualifier}}ticket_status AS ENUM ('pending', 'done', 'failed', 'cancelled', 'dead');
 EXCEPTION
 	WHEN duplicate_object THEN null;
-END $$;
+END $$;`))
+
+// migrateStatusDown keeps the ticket_status type, which the tables of other
+// stores in the schema may use.
+var migrateStatusDown = template.Must(template.New("migrate_status_down").Parse(`
+-- ticket_status is kept`))
+
+// migrateDead is version 2, adding the 'dead' status to the enums created
+// before it. ALTER TYPE ... ADD VALUE can't run in a transaction block before
+// PostgreSQL 12, nor the value be used in the transaction adding it: the
+// version is a single statement run on its own, committed before the next.
+var migrateDead = template.Must(template.New("migrate_dead").Parse(`ALTER TYPE {{.Qualifier}}ticket_status ADD VALUE IF NOT EXISTS 'dead'`))
+
+// migrateDeadDown keeps the 'dead' value: values can't be dropped from an enum.
+var migrateDeadDown = template.Must(template.New("migrate_dead_down").Parse(`
+-- 'dead' is kept`))
 
+// migrate is version 3, the schema of the tables created before versioning,
+// which it completes as they were by adding the missing columns and indexes.
+var migrate = template.Must(template.New("migrate").Parse(`
 -- Create table with parameterized name
-CREATE TABLE IF NOT EXISTS {{.TableName}} (
-	id           UUID PRIMARY KEY,
-	status       ticket_status NOT NULL DEFAULT 'pending',
+CREATE TABLE IF NOT EXISTS {{.Qualifier}}{{.TableName}} (
+	id           UUID          {{if .Partitioned}}NOT NULL{{else}}PRIMARY KEY{{end}},
+	status       {{.Qualifier}}ticket_status NOT NULL DEFAULT 'pending',
 	runat        TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
 	nice         SMALLINT      NOT NULL DEFAULT 512,
 	type         TEXT          NOT NULL,
+	queue        TEXT          NOT NULL DEFAULT '',
 	ctime        TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
 	mtime        TIMESTAMPTZ   NULL,
 	attempts     INTEGER       NOT NULL DEFAULT 0,
 	payload      JSONB         NULL,
-	error_reason JSONB         NULL
-);
+	error_reason JSONB         NULL,
+	labels       JSONB         NOT NULL DEFAULT '{}',
+	metadata     JSONB         NOT NULL DEFAULT '{}',
+	lease        TEXT          NULL,
+	unique_key   TEXT          NULL,
+	result       JSONB         NULL,
+	deadline     TIMESTAMPTZ   NULL,
+	attempt_log  JSONB         NULL,
+	owner        TEXT          NULL,
+	depends_on   JSONB         NULL,
+	tenant_id    TEXT          NOT NULL DEFAULT ''{{if .Partitioned}},
+	PRIMARY KEY (id, ctime)
+) PARTITION BY RANGE (ctime);
+
+-- Create partition of the tickets created out of the monthly partitions
+CREATE TABLE IF NOT EXISTS {{.Qualifier}}{{.TableName}}_default PARTITION OF {{.Qualifier}}{{.TableName}} DEFAULT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_{{.TableName}}_default_unique ON {{.Qualifier}}{{.TableName}}_default (type, unique_key)
+WHERE status = 'pending' AND unique_key IS NOT NULL;{{else}}
+);{{end}}
+
+-- Add columns missing from tables created by older versions
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS lease TEXT NULL;
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS queue TEXT NOT NULL DEFAULT '';
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS unique_key TEXT NULL;
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS result JSONB NULL;
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS deadline TIMESTAMPTZ NULL;
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS attempt_log JSONB NULL;
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS owner TEXT NULL;
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS depends_on JSONB NULL;
+ALTER TABLE {{.Qualifier}}{{.TableName}} ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
 
 -- Create index
-CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_pending_runat_nice ON {{.TableName}} (runat, nice)
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_pending_runat_nice ON {{.Qualifier}}{{.TableName}} (runat, nice)
+WHERE status = 'pending';
+
+-- Create index for polls of a queue
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_pending_queue_runat_nice ON {{.Qualifier}}{{.TableName}} (queue, runat, nice)
 WHERE status = 'pending';
 
+-- Create index for polls of tenants
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_pending_tenant_runat_nice ON {{.Qualifier}}{{.TableName}} (tenant_id, runat, nice)
+WHERE status = 'pending';
+
+{{if not .Partitioned}}-- Create index deduplicating pending tickets by unique key
+CREATE UNIQUE INDEX IF NOT EXISTS idx_{{.TableName}}_unique ON {{.Qualifier}}{{.TableName}} (type, unique_key)
+WHERE status = 'pending' AND unique_key IS NOT NULL;
+{{end}}
+-- Create index for listing pages
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_ctime_id ON {{.Qualifier}}{{.TableName}} (ctime, id);
+
+-- Create index for overdue tickets
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_pending_deadline ON {{.Qualifier}}{{.TableName}} (deadline)
+WHERE status = 'pending' AND deadline IS NOT NULL;
+
+-- Create index for label selectors
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_labels ON {{.Qualifier}}{{.TableName}} USING GIN (labels);
+
+-- Create index for the dependents of a ticket
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_depends_on ON {{.Qualifier}}{{.TableName}} USING GIN (depends_on)
+WHERE status = 'pending' AND depends_on IS NOT NULL;
+
+-- Create table of the paused types
+CREATE TABLE IF NOT EXISTS {{.Qualifier}}{{.TableName}}_paused (
+	type      TEXT        PRIMARY KEY,
+	paused_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 -- Create trigger function
-CREATE OR REPLACE FUNCTION {{.TableName}}_update_mtime()
+CREATE OR REPLACE FUNCTION {{.Qualifier}}{{.TableName}}_update_mtime()
 RETURNS trigger AS $$
 BEGIN
 	IF ROW(NEW.status, NEW.runat)
@@ -48,23 +143,261 @@ END;
 $$ LANGUAGE plpgsql;
 
 -- Create trigger
-DROP TRIGGER IF EXISTS {{.TableName}}_update_mtime_trg ON {{.TableName}};
+DROP TRIGGER IF EXISTS {{.TableName}}_update_mtime_trg ON {{.Qualifier}}{{.TableName}};
 CREATE TRIGGER {{.TableName}}_update_mtime_trg
-	BEFORE UPDATE ON {{.TableName}}
+	BEFORE UPDATE ON {{.Qualifier}}{{.TableName}}
 	FOR EACH ROW
-	EXECUTE FUNCTION {{.TableName}}_update_mtime();
+	EXECUTE FUNCTION {{.Qualifier}}{{.TableName}}_update_mtime();`))
+
+// migrateDown reverts migrate, dropping the tables and the functions of its
+// triggers, the notify one included.
+var migrateDown = template.Must(template.New("migrate_down").Parse(`
+DROP TABLE IF EXISTS {{.Qualifier}}{{.TableName}}_paused;
+DROP TABLE IF EXISTS {{.Qualifier}}{{.TableName}};
+DROP FUNCTION IF EXISTS {{.Qualifier}}{{.TableName}}_update_mtime();
+DROP FUNCTION IF EXISTS {{.Qualifier}}{{.TableName}}_notify();`))
+
+// migrateKeys is version 4, which keeps the IDs and the unique keys of the
+// tickets of a partitioned table in tables of their own, maintained by a
+// trigger: the primary key and the unique indexes of a partitioned table
+// include the partition key, ctime, so they can't enforce them across
+// partitions. Put looks the ctime of a ticket up in {TableName}_ids to
+// update it in place, and {TableName}_keys holds the unique key of each
+// pending ticket under the name of the unique index of a table that isn't
+// partitioned. Tables that aren't partitioned are left as they are.
+var migrateKeys = template.Must(template.New("migrate_keys").Parse(`{{if .Partitioned}}
+-- Create table of the ctime of each ticket
+CREATE TABLE IF NOT EXISTS {{.Qualifier}}{{.TableName}}_ids (
+	id    UUID        PRIMARY KEY,
+	ctime TIMESTAMPTZ NOT NULL
+);
+
+-- Create table of the unique keys held by pending tickets
+CREATE TABLE IF NOT EXISTS {{.Qualifier}}{{.TableName}}_keys (
+	type       TEXT NOT NULL,
+	unique_key TEXT NOT NULL,
+	id         UUID NOT NULL,
+	CONSTRAINT idx_{{.TableName}}_unique PRIMARY KEY (type, unique_key)
+);
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_keys_id ON {{.Qualifier}}{{.TableName}}_keys (id);
 
+-- Fill them with the tickets put before, the oldest row of an ID winning
+INSERT INTO {{.Qualifier}}{{.TableName}}_ids (id, ctime)
+SELECT DISTINCT ON (id) id, ctime
+FROM {{.Qualifier}}{{.TableName}}
+ORDER BY id, ctime
+ON CONFLICT DO NOTHING;
+INSERT INTO {{.Qualifier}}{{.TableName}}_keys (type, unique_key, id)
+SELECT DISTINCT ON (type, unique_key) type, unique_key, id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE status = 'pending' AND unique_key IS NOT NULL
+ORDER BY type, unique_key, ctime
+ON CONFLICT DO NOTHING;
+
+-- Create trigger function
+CREATE OR REPLACE FUNCTION {{.Qualifier}}{{.TableName}}_track_keys()
+RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'UPDATE' AND ROW(NEW.status, NEW.type, NEW.unique_key, NEW.ctime)
+	IS NOT DISTINCT FROM
+	ROW(OLD.status, OLD.type, OLD.unique_key, OLD.ctime)
+	THEN
+		RETURN NULL;
+	END IF;
+	IF TG_OP = 'INSERT' THEN
+		INSERT INTO {{.Qualifier}}{{.TableName}}_ids (id, ctime) VALUES (NEW.id, NEW.ctime);
+	ELSIF TG_OP = 'UPDATE' THEN
+		UPDATE {{.Qualifier}}{{.TableName}}_ids SET ctime = NEW.ctime WHERE id = NEW.id;
+		DELETE FROM {{.Qualifier}}{{.TableName}}_keys WHERE id = OLD.id;
+	ELSE
+		DELETE FROM {{.Qualifier}}{{.TableName}}_ids WHERE id = OLD.id;
+		DELETE FROM {{.Qualifier}}{{.TableName}}_keys WHERE id = OLD.id;
+		RETURN NULL;
+	END IF;
+	IF NEW.status = 'pending' AND NEW.unique_key IS NOT NULL THEN
+		INSERT INTO {{.Qualifier}}{{.TableName}}_keys (type, unique_key, id) VALUES (NEW.type, NEW.unique_key, NEW.id);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Create trigger
+DROP TRIGGER IF EXISTS {{.TableName}}_track_keys_trg ON {{.Qualifier}}{{.TableName}};
+CREATE TRIGGER {{.TableName}}_track_keys_trg
+	AFTER INSERT OR UPDATE OR DELETE ON {{.Qualifier}}{{.TableName}}
+	FOR EACH ROW
+	EXECUTE FUNCTION {{.Qualifier}}{{.TableName}}_track_keys();{{else}}
+-- the table isn't partitioned{{end}}`))
+
+// migrateKeysDown reverts migrateKeys.
+var migrateKeysDown = template.Must(template.New("migrate_keys_down").Parse(`{{if .Partitioned}}
+DROP TRIGGER IF EXISTS {{.TableName}}_track_keys_trg ON {{.Qualifier}}{{.TableName}};
+DROP FUNCTION IF EXISTS {{.Qualifier}}{{.TableName}}_track_keys();
+DROP TABLE IF EXISTS {{.Qualifier}}{{.TableName}}_keys;
+DROP TABLE IF EXISTS {{.Qualifier}}{{.TableName}}_ids;{{else}}
+-- the table isn't partitioned{{end}}`))
+
+// migrateVersions creates the schema of the tables, if any, and the table
+// of the schema versions applied by Migrate.
+var migrateVersions = template.Must(template.New("migrate_versions").Parse(`{{if .Schema}}
+CREATE SCHEMA IF NOT EXISTS {{.Schema}};{{end}}
+CREATE TABLE IF NOT EXISTS {{.Qualifier}}{{.TableName}}_schema_version (
+	version    INTEGER     PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);`))
+
+// lockMigrations serializes the migrations of the table until unlockMigrations
+// or the end of the session.
+var lockMigrations = template.Must(template.New("lock_migrations").Parse(`SELECT pg_advisory_lock(hashtext('{{.Qualifier}}{{.TableName}}_schema_version'))`))
+
+var unlockMigrations = template.Must(template.New("unlock_migrations").Parse(`SELECT pg_advisory_unlock(hashtext('{{.Qualifier}}{{.TableName}}_schema_version'))`))
+
+var schemaVersion = template.Must(template.New("schema_version").Parse(`SELECT COALESCE(MAX(version), 0) FROM {{.Qualifier}}{{.TableName}}_schema_version`))
+
+var setVersion = template.Must(template.New("set_version").Parse(`INSERT INTO {{.Qualifier}}{{.TableName}}_schema_version (version) VALUES ($1)`))
+
+var unsetVersion = template.Must(template.New("unset_version").Parse(`DELETE FROM {{.Qualifier}}{{.TableName}}_schema_version WHERE version = $1`))
+
+// migratePayload creates the index of the payloads searched by the `search` query.
+var migratePayload = template.Must(template.New("migrate_payload").Parse(`
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_payload ON {{.Qualifier}}{{.TableName}} USING GIN (payload jsonb_path_ops);`))
+
+// migrateNotify installs the trigger notifying {{.TableName}}_ready with the
+// Runat, in Unix milliseconds, of tickets inserted or updated as pending.
+// Claims by a poll, which count an attempt, are not notified.
+var migrateNotify = template.Must(template.New("migrate_notify").Parse(`
+BEGIN;
+CREATE OR REPLACE FUNCTION {{.Qualifier}}{{.TableName}}_notify()
+RETURNS trigger AS $$
+BEGIN
+	IF NEW.status = 'pending' AND (TG_OP = 'INSERT' OR NEW.attempts <= OLD.attempts) THEN
+		PERFORM pg_notify('{{.Qualifier}}{{.TableName}}_ready', (EXTRACT(EPOCH FROM NEW.runat) * 1000)::bigint::text);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS {{.TableName}}_notify_trg ON {{.Qualifier}}{{.TableName}};
+CREATE TRIGGER {{.TableName}}_notify_trg
+	AFTER INSERT OR UPDATE ON {{.Qualifier}}{{.TableName}}
+	FOR EACH ROW
+	EXECUTE FUNCTION {{.Qualifier}}{{.TableName}}_notify();
 COMMIT;`))
 
 var get = template.Must(template.New("get").Parse(`
-SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason
-FROM {{.TableName}}
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
 WHERE id = $1;`))
 
+// lock is get, locking the row until the end of the transaction.
+var lock = template.Must(template.New("lock").Parse(`
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE id = $1
+FOR UPDATE;`))
+
+var inflight = template.Must(template.New("inflight").Parse(`
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE status = 'pending' AND attempts > 0 AND runat > $1
+ORDER BY runat ASC;`))
+
+var overdue = template.Must(template.New("overdue").Parse(`
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE status = 'pending' AND deadline <= $1
+ORDER BY deadline ASC
+LIMIT $2;`))
+
+// A NULL filter selects every ticket, a NULL limit all of them:
+// $1 status, $3 types, $4-$5 ctime and $6-$7 runat ranges, $8-$9 the cursor,
+// $10 tenants.
+var list = template.Must(template.New("list").Parse(`
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE ($1::{{.Qualifier}}ticket_status IS NULL OR status = $1)
+	AND ($3::text[] IS NULL OR type = ANY($3))
+	AND ($4::timestamptz IS NULL OR ctime >= $4)
+	AND ($5::timestamptz IS NULL OR ctime < $5)
+	AND ($6::timestamptz IS NULL OR runat >= $6)
+	AND ($7::timestamptz IS NULL OR runat < $7)
+	AND ($8::timestamptz IS NULL OR (ctime, id) > ($8, $9::uuid))
+	AND ($10::text[] IS NULL OR tenant_id = ANY($10))
+ORDER BY ctime ASC, id ASC
+LIMIT $2;`))
+
+// Selects up to $2 tickets whose payload matches the jsonpath predicate $1.
+// @@ is served by the jsonb_path_ops index of migrate_payload.
+var search = template.Must(template.New("search").Parse(`
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE payload @@ $1::jsonpath
+ORDER BY ctime ASC, id ASC
+LIMIT $2;`))
+
+// Vacuum checks, see lymbo.VacuumRequest.
+var unreachable = template.Must(template.New("unreachable").Parse(`
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE status = 'pending' AND runat > $1;`))
+
+var missingMtime = template.Must(template.New("missing_mtime").Parse(`
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE status <> 'pending' AND mtime IS NULL;`))
+
+var exhausted = template.Must(template.New("exhausted").Parse(`
+SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}}
+WHERE status = 'pending' AND attempts > $1;`))
+
+var getResult = template.Must(template.New("get_result").Parse(`SELECT status, result FROM {{.Qualifier}}{{.TableName}} WHERE id = $1`))
+
+var exists = template.Must(template.New("exists").Parse(`SELECT EXISTS (SELECT 1 FROM {{.Qualifier}}{{.TableName}} WHERE id = $1)`))
+
+// put upserts a ticket. The ticket of a partitioned table keeps its ctime,
+// so that it is updated in its partition, see migrateKeys.
 var put = template.Must(template.New("put").Parse(`
-INSERT INTO {{.TableName}} (id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-ON CONFLICT (id) DO UPDATE SET
+INSERT INTO {{.Qualifier}}{{.TableName}} (id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id)
+VALUES ($1, $2, $3, $4, $5, {{if .Partitioned}}COALESCE((SELECT ctime FROM {{.Qualifier}}{{.TableName}}_ids WHERE id = $1), $6){{else}}$6{{end}}, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+ON CONFLICT (id{{if .Partitioned}}, ctime{{end}}) DO UPDATE SET
+	status = EXCLUDED.status,
+	runat = EXCLUDED.runat,
+	nice = EXCLUDED.nice,
+	type = EXCLUDED.type,
+	ctime = EXCLUDED.ctime,
+	mtime = EXCLUDED.mtime,
+	attempts = EXCLUDED.attempts,
+	payload = EXCLUDED.payload,
+	error_reason = EXCLUDED.error_reason,
+	labels = EXCLUDED.labels,
+	metadata = EXCLUDED.metadata,
+	lease = EXCLUDED.lease,
+	queue = EXCLUDED.queue,
+	unique_key = EXCLUDED.unique_key,
+	result = EXCLUDED.result,
+	deadline = EXCLUDED.deadline,
+	attempt_log = EXCLUDED.attempt_log,
+	owner = EXCLUDED.owner,
+	depends_on = EXCLUDED.depends_on,
+	tenant_id = EXCLUDED.tenant_id;`))
+
+// putBatch upserts the tickets whose columns are passed as arrays, in the order of put,
+// returning the IDs of those written: pending tickets whose unique key is held
+// by another pending ticket are left out. IDs and unique keys must be unique within a batch.
+var putBatch = template.Must(template.New("put_batch").Parse(`
+INSERT INTO {{.Qualifier}}{{.TableName}} (id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id)
+SELECT u.id::uuid, u.status::{{.Qualifier}}ticket_status, u.runat, u.nice, u.type,
+	{{if .Partitioned}}COALESCE((SELECT i.ctime FROM {{.Qualifier}}{{.TableName}}_ids as i WHERE i.id = u.id::uuid), u.ctime){{else}}u.ctime{{end}}, u.mtime, u.attempts,
+	u.payload::jsonb, u.error_reason::jsonb, u.labels::jsonb, u.metadata::jsonb, u.lease, u.queue, u.unique_key, u.result::jsonb, u.deadline, u.attempt_log::jsonb, u.owner, u.depends_on::jsonb, u.tenant_id
+FROM unnest($1::text[], $2::text[], $3::timestamptz[], $4::smallint[], $5::text[], $6::timestamptz[],
+	$7::timestamptz[], $8::integer[], $9::text[], $10::text[], $11::text[], $12::text[], $13::text[], $14::text[], $15::text[], $16::text[], $17::timestamptz[], $18::text[], $19::text[], $20::text[], $21::text[])
+	AS u(id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id)
+WHERE u.status <> 'pending' OR u.unique_key IS NULL OR NOT EXISTS (
+	SELECT 1 FROM {{.Qualifier}}{{.TableName}} as h
+	WHERE h.status = 'pending' AND h.type = u.type AND h.unique_key = u.unique_key AND h.id <> u.id::uuid
+)
+ON CONFLICT (id{{if .Partitioned}}, ctime{{end}}) DO UPDATE SET
 	status = EXCLUDED.status,
 	runat = EXCLUDED.runat,
 	nice = EXCLUDED.nice,
@@ -73,50 +406,200 @@ ON CONFLICT (id) DO UPDATE SET
 	mtime = EXCLUDED.mtime,
 	attempts = EXCLUDED.attempts,
 	payload = EXCLUDED.payload,
-	error_reason = EXCLUDED.error_reason;`))
+	error_reason = EXCLUDED.error_reason,
+	labels = EXCLUDED.labels,
+	metadata = EXCLUDED.metadata,
+	lease = EXCLUDED.lease,
+	queue = EXCLUDED.queue,
+	unique_key = EXCLUDED.unique_key,
+	result = EXCLUDED.result,
+	deadline = EXCLUDED.deadline,
+	attempt_log = EXCLUDED.attempt_log,
+	owner = EXCLUDED.owner,
+	depends_on = EXCLUDED.depends_on,
+	tenant_id = EXCLUDED.tenant_id
+RETURNING id;`))
 
-var delete = template.Must(template.New("delete").Parse(`DELETE FROM {{.TableName}} WHERE id = $1`))
+var delete = template.Must(template.New("delete").Parse(`DELETE FROM {{.Qualifier}}{{.TableName}} WHERE id = $1`))
 
-var update = template.Must(template.New("update").Parse(`UPDATE {{.TableName}}
+var deleteLeased = template.Must(template.New("delete_leased").Parse(`DELETE FROM {{.Qualifier}}{{.TableName}} WHERE id = $1 AND ($2::text IS NULL OR lease = $2)`))
+
+// errorReason sets error_reason to the lymbo.ErrorInfo of the parameter
+// passed as dot unless it is NULL, as Ticket.SetErrorReason does: its attempt
+// defaults to the ticket's, and the previous error_reason is appended to its
+// history, reasons stored as plain JSON strings by earlier versions included.
+var errorReason = `error_reason = CASE WHEN {{.}}::jsonb IS NULL THEN error_reason
+	ELSE jsonb_build_object('attempt', attempts) || {{.}}::jsonb || CASE
+		WHEN error_reason IS NULL OR error_reason = 'null'::jsonb THEN '{}'::jsonb
+		WHEN jsonb_typeof(error_reason) = 'object' THEN jsonb_build_object('history',
+			COALESCE(error_reason->'history', '[]'::jsonb) || jsonb_build_array(error_reason - 'history'))
+		WHEN jsonb_typeof(error_reason) = 'string' THEN jsonb_build_object('history',
+			jsonb_build_array(jsonb_build_object('message', error_reason #>> '{}')))
+		ELSE jsonb_build_object('history', jsonb_build_array(jsonb_build_object('message', error_reason::text)))
+	END
+END`
+
+// attemptLog appends the lymbo.Attempt of the parameter passed as dot to
+// attempt_log unless it is NULL.
+var attemptLog = `attempt_log = CASE WHEN {{.}}::jsonb IS NULL THEN attempt_log
+	ELSE COALESCE(attempt_log, '[]'::jsonb) || jsonb_build_array({{.}}::jsonb)
+END`
+
+// updateParts defines the templates shared by update and backoff.
+var updateParts = `{{define "error_reason"}}` + errorReason + `{{end}}{{define "attempt_log"}}` + attemptLog + `{{end}}`
+
+var update = template.Must(template.New("update").Parse(updateParts + `UPDATE {{.Qualifier}}{{.TableName}}
 SET
 	status = COALESCE($2, status),
 	nice = COALESCE($3, nice),
 	runat = COALESCE($4, runat),
 	payload = COALESCE($5, payload),
-	error_reason = COALESCE($6, error_reason)
-WHERE id = $1`))
+	{{template "error_reason" "$6"}},
+	result = COALESCE($8, result),
+	{{template "attempt_log" "$9"}},
+	owner = CASE WHEN $2::{{.Qualifier}}ticket_status IS NULL AND $4::timestamptz IS NULL THEN owner END
+WHERE id = $1 AND ($7::text IS NULL OR lease = $7)`))
+
+// Returns no rows if the ticket doesn't exist, and rescheduled = false
+// if it exists but is no longer pending.
+var reschedule = template.Must(template.New("reschedule").Parse(`WITH existing AS (
+	SELECT status FROM {{.Qualifier}}{{.TableName}} WHERE id = $1
+),
+rescheduled AS (
+	UPDATE {{.Qualifier}}{{.TableName}}
+	SET runat = $2, mtime = NOW()
+	WHERE id = $1 AND status = 'pending'
+	RETURNING id
+)
+SELECT existing.status, EXISTS (SELECT 1 FROM rescheduled) AS rescheduled
+FROM existing`))
 
 // runat = now() + {jitter} + min(pow({base}, attempt), {max})
-var backoff = template.Must(template.New("backoff").Parse(`UPDATE {{.TableName}}
+var backoff = template.Must(template.New("backoff").Parse(updateParts + `UPDATE {{.Qualifier}}{{.TableName}}
 SET
 	status = COALESCE($2, status),
 	nice = COALESCE($3, nice),
-	runat = now() + (GREATEST($4,0) + LEAST(POWER($5, attempts), $6)) * INTERVAL '1 second',
+	runat = now() + (GREATEST($4::float8, 0) + LEAST(POWER($5, attempts), $6)) * INTERVAL '1 second',
 	payload = COALESCE($7, payload),
-	error_reason = COALESCE($8, error_reason)
-WHERE id = $1`))
+	{{template "error_reason" "$8"}},
+	result = COALESCE($10, result),
+	{{template "attempt_log" "$11"}},
+	owner = NULL
+WHERE id = $1 AND ($9::text IS NULL OR lease = $9)`))
 
-var poll = template.Must(template.New("poll").Parse(`WITH rescheduled_tickets AS (
-	UPDATE {{.TableName}} as t
+// due matches the claimable pending tickets of alias t, waiting for no other,
+// of the tenants $9 unless NULL and of a type that isn't paused: ready ones, and with .BoostNice urgent ones never attempted that are due
+// within .BoostGrace milliseconds.
+var due = `t.status = 'pending' AND t.queue = $7 AND t.labels @> $6::jsonb AND t.depends_on IS NULL
+			AND ($9::text[] IS NULL OR t.tenant_id = ANY($9))
+			AND NOT EXISTS (SELECT 1 FROM {{.Qualifier}}{{.TableName}}_paused as p WHERE p.type = t.type) AND (t.runat <= $1::Timestamptz{{if .BoostNice}}
+			OR (t.attempts = 0 AND t.nice <= {{.BoostNice}}::int AND t.runat <= $1::Timestamptz + {{.BoostGrace}}::bigint * INTERVAL '1 millisecond'){{end}})`
+
+// order sorts claimable tickets of alias t: by runat, and with .Aging, ready
+// ones first by their nice lowered by one every .Aging milliseconds they've
+// been due (never with a 0 .Aging), see lymbo.Priority.
+var order = `{{if .Aging}}(t.runat > $1::Timestamptz) ASC,
+			t.nice - COALESCE(floor(GREATEST(extract(epoch FROM $1::Timestamptz - t.runat) * 1000, 0) / NULLIF({{.Aging}}::bigint, 0)), 0) ASC,
+			{{end}}t.runat ASC, t.nice ASC`
+
+// $10 is the lymbo.Jitter of the backoff, drawn per ticket.
+// Optional parts are enabled by the placeholders of their parameters:
+// with .OverdueAfter, overdue tickets (.OverdueAfter milliseconds late) are
+// ranked by age within their type, and only the first .OverdueCap of each type
+// are claimed; with .Caps, a JSON object of type to max in-flight tickets,
+// claimable tickets of those types are ranked by age, and only as many as the
+// type has in-flight capacity left are claimed; with .BoostNice, see due;
+// with .Delays, a JSON object of type to the backoff delays in milliseconds
+// by attempts ("" for the other types), the backoff of the listed types is
+// read from it instead of being computed, the delay at .LastDelay applying
+// to later attempts; with .Aging, see order; with .Limits, a JSON object of
+// type to the max tickets of the type claimed, claimable tickets of those
+// types are ranked by order, and only as many as the limit are claimed; with
+// .Fair, claimable tickets are ranked by order within their tenant, and
+// claimed by rank first, so that the tenants take turns; with .TypeWeights, a
+// JSON object of type to the tickets of the type claimed per turn (1 for the
+// other types), claimable tickets are ranked by order within their type, and
+// claimed by turn first, within the rank of their tenant with .Fair.
+var poll = template.Must(template.New("poll").Funcs(template.FuncMap{"delay": delay}).Parse(`{{define "due"}}` + due + `{{end}}{{define "order"}}` + order + `{{end}}WITH {{if .OverdueAfter}}overdue AS (
+	SELECT o.id, row_number() OVER (PARTITION BY o.type ORDER BY o.runat ASC, o.nice ASC) AS overdue_rank
+	FROM {{.Qualifier}}{{.TableName}} as o
+	WHERE o.status = 'pending' AND o.runat < $1::Timestamptz - {{.OverdueAfter}}::bigint * INTERVAL '1 millisecond' AND o.queue = $7 AND o.labels @> $6::jsonb
+		AND o.depends_on IS NULL AND ($9::text[] IS NULL OR o.tenant_id = ANY($9)) AND NOT EXISTS (SELECT 1 FROM {{.Qualifier}}{{.TableName}}_paused as p WHERE p.type = o.type)
+),
+{{end}}{{if .Caps}}capacity AS (
+	SELECT c.key AS type, c.value::bigint - (
+		SELECT count(*)
+		FROM {{.Qualifier}}{{.TableName}} as i
+		WHERE i.type = c.key AND i.status = 'pending' AND i.attempts > 0 AND i.runat > $1::Timestamptz
+	) AS free
+	FROM jsonb_each_text({{.Caps}}::jsonb) as c
+),
+capped AS (
+	SELECT t.id, row_number() OVER (PARTITION BY t.type ORDER BY {{template "order" .}}) AS capped_rank
+	FROM {{.Qualifier}}{{.TableName}} as t
+	WHERE {{template "due" .}}
+		AND t.type IN (SELECT type FROM capacity)
+),
+{{end}}{{if .Limits}}limited AS (
+	SELECT t.id, row_number() OVER (PARTITION BY t.type ORDER BY {{template "order" .}}) AS limited_rank
+	FROM {{.Qualifier}}{{.TableName}} as t
+	WHERE {{template "due" .}}
+		AND t.type IN (SELECT key FROM jsonb_each_text({{.Limits}}::jsonb))
+),
+{{end}}{{if .TypeWeights}}type_share AS (
+	SELECT t.id, (row_number() OVER (PARTITION BY t.type ORDER BY {{template "order" .}}) - 1)
+		/ GREATEST(COALESCE(({{.TypeWeights}}::jsonb ->> t.type)::bigint, 1), 1) AS type_turn
+	FROM {{.Qualifier}}{{.TableName}} as t
+	WHERE {{template "due" .}}
+),
+{{end}}{{if .Fair}}fair AS (
+	SELECT t.id, row_number() OVER (PARTITION BY t.tenant_id ORDER BY {{if .TypeWeights}}type_share.type_turn ASC, {{end}}{{template "order" .}}) AS fair_rank
+	FROM {{.Qualifier}}{{.TableName}} as t{{if .TypeWeights}}
+	LEFT JOIN type_share ON type_share.id = t.id{{end}}
+	WHERE {{template "due" .}}
+),
+{{end}}rescheduled_tickets AS (
+	UPDATE {{.Qualifier}}{{.TableName}} as t
 	SET
 		attempts = attempts + 1,
-		runat = $1::Timestamptz + (GREATEST($2, 0) + LEAST($3, POWER($4, t.attempts))) * INTERVAL '1 second'
-	WHERE id IN (
-		SELECT t.id
-		FROM {{.TableName}} as t
-		WHERE t.status = 'pending' AND t.runat <= $1::Timestamptz
+		lease = gen_random_uuid()::text,
+		owner = NULLIF($8::text, ''),
+		runat = $1::Timestamptz + GREATEST($2, 0) * INTERVAL '1 second' + CASE $10::smallint
+			WHEN 1 THEN {{delay .Delays .LastDelay "t.attempts"}} / 2 + random() * ({{delay .Delays .LastDelay "t.attempts"}} / 2)
+			WHEN 2 THEN random() * {{delay .Delays .LastDelay "t.attempts"}}
+			WHEN 3 THEN LEAST(NULLIF($3, 0) * INTERVAL '1 second', {{delay .Delays .LastDelay "0"}}
+				+ random() * GREATEST(3 * {{delay .Delays .LastDelay "GREATEST(t.attempts - 1, 0)"}} - {{delay .Delays .LastDelay "0"}}, INTERVAL '0'))
+			ELSE {{delay .Delays .LastDelay "t.attempts"}}
+		END
+	FROM (
+		SELECT t.id AS claimed_id, t.runat AS due
+		FROM {{.Qualifier}}{{.TableName}} as t{{if .OverdueAfter}}
+		LEFT JOIN overdue ON overdue.id = t.id{{end}}{{if .Caps}}
+		LEFT JOIN capped ON capped.id = t.id
+		LEFT JOIN capacity ON capacity.type = t.type{{end}}{{if .Limits}}
+		LEFT JOIN limited ON limited.id = t.id{{end}}{{if .Fair}}
+		LEFT JOIN fair ON fair.id = t.id{{end}}{{if .TypeWeights}}
+		LEFT JOIN type_share ON type_share.id = t.id{{end}}
+		WHERE {{template "due" .}}{{if .OverdueAfter}}
+			AND (overdue.overdue_rank IS NULL OR overdue.overdue_rank <= {{.OverdueCap}}){{end}}{{if .Caps}}
+			AND (capacity.type IS NULL OR capped.capped_rank <= capacity.free){{end}}{{if .Limits}}
+			AND (limited.id IS NULL OR limited.limited_rank <= ({{.Limits}}::jsonb ->> t.type)::bigint){{end}}
+		ORDER BY {{if or .Fair .TypeWeights}}(t.runat > $1::Timestamptz) ASC, {{end}}{{if .Fair}}fair.fair_rank ASC, {{end}}{{if .TypeWeights}}type_share.type_turn ASC, {{end}}{{template "order" .}}
 		LIMIT $5
-		FOR UPDATE SKIP LOCKED
-	)
-	RETURNING id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason
+		FOR UPDATE OF t SKIP LOCKED
+	) AS claimed
+	WHERE t.id = claimed.claimed_id
+	RETURNING id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id, due
 ),
 future_ticket AS (
-	SELECT ft.id, ft.status, ft.runat, ft.nice, ft.type, ft.ctime, ft.mtime, ft.attempts, ft.payload, ft.error_reason
-	FROM {{.TableName}} as ft
-	WHERE status = 'pending'
-	ORDER BY ft.runat ASC, ft.nice ASC
+	-- the pending ticket with MIN(runat) after now; rows aren't locked, so that
+	-- tickets being claimed or updated concurrently are never skipped
+	SELECT ft.id, ft.status, ft.runat, ft.nice, ft.type, ft.ctime, ft.mtime, ft.attempts, ft.payload, ft.error_reason, ft.labels, ft.metadata, ft.lease, ft.queue, ft.unique_key, ft.result, ft.deadline, ft.attempt_log, ft.owner, ft.depends_on, ft.tenant_id
+	FROM {{.Qualifier}}{{.TableName}} as ft
+	WHERE ft.status = 'pending' AND ft.runat > $1::Timestamptz AND ft.queue = $7 AND ft.labels @> $6::jsonb AND ft.depends_on IS NULL
+		AND ($9::text[] IS NULL OR ft.tenant_id = ANY($9)) AND NOT EXISTS (SELECT 1 FROM {{.Qualifier}}{{.TableName}}_paused as p WHERE p.type = ft.type)
+	ORDER BY ft.runat ASC, ft.id ASC
 	LIMIT 1
-	FOR SHARE SKIP LOCKED
 )
 SELECT
 	'ticket' AS ticket,
@@ -129,7 +612,19 @@ SELECT
 	rescheduled_tickets.mtime        AS mtime,
 	rescheduled_tickets.attempts     AS attempts,
 	rescheduled_tickets.payload      AS payload,
-	rescheduled_tickets.error_reason AS error_reason
+	rescheduled_tickets.error_reason AS error_reason,
+	rescheduled_tickets.labels       AS labels,
+	rescheduled_tickets.metadata     AS metadata,
+	rescheduled_tickets.lease        AS lease,
+	rescheduled_tickets.queue        AS queue,
+	rescheduled_tickets.unique_key   AS unique_key,
+	rescheduled_tickets.result       AS result,
+	rescheduled_tickets.deadline     AS deadline,
+	rescheduled_tickets.attempt_log  AS attempt_log,
+	rescheduled_tickets.owner        AS owner,
+	rescheduled_tickets.depends_on   AS depends_on,
+	rescheduled_tickets.tenant_id    AS tenant_id,
+	rescheduled_tickets.due          AS due
 FROM rescheduled_tickets
 UNION ALL
 SELECT
@@ -143,62 +638,545 @@ SELECT
 	future_ticket.mtime        AS mtime,
 	future_ticket.attempts     AS attempts,
 	future_ticket.payload      AS payload,
-	future_ticket.error_reason AS error_reason
+	future_ticket.error_reason AS error_reason,
+	future_ticket.labels       AS labels,
+	future_ticket.metadata     AS metadata,
+	future_ticket.lease        AS lease,
+	future_ticket.queue        AS queue,
+	future_ticket.unique_key   AS unique_key,
+	future_ticket.result       AS result,
+	future_ticket.deadline     AS deadline,
+	future_ticket.attempt_log  AS attempt_log,
+	future_ticket.owner        AS owner,
+	future_ticket.depends_on   AS depends_on,
+	future_ticket.tenant_id    AS tenant_id,
+	NULL::timestamptz          AS due
 FROM future_ticket
 WHERE NOT EXISTS (SELECT 1 FROM rescheduled_tickets);`))
 
-var expire = template.Must(template.New("expire").Parse(`DELETE FROM {{.TableName}}
-WHERE id IN (SELECT id FROM {{.TableName}} as t WHERE t.status != 'pending' AND t.runat <= $1 LIMIT $2);`))
+var pause = template.Must(template.New("pause").Parse(`INSERT INTO {{.Qualifier}}{{.TableName}}_paused (type) VALUES ($1) ON CONFLICT (type) DO NOTHING`))
+
+var resume = template.Must(template.New("resume").Parse(`DELETE FROM {{.Qualifier}}{{.TableName}}_paused WHERE type = $1`))
+
+var paused = template.Must(template.New("paused").Parse(`SELECT type FROM {{.Qualifier}}{{.TableName}}_paused ORDER BY type`))
+
+// Makes the in-flight tickets claimed by owner $1 due at $2 again.
+var releaseOwned = template.Must(template.New("release_owned").Parse(`UPDATE {{.Qualifier}}{{.TableName}}
+SET runat = $2, owner = NULL, lease = NULL, mtime = $2
+WHERE status = 'pending' AND owner = $1 AND attempts > 0 AND runat > $2`))
+
+// Removes ticket $1 from the depends_on of the pending tickets waiting for it,
+// those left waiting for none being due at $2 at the latest.
+var releaseDependents = template.Must(template.New("release_dependents").Parse(`UPDATE {{.Qualifier}}{{.TableName}}
+SET
+	depends_on = NULLIF(depends_on - $1::text, '[]'::jsonb),
+	runat = CASE WHEN depends_on - $1::text = '[]'::jsonb THEN GREATEST(runat, $2) ELSE runat END
+WHERE status = 'pending' AND depends_on ? $1::text`))
+
+// Makes pending, due at $1, the failed tickets of types $2 and tenants $3
+// unless NULL, created within [$4, $5) and failed within [$6, $7), with their
+// attempts reset unless $8. A ticket whose unique key is held by a pending
+// ticket stays failed, and of failed tickets sharing one, only the last one
+// to fail is retried.
+var retryFailed = template.Must(template.New("retry_failed").Parse(`WITH failed AS (
+	SELECT DISTINCT ON (t.type, COALESCE(t.unique_key, t.id::text)) t.id
+	FROM {{.Qualifier}}{{.TableName}} as t
+	WHERE t.status = 'failed'
+		AND ($2::text[] IS NULL OR t.type = ANY($2))
+		AND ($3::text[] IS NULL OR t.tenant_id = ANY($3))
+		AND ($4::timestamptz IS NULL OR t.ctime >= $4)
+		AND ($5::timestamptz IS NULL OR t.ctime < $5)
+		AND ($6::timestamptz IS NULL OR COALESCE(t.mtime, t.ctime) >= $6)
+		AND ($7::timestamptz IS NULL OR COALESCE(t.mtime, t.ctime) < $7)
+		AND (t.unique_key IS NULL OR NOT EXISTS (
+			SELECT 1 FROM {{.Qualifier}}{{.TableName}} as p
+			WHERE p.status = 'pending' AND p.type = t.type AND p.unique_key = t.unique_key
+		))
+	ORDER BY t.type, COALESCE(t.unique_key, t.id::text), COALESCE(t.mtime, t.ctime) DESC
+)
+UPDATE {{.Qualifier}}{{.TableName}}
+SET status = 'pending', runat = $1, mtime = $1, owner = NULL, lease = NULL,
+	attempts = CASE WHEN $8::boolean THEN attempts ELSE 0 END
+WHERE status = 'failed' AND id IN (SELECT id FROM failed)`))
+
+// Serializes capped polls of type $1 until the end of the transaction.
+var lockType = template.Must(template.New("lock_type").Parse(`SELECT pg_advisory_xact_lock(hashtext('{{.Qualifier}}{{.TableName}}/' || $1::text))`))
+
+// Cancels up to $4 pending tickets of queue $6, of the tenants $7 unless
+// NULL and of types that aren't paused, more than $2 milliseconds late, with
+// the lymbo.ErrorInfo $5.
+var dropStale = template.Must(template.New("drop_stale").Parse(`{{define "error_reason"}}` + errorReason + `{{end}}UPDATE {{.Qualifier}}{{.TableName}}
+SET status = 'cancelled', {{template "error_reason" "$5"}}
+WHERE id IN (
+	SELECT t.id
+	FROM {{.Qualifier}}{{.TableName}} as t
+	WHERE t.status = 'pending' AND t.runat < $1::Timestamptz - $2::bigint * INTERVAL '1 millisecond' AND t.queue = $6 AND t.labels @> $3::jsonb
+		AND t.depends_on IS NULL AND ($7::text[] IS NULL OR t.tenant_id = ANY($7)) AND NOT EXISTS (SELECT 1 FROM {{.Qualifier}}{{.TableName}}_paused as p WHERE p.type = t.type)
+	LIMIT $4
+	FOR UPDATE SKIP LOCKED
+)`))
+
+// expired matches the tickets of alias t expired at $1: a terminal ticket
+// expires at mtime (or ctime) + retention[status] when a retention is
+// configured for its status ($2 done, $3 failed, $4 cancelled, in
+// milliseconds), and at runat otherwise.
+var expired = `t.status != 'pending' AND COALESCE(
+		CASE t.status
+			WHEN 'done'      THEN COALESCE(t.mtime, t.ctime) + $2::bigint * INTERVAL '1 millisecond'
+			WHEN 'failed'    THEN COALESCE(t.mtime, t.ctime) + $3::bigint * INTERVAL '1 millisecond'
+			WHEN 'cancelled' THEN COALESCE(t.mtime, t.ctime) + $4::bigint * INTERVAL '1 millisecond'
+		END,
+		t.runat
+	) <= $1`
+
+// Deletes up to $5 expired tickets.
+var expire = template.Must(template.New("expire").Parse(`{{define "expired"}}` + expired + `{{end}}DELETE FROM {{.Qualifier}}{{.TableName}}
+WHERE id IN (
+	SELECT id
+	FROM {{.Qualifier}}{{.TableName}} as t
+	WHERE {{template "expired"}}
+	LIMIT $5
+);`))
+
+// Locks up to $5 expired tickets, skipping those locked by others.
+var lockExpired = template.Must(template.New("lock_expired").Parse(`{{define "expired"}}` + expired + `{{end}}SELECT id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.TableName}} as t
+WHERE {{template "expired"}}
+LIMIT $5
+FOR UPDATE SKIP LOCKED;`))
+
+var deleteAll = template.Must(template.New("delete_all").Parse(`DELETE FROM {{.Qualifier}}{{.TableName}} WHERE id = ANY($1::uuid[])`))
+
+// Moves up to $5 expired tickets to the archive table .Archive. Its status
+// column is of the ticket_status type of the search path, see Archive.Migrate.
+var moveExpired = template.Must(template.New("move_expired").Parse(`{{define "expired"}}` + expired + `{{end}}WITH expired AS (
+	DELETE FROM {{.Qualifier}}{{.TableName}}
+	WHERE id IN (
+		SELECT id
+		FROM {{.Qualifier}}{{.TableName}} as t
+		WHERE {{template "expired"}}
+		LIMIT $5
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+)
+INSERT INTO {{.Archive}} (id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id)
+SELECT id, status::text::ticket_status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM expired;`))
+
+// The archive table has the columns of the tickets table, without its
+// constraints: a ticket may be archived more than once. Its status is of the
+// ticket_status type of the search path, whatever the schema of the tickets.
+var migrateArchive = template.Must(template.New("migrate_archive").Parse(`
+BEGIN;
+CREATE TABLE IF NOT EXISTS {{.Archive}} (
+	id           UUID          NOT NULL,
+	status       ticket_status NOT NULL,
+	runat        TIMESTAMPTZ   NOT NULL,
+	nice         SMALLINT      NOT NULL,
+	type         TEXT          NOT NULL,
+	queue        TEXT          NOT NULL DEFAULT '',
+	ctime        TIMESTAMPTZ   NOT NULL,
+	mtime        TIMESTAMPTZ   NULL,
+	attempts     INTEGER       NOT NULL,
+	payload      JSONB         NULL,
+	error_reason JSONB         NULL,
+	labels       JSONB         NOT NULL DEFAULT '{}',
+	metadata     JSONB         NOT NULL DEFAULT '{}',
+	lease        TEXT          NULL,
+	unique_key   TEXT          NULL,
+	result       JSONB         NULL,
+	deadline     TIMESTAMPTZ   NULL,
+	attempt_log  JSONB         NULL,
+	owner        TEXT          NULL,
+	depends_on   JSONB         NULL,
+	tenant_id    TEXT          NOT NULL DEFAULT '',
+	archived_at  TIMESTAMPTZ   NOT NULL DEFAULT NOW()
+);
+ALTER TABLE {{.Archive}} ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_{{.Archive}}_id ON {{.Archive}} (id);
+CREATE INDEX IF NOT EXISTS idx_{{.Archive}}_archived_at ON {{.Archive}} (archived_at);
+COMMIT;`))
+
+// Inserts the tickets whose columns are passed as arrays, see put_batch.
+var archiveBatch = template.Must(template.New("archive_batch").Parse(`
+INSERT INTO {{.Archive}} (id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id)
+SELECT u.id::uuid, u.status::ticket_status, u.runat, u.nice, u.type, u.ctime, u.mtime, u.attempts,
+	u.payload::jsonb, u.error_reason::jsonb, u.labels::jsonb, u.metadata::jsonb, u.lease, u.queue, u.unique_key, u.result::jsonb, u.deadline, u.attempt_log::jsonb, u.owner, u.depends_on::jsonb, u.tenant_id
+FROM unnest($1::text[], $2::text[], $3::timestamptz[], $4::smallint[], $5::text[], $6::timestamptz[],
+	$7::timestamptz[], $8::integer[], $9::text[], $10::text[], $11::text[], $12::text[], $13::text[], $14::text[], $15::text[], $16::text[], $17::timestamptz[], $18::text[], $19::text[], $20::text[], $21::text[])
+	AS u(id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id);`))
+
+// Creates the audit table .TableName of an AuditLog.
+var migrateAudit = template.Must(template.New("migrate_audit").Parse(`
+BEGIN;
+CREATE TABLE IF NOT EXISTS {{.Qualifier}}{{.TableName}} (
+	seq       BIGSERIAL     PRIMARY KEY,
+	ticket_id TEXT          NOT NULL,
+	status    ticket_status NOT NULL,
+	actor     TEXT          NOT NULL,
+	reason    TEXT          NOT NULL DEFAULT '',
+	at        TIMESTAMPTZ   NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_{{.TableName}}_ticket_id ON {{.Qualifier}}{{.TableName}} (ticket_id, seq);
+COMMIT;`))
+
+// Inserts the audit entries whose columns are passed as arrays.
+var auditBatch = template.Must(template.New("audit_batch").Parse(`
+INSERT INTO {{.Qualifier}}{{.TableName}} (ticket_id, status, actor, reason, at)
+SELECT u.ticket_id, u.status::ticket_status, u.actor, u.reason, u.at
+FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::timestamptz[])
+	WITH ORDINALITY AS u(ticket_id, status, actor, reason, at, n)
+ORDER BY u.n;`))
+
+// Returns the audit entries of the ticket $1, oldest first.
+var auditHistory = template.Must(template.New("audit_history").Parse(`SELECT ticket_id, status::text, actor, reason, at
+FROM {{.Qualifier}}{{.TableName}}
+WHERE ticket_id = $1
+ORDER BY seq`))
+
+// Lists the partitions of a partitioned table.
+var partitions = template.Must(template.New("partitions").Parse(`SELECT c.relname::text
+FROM pg_inherits as i
+JOIN pg_class as c ON c.oid = i.inhrelid
+WHERE i.inhparent = '{{.Qualifier}}{{.TableName}}'::regclass`))
+
+// Returns the kind of the table, 'p' if partitioned, and no rows if it doesn't exist.
+var tableKind = template.Must(template.New("table_kind").Parse(`SELECT relkind::text FROM pg_class WHERE oid = to_regclass('{{.Qualifier}}{{.TableName}}')`))
+
+// delay returns the backoff delay, an interval, of the tickets of alias t
+// after the attempts of the SQL expression attempts: read from the delays
+// placeholder of poll, if any, the delay at last applying to later attempts,
+// and otherwise computed from the exponential backoff of $3 and $4.
+func delay(delays string, last int, attempts string) string {
+	exp := fmt.Sprintf("LEAST($3, POWER($4, %s)) * INTERVAL '1 second'", attempts)
+	if delays == "" {
+		return exp
+	}
+	return fmt.Sprintf("COALESCE((COALESCE(%[1]s::jsonb -> t.type, %[1]s::jsonb -> '') ->> LEAST(%[2]s, %[3]d))::bigint * INTERVAL '1 millisecond', %[4]s)",
+		delays, attempts, last, exp)
+}
+
+// renderArgs are the arguments of the templates rendered when needed rather
+// than once by newQueries: those of a single partition, or of an Archive.
+type renderArgs struct {
+	TableName string
+	Partition string
+	Archive   string
+
+	// Qualifier qualifies the names of the tickets table and its partitions
+	// with their schema, see qualifier.
+	Qualifier string
+
+	// From and To bound the ctime of the tickets of the partition.
+	From, To time.Time
+}
+
+// Creates the partition of the tickets created from .From until .To, with
+// the unique key index a partitioned table can't have across partitions.
+var createPartition = template.Must(template.New("create_partition").Parse(`
+CREATE TABLE IF NOT EXISTS {{.Qualifier}}{{.Partition}} PARTITION OF {{.Qualifier}}{{.TableName}}
+	FOR VALUES FROM ('{{.From.Format "2006-01-02 15:04:05Z07:00"}}') TO ('{{.To.Format "2006-01-02 15:04:05Z07:00"}}');
+CREATE UNIQUE INDEX IF NOT EXISTS idx_{{.Partition}}_unique ON {{.Qualifier}}{{.Partition}} (type, unique_key)
+WHERE status = 'pending' AND unique_key IS NOT NULL;`))
+
+// Blocks writes to the partition until the end of the transaction.
+var lockPartition = template.Must(template.New("lock_partition").Parse(`LOCK TABLE {{.Qualifier}}{{.Partition}} IN SHARE MODE`))
+
+// Counts the tickets of the partition, and those not expired at $1, see expired.
+var countPartition = template.Must(template.New("count_partition").Parse(`{{define "expired"}}` + expired + `{{end}}SELECT count(*), count(*) FILTER (WHERE NOT ({{template "expired"}}))
+FROM {{.Qualifier}}{{.Partition}} as t`))
+
+// Copies the tickets of the partition to the archive table .Archive, see moveExpired.
+var archivePartition = template.Must(template.New("archive_partition").Parse(`
+INSERT INTO {{.Archive}} (id, status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id)
+SELECT id, status::text::ticket_status, runat, nice, type, ctime, mtime, attempts, payload, error_reason, labels, metadata, lease, queue, unique_key, result, deadline, attempt_log, owner, depends_on, tenant_id
+FROM {{.Qualifier}}{{.Partition}};`))
+
+// Drops the partition, whose rows leave the tables of migrateKeys with it.
+var dropPartition = template.Must(template.New("drop_partition").Parse(`
+DELETE FROM {{.Qualifier}}{{.TableName}}_keys WHERE id IN (SELECT id FROM {{.Qualifier}}{{.Partition}});
+DELETE FROM {{.Qualifier}}{{.TableName}}_ids WHERE id IN (SELECT id FROM {{.Qualifier}}{{.Partition}});
+ALTER TABLE {{.Qualifier}}{{.TableName}} DETACH PARTITION {{.Qualifier}}{{.Partition}};
+DROP TABLE {{.Qualifier}}{{.Partition}};`))
+
+// qualifier returns the prefix qualifying the names of the tables of schema,
+// empty if schema is.
+func qualifier(schema string) string {
+	if schema == "" {
+		return ""
+	}
+	return schema + "."
+}
+
+// render renders one of the templates taking renderArgs.
+func render(tmpl *template.Template, args renderArgs) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, args); err != nil {
+		return "", fmt.Errorf("failed to execute template `%s`: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// pollMode selects a variant of the poll query.
+type pollMode struct {
+	smear    bool
+	capped   bool
+	boost    bool
+	delays   bool
+	priority bool
+	limited  bool
+	fair     bool
+	shared   bool
+}
 
 type Queries struct {
-	migrate string
-	get     string
-	put     string
-	delete  string
-	update  string
-	backoff string
-	poll    string
-	expire  string
+	migrations       []Migration
+	migrateVersions  string
+	lockMigrations   string
+	unlockMigrations string
+	schemaVersion    string
+	setVersion       string
+	unsetVersion     string
+	migrateNotify    string
+	payloadIndex     string
+	get              string
+	getResult        string
+	lock             string
+	exists           string
+	inflight         string
+	overdue          string
+	list             string
+	search           string
+	unreachable      string
+	missingMtime     string
+	exhausted        string
+	put              string
+	putBatch         string
+	delete           string
+	deleteLeased     string
+	update           string
+	backoff          string
+	reschedule       string
+	poll             map[pollMode]string
+	pause            string
+	resume           string
+	paused           string
+	lockType         string
+	releaseOwned     string
+	releaseDeps      string
+	retryFailed      string
+	dropStale        string
+	expire           string
+	partitions       string
+	tableKind        string
+	lockExpired      string
+	deleteAll        string
 }
 
-func newQueries(tableName string) (*Queries, error) {
+func newQueries(schema, tableName string, partitioned bool) (*Queries, error) {
 	// tableName = pgx.Identifier([]string{tableName}).Sanitize()
-	args := struct{ TableName string }{TableName: tableName}
+	type queryArgs struct {
+		TableName string
 
-	exec := func(tmpl *template.Template) (string, error) {
+		// Schema is the schema of the tables, empty for the search path's,
+		// and Qualifier qualifies their names with it: the schema followed
+		// by a dot, or empty. Names of indexes and triggers aren't qualified.
+		Schema    string
+		Qualifier string
+
+		// Partitioned sets up the table partitioned by month of ctime.
+		Partitioned bool
+
+		// placeholders of the optional poll parameters, empty if disabled
+		OverdueAfter string
+		OverdueCap   string
+		Caps         string
+		BoostNice    string
+		BoostGrace   string
+		Delays       string
+		LastDelay    int
+		Aging        string
+		Limits       string
+		Fair         bool
+		TypeWeights  string
+	}
+	args := queryArgs{TableName: tableName, Schema: schema, Qualifier: qualifier(schema), Partitioned: partitioned}
+
+	execWith := func(tmpl *template.Template, args queryArgs) (string, error) {
 		var buf bytes.Buffer
 		if err := tmpl.Execute(&buf, args); err != nil {
 			return "", err
 		}
 		return buf.String(), nil
 	}
+	exec := func(tmpl *template.Template) (string, error) {
+		return execWith(tmpl, args)
+	}
 
-	qt := &Queries{}
+	qt := &Queries{poll: make(map[pollMode]string)}
 	var err error
 
-	if qt.migrate, err = exec(migrate); err != nil {
-		return nil, fmt.Errorf("failed to execute template `migrate`: %w", err)
+	for i, m := range migrations {
+		mig := Migration{Version: i + 1, NoTx: m.noTx}
+		if mig.Up, err = exec(m.up); err != nil {
+			return nil, fmt.Errorf("failed to execute template `%s`: %w", m.up.Name(), err)
+		}
+		if mig.Down, err = exec(m.down); err != nil {
+			return nil, fmt.Errorf("failed to execute template `%s`: %w", m.down.Name(), err)
+		}
+		qt.migrations = append(qt.migrations, mig)
+	}
+	if qt.migrateVersions, err = exec(migrateVersions); err != nil {
+		return nil, fmt.Errorf("failed to execute template `migrate_versions`: %w", err)
+	}
+	if qt.lockMigrations, err = exec(lockMigrations); err != nil {
+		return nil, fmt.Errorf("failed to execute template `lock_migrations`: %w", err)
+	}
+	if qt.unlockMigrations, err = exec(unlockMigrations); err != nil {
+		return nil, fmt.Errorf("failed to execute template `unlock_migrations`: %w", err)
+	}
+	if qt.schemaVersion, err = exec(schemaVersion); err != nil {
+		return nil, fmt.Errorf("failed to execute template `schema_version`: %w", err)
+	}
+	if qt.setVersion, err = exec(setVersion); err != nil {
+		return nil, fmt.Errorf("failed to execute template `set_version`: %w", err)
+	}
+	if qt.unsetVersion, err = exec(unsetVersion); err != nil {
+		return nil, fmt.Errorf("failed to execute template `unset_version`: %w", err)
+	}
+	if qt.migrateNotify, err = exec(migrateNotify); err != nil {
+		return nil, fmt.Errorf("failed to execute template `migrate_notify`: %w", err)
+	}
+	if qt.payloadIndex, err = exec(migratePayload); err != nil {
+		return nil, fmt.Errorf("failed to execute template `migrate_payload`: %w", err)
 	}
 	if qt.get, err = exec(get); err != nil {
 		return nil, fmt.Errorf("failed to execute template `get`: %w", err)
 	}
+	if qt.getResult, err = exec(getResult); err != nil {
+		return nil, fmt.Errorf("failed to execute template `get_result`: %w", err)
+	}
+	if qt.lock, err = exec(lock); err != nil {
+		return nil, fmt.Errorf("failed to execute template `lock`: %w", err)
+	}
+	if qt.inflight, err = exec(inflight); err != nil {
+		return nil, fmt.Errorf("failed to execute template `inflight`: %w", err)
+	}
+	if qt.overdue, err = exec(overdue); err != nil {
+		return nil, fmt.Errorf("failed to execute template `overdue`: %w", err)
+	}
+	if qt.list, err = exec(list); err != nil {
+		return nil, fmt.Errorf("failed to execute template `list`: %w", err)
+	}
+	if qt.search, err = exec(search); err != nil {
+		return nil, fmt.Errorf("failed to execute template `search`: %w", err)
+	}
+	if qt.unreachable, err = exec(unreachable); err != nil {
+		return nil, fmt.Errorf("failed to execute template `unreachable`: %w", err)
+	}
+	if qt.missingMtime, err = exec(missingMtime); err != nil {
+		return nil, fmt.Errorf("failed to execute template `missing_mtime`: %w", err)
+	}
+	if qt.exhausted, err = exec(exhausted); err != nil {
+		return nil, fmt.Errorf("failed to execute template `exhausted`: %w", err)
+	}
+	if qt.exists, err = exec(exists); err != nil {
+		return nil, fmt.Errorf("failed to execute template `exists`: %w", err)
+	}
 	if qt.put, err = exec(put); err != nil {
 		return nil, fmt.Errorf("failed to execute template `put`: %w", err)
 	}
+	if qt.putBatch, err = exec(putBatch); err != nil {
+		return nil, fmt.Errorf("failed to execute template `put_batch`: %w", err)
+	}
 	if qt.delete, err = exec(delete); err != nil {
 		return nil, fmt.Errorf("failed to execute template `delete`: %w", err)
 	}
+	if qt.deleteLeased, err = exec(deleteLeased); err != nil {
+		return nil, fmt.Errorf("failed to execute template `delete_leased`: %w", err)
+	}
 	if qt.update, err = exec(update); err != nil {
 		return nil, fmt.Errorf("failed to execute template `update`: %w", err)
 	}
-	if qt.poll, err = exec(poll); err != nil {
-		return nil, fmt.Errorf("failed to execute template `poll`: %w", err)
+	for i := range 1 << 8 {
+		mode := pollMode{smear: i&1 != 0, capped: i&2 != 0, boost: i&4 != 0, delays: i&8 != 0, priority: i&16 != 0, limited: i&32 != 0, fair: i&64 != 0, shared: i&128 != 0}
+
+		// optional parameters follow the 10 common ones, in this order
+		pa, n := queryArgs{TableName: tableName, Qualifier: args.Qualifier, Partitioned: partitioned, Fair: mode.fair}, 10
+		param := func() string {
+			n++
+			return fmt.Sprintf("$%d", n)
+		}
+		if mode.smear {
+			pa.OverdueAfter, pa.OverdueCap = param(), param()
+		}
+		if mode.capped {
+			pa.Caps = param()
+		}
+		if mode.boost {
+			pa.BoostNice, pa.BoostGrace = param(), param()
+		}
+		if mode.delays {
+			pa.Delays, pa.LastDelay = param(), backoffSamples-1
+		}
+		if mode.priority {
+			pa.Aging = param()
+		}
+		if mode.limited {
+			pa.Limits = param()
+		}
+		if mode.shared {
+			pa.TypeWeights = param()
+		}
+		if qt.poll[mode], err = execWith(poll, pa); err != nil {
+			return nil, fmt.Errorf("failed to execute template `poll` (%+v): %w", mode, err)
+		}
+	}
+	if qt.pause, err = exec(pause); err != nil {
+		return nil, fmt.Errorf("failed to execute template `pause`: %w", err)
+	}
+	if qt.resume, err = exec(resume); err != nil {
+		return nil, fmt.Errorf("failed to execute template `resume`: %w", err)
+	}
+	if qt.paused, err = exec(paused); err != nil {
+		return nil, fmt.Errorf("failed to execute template `paused`: %w", err)
+	}
+	if qt.lockType, err = exec(lockType); err != nil {
+		return nil, fmt.Errorf("failed to execute template `lock_type`: %w", err)
+	}
+	if qt.releaseOwned, err = exec(releaseOwned); err != nil {
+		return nil, fmt.Errorf("failed to execute template `release_owned`: %w", err)
+	}
+	if qt.releaseDeps, err = exec(releaseDependents); err != nil {
+		return nil, fmt.Errorf("failed to execute template `release_dependents`: %w", err)
+	}
+	if qt.retryFailed, err = exec(retryFailed); err != nil {
+		return nil, fmt.Errorf("failed to execute template `retry_failed`: %w", err)
+	}
+	if qt.dropStale, err = exec(dropStale); err != nil {
+		return nil, fmt.Errorf("failed to execute template `drop_stale`: %w", err)
 	}
 	if qt.expire, err = exec(expire); err != nil {
 		return nil, fmt.Errorf("failed to execute template `expire`: %w", err)
 	}
+	if qt.lockExpired, err = exec(lockExpired); err != nil {
+		return nil, fmt.Errorf("failed to execute template `lock_expired`: %w", err)
+	}
+	if qt.deleteAll, err = exec(deleteAll); err != nil {
+		return nil, fmt.Errorf("failed to execute template `delete_all`: %w", err)
+	}
+	if qt.partitions, err = exec(partitions); err != nil {
+		return nil, fmt.Errorf("failed to execute template `partitions`: %w", err)
+	}
+	if qt.tableKind, err = exec(tableKind); err != nil {
+		return nil, fmt.Errorf("failed to execute template `table_kind`: %w", err)
+	}
 	if qt.backoff, err = exec(backoff); err != nil {
 		return nil, fmt.Errorf("failed to execute template `backoff`: %w", err)
 	}
+	if qt.reschedule, err = exec(reschedule); err != nil {
+		return nil, fmt.Errorf("failed to execute template `reschedule`: %w", err)
+	}
 	return qt, nil
 }
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ochaton/lymbo"
+)
+
+// Execer executes a statement, e.g. pgx.Tx, *pgx.Conn or *pgxpool.Pool.
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// PutTx puts tickets as Put does within the caller's transaction tx, so that
+// they are committed or rolled back with the rest of it, e.g. to enqueue the
+// side effects of a business write without an outbox table. The tickets
+// become visible to pollers on commit. Several tickets are written with a
+// single statement. Nothing is written if any ticket is invalid or two of
+// them are pending with the same UniqueKey and Type. On ErrDuplicateTicket
+// for a UniqueKey already pending, tx must be rolled back: the other tickets
+// may have been written.
+// Tickets put this way are not counted in the Stats of a Kharon.
+func (r *Tickets) PutTx(ctx context.Context, tx Execer, tickets ...lymbo.Ticket) error {
+	if len(tickets) == 1 {
+		args, err := putArgs(tickets[0], r.clock.Now())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, r.queries.put, args...)
+		return r.duplicate(err)
+	}
+
+	// the last of several tickets with the same ID wins, as with PutBatch
+	var first error
+	cols, _ := batchColumns(tickets, r.clock.Now(), func(_ int, err error) {
+		if first == nil {
+			first = err
+		}
+	})
+	if first != nil {
+		return first
+	}
+	if len(cols.id) == 0 {
+		return nil
+	}
+	tag, err := tx.Exec(ctx, r.queries.putBatch, cols.args()...)
+	if err != nil {
+		return r.duplicate(err)
+	}
+	if tag.RowsAffected() < int64(len(cols.id)) {
+		return lymbo.ErrDuplicateTicket
+	}
+	return nil
+}
+
+// PutSQLTx is PutTx for a database/sql transaction, e.g. opened with the
+// pgx stdlib driver. Tickets are written one statement each.
+func (r *Tickets) PutSQLTx(ctx context.Context, tx *sql.Tx, tickets ...lymbo.Ticket) error {
+	now := r.clock.Now()
+	all := make([][]any, 0, len(tickets))
+	for _, t := range tickets {
+		args, err := putArgs(t, now)
+		if err != nil {
+			return err
+		}
+		all = append(all, args)
+	}
+	for _, args := range all {
+		if _, err := tx.ExecContext(ctx, r.queries.put, args...); err != nil {
+			return r.duplicate(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+-- Applies revision-checked writes of tickets, see Store.apply.
+--
+-- KEYS[1], KEYS[2], KEYS[3]: the pending, terminal and modified indexes.
+-- KEYS[4]: the hash of the unique keys of pending tickets to their ids.
+-- KEYS[5]: the deadline index of pending tickets.
+-- KEYS[6]: the index of pending tickets waiting for others.
+-- KEYS[6+i]: the hash of the i-th ticket.
+-- ARGV[1]: "all" to write every op or none, "each" to skip conflicting ones.
+-- ARGV[2]: the maximum number of ops written, 0 for all of them.
+-- ARGV[3+9*(i-1)...]: per op, its kind ("p" pending, "t" terminal, "d" delete),
+-- ticket id, expected revision ("" for any), data, runat and modified scores,
+-- unique key ("" for none), deadline score ("" for none) and "w" if it waits
+-- for other tickets ("" otherwise).
+--
+-- Returns 1 for every op written, -1 for the ones holding the unique key of
+-- another pending ticket and 0 for the others.
+
+local all = ARGV[1] == 'all'
+local limit = tonumber(ARGV[2])
+local n = #KEYS - 6
+
+local function arg(i, field)
+  return ARGV[3 + 9 * (i - 1) + field]
+end
+
+local function current(i)
+  local rev = arg(i, 2)
+  return rev == '' or (redis.call('HGET', KEYS[6 + i], 'rev') or '0') == rev
+end
+
+-- owner returns the id of the pending ticket holding unique key u, if any.
+local function owner(u)
+  local id = redis.call('HGET', KEYS[4], u)
+  if id and redis.call('ZSCORE', KEYS[1], id) then
+    return id
+  end
+  return nil
+end
+
+local res = {}
+for i = 1, n do
+  res[i] = 0
+end
+
+if all then
+  -- held simulates the unique index through the ops, false once released
+  local held = {}
+  for i = 1, n do
+    if not current(i) then
+      return res
+    end
+    local id, u = arg(i, 1), arg(i, 6)
+    local old = redis.call('HGET', KEYS[6 + i], 'uniq')
+    if old and old ~= '' then
+      local o = held[old]
+      if o == nil then
+        o = owner(old)
+      end
+      if o == id then
+        held[old] = false
+      end
+    end
+    if arg(i, 0) == 'p' and u ~= '' then
+      local o = held[u]
+      if o == nil then
+        o = owner(u)
+      end
+      if o and o ~= id then
+        res[i] = -1
+        return res
+      end
+      held[u] = id
+    end
+  end
+end
+
+local written = 0
+for i = 1, n do
+  if limit > 0 and written == limit then
+    break
+  end
+  local kind, id, key, u = arg(i, 0), arg(i, 1), KEYS[6 + i], arg(i, 6)
+  local o = nil
+  if kind == 'p' and u ~= '' then
+    o = owner(u)
+  end
+  if o and o ~= id then
+    res[i] = -1
+  elseif all or current(i) then
+    redis.call('ZREM', KEYS[1], id)
+    redis.call('ZREM', KEYS[2], id)
+    redis.call('ZREM', KEYS[3], id)
+    redis.call('ZREM', KEYS[5], id)
+    redis.call('ZREM', KEYS[6], id)
+    local old = redis.call('HGET', key, 'uniq')
+    if old and old ~= '' and redis.call('HGET', KEYS[4], old) == id then
+      redis.call('HDEL', KEYS[4], old)
+    end
+    if kind == 'd' then
+      redis.call('DEL', key)
+    else
+      redis.call('HSET', key, 'data', arg(i, 3))
+      redis.call('HINCRBY', key, 'rev', 1)
+      if kind == 'p' then
+        redis.call('ZADD', KEYS[1], arg(i, 4), id)
+        if u ~= '' then
+          redis.call('HSET', KEYS[4], u, id)
+        end
+        if arg(i, 7) ~= '' then
+          redis.call('ZADD', KEYS[5], arg(i, 7), id)
+        end
+        if arg(i, 8) ~= '' then
+          redis.call('ZADD', KEYS[6], arg(i, 4), id)
+        end
+      else
+        redis.call('ZADD', KEYS[2], arg(i, 4), id)
+        redis.call('ZADD', KEYS[3], arg(i, 5), id)
+      end
+      redis.call('HSET', key, 'uniq', u)
+    end
+    written = written + 1
+    res[i] = 1
+  end
+end
+return res
@@ -0,0 +1,786 @@
+// Package redis provides a Redis implementation of the lymbo.Store interface,
+// for lightweight deployments that don't run a database.
+//
+// Usage:
+//
+//	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//	store, err := lymboredis.NewStore(lymboredis.Config{Client: rdb})
+//
+// Every ticket is a hash holding its serialized body and a revision number.
+// Pending tickets are indexed by a sorted set scored by Runat, terminal ones by
+// two sorted sets scored by Runat and by last modification, for expiration.
+// The UniqueKeys of pending tickets are indexed by a hash of keys to IDs,
+// their Deadlines by a sorted set, as are the ones waiting for others.
+// Paused types are members of a set.
+// Writes are applied by a Lua script that checks the revision of every ticket
+// it touches, so a ticket is claimed by exactly one poller, a batch of claims
+// takes a single round trip, and Settle writes all of its tickets atomically.
+// All keys share the {Prefix} hash tag, so the store also works on Redis Cluster,
+// within a single slot. Polling reads every ready ticket, which suits queues
+// of up to a few tens of thousands of ready tickets.
+package redis
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/internal/storeutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultPrefix is the key prefix used when Config.Prefix is empty.
+const DefaultPrefix = "lymbo"
+
+type Config struct {
+	Client redis.UniversalClient
+
+	// Prefix namespaces the keys of the store. Defaults to DefaultPrefix.
+	Prefix string
+
+	// Clock tells the time of the tickets put or updated, e.g. the
+	// lymbo.FakeClock of the Kharon, see lymbo.Settings.WithClock.
+	// Defaults to lymbo.SystemClock.
+	Clock lymbo.Clock
+}
+
+// Store is a Redis backed ticket store.
+type Store struct {
+	rdb   redis.UniversalClient
+	clock lymbo.Clock
+
+	prefix   string
+	pending  string
+	terminal string
+	modified string
+	unique   string
+	deadline string
+	waiting  string
+	paused   string
+}
+
+// Ensure Store implements lymbo.Store interface.
+var _ lymbo.Store = (*Store)(nil)
+
+//go:embed apply.lua
+var applySource string
+
+var applyScript = redis.NewScript(applySource)
+
+// NewStore returns a store keeping its tickets under cfg.Prefix.
+func NewStore(cfg Config) (*Store, error) {
+	if cfg.Client == nil {
+		return nil, errors.New("client cannot be nil")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = lymbo.SystemClock
+	}
+
+	prefix := "{" + cfg.Prefix + "}"
+	return &Store{
+		rdb:      cfg.Client,
+		prefix:   prefix,
+		pending:  prefix + ":pending",
+		terminal: prefix + ":terminal",
+		modified: prefix + ":modified",
+		unique:   prefix + ":unique",
+		deadline: prefix + ":deadline",
+		waiting:  prefix + ":waiting",
+		paused:   prefix + ":paused",
+		clock:    cfg.Clock,
+	}, nil
+}
+
+func (s *Store) key(id lymbo.TicketId) string {
+	return s.prefix + ":ticket:" + id.String()
+}
+
+// anyRev makes a write unconditional.
+const anyRev = ""
+
+// op is a write of a single ticket, applied by applyScript if the ticket is
+// still at revision rev.
+type op struct {
+	id     lymbo.TicketId
+	rev    string
+	delete bool
+	ticket lymbo.Ticket
+}
+
+// apply runs ops in a single script. If all is set, either every op is written
+// or, on a revision conflict, none is, or on a UniqueKey already held by
+// another pending ticket, none is and ErrDuplicateTicket is returned;
+// otherwise conflicting ops are skipped.
+// At most limit ops are written, 0 meaning all of them.
+// It reports which ops were written.
+func (s *Store) apply(ctx context.Context, ops []op, all bool, limit int) ([]bool, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	mode := "each"
+	if all {
+		mode = "all"
+	}
+	keys := make([]string, 0, 6+len(ops))
+	keys = append(keys, s.pending, s.terminal, s.modified, s.unique, s.deadline, s.waiting)
+	args := make([]any, 0, 2+9*len(ops))
+	args = append(args, mode, limit)
+	for _, o := range ops {
+		keys = append(keys, s.key(o.id))
+		if o.delete {
+			args = append(args, "d", o.id.String(), o.rev, "", 0, 0, "", "", "")
+			continue
+		}
+
+		data, err := storeutil.Marshal(o.ticket)
+		if err != nil {
+			return nil, err
+		}
+		kind := "t"
+		if o.ticket.Status == status.Pending {
+			kind = "p"
+		}
+		modified := o.ticket.Ctime
+		if o.ticket.Mtime != nil {
+			modified = *o.ticket.Mtime
+		}
+		var unique string
+		if u, ok := storeutil.Unique(o.ticket); ok {
+			unique = u.String()
+		}
+		var deadline string
+		if o.ticket.Deadline != nil {
+			deadline = strconv.FormatInt(o.ticket.Deadline.UnixMilli(), 10)
+		}
+		var waiting string
+		if storeutil.Blocked(o.ticket) {
+			waiting = "w"
+		}
+		args = append(args, kind, o.id.String(), o.rev, data, o.ticket.Runat.UnixMilli(), modified.UnixMilli(), unique, deadline, waiting)
+	}
+
+	res, err := applyScript.Run(ctx, s.rdb, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	written := make([]bool, len(ops))
+	for i, r := range res {
+		if r == -1 && all {
+			return nil, lymbo.ErrDuplicateTicket
+		}
+		written[i] = r == 1
+	}
+	return written, nil
+}
+
+type entry struct {
+	ticket   lymbo.Ticket
+	revision string
+}
+
+// load returns the tickets with their current revisions, skipping
+// the ones that don't exist.
+func (s *Store) load(ctx context.Context, ids ...string) ([]entry, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.SliceCmd, len(ids))
+	_, err := s.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = p.HMGet(ctx, s.key(lymbo.TicketId(id)), "data", "rev")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(ids))
+	for i, cmd := range cmds {
+		vals := cmd.Val()
+		data, ok := vals[0].(string)
+		if !ok {
+			// deleted meanwhile
+			continue
+		}
+		t, err := storeutil.Unmarshal([]byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode ticket %q: %w", ids[i], err)
+		}
+		rev, _ := vals[1].(string)
+		entries = append(entries, entry{ticket: t, revision: rev})
+	}
+	return entries, nil
+}
+
+// get returns a single ticket with its revision.
+func (s *Store) get(ctx context.Context, id lymbo.TicketId) (entry, error) {
+	if id == "" {
+		return entry{}, lymbo.ErrTicketIDEmpty
+	}
+	entries, err := s.load(ctx, id.String())
+	if err != nil {
+		return entry{}, err
+	}
+	if len(entries) == 0 {
+		return entry{}, lymbo.ErrTicketNotFound
+	}
+	return entries[0], nil
+}
+
+// modify applies fn to the ticket and writes it back if its revision didn't
+// change meanwhile, retrying on conflicts. fn may run several times.
+func (s *Store) modify(ctx context.Context, id lymbo.TicketId, fn func(*lymbo.Ticket) error) error {
+	for {
+		e, err := s.get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(&e.ticket); err != nil {
+			return err
+		}
+
+		written, err := s.apply(ctx, []op{{id: id, rev: e.revision, ticket: e.ticket}}, true, 0)
+		if err != nil || written[0] {
+			return err
+		}
+	}
+}
+
+// score returns a ZRANGE BYSCORE bound for t, exclusive if open is set.
+func score(t time.Time, open bool) string {
+	ms := strconv.FormatInt(t.UnixMilli(), 10)
+	if open {
+		return "(" + ms
+	}
+	return ms
+}
+
+// rangeIDs returns the members of the sorted set key scored within [min, max].
+func (s *Store) rangeIDs(ctx context.Context, key, min, max string, count int64) ([]string, error) {
+	return s.rdb.ZRangeArgs(ctx, redis.ZRangeArgs{
+		Key:     key,
+		Start:   min,
+		Stop:    max,
+		ByScore: true,
+		Count:   count,
+	}).Result()
+}
+
+// values iterates over the tickets of entries.
+func values(entries []entry) iter.Seq[lymbo.Ticket] {
+	return func(yield func(lymbo.Ticket) bool) {
+		for _, e := range entries {
+			if !yield(e.ticket) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Store) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
+	e, err := s.get(ctx, id)
+	return e.ticket, err
+}
+
+func (s *Store) Exists(ctx context.Context, id lymbo.TicketId) (bool, error) {
+	if id == "" {
+		return false, lymbo.ErrTicketIDEmpty
+	}
+	n, err := s.rdb.Exists(ctx, s.key(id)).Result()
+	return n > 0, err
+}
+
+// GetResult returns the Result of a settled ticket.
+func (s *Store) GetResult(ctx context.Context, id lymbo.TicketId) (any, error) {
+	t, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.Result(t)
+}
+
+func (s *Store) Put(ctx context.Context, t lymbo.Ticket) error {
+	if t.ID == "" {
+		return lymbo.ErrTicketIDEmpty
+	}
+
+	storeutil.Defaults(&t, s.clock.Now())
+	_, err := s.apply(ctx, []op{{id: t.ID, rev: anyRev, ticket: t}}, true, 0)
+	return err
+}
+
+// PutBatch puts the tickets with a single script call.
+// Tickets are written unconditionally, so the ones skipped are duplicates.
+func (s *Store) PutBatch(ctx context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs := storeutil.NewBatchErrors(len(tickets))
+	now := s.clock.Now()
+	ops := make([]op, 0, len(tickets))
+	index := make([]int, 0, len(tickets)) // of ops tickets in tickets
+	for i, t := range tickets {
+		if t.ID == "" {
+			errs.Set(i, lymbo.ErrTicketIDEmpty)
+			continue
+		}
+		storeutil.Defaults(&t, now)
+		ops = append(ops, op{id: t.ID, rev: anyRev, ticket: t})
+		index = append(index, i)
+	}
+	if len(ops) > 0 {
+		written, err := s.apply(ctx, ops, false, 0)
+		if err != nil {
+			return nil, err
+		}
+		for j, ok := range written {
+			if !ok {
+				errs.Set(index[j], lymbo.ErrDuplicateTicket)
+			}
+		}
+	}
+	return errs.Errs(), nil
+}
+
+func (s *Store) Delete(ctx context.Context, id lymbo.TicketId) error {
+	return s.DeleteBatch(ctx, []lymbo.TicketId{id})
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, ids []lymbo.TicketId) error {
+	ops := make([]op, 0, len(ids))
+	for _, id := range ids {
+		if id == "" {
+			return lymbo.ErrTicketIDEmpty
+		}
+		ops = append(ops, op{id: id, rev: anyRev, delete: true})
+	}
+	_, err := s.apply(ctx, ops, false, 0)
+	return err
+}
+
+// Update modifies a ticket with compare-and-swap semantics.
+// fn is called again if the ticket changed concurrently.
+func (s *Store) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.UpdateFunc) error {
+	return s.modify(ctx, id, func(t *lymbo.Ticket) error {
+		return fn(ctx, t)
+	})
+}
+
+func (s *Store) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
+	return s.modify(ctx, us.Id, func(t *lymbo.Ticket) error {
+		if err := storeutil.CheckLease(*t, us); err != nil {
+			return err
+		}
+		storeutil.Apply(t, us, s.clock.Now())
+		return nil
+	})
+}
+
+func (s *Store) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
+	var errs []error
+	for _, us := range updates {
+		if err := s.UpdateSet(ctx, us); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Settle writes the outcome and the follow-up tickets in a single script,
+// retrying if the settled ticket changed concurrently.
+func (s *Store) Settle(ctx context.Context, st lymbo.Settlement) error {
+	for _, next := range st.Next {
+		if next.ID == "" {
+			return lymbo.ErrTicketIDEmpty
+		}
+	}
+
+	for {
+		e, err := s.get(ctx, st.Update.Id)
+		if err != nil {
+			return err
+		}
+		now := s.clock.Now()
+		if err := storeutil.Settle(ctx, &e.ticket, st, now); err != nil {
+			return err
+		}
+
+		ops := make([]op, 0, 1+len(st.Next))
+		ops = append(ops, op{id: e.ticket.ID, rev: e.revision, delete: st.Delete, ticket: e.ticket})
+		for _, next := range st.Next {
+			storeutil.Defaults(&next, now)
+			ops = append(ops, op{id: next.ID, rev: anyRev, ticket: next})
+		}
+
+		written, err := s.apply(ctx, ops, true, 0)
+		if err != nil || written[0] {
+			return err
+		}
+	}
+}
+
+func (s *Store) Reschedule(ctx context.Context, id lymbo.TicketId, runat time.Time) error {
+	return s.modify(ctx, id, func(t *lymbo.Ticket) error {
+		if t.Status != status.Pending {
+			return lymbo.ErrInvalidStatusTransition
+		}
+		now := s.clock.Now()
+		t.Runat = runat
+		t.Mtime = &now
+		return nil
+	})
+}
+
+// Touch reschedules the ticket to now + extendBy, see Reschedule.
+func (s *Store) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	return s.Reschedule(ctx, id, s.clock.Now().Add(extendBy))
+}
+
+// PollPending reads the ready tickets, and the earliest future one for
+// SleepUntil, then claims them in a single script, skipping the ones
+// claimed concurrently by another poller.
+func (s *Store) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
+	if req.Limit <= 0 {
+		return lymbo.PollResult{}, lymbo.ErrLimitInvalid
+	}
+
+	paused, err := s.Paused(ctx)
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+	horizon := req.Now.Add(max(req.Boost.Grace, 0))
+	ids, err := s.rangeIDs(ctx, s.pending, "-inf", score(horizon, false), 0)
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+	if len(req.MaxInFlightPerType) > 0 || len(paused) > 0 {
+		// in-flight tickets are pending with a future runat, counted for the
+		// caps, and the earliest one may be paused
+		ids, err = s.rangeIDs(ctx, s.pending, "-inf", "+inf", 0)
+	} else {
+		var later []string
+		later, err = s.rangeIDs(ctx, s.pending, score(horizon, true), "+inf", 1)
+		ids = append(ids, later...)
+	}
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+
+	entries, err := s.load(ctx, ids...)
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+	ready, sleepUntil := storeutil.Select(storeutil.Unpaused(values(entries), paused), req)
+	if len(ready) == 0 {
+		return lymbo.PollResult{SleepUntil: sleepUntil}, nil
+	}
+
+	revisions := make(map[lymbo.TicketId]string, len(entries))
+	for _, e := range entries {
+		revisions[e.ticket.ID] = e.revision
+	}
+
+	ready, stale := storeutil.CatchUp(ready, req)
+	drops := make([]op, 0, len(stale))
+	for _, t := range stale {
+		storeutil.Drop(&t, req.Now)
+		drops = append(drops, op{id: t.ID, rev: revisions[t.ID], ticket: t})
+	}
+	dropped, err := s.apply(ctx, drops, false, 0)
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+
+	claims := make([]op, 0, len(ready))
+	for _, t := range ready {
+		storeutil.Claim(&t, req)
+		claims = append(claims, op{id: t.ID, rev: revisions[t.ID], ticket: t})
+	}
+	claimed, err := s.apply(ctx, claims, false, req.Limit)
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+
+	tickets := make([]lymbo.Ticket, 0, min(req.Limit, len(claims)))
+	due := make([]time.Time, 0, cap(tickets))
+	for i, ok := range claimed {
+		if ok {
+			tickets = append(tickets, claims[i].ticket)
+			due = append(due, ready[i].Runat)
+		}
+	}
+	res := lymbo.PollResult{Tickets: tickets, Due: due}
+	for _, ok := range dropped {
+		if ok {
+			res.Dropped++
+		}
+	}
+	return res, nil
+}
+
+// Pause adds typ to the set of paused types.
+func (s *Store) Pause(ctx context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	return s.rdb.SAdd(ctx, s.paused, typ).Err()
+}
+
+// Resume removes typ from the set of paused types.
+func (s *Store) Resume(ctx context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	return s.rdb.SRem(ctx, s.paused, typ).Err()
+}
+
+func (s *Store) Paused(ctx context.Context) ([]string, error) {
+	types, err := s.rdb.SMembers(ctx, s.paused).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(types)
+	return types, nil
+}
+
+func (s *Store) ListInFlight(ctx context.Context, now time.Time) ([]lymbo.Ticket, error) {
+	ids, err := s.rangeIDs(ctx, s.pending, score(now, true), "+inf", 0)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.load(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	var tickets []lymbo.Ticket
+	for _, e := range entries {
+		if storeutil.InFlight(e.ticket, now) {
+			tickets = append(tickets, e.ticket)
+		}
+	}
+	return tickets, nil
+}
+
+// ReleaseOwned releases the in-flight tickets of owner in a single script,
+// skipping the ones modified concurrently, e.g. settled meanwhile.
+func (s *Store) ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error) {
+	ids, err := s.rangeIDs(ctx, s.pending, score(now, true), "+inf", 0)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := s.load(ctx, ids...)
+	if err != nil {
+		return 0, err
+	}
+
+	var ops []op
+	for _, e := range entries {
+		if !storeutil.Owned(e.ticket, owner, now) {
+			continue
+		}
+		storeutil.Release(&e.ticket, now)
+		ops = append(ops, op{id: e.ticket.ID, rev: e.revision, ticket: e.ticket})
+	}
+	released, err := s.apply(ctx, ops, false, 0)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, ok := range released {
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ReleaseDependents reads the tickets of the waiting index and unblocks the
+// ones waiting for id one by one, retrying the ones modified concurrently,
+// e.g. claimed meanwhile, so that none stays blocked.
+func (s *Store) ReleaseDependents(ctx context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	ids, err := s.rangeIDs(ctx, s.waiting, "-inf", "+inf", 0)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := s.load(ctx, ids...)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, e := range entries {
+		if !storeutil.Waits(e.ticket, id) {
+			continue
+		}
+		var released bool
+		err := s.modify(ctx, e.ticket.ID, func(t *lymbo.Ticket) error {
+			if released = storeutil.Waits(*t, id); released {
+				storeutil.Unblock(t, id, now)
+			}
+			return nil
+		})
+		switch {
+		case errors.Is(err, lymbo.ErrTicketNotFound):
+			// removed meanwhile
+		case err != nil:
+			return n, err
+		case released:
+			n++
+		}
+	}
+	return n, nil
+}
+
+// RetryFailed makes the selected failed tickets pending in a single script,
+// skipping the ones modified concurrently, e.g. deleted meanwhile.
+func (s *Store) RetryFailed(ctx context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	entries, err := s.all(ctx, s.terminal)
+	if err != nil {
+		return 0, err
+	}
+
+	var ops []op
+	for _, e := range entries {
+		if !storeutil.Retried(e.ticket, req) {
+			continue
+		}
+		storeutil.Requeue(&e.ticket, req)
+		ops = append(ops, op{id: e.ticket.ID, rev: e.revision, ticket: e.ticket})
+	}
+	retried, err := s.apply(ctx, ops, false, 0)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, ok := range retried {
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ListOverdue reads the tickets of the deadline index up to now.
+func (s *Store) ListOverdue(ctx context.Context, now time.Time, limit int) ([]lymbo.Ticket, error) {
+	if limit <= 0 {
+		return nil, lymbo.ErrLimitInvalid
+	}
+	ids, err := s.rangeIDs(ctx, s.deadline, "-inf", score(now, false), int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.load(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.ListOverdue(values(entries), now, limit), nil
+}
+
+// all returns the tickets indexed by keys.
+func (s *Store) all(ctx context.Context, keys ...string) ([]entry, error) {
+	var ids []string
+	for _, key := range keys {
+		members, err := s.rdb.ZRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, members...)
+	}
+	return s.load(ctx, ids...)
+}
+
+func (s *Store) List(ctx context.Context, req lymbo.ListRequest) ([]lymbo.Ticket, error) {
+	keys := []string{s.pending, s.terminal}
+	if req.Status != nil {
+		if *req.Status == status.Pending {
+			keys = keys[:1]
+		} else {
+			keys = keys[1:]
+		}
+	}
+	entries, err := s.all(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.List(values(entries), req), nil
+}
+
+func (s *Store) Vacuum(ctx context.Context, req lymbo.VacuumRequest) (lymbo.VacuumReport, error) {
+	entries, err := s.all(ctx, s.pending, s.terminal)
+	if err != nil {
+		return lymbo.VacuumReport{}, err
+	}
+	return storeutil.Vacuum(values(entries), req), nil
+}
+
+// ExpireTickets removes expired non-pending tickets, skipping the ones
+// modified since they were read. Candidates are the terminal tickets whose
+// Runat has passed or that weren't modified since the shortest retention.
+func (s *Store) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
+	ids, err := s.rangeIDs(ctx, s.terminal, "-inf", score(req.Now, false), 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(req.Retention) > 0 {
+		shortest := time.Duration(-1)
+		for _, d := range req.Retention {
+			if shortest < 0 || d < shortest {
+				shortest = d
+			}
+		}
+		modified, err := s.rangeIDs(ctx, s.modified, "-inf", score(req.Now.Add(-shortest), false), 0)
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, modified...)
+	}
+
+	entries, err := s.load(ctx, ids...)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[lymbo.TicketId]bool, len(entries))
+	ops := make([]op, 0, min(req.Limit, len(entries)))
+	var expired []lymbo.Ticket
+	for _, e := range entries {
+		if len(ops) == req.Limit {
+			break
+		}
+		if seen[e.ticket.ID] || e.ticket.Status == status.Pending {
+			continue
+		}
+		seen[e.ticket.ID] = true
+		if storeutil.ExpiresAt(e.ticket, req.Retention).After(req.Now) {
+			continue
+		}
+		ops = append(ops, op{id: e.ticket.ID, rev: e.revision, delete: true})
+		expired = append(expired, e.ticket)
+	}
+	if err := storeutil.Archive(ctx, req, expired); err != nil {
+		return 0, err
+	}
+
+	deleted, err := s.apply(ctx, ops, false, 0)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, ok := range deleted {
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
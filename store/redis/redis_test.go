@@ -0,0 +1,50 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/store/redis"
+	"github.com/ochaton/lymbo/store/storetest"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// prefixes numbers the key prefixes of the stores.
+var prefixes atomic.Int64
+
+func TestStore(t *testing.T) {
+	url := os.Getenv("LYMBO_TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("LYMBO_TEST_REDIS_URL is not set")
+	}
+	opts, err := goredis.ParseURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := goredis.NewClient(opts)
+	t.Cleanup(func() { client.Close() })
+
+	storetest.TestStore(t, func() lymbo.Store {
+		// keys of its own per store, deleted when the test ends
+		prefix := fmt.Sprintf("storetest:%d:%d", os.Getpid(), prefixes.Add(1))
+		t.Cleanup(func() {
+			ctx := context.Background()
+			keys, err := client.Keys(ctx, "{"+prefix+"}:*").Result()
+			if err == nil && len(keys) > 0 {
+				err = client.Del(ctx, keys...).Err()
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		s, err := redis.NewStore(redis.Config{Client: client, Prefix: prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,855 @@
+// Package sqlite provides a SQLite implementation of the lymbo.Store interface,
+// for CLI tools, edge devices and other single-binary deployments without a
+// database server. It works with any database/sql SQLite driver, which the
+// application imports and opens itself.
+//
+// Usage:
+//
+//	db, _ := sql.Open("sqlite", "file:lymbo.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+//	store, err := sqlite.NewStore(sqlite.Config{DB: db})
+//	err = store.Migrate(ctx)
+//
+// SQLite has no row locks to skip: every write runs in a BEGIN IMMEDIATE
+// transaction, which takes the database write lock upfront, and writes of a
+// Store are serialized so that they never wait on each other. A poll thus
+// claims its tickets alone, like a SKIP LOCKED poll that found nothing locked.
+// Other processes sharing the file wait on the lock up to the busy timeout.
+//
+// Tickets are kept serialized in a data column, next to the columns that
+// polling and expiration filter on.
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+	"github.com/ochaton/lymbo/store/internal/storeutil"
+)
+
+type Config struct {
+	DB *sql.DB
+
+	// TableName is the name of the tickets table. Defaults to "tickets".
+	TableName string
+
+	// Clock tells the time of the tickets put or updated, e.g. the
+	// lymbo.FakeClock of the Kharon, see lymbo.Settings.WithClock.
+	// Defaults to lymbo.SystemClock.
+	Clock lymbo.Clock
+}
+
+// Store is a SQLite backed ticket store.
+type Store struct {
+	db      *sql.DB
+	queries queries
+	clock   lymbo.Clock
+
+	// mu serializes the write transactions of the store.
+	mu sync.Mutex
+}
+
+// Ensure Store implements lymbo.Store interface.
+var _ lymbo.Store = (*Store)(nil)
+
+// NewStore returns a store on top of db. Run Migrate to create the table.
+func NewStore(cfg Config) (*Store, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("db cannot be nil")
+	}
+	if cfg.TableName == "" {
+		cfg.TableName = "tickets"
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = lymbo.SystemClock
+	}
+
+	q, err := newQueries(cfg.TableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize query templates: %w", err)
+	}
+	return &Store{db: cfg.DB, queries: q, clock: cfg.Clock}, nil
+}
+
+type queries struct {
+	migrate  string
+	get      string
+	exists   string
+	put      string
+	delete   string
+	poll     string
+	next     string
+	pending  string
+	inflight string
+	waiting  string
+	overdue  string
+	failed   string
+	list     string
+	all      string
+	expired  string
+	pause    string
+	resume   string
+	paused   string
+}
+
+const templates = `
+{{define "migrate"}}
+CREATE TABLE IF NOT EXISTS {{.}} (
+	id       TEXT PRIMARY KEY,
+	status   TEXT NOT NULL,
+	runat    INTEGER NOT NULL, -- unix milliseconds
+	nice     INTEGER NOT NULL,
+	type     TEXT NOT NULL,
+	ctime    INTEGER NOT NULL,
+	modified INTEGER NOT NULL, -- mtime, or ctime if never modified
+	data     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS {{.}}_pending ON {{.}} (runat, nice) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS {{.}}_pending_queue ON {{.}} ({{template "queue"}}, runat, nice) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS {{.}}_terminal_runat ON {{.}} (runat) WHERE status <> 'pending';
+CREATE INDEX IF NOT EXISTS {{.}}_terminal_modified ON {{.}} (modified) WHERE status <> 'pending';
+CREATE INDEX IF NOT EXISTS {{.}}_ctime ON {{.}} (ctime, id);
+CREATE UNIQUE INDEX IF NOT EXISTS {{.}}_unique ON {{.}} (type, json_extract(data, '$.unique_key'))
+WHERE status = 'pending' AND json_extract(data, '$.unique_key') IS NOT NULL;
+CREATE INDEX IF NOT EXISTS {{.}}_pending_deadline ON {{.}} (id)
+WHERE status = 'pending' AND json_extract(data, '$.deadline') IS NOT NULL;
+CREATE TABLE IF NOT EXISTS {{.}}_paused (type TEXT PRIMARY KEY);
+{{end}}
+{{define "get"}}SELECT data FROM {{.}} WHERE id = ?{{end}}
+{{define "exists"}}SELECT EXISTS (SELECT 1 FROM {{.}} WHERE id = ?){{end}}
+{{define "put"}}
+INSERT INTO {{.}} (id, status, runat, nice, type, ctime, modified, data)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET
+	status = excluded.status,
+	runat = excluded.runat,
+	nice = excluded.nice,
+	type = excluded.type,
+	ctime = excluded.ctime,
+	modified = excluded.modified,
+	data = excluded.data
+{{end}}
+{{define "delete"}}DELETE FROM {{.}} WHERE id = ?{{end}}
+{{define "queue"}}IFNULL(json_extract(data, '$.queue'), ''){{end}}
+{{define "unblocked"}}json_extract(data, '$.depends_on') IS NULL{{end}}
+{{define "unpaused"}}type NOT IN (SELECT type FROM {{.}}_paused){{end}}
+{{define "poll"}}
+SELECT data FROM {{.}}
+WHERE status = 'pending' AND {{template "queue"}} = ? AND runat <= ? AND {{template "unblocked"}} AND {{template "unpaused" .}}
+ORDER BY runat, nice
+LIMIT ?
+{{end}}
+{{define "next"}}
+SELECT data FROM {{.}}
+WHERE status = 'pending' AND {{template "queue"}} = ? AND runat > ? AND {{template "unblocked"}} AND {{template "unpaused" .}}
+ORDER BY runat, nice
+LIMIT 1
+{{end}}
+{{define "pending"}}SELECT data FROM {{.}} WHERE status = 'pending'{{end}}
+{{define "inflight"}}SELECT data FROM {{.}} WHERE status = 'pending' AND runat > ?{{end}}
+{{define "waiting"}}
+SELECT data FROM {{.}}
+WHERE status = 'pending' AND EXISTS (SELECT 1 FROM json_each(data, '$.depends_on') WHERE value = ?)
+{{end}}
+{{define "overdue"}}
+SELECT data FROM {{.}}
+WHERE status = 'pending' AND json_extract(data, '$.deadline') IS NOT NULL
+{{end}}
+{{define "failed"}}SELECT data FROM {{.}} WHERE status = 'failed'{{end}}
+{{define "list"}}
+SELECT data FROM {{.}}
+WHERE (?1 IS NULL OR status = ?1)
+	AND (?3 IS NULL OR type IN (SELECT value FROM json_each(?3)))
+	AND (?4 IS NULL OR ctime >= ?4)
+	AND (?5 IS NULL OR ctime < ?5)
+	AND (?6 IS NULL OR runat >= ?6)
+	AND (?7 IS NULL OR runat < ?7)
+	AND (?8 IS NULL OR (ctime, id) > (?8, ?9))
+	AND (?10 IS NULL OR IFNULL(json_extract(data, '$.tenant_id'), '') IN (SELECT value FROM json_each(?10)))
+ORDER BY ctime, id
+LIMIT ?2
+{{end}}
+{{define "all"}}SELECT data FROM {{.}}{{end}}
+{{define "pause"}}INSERT INTO {{.}}_paused (type) VALUES (?) ON CONFLICT (type) DO NOTHING{{end}}
+{{define "resume"}}DELETE FROM {{.}}_paused WHERE type = ?{{end}}
+{{define "paused"}}SELECT type FROM {{.}}_paused ORDER BY type{{end}}
+{{define "expired"}}
+SELECT data FROM {{.}}
+WHERE status <> 'pending' AND (runat <= ? OR modified <= ?)
+ORDER BY modified
+{{end}}
+`
+
+func newQueries(tableName string) (queries, error) {
+	tmpl, err := template.New("queries").Parse(templates)
+	if err != nil {
+		return queries{}, err
+	}
+
+	var q queries
+	for name, dst := range map[string]*string{
+		"migrate":  &q.migrate,
+		"get":      &q.get,
+		"exists":   &q.exists,
+		"put":      &q.put,
+		"delete":   &q.delete,
+		"poll":     &q.poll,
+		"next":     &q.next,
+		"pending":  &q.pending,
+		"inflight": &q.inflight,
+		"waiting":  &q.waiting,
+		"overdue":  &q.overdue,
+		"failed":   &q.failed,
+		"list":     &q.list,
+		"all":      &q.all,
+		"expired":  &q.expired,
+		"pause":    &q.pause,
+		"resume":   &q.resume,
+		"paused":   &q.paused,
+	} {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, name, tableName); err != nil {
+			return queries{}, fmt.Errorf("failed to render %s: %w", name, err)
+		}
+		*dst = buf.String()
+	}
+	return q, nil
+}
+
+// Migrate creates the tickets table, its indexes and the table of paused
+// types if they don't exist.
+func (s *Store) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, s.queries.migrate); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return nil
+}
+
+// querier is implemented by *sql.DB and *sql.Conn.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// write runs fn in a BEGIN IMMEDIATE transaction, committed if fn succeeds.
+func (s *Store) write(ctx context.Context, fn func(q querier) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	if err := fn(conn); err != nil {
+		// the context may be done already, roll back regardless
+		_, rerr := conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK")
+		return errors.Join(err, rerr)
+	}
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+func (s *Store) load(ctx context.Context, q querier, id lymbo.TicketId) (lymbo.Ticket, error) {
+	if id == "" {
+		return lymbo.Ticket{}, lymbo.ErrTicketIDEmpty
+	}
+
+	var data []byte
+	err := q.QueryRowContext(ctx, s.queries.get, id.String()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lymbo.Ticket{}, lymbo.ErrTicketNotFound
+	}
+	if err != nil {
+		return lymbo.Ticket{}, err
+	}
+	return decode(data)
+}
+
+func decode(data []byte) (lymbo.Ticket, error) {
+	t, err := storeutil.Unmarshal(data)
+	if err != nil {
+		return lymbo.Ticket{}, fmt.Errorf("failed to decode ticket: %w", err)
+	}
+	return t, nil
+}
+
+func (s *Store) save(ctx context.Context, q querier, t lymbo.Ticket) error {
+	data, err := storeutil.Marshal(t)
+	if err != nil {
+		return err
+	}
+	modified := t.Ctime
+	if t.Mtime != nil {
+		modified = *t.Mtime
+	}
+	_, err = q.ExecContext(ctx, s.queries.put,
+		t.ID.String(), t.Status.String(), t.Runat.UnixMilli(), t.Nice, t.Type, t.Ctime.UnixMilli(), modified.UnixMilli(), string(data))
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		// the id conflicts are upserts, so it's the UniqueKey index
+		return lymbo.ErrDuplicateTicket
+	}
+	return err
+}
+
+// query returns the tickets selected by query.
+func (s *Store) query(ctx context.Context, q querier, query string, args ...any) ([]lymbo.Ticket, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []lymbo.Ticket
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		t, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
+
+// modify applies fn to the ticket and writes it back in a single transaction.
+func (s *Store) modify(ctx context.Context, id lymbo.TicketId, fn func(*lymbo.Ticket) error) error {
+	return s.write(ctx, func(q querier) error {
+		t, err := s.load(ctx, q, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(&t); err != nil {
+			return err
+		}
+		return s.save(ctx, q, t)
+	})
+}
+
+func (s *Store) Get(ctx context.Context, id lymbo.TicketId) (lymbo.Ticket, error) {
+	return s.load(ctx, s.db, id)
+}
+
+func (s *Store) Exists(ctx context.Context, id lymbo.TicketId) (bool, error) {
+	if id == "" {
+		return false, lymbo.ErrTicketIDEmpty
+	}
+	var exists bool
+	err := s.db.QueryRowContext(ctx, s.queries.exists, id.String()).Scan(&exists)
+	return exists, err
+}
+
+// GetResult returns the Result of a settled ticket.
+func (s *Store) GetResult(ctx context.Context, id lymbo.TicketId) (any, error) {
+	t, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.Result(t)
+}
+
+func (s *Store) Put(ctx context.Context, t lymbo.Ticket) error {
+	if t.ID == "" {
+		return lymbo.ErrTicketIDEmpty
+	}
+
+	storeutil.Defaults(&t, s.clock.Now())
+	return s.write(ctx, func(q querier) error {
+		return s.save(ctx, q, t)
+	})
+}
+
+// PutBatch puts the tickets in a single transaction.
+func (s *Store) PutBatch(ctx context.Context, tickets []lymbo.Ticket) ([]error, error) {
+	errs := storeutil.NewBatchErrors(len(tickets))
+	now := s.clock.Now()
+	err := s.write(ctx, func(q querier) error {
+		for i, t := range tickets {
+			if t.ID == "" {
+				errs.Set(i, lymbo.ErrTicketIDEmpty)
+				continue
+			}
+			storeutil.Defaults(&t, now)
+			err := s.save(ctx, q, t)
+			switch {
+			case errors.Is(err, lymbo.ErrDuplicateTicket):
+				// only the failed statement is rolled back
+				errs.Set(i, err)
+			case err != nil:
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs.Errs(), nil
+}
+
+func (s *Store) Delete(ctx context.Context, id lymbo.TicketId) error {
+	return s.DeleteBatch(ctx, []lymbo.TicketId{id})
+}
+
+func (s *Store) DeleteBatch(ctx context.Context, ids []lymbo.TicketId) error {
+	return s.write(ctx, func(q querier) error {
+		for _, id := range ids {
+			if _, err := q.ExecContext(ctx, s.queries.delete, id.String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Update(ctx context.Context, id lymbo.TicketId, fn lymbo.UpdateFunc) error {
+	return s.modify(ctx, id, func(t *lymbo.Ticket) error {
+		return fn(ctx, t)
+	})
+}
+
+func (s *Store) UpdateSet(ctx context.Context, us lymbo.UpdateSet) error {
+	return s.modify(ctx, us.Id, func(t *lymbo.Ticket) error {
+		if err := storeutil.CheckLease(*t, us); err != nil {
+			return err
+		}
+		storeutil.Apply(t, us, s.clock.Now())
+		return nil
+	})
+}
+
+// UpdateBatch applies every update in a single transaction: if one fails,
+// none is written.
+func (s *Store) UpdateBatch(ctx context.Context, updates []lymbo.UpdateSet) error {
+	return s.write(ctx, func(q querier) error {
+		now := s.clock.Now()
+		for _, us := range updates {
+			t, err := s.load(ctx, q, us.Id)
+			if err != nil {
+				return err
+			}
+			if err := storeutil.CheckLease(t, us); err != nil {
+				return err
+			}
+			storeutil.Apply(&t, us, now)
+			if err := s.save(ctx, q, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Settle writes the outcome and the follow-up tickets in a single transaction.
+func (s *Store) Settle(ctx context.Context, st lymbo.Settlement) error {
+	for _, next := range st.Next {
+		if next.ID == "" {
+			return lymbo.ErrTicketIDEmpty
+		}
+	}
+
+	return s.write(ctx, func(q querier) error {
+		t, err := s.load(ctx, q, st.Update.Id)
+		if err != nil {
+			return err
+		}
+		now := s.clock.Now()
+		if err := storeutil.Settle(ctx, &t, st, now); err != nil {
+			return err
+		}
+
+		if st.Delete {
+			_, err = q.ExecContext(ctx, s.queries.delete, t.ID.String())
+		} else {
+			err = s.save(ctx, q, t)
+		}
+		if err != nil {
+			return err
+		}
+		for _, next := range st.Next {
+			storeutil.Defaults(&next, now)
+			if err := s.save(ctx, q, next); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Reschedule(ctx context.Context, id lymbo.TicketId, runat time.Time) error {
+	return s.modify(ctx, id, func(t *lymbo.Ticket) error {
+		if t.Status != status.Pending {
+			return lymbo.ErrInvalidStatusTransition
+		}
+		now := s.clock.Now()
+		t.Runat = runat
+		t.Mtime = &now
+		return nil
+	})
+}
+
+// Touch reschedules the ticket to now + extendBy, see Reschedule.
+func (s *Store) Touch(ctx context.Context, id lymbo.TicketId, extendBy time.Duration) error {
+	return s.Reschedule(ctx, id, s.clock.Now().Add(extendBy))
+}
+
+// PollPending selects and claims ready tickets in a single write transaction.
+// Unless the request filters or orders tickets beyond their runat (labels,
+// catch-up, in-flight caps or priority), only the first req.Limit ready
+// tickets are read.
+func (s *Store) PollPending(ctx context.Context, req lymbo.PollRequest) (lymbo.PollResult, error) {
+	if req.Limit <= 0 {
+		return lymbo.PollResult{}, lymbo.ErrLimitInvalid
+	}
+
+	var res lymbo.PollResult
+	err := s.write(ctx, func(q querier) error {
+		res = lymbo.PollResult{}
+		candidates, err := s.candidates(ctx, q, req)
+		if err != nil {
+			return err
+		}
+		paused, err := s.pausedTypes(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		ready, sleepUntil := storeutil.Select(storeutil.Unpaused(values(candidates), paused), req)
+		if len(ready) == 0 {
+			res.SleepUntil = sleepUntil
+			return nil
+		}
+
+		ready, stale := storeutil.CatchUp(ready, req)
+		for _, t := range stale {
+			storeutil.Drop(&t, req.Now)
+			if err := s.save(ctx, q, t); err != nil {
+				return err
+			}
+		}
+		res.Dropped = len(stale)
+
+		ready = ready[:min(req.Limit, len(ready))]
+		res.Due = make([]time.Time, len(ready))
+		for i := range ready {
+			res.Due[i] = storeutil.Claim(&ready[i], req)
+			if err := s.save(ctx, q, ready[i]); err != nil {
+				return err
+			}
+		}
+		res.Tickets = ready
+		return nil
+	})
+	if err != nil {
+		return lymbo.PollResult{}, err
+	}
+	return res, nil
+}
+
+// candidates reads the pending tickets storeutil.Select needs for req:
+// the ones of req.Queue due by the boost horizon and the earliest later one, for SleepUntil,
+// or every pending ticket if in-flight tickets must be counted for the caps.
+func (s *Store) candidates(ctx context.Context, q querier, req lymbo.PollRequest) ([]lymbo.Ticket, error) {
+	if len(req.MaxInFlightPerType) > 0 {
+		return s.query(ctx, q, s.queries.pending)
+	}
+
+	limit := req.Limit
+	if len(req.Labels) > 0 || len(req.Tenants) > 0 || req.FairShare || req.TypeShare.Enabled || len(req.LimitPerType) > 0 ||
+		req.CatchUp.MaxOverduePerType > 0 || req.CatchUp.DropAfter > 0 || req.Priority.Enabled {
+		// -1 is no limit
+		limit = -1
+	}
+	horizon := req.Now.Add(max(req.Boost.Grace, 0)).UnixMilli()
+	tickets, err := s.query(ctx, q, s.queries.poll, req.Queue, horizon, limit)
+	if err != nil {
+		return nil, err
+	}
+	next, err := s.query(ctx, q, s.queries.next, req.Queue, horizon)
+	if err != nil {
+		return nil, err
+	}
+	return append(tickets, next...), nil
+}
+
+// pausedTypes returns the paused types, sorted.
+func (s *Store) pausedTypes(ctx context.Context, q querier) ([]string, error) {
+	rows, err := q.QueryContext(ctx, s.queries.paused)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []string
+	for rows.Next() {
+		var typ string
+		if err := rows.Scan(&typ); err != nil {
+			return nil, err
+		}
+		types = append(types, typ)
+	}
+	return types, rows.Err()
+}
+
+// Pause inserts typ into the paused table.
+func (s *Store) Pause(ctx context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	return s.write(ctx, func(q querier) error {
+		_, err := q.ExecContext(ctx, s.queries.pause, typ)
+		return err
+	})
+}
+
+// Resume deletes typ from the paused table.
+func (s *Store) Resume(ctx context.Context, typ string) error {
+	if typ == "" {
+		return lymbo.ErrTypeEmpty
+	}
+	return s.write(ctx, func(q querier) error {
+		_, err := q.ExecContext(ctx, s.queries.resume, typ)
+		return err
+	})
+}
+
+func (s *Store) Paused(ctx context.Context) ([]string, error) {
+	return s.pausedTypes(ctx, s.db)
+}
+
+// values iterates over tickets.
+func values(tickets []lymbo.Ticket) iter.Seq[lymbo.Ticket] {
+	return func(yield func(lymbo.Ticket) bool) {
+		for _, t := range tickets {
+			if !yield(t) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Store) ListInFlight(ctx context.Context, now time.Time) ([]lymbo.Ticket, error) {
+	pending, err := s.query(ctx, s.db, s.queries.inflight, now.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	var tickets []lymbo.Ticket
+	for _, t := range pending {
+		if storeutil.InFlight(t, now) {
+			tickets = append(tickets, t)
+		}
+	}
+	return tickets, nil
+}
+
+// ReleaseOwned releases the in-flight tickets of owner in a single write transaction.
+func (s *Store) ReleaseOwned(ctx context.Context, owner string, now time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, func(q querier) error {
+		n = 0
+		pending, err := s.query(ctx, q, s.queries.inflight, now.UnixMilli())
+		if err != nil {
+			return err
+		}
+		for _, t := range pending {
+			if !storeutil.Owned(t, owner, now) {
+				continue
+			}
+			storeutil.Release(&t, now)
+			if err := s.save(ctx, q, t); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ReleaseDependents unblocks the tickets waiting for id in a single write transaction.
+func (s *Store) ReleaseDependents(ctx context.Context, id lymbo.TicketId, now time.Time) (int, error) {
+	var n int
+	err := s.write(ctx, func(q querier) error {
+		n = 0
+		waiting, err := s.query(ctx, q, s.queries.waiting, id.String())
+		if err != nil {
+			return err
+		}
+		for _, t := range waiting {
+			storeutil.Unblock(&t, id, now)
+			if err := s.save(ctx, q, t); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// RetryFailed reads the failed tickets and makes the selected ones pending
+// in a single write transaction.
+func (s *Store) RetryFailed(ctx context.Context, req lymbo.RetryFailedRequest) (int, error) {
+	var n int
+	err := s.write(ctx, func(q querier) error {
+		n = 0
+		failed, err := s.query(ctx, q, s.queries.failed)
+		if err != nil {
+			return err
+		}
+		for _, t := range failed {
+			if !storeutil.Retried(t, req) {
+				continue
+			}
+			storeutil.Requeue(&t, req)
+			err := s.save(ctx, q, t)
+			if errors.Is(err, lymbo.ErrDuplicateTicket) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ListOverdue reads the pending tickets having a deadline, whose RFC 3339
+// times SQLite doesn't compare, and keeps the overdue ones.
+func (s *Store) ListOverdue(ctx context.Context, now time.Time, limit int) ([]lymbo.Ticket, error) {
+	if limit <= 0 {
+		return nil, lymbo.ErrLimitInvalid
+	}
+	pending, err := s.query(ctx, s.db, s.queries.overdue)
+	if err != nil {
+		return nil, err
+	}
+	return storeutil.ListOverdue(values(pending), now, limit), nil
+}
+
+func (s *Store) List(ctx context.Context, req lymbo.ListRequest) ([]lymbo.Ticket, error) {
+	var st sql.NullString
+	if req.Status != nil {
+		st = sql.NullString{String: req.Status.String(), Valid: true}
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		// -1 is no limit
+		limit = -1
+	}
+	var types sql.NullString
+	if len(req.Types) > 0 {
+		data, err := json.Marshal(req.Types)
+		if err != nil {
+			return nil, err
+		}
+		types = sql.NullString{String: string(data), Valid: true}
+	}
+	var tenants sql.NullString
+	if len(req.Tenants) > 0 {
+		data, err := json.Marshal(req.Tenants)
+		if err != nil {
+			return nil, err
+		}
+		tenants = sql.NullString{String: string(data), Valid: true}
+	}
+	var afterCtime sql.NullInt64
+	var afterID sql.NullString
+	if req.After != nil {
+		afterCtime = millis(req.After.Ctime)
+		afterID = sql.NullString{String: req.After.ID.String(), Valid: true}
+	}
+	return s.query(ctx, s.db, s.queries.list, st, limit, types,
+		millis(req.Created.From), millis(req.Created.To),
+		millis(req.Runat.From), millis(req.Runat.To),
+		afterCtime, afterID, tenants,
+	)
+}
+
+// millis returns t in the unix milliseconds of the columns, NULL if zero.
+func millis(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: !t.IsZero()}
+}
+
+func (s *Store) Vacuum(ctx context.Context, req lymbo.VacuumRequest) (lymbo.VacuumReport, error) {
+	tickets, err := s.query(ctx, s.db, s.queries.all)
+	if err != nil {
+		return lymbo.VacuumReport{}, err
+	}
+	return storeutil.Vacuum(values(tickets), req), nil
+}
+
+// ExpireTickets removes expired non-pending tickets in a single transaction.
+// Candidates are the terminal tickets whose Runat has passed or that weren't
+// modified since the shortest retention.
+func (s *Store) ExpireTickets(ctx context.Context, req lymbo.ExpireRequest) (int64, error) {
+	modifiedBefore := int64(-1 << 63)
+	if len(req.Retention) > 0 {
+		shortest := time.Duration(-1)
+		for _, d := range req.Retention {
+			if shortest < 0 || d < shortest {
+				shortest = d
+			}
+		}
+		modifiedBefore = req.Now.Add(-shortest).UnixMilli()
+	}
+
+	var count int64
+	err := s.write(ctx, func(q querier) error {
+		count = 0
+		tickets, err := s.query(ctx, q, s.queries.expired, req.Now.UnixMilli(), modifiedBefore)
+		if err != nil {
+			return err
+		}
+		var expired []lymbo.Ticket
+		for _, t := range tickets {
+			if len(expired) == req.Limit {
+				break
+			}
+			if !storeutil.ExpiresAt(t, req.Retention).After(req.Now) {
+				expired = append(expired, t)
+			}
+		}
+		if err := storeutil.Archive(ctx, req, expired); err != nil {
+			return err
+		}
+		for _, t := range expired {
+			if _, err := q.ExecContext(ctx, s.queries.delete, t.ID.String()); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,37 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/store/sqlite"
+	"github.com/ochaton/lymbo/store/storetest"
+	_ "modernc.org/sqlite"
+)
+
+func TestStore(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "lymbo.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	var n int
+	storetest.TestStore(t, func() lymbo.Store {
+		// a table of its own per subtest, so that each store starts empty
+		n++
+		s, err := sqlite.NewStore(sqlite.Config{DB: db, TableName: fmt.Sprintf("storetest%d", n)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
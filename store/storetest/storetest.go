@@ -0,0 +1,469 @@
+// Package storetest is a conformance suite for lymbo.Store implementations,
+// so that third-party stores can check they behave as the Kharon expects:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.TestStore(t, func() lymbo.Store {
+//			return mystore.New(...) // empty
+//		})
+//	}
+//
+// Ticket IDs are UUIDs, as some stores require. Times are compared to the
+// millisecond, which every store keeps.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ochaton/lymbo"
+	"github.com/ochaton/lymbo/status"
+)
+
+// TestStore runs the conformance suite against the stores made by newStore,
+// one per subtest, which must be empty: unique keys, status transitions, polling order,
+// the time of the next poll, redelivery of tickets whose time-to-run elapsed, leases, pausing and
+// expiration.
+func TestStore(t *testing.T, newStore func() lymbo.Store) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, lymbo.Store)
+	}{
+		{"PutGet", testPutGet},
+		{"UniqueKey", testUniqueKey},
+		{"UpdateUniqueKey", testUpdateUniqueKey},
+		{"Transitions", testTransitions},
+		{"PollOrder", testPollOrder},
+		{"SleepUntil", testSleepUntil},
+		{"Redelivery", testRedelivery},
+		{"Lease", testLease},
+		{"Pause", testPause},
+		{"Expiration", testExpiration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore())
+		})
+	}
+}
+
+// now is the time the tickets of a test are relative to, to the millisecond.
+func now() time.Time {
+	return time.Now().Truncate(time.Millisecond)
+}
+
+// ticket returns a pending ticket of type typ due at runat.
+func ticket(typ string, runat time.Time) lymbo.Ticket {
+	return lymbo.Ticket{
+		ID:     lymbo.TicketId(uuid.NewString()),
+		Status: status.Pending,
+		Type:   typ,
+		Runat:  runat,
+		Ctime:  runat,
+	}
+}
+
+func put(t *testing.T, s lymbo.Store, tickets ...lymbo.Ticket) {
+	t.Helper()
+	for _, tk := range tickets {
+		if err := s.Put(context.Background(), tk); err != nil {
+			t.Fatalf("Put(%s): %v", tk.ID, err)
+		}
+	}
+}
+
+func get(t *testing.T, s lymbo.Store, id lymbo.TicketId) lymbo.Ticket {
+	t.Helper()
+	tk, err := s.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", id, err)
+	}
+	return tk
+}
+
+func poll(t *testing.T, s lymbo.Store, req lymbo.PollRequest) lymbo.PollResult {
+	t.Helper()
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+	if req.Backoff == nil {
+		req.Backoff = lymbo.ConstantBackoff(0)
+	}
+	res, err := s.PollPending(context.Background(), req)
+	if err != nil {
+		t.Fatalf("PollPending: %v", err)
+	}
+	return res
+}
+
+// ids returns the IDs of tickets, in order.
+func ids(tickets []lymbo.Ticket) []lymbo.TicketId {
+	out := make([]lymbo.TicketId, len(tickets))
+	for i, tk := range tickets {
+		out[i] = tk.ID
+	}
+	return out
+}
+
+func sameTime(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func wantErr(t *testing.T, op string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s: got error %v, want %v", op, err, want)
+	}
+}
+
+func testPutGet(t *testing.T, s lymbo.Store) {
+	ctx := context.Background()
+	at := now()
+	tk := ticket("email", at)
+	tk.Nice = 3
+	tk.Queue = "billing"
+	tk.TenantID = "acme"
+	tk.Labels = map[string]string{"region": "eu"}
+	tk.Metadata = map[string]string{"trace": "abc"}
+	put(t, s, tk)
+
+	got := get(t, s, tk.ID)
+	if got.ID != tk.ID || got.Type != tk.Type || got.Status != status.Pending || got.Nice != tk.Nice ||
+		got.Queue != tk.Queue || got.TenantID != tk.TenantID || got.Attempts != 0 {
+		t.Errorf("Get returned %+v, want %+v", got, tk)
+	}
+	if !sameTime(got.Runat, tk.Runat) || !sameTime(got.Ctime, tk.Ctime) {
+		t.Errorf("Get returned runat %v ctime %v, want %v", got.Runat, got.Ctime, at)
+	}
+	if got.Labels["region"] != "eu" || got.Metadata["trace"] != "abc" {
+		t.Errorf("Get returned labels %v metadata %v", got.Labels, got.Metadata)
+	}
+
+	ok, err := s.Exists(ctx, tk.ID)
+	if err != nil || !ok {
+		t.Errorf("Exists(%s) = %v, %v, want true", tk.ID, ok, err)
+	}
+	missing := lymbo.TicketId(uuid.NewString())
+	ok, err = s.Exists(ctx, missing)
+	if err != nil || ok {
+		t.Errorf("Exists(missing) = %v, %v, want false", ok, err)
+	}
+	_, err = s.Get(ctx, missing)
+	wantErr(t, "Get(missing)", err, lymbo.ErrTicketNotFound)
+
+	empty := ticket("email", at)
+	empty.ID = ""
+	wantErr(t, "Put(empty ID)", s.Put(ctx, empty), lymbo.ErrTicketIDEmpty)
+
+	batch := []lymbo.Ticket{ticket("email", at), empty, ticket("email", at)}
+	errs, err := s.PutBatch(ctx, batch)
+	if err != nil {
+		t.Fatalf("PutBatch: %v", err)
+	}
+	if len(errs) != len(batch) || errs[0] != nil || errs[2] != nil {
+		t.Fatalf("PutBatch returned errors %v, want one for the empty ID only", errs)
+	}
+	wantErr(t, "PutBatch(empty ID)", errs[1], lymbo.ErrTicketIDEmpty)
+	get(t, s, batch[0].ID)
+	get(t, s, batch[2].ID)
+}
+
+func testUniqueKey(t *testing.T, s lymbo.Store) {
+	ctx := context.Background()
+	at := now()
+	first := ticket("email", at)
+	first.UniqueKey = "user-1"
+	put(t, s, first)
+
+	dup := ticket("email", at)
+	dup.UniqueKey = "user-1"
+	wantErr(t, "Put(duplicate)", s.Put(ctx, dup), lymbo.ErrDuplicateTicket)
+
+	other := ticket("sms", at)
+	other.UniqueKey = "user-1"
+	put(t, s, other) // of another type
+
+	done := status.Done
+	if err := s.UpdateSet(ctx, lymbo.UpdateSet{Id: first.ID, Status: &done}); err != nil {
+		t.Fatalf("UpdateSet(done): %v", err)
+	}
+	put(t, s, dup) // no longer held once first is done
+}
+
+func testUpdateUniqueKey(t *testing.T, s lymbo.Store) {
+	ctx := context.Background()
+	at := now()
+	email := ticket("email", at)
+	email.UniqueKey = "user-1"
+	put(t, s, email)
+	sms := ticket("sms", at)
+	sms.UniqueKey = "user-1"
+	put(t, s, sms)
+
+	err := s.Update(ctx, sms.ID, func(_ context.Context, u *lymbo.Ticket) error {
+		u.Type = "email"
+		return nil
+	})
+	wantErr(t, "Update(duplicate)", err, lymbo.ErrDuplicateTicket)
+	if got := get(t, s, sms.ID); got.Type != "sms" {
+		t.Errorf("duplicate ticket has type %q, want it left as sms", got.Type)
+	}
+
+	done := status.Done
+	if err := s.UpdateSet(ctx, lymbo.UpdateSet{Id: email.ID, Status: &done}); err != nil {
+		t.Fatalf("UpdateSet(done): %v", err)
+	}
+	err = s.Update(ctx, sms.ID, func(_ context.Context, u *lymbo.Ticket) error {
+		u.Type = "email"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update(type) once the key is no longer held: %v", err)
+	}
+	if got := get(t, s, sms.ID); got.Type != "email" {
+		t.Errorf("moved ticket has type %q, want email", got.Type)
+	}
+}
+
+func testTransitions(t *testing.T, s lymbo.Store) {
+	ctx := context.Background()
+	at := now()
+	tk := ticket("email", at)
+	put(t, s, tk)
+
+	failed := status.Failed
+	reason := &lymbo.ErrorInfo{Message: "boom", OccurredAt: at}
+	if err := s.UpdateSet(ctx, lymbo.UpdateSet{Id: tk.ID, Status: &failed, ErrorReason: reason}); err != nil {
+		t.Fatalf("UpdateSet(failed): %v", err)
+	}
+	got := get(t, s, tk.ID)
+	if got.Status != status.Failed || got.ErrorReason == nil || got.ErrorReason.Message != "boom" {
+		t.Errorf("failed ticket is %v with reason %+v, want failed with boom", got.Status, got.ErrorReason)
+	}
+	if got.Mtime == nil {
+		t.Errorf("failed ticket has no Mtime")
+	}
+	wantErr(t, "Reschedule(failed)", s.Reschedule(ctx, tk.ID, at), lymbo.ErrInvalidStatusTransition)
+
+	err := s.Update(ctx, tk.ID, func(_ context.Context, u *lymbo.Ticket) error {
+		u.Status = status.Pending
+		u.Nice = 7
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got := get(t, s, tk.ID); got.Status != status.Pending || got.Nice != 7 {
+		t.Errorf("updated ticket is %v with nice %d, want pending with 7", got.Status, got.Nice)
+	}
+	later := at.Add(time.Hour)
+	if err := s.Reschedule(ctx, tk.ID, later); err != nil {
+		t.Fatalf("Reschedule: %v", err)
+	}
+	if got := get(t, s, tk.ID); !sameTime(got.Runat, later) {
+		t.Errorf("rescheduled ticket is due at %v, want %v", got.Runat, later)
+	}
+
+	missing := lymbo.TicketId(uuid.NewString())
+	done := status.Done
+	wantErr(t, "UpdateSet(missing)", s.UpdateSet(ctx, lymbo.UpdateSet{Id: missing, Status: &done}), lymbo.ErrTicketNotFound)
+	wantErr(t, "Reschedule(missing)", s.Reschedule(ctx, missing, at), lymbo.ErrTicketNotFound)
+
+	if err := s.Delete(ctx, tk.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err = s.Get(ctx, tk.ID)
+	wantErr(t, "Get(deleted)", err, lymbo.ErrTicketNotFound)
+	if err := s.Delete(ctx, tk.ID); err != nil {
+		t.Errorf("Delete(deleted): %v, want nil", err)
+	}
+}
+
+func testPollOrder(t *testing.T, s lymbo.Store) {
+	ctx := context.Background()
+	at := now()
+	late := ticket("email", at.Add(-3*time.Second))
+	early := ticket("email", at.Add(-time.Second))
+	mid := ticket("email", at.Add(-2*time.Second))
+	urgent := ticket("email", at.Add(-2*time.Second))
+	urgent.Nice = -5
+	future := ticket("email", at.Add(time.Hour))
+	put(t, s, early, late, mid, urgent, future)
+
+	_, err := s.PollPending(ctx, lymbo.PollRequest{Limit: 0, Now: at})
+	wantErr(t, "PollPending(limit 0)", err, lymbo.ErrLimitInvalid)
+
+	res := poll(t, s, lymbo.PollRequest{Limit: 2, Now: at, TTR: time.Minute})
+	want := []lymbo.TicketId{late.ID, urgent.ID}
+	if got := ids(res.Tickets); !slices.Equal(got, want) {
+		t.Fatalf("first poll returned %v, want %v (by runat, then nice)", got, want)
+	}
+	if res.Due != nil && !slices.EqualFunc(res.Due, []time.Time{late.Runat, urgent.Runat}, sameTime) {
+		t.Errorf("first poll reported due %v, want %v", res.Due, []time.Time{late.Runat, urgent.Runat})
+	}
+	for _, tk := range res.Tickets {
+		if tk.Attempts != 1 || tk.Lease == "" || !sameTime(tk.Runat, at.Add(time.Minute)) {
+			t.Errorf("claimed ticket has attempts %d, lease %q, runat %v, want 1, a lease and %v",
+				tk.Attempts, tk.Lease, tk.Runat, at.Add(time.Minute))
+		}
+	}
+
+	res = poll(t, s, lymbo.PollRequest{Now: at, TTR: time.Minute})
+	want = []lymbo.TicketId{mid.ID, early.ID}
+	if got := ids(res.Tickets); !slices.Equal(got, want) {
+		t.Fatalf("second poll returned %v, want %v", got, want)
+	}
+
+	res = poll(t, s, lymbo.PollRequest{Now: at, TTR: time.Minute})
+	if len(res.Tickets) != 0 {
+		t.Fatalf("third poll returned %v, want none ready", ids(res.Tickets))
+	}
+	if res.SleepUntil == nil || !sameTime(*res.SleepUntil, at.Add(time.Minute)) {
+		t.Errorf("third poll sleeps until %v, want the end of the claims at %v", res.SleepUntil, at.Add(time.Minute))
+	}
+}
+
+func testSleepUntil(t *testing.T, s lymbo.Store) {
+	at := now()
+	soonest := ticket("email", at.Add(time.Minute))
+	put(t, s,
+		ticket("email", at.Add(3*time.Minute)),
+		soonest,
+		ticket("email", at.Add(2*time.Minute)),
+	)
+
+	res := poll(t, s, lymbo.PollRequest{Now: at, TTR: time.Minute})
+	if len(res.Tickets) != 0 {
+		t.Fatalf("poll returned %v, want none ready", ids(res.Tickets))
+	}
+	if res.SleepUntil == nil || !sameTime(*res.SleepUntil, soonest.Runat) {
+		t.Errorf("poll sleeps until %v, want the runat of the soonest ticket %v", res.SleepUntil, soonest.Runat)
+	}
+}
+
+func testRedelivery(t *testing.T, s lymbo.Store) {
+	at := now()
+	tk := ticket("email", at)
+	put(t, s, tk)
+
+	first := poll(t, s, lymbo.PollRequest{Now: at, TTR: time.Minute})
+	if len(first.Tickets) != 1 {
+		t.Fatalf("poll returned %v, want %s", ids(first.Tickets), tk.ID)
+	}
+	if res := poll(t, s, lymbo.PollRequest{Now: at.Add(30 * time.Second), TTR: time.Minute}); len(res.Tickets) != 0 {
+		t.Fatalf("poll within the time-to-run returned %v, want none", ids(res.Tickets))
+	}
+
+	inFlight, err := s.ListInFlight(context.Background(), at.Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("ListInFlight: %v", err)
+	}
+	if got := ids(inFlight); !slices.Equal(got, []lymbo.TicketId{tk.ID}) {
+		t.Errorf("ListInFlight returned %v, want %s", got, tk.ID)
+	}
+
+	res := poll(t, s, lymbo.PollRequest{Now: at.Add(time.Minute), TTR: time.Minute})
+	if len(res.Tickets) != 1 || res.Tickets[0].ID != tk.ID {
+		t.Fatalf("poll past the time-to-run returned %v, want %s redelivered", ids(res.Tickets), tk.ID)
+	}
+	again := res.Tickets[0]
+	if again.Attempts != 2 || again.Lease == "" || again.Lease == first.Tickets[0].Lease {
+		t.Errorf("redelivered ticket has attempts %d, lease %q, want 2 and a new lease", again.Attempts, again.Lease)
+	}
+}
+
+func testLease(t *testing.T, s lymbo.Store) {
+	ctx := context.Background()
+	at := now()
+	tk := ticket("email", at)
+	put(t, s, tk)
+
+	stale := poll(t, s, lymbo.PollRequest{Now: at, TTR: time.Minute}).Tickets[0]
+	fresh := poll(t, s, lymbo.PollRequest{Now: at.Add(time.Minute), TTR: time.Minute}).Tickets[0]
+
+	done := status.Done
+	err := s.UpdateSet(ctx, lymbo.UpdateSet{Id: tk.ID, Status: &done, Lease: stale.Lease})
+	wantErr(t, "UpdateSet(stale lease)", err, lymbo.ErrLeaseLost)
+	if got := get(t, s, tk.ID); got.Status != status.Pending {
+		t.Errorf("ticket settled with a stale lease is %v, want pending", got.Status)
+	}
+	if err := s.UpdateSet(ctx, lymbo.UpdateSet{Id: tk.ID, Status: &done, Lease: fresh.Lease}); err != nil {
+		t.Fatalf("UpdateSet(lease): %v", err)
+	}
+	if got := get(t, s, tk.ID); got.Status != status.Done {
+		t.Errorf("ticket settled with its lease is %v, want done", got.Status)
+	}
+}
+
+func testPause(t *testing.T, s lymbo.Store) {
+	ctx := context.Background()
+	at := now()
+	email, sms := ticket("email", at), ticket("sms", at)
+	put(t, s, email, sms)
+
+	if err := s.Pause(ctx, "email"); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	paused, err := s.Paused(ctx)
+	if err != nil || !slices.Equal(paused, []string{"email"}) {
+		t.Errorf("Paused() = %v, %v, want [email]", paused, err)
+	}
+	if got := ids(poll(t, s, lymbo.PollRequest{Now: at, TTR: time.Minute}).Tickets); !slices.Equal(got, []lymbo.TicketId{sms.ID}) {
+		t.Fatalf("poll with email paused returned %v, want %s", got, sms.ID)
+	}
+
+	if err := s.Resume(ctx, "email"); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if got := ids(poll(t, s, lymbo.PollRequest{Now: at, TTR: time.Minute}).Tickets); !slices.Equal(got, []lymbo.TicketId{email.ID}) {
+		t.Fatalf("poll with email resumed returned %v, want %s", got, email.ID)
+	}
+}
+
+func testExpiration(t *testing.T, s lymbo.Store) {
+	ctx := context.Background()
+	at := now()
+	pending := ticket("email", at.Add(-time.Hour))
+	due := ticket("email", at.Add(-time.Hour))
+	due.Status = status.Done
+	notDue := ticket("email", at.Add(time.Hour))
+	notDue.Status = status.Done
+	retained := ticket("email", at.Add(-time.Hour))
+	retained.Status = status.Failed
+	retained.Mtime = &at
+	put(t, s, pending, due, notDue, retained)
+
+	n, err := s.ExpireTickets(ctx, lymbo.ExpireRequest{
+		Limit:     100,
+		Now:       at,
+		Retention: map[status.Status]time.Duration{status.Failed: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("ExpireTickets: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("ExpireTickets removed %d tickets, want 1", n)
+	}
+	_, err = s.Get(ctx, due.ID)
+	wantErr(t, "Get(expired)", err, lymbo.ErrTicketNotFound)
+	for _, tk := range []lymbo.Ticket{pending, notDue, retained} {
+		get(t, s, tk.ID)
+	}
+
+	n, err = s.ExpireTickets(ctx, lymbo.ExpireRequest{
+		Limit:     100,
+		Now:       at.Add(2 * time.Hour),
+		Retention: map[status.Status]time.Duration{status.Failed: time.Hour},
+	})
+	if err != nil {
+		t.Fatalf("ExpireTickets: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("ExpireTickets removed %d tickets past their retention, want 2", n)
+	}
+	get(t, s, pending.ID)
+}
@@ -19,13 +19,42 @@ type Ticket struct {
 	ID          TicketId
 	Status      status.Status
 	Runat       time.Time  // Time when the ticket should be processed
+	Deadline    *time.Time // Time by which the ticket must be settled, see WithDeadline
 	Nice        int        // Priority value (lower = higher priority)
 	Type        string     // Ticket type identifier for routing
+	Queue       string     // Logical queue, "" for the default one, see Settings.WithQueue
+	TenantID    string     // Tenant the ticket belongs to, "" for none, see Settings.WithTenants
 	Ctime       time.Time  // Creation time
 	Mtime       *time.Time // Last modification time
 	Attempts    int        // Number of processing attempts
+	Lease       string     // Token stamped by the poll that claimed the ticket
+	Owner       string     // Worker ID of the Kharon holding the claimed ticket, see ReleaseOwned
 	Payload     any        // Arbitrary payload data
-	ErrorReason any        // Error information if processing failed
+	ErrorReason *ErrorInfo // Why processing failed, if it did, see SetErrorReason
+	Result      any        // Output of the handler, see WithResult
+
+	// Labels are arbitrary key/value pairs, e.g. tenant=acme,
+	// that pollers can select tickets by.
+	Labels map[string]string
+
+	// UniqueKey deduplicates tickets: putting a pending ticket fails with
+	// ErrDuplicateTicket while another pending ticket of the same Type has the
+	// same UniqueKey. Empty never conflicts.
+	UniqueKey string
+
+	// Metadata are arbitrary key/value pairs carried along with the ticket,
+	// e.g. trace IDs or routing hints, that aren't indexed nor selected by.
+	// Keys prefixed with "$lymbo_" are reserved: Kharon drops them from the
+	// tickets it is given to put.
+	Metadata map[string]string
+
+	// AttemptLog are the runs of the handlers of the ticket, oldest first.
+	AttemptLog []Attempt
+
+	// DependsOn are the IDs of the tickets that must be done before this
+	// pending ticket is polled. Acking or completing one of them removes it
+	// from the list, see Kharon.ReleaseDependents.
+	DependsOn []TicketId
 }
 
 var (
@@ -38,6 +67,10 @@ var (
 // DefaultNice is the default priority value for new tickets.
 const DefaultNice = 512
 
+// UrgentNice is the nice value of urgent tickets, set by WithPriority.
+// Tickets at or below it may run ahead of schedule, see Settings.WithPriorityBoost.
+const UrgentNice = 0
+
 // NewTicket creates a new ticket with the given ID and type.
 // Returns an error if tid or typ is empty.
 func NewTicket(tid TicketId, typ string) (*Ticket, error) {
@@ -75,6 +108,50 @@ func (t *Ticket) WithNice(nice int) *Ticket {
 	return t
 }
 
+// WithPriority marks the ticket urgent (Nice = UrgentNice) and returns the ticket.
+func (t *Ticket) WithPriority() *Ticket {
+	t.Nice = UrgentNice
+	return t
+}
+
+// WithLabels sets the labels for the ticket and returns the ticket.
+func (t *Ticket) WithLabels(labels map[string]string) *Ticket {
+	t.Labels = labels
+	return t
+}
+
+// WithQueue sets the queue of the ticket and returns the ticket.
+func (t *Ticket) WithQueue(queue string) *Ticket {
+	t.Queue = queue
+	return t
+}
+
+// WithTenant sets the tenant of the ticket and returns the ticket.
+func (t *Ticket) WithTenant(tenant string) *Ticket {
+	t.TenantID = tenant
+	return t
+}
+
+// WithUniqueKey sets the deduplication key of the ticket and returns the ticket.
+func (t *Ticket) WithUniqueKey(key string) *Ticket {
+	t.UniqueKey = key
+	return t
+}
+
+// WithMetadata sets the metadata for the ticket and returns the ticket.
+func (t *Ticket) WithMetadata(metadata map[string]string) *Ticket {
+	t.Metadata = metadata
+	return t
+}
+
+// WithDependsOn sets the tickets the ticket waits for and returns the ticket.
+// Put it before they are acked, e.g. in the same PutBatch: a ticket waiting
+// for one already done is never released.
+func (t *Ticket) WithDependsOn(ids ...TicketId) *Ticket {
+	t.DependsOn = ids
+	return t
+}
+
 // WithRunat sets the run time for the ticket and returns the ticket.
 func (t *Ticket) WithRunat(runat time.Time) *Ticket {
 	t.Runat = runat
@@ -0,0 +1,113 @@
+package lymbo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ochaton/lymbo/status"
+)
+
+// Touch extends the reservation of a ticket being handled, e.g. from the
+// heartbeat of a long-running handler: its Runat is moved to extendBy from
+// now, so that it isn't redelivered to another worker once its time-to-run
+// elapses. Called with the handler's context, the handler's deadline is moved
+// along. With WithLeaseCheck, ErrLeaseLost is returned if the ticket was
+// claimed again meanwhile.
+// Returns ErrInvalidStatusTransition if the ticket is no longer pending.
+func (k *Kharon) Touch(ctx context.Context, tid TicketId, extendBy time.Duration) error {
+	now := k.now()
+	runat := now.Add(extendBy)
+	var err error
+	if token, checked := leaseFrom(ctx, tid); checked {
+		err = k.store.Update(ctx, tid, func(_ context.Context, t *Ticket) error {
+			if t.Lease != token {
+				return ErrLeaseLost
+			}
+			if t.Status != status.Pending {
+				return ErrInvalidStatusTransition
+			}
+			t.Runat = runat
+			t.Mtime = &now
+			return nil
+		})
+		err = k.leaseErr(ctx, tid, err)
+	} else {
+		err = k.store.Touch(ctx, tid, extendBy)
+	}
+	if err != nil {
+		return err
+	}
+	if r, ok := ctx.Value(reservationKey{}).(*reservation); ok && r.tid == tid {
+		r.extend(runat, now)
+	}
+	return nil
+}
+
+type reservationKey struct{}
+
+// reservation is the deadline of a handler, until its ticket is redelivered.
+type reservation struct {
+	tid TicketId
+
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// extend moves the deadline to d, unless it has passed already, now being
+// the time of the clock of the Kharon.
+func (r *reservation) extend(d, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timer.Stop() {
+		r.deadline = d
+		r.timer.Reset(d.Sub(now))
+	}
+}
+
+// reservedCtx is a handler context whose deadline Touch can move, which
+// context.WithDeadline doesn't allow. Its embedded context is cancelled with
+// context.DeadlineExceeded as cause when the deadline passes.
+// Contexts derived from it report context.Canceled as their Err then.
+type reservedCtx struct {
+	context.Context
+	r *reservation
+}
+
+// withReservation returns a handler context for t, due at t.Runat, now being
+// the time of the clock of the Kharon.
+func withReservation(ctx context.Context, t *Ticket, now time.Time) (context.Context, context.CancelFunc) {
+	cctx, cancel := context.WithCancelCause(ctx)
+	r := &reservation{tid: t.ID, deadline: t.Runat}
+	r.timer = time.AfterFunc(t.Runat.Sub(now), func() { cancel(context.DeadlineExceeded) })
+	return reservedCtx{Context: cctx, r: r}, func() {
+		r.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+func (c reservedCtx) Deadline() (time.Time, bool) {
+	c.r.mu.Lock()
+	d := c.r.deadline
+	c.r.mu.Unlock()
+	if pd, ok := c.Context.Deadline(); ok && pd.Before(d) {
+		return pd, true
+	}
+	return d, true
+}
+
+func (c reservedCtx) Err() error {
+	err := c.Context.Err()
+	if err != nil && context.Cause(c.Context) == context.DeadlineExceeded {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (c reservedCtx) Value(key any) any {
+	if key == (reservationKey{}) {
+		return c.r
+	}
+	return c.Context.Value(key)
+}
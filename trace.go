@@ -0,0 +1,45 @@
+package lymbo
+
+import "context"
+
+// Op names a Kharon operation traced by a Tracer.
+type Op string
+
+const (
+	OpAdd     Op = "add"
+	OpPoll    Op = "poll"
+	OpProcess Op = "process"
+	OpAck     Op = "ack"
+	OpDone    Op = "done"
+	OpFail    Op = "fail"
+	OpCancel  Op = "cancel"
+	OpRetry   Op = "retry"
+	OpRelease Op = "release"
+)
+
+// Tracer instruments Kharon operations, see package tracing for OpenTelemetry.
+// Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start starts a span for op and returns the context to run it in and a
+	// function ending the span with the operation's error.
+	// t is the ticket added (OpAdd) or processed (OpProcess), Start may store
+	// the trace context in its Metadata to be propagated from one to the other;
+	// for outcomes, t only has its ID set; for OpPoll, t is nil.
+	Start(ctx context.Context, op Op, t *Ticket) (context.Context, func(error))
+}
+
+// trace starts a span with the configured tracer, if any.
+func (k *Kharon) trace(ctx context.Context, op Op, t *Ticket) (context.Context, func(error)) {
+	if k.settings.tracer == nil {
+		return ctx, func(error) {}
+	}
+	return k.settings.tracer.Start(ctx, op, t)
+}
+
+// traceOutcome starts a span for an outcome reported for the ticket tid.
+func (k *Kharon) traceOutcome(ctx context.Context, op Op, tid TicketId) (context.Context, func(error)) {
+	if k.settings.tracer == nil {
+		return ctx, func(error) {}
+	}
+	return k.settings.tracer.Start(ctx, op, &Ticket{ID: tid})
+}
@@ -0,0 +1,137 @@
+// Package tracing implements lymbo.Tracer with OpenTelemetry.
+//
+// Usage:
+//
+//	settings := lymbo.DefaultSettings().WithTracer(tracing.New(tracing.Config{}))
+//
+// Adding a ticket injects the trace context of the caller into the ticket
+// Metadata with the configured propagator (the traceparent and tracestate
+// keys for W3C Trace Context), and processing it starts a span child of
+// that context, so that enqueue→process flows show up as a single trace.
+// Outcomes reported by the handler are children of the processing span.
+package tracing
+
+import (
+	"context"
+	"maps"
+
+	"github.com/ochaton/lymbo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope of the spans.
+const ScopeName = "github.com/ochaton/lymbo"
+
+type Config struct {
+	// TracerProvider creates the tracer. Defaults to otel.GetTracerProvider().
+	TracerProvider trace.TracerProvider
+
+	// Propagator carries the trace context from adding to processing a ticket.
+	// Defaults to otel.GetTextMapPropagator().
+	Propagator propagation.TextMapPropagator
+
+	// SkipPolls disables the span of every poll, which are frequent and
+	// mostly empty while the queue is idle.
+	SkipPolls bool
+}
+
+// Tracer is an OpenTelemetry lymbo.Tracer.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	skipPolls  bool
+}
+
+// Ensure Tracer implements lymbo.Tracer interface.
+var _ lymbo.Tracer = (*Tracer)(nil)
+
+// New returns a Tracer to pass to Settings.WithTracer.
+func New(cfg Config) *Tracer {
+	if cfg.TracerProvider == nil {
+		cfg.TracerProvider = otel.GetTracerProvider()
+	}
+	if cfg.Propagator == nil {
+		cfg.Propagator = otel.GetTextMapPropagator()
+	}
+	return &Tracer{
+		tracer:     cfg.TracerProvider.Tracer(ScopeName),
+		propagator: cfg.Propagator,
+		skipPolls:  cfg.SkipPolls,
+	}
+}
+
+var (
+	systemAttr  = attribute.Key("messaging.system").String("lymbo")
+	opKey       = attribute.Key("messaging.operation.name")
+	idKey       = attribute.Key("messaging.message.id")
+	typeKey     = attribute.Key("messaging.destination.name")
+	attemptsKey = attribute.Key("lymbo.ticket.attempts")
+)
+
+func (tr *Tracer) Start(ctx context.Context, op lymbo.Op, t *lymbo.Ticket) (context.Context, func(error)) {
+	if op == lymbo.OpPoll && tr.skipPolls {
+		return ctx, func(error) {}
+	}
+
+	name := string(op)
+	kind := trace.SpanKindInternal
+	attrs := []attribute.KeyValue{systemAttr, opKey.String(string(op))}
+	if t != nil {
+		attrs = append(attrs, idKey.String(t.ID.String()))
+	}
+
+	switch op {
+	case lymbo.OpAdd:
+		name, kind = "add "+t.Type, trace.SpanKindProducer
+		attrs = append(attrs, typeKey.String(t.Type))
+	case lymbo.OpPoll:
+		kind = trace.SpanKindClient
+	case lymbo.OpProcess:
+		name, kind = "process "+t.Type, trace.SpanKindConsumer
+		attrs = append(attrs, typeKey.String(t.Type), attemptsKey.Int(t.Attempts))
+		ctx = tr.propagator.Extract(ctx, propagation.MapCarrier(t.Metadata))
+	}
+
+	ctx, span := tr.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	if op == lymbo.OpAdd {
+		// copied, the metadata may be shared with the caller's ticket
+		metadata := make(map[string]string, len(t.Metadata)+2)
+		maps.Copy(metadata, t.Metadata)
+		tr.propagator.Inject(ctx, propagation.MapCarrier(metadata))
+		t.Metadata = metadata
+	}
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+type propagator struct {
+	propagation.TextMapPropagator
+}
+
+// Propagator adapts p, otel.GetTextMapPropagator() if nil, into a
+// lymbo.Propagator, for Settings.WithPropagator to carry the trace context
+// and baggage to handlers without a Tracer starting spans.
+func Propagator(p propagation.TextMapPropagator) lymbo.Propagator {
+	if p == nil {
+		p = otel.GetTextMapPropagator()
+	}
+	return propagator{p}
+}
+
+func (p propagator) Inject(ctx context.Context, metadata map[string]string) {
+	p.TextMapPropagator.Inject(ctx, propagation.MapCarrier(metadata))
+}
+
+func (p propagator) Extract(ctx context.Context, metadata map[string]string) context.Context {
+	return p.TextMapPropagator.Extract(ctx, propagation.MapCarrier(metadata))
+}
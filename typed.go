@@ -0,0 +1,52 @@
+package lymbo
+
+import (
+	"context"
+	"fmt"
+)
+
+// Enqueue puts a new ticket of type typ carrying payload encoded with codec c,
+// as Kharon.Put does with opts. A nil codec means the one of typ, see
+// Kharon.Codec.
+func Enqueue[T any](ctx context.Context, k *Kharon, tid TicketId, typ string, payload T, c Codec, opts ...Option) error {
+	t, err := NewTicket(tid, typ)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		c = k.Codec(typ)
+	}
+	if err := SetPayload(t, c, payload); err != nil {
+		return err
+	}
+	return k.Put(ctx, *t, opts...)
+}
+
+// TypedHandlerFunc processes tickets whose payload is decoded as T.
+type TypedHandlerFunc[T any] func(ctx context.Context, t *Ticket, payload T) error
+
+// Typed adapts fn into a Handler decoding payloads with codec c, see DecodePayload.
+// A nil codec means the one of the ticket type, see CodecFrom.
+// A payload that can't be decoded is returned as an error without calling fn.
+func Typed[T any](c Codec, fn TypedHandlerFunc[T]) Handler {
+	if fn == nil {
+		return nil
+	}
+	return HandlerFunc(func(ctx context.Context, t *Ticket) error {
+		c := c
+		if c == nil {
+			c = CodecFrom(ctx)
+		}
+		payload, err := DecodePayload[T](t, c)
+		if err != nil {
+			return fmt.Errorf("ticket %s: %w", t.ID, err)
+		}
+		return fn(ctx, t, payload)
+	})
+}
+
+// HandleTyped registers on r a handler for route receiving payloads decoded
+// as T with codec c.
+func HandleTyped[T any](r *Router, route string, c Codec, fn TypedHandlerFunc[T]) error {
+	return r.register(route, Typed(c, fn))
+}
@@ -0,0 +1,87 @@
+package lymbo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Flow is a set of tickets wired by their DependsOn, put at once by
+// Kharon.PutFlow: a single Ticket, or a Chain or Group of flows, nested at will.
+// E.g. Chain(Group(a, b), callback) runs a and b in parallel and callback
+// once both are done.
+type Flow interface {
+	// wire returns the tickets of the flow, those it starts with waiting for
+	// after, and the IDs of the ones it ends with, which whatever follows the
+	// flow waits for.
+	wire(after []TicketId) (tickets []Ticket, last []TicketId)
+}
+
+// wire makes the ticket wait for after, besides its own DependsOn.
+func (t Ticket) wire(after []TicketId) ([]Ticket, []TicketId) {
+	t.DependsOn = append(slices.Clone(t.DependsOn), after...)
+	return []Ticket{t}, []TicketId{t.ID}
+}
+
+type sequence []Flow
+
+// Chain returns the flow running flows one after the other: each one starts
+// once the previous one is done.
+func Chain(flows ...Flow) Flow {
+	return sequence(flows)
+}
+
+func (c sequence) wire(after []TicketId) ([]Ticket, []TicketId) {
+	var tickets []Ticket
+	for _, f := range c {
+		var t []Ticket
+		t, after = f.wire(after)
+		tickets = append(tickets, t...)
+	}
+	return tickets, after
+}
+
+type parallel []Flow
+
+// Group returns the flow running flows in parallel, done once all of them
+// are: what follows it waits for the last tickets of every flow.
+func Group(flows ...Flow) Flow {
+	return parallel(flows)
+}
+
+func (g parallel) wire(after []TicketId) ([]Ticket, []TicketId) {
+	if len(g) == 0 {
+		return nil, after
+	}
+	var (
+		tickets []Ticket
+		last    []TicketId
+	)
+	for _, f := range g {
+		t, l := f.wire(after)
+		tickets = append(tickets, t...)
+		last = append(last, l...)
+	}
+	return tickets, last
+}
+
+// PutFlow puts the tickets of f, as PutBatch does with opts, each waiting
+// for the ones preceding it in f, and returns them with their DependsOn set.
+// A ticket that isn't put, e.g. with ErrDuplicateTicket, never runs the ones
+// waiting for it: err joins the errors of such tickets, each prefixed by
+// the ticket ID.
+func (k *Kharon) PutFlow(ctx context.Context, f Flow, opts ...Option) ([]Ticket, error) {
+	tickets, _ := f.wire(nil)
+	errs, err := k.PutBatch(ctx, tickets, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("ticket %s: %w", tickets[i].ID, err))
+		}
+	}
+	return tickets, errors.Join(failed...)
+}